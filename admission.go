@@ -0,0 +1,148 @@
+package transcoder
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// AdmissionConfig ...
+type AdmissionConfig struct {
+	// MaxConcurrent is the number of processes running at once, 0 leaving it
+	// unlimited
+	MaxConcurrent int
+	// MaxLoad delays the starts while the 1 minute load average divided by the number
+	// of CPUs is above it, 0 disabling the check. Linux only
+	MaxLoad float64
+	// MinFreeMemory delays the starts while the available memory is below it in
+	// bytes, 0 disabling the check. Linux only
+	MinFreeMemory int64
+	// Interval is the interval between two checks of a saturated host, defaults to 5s
+	Interval time.Duration
+}
+
+// Admission limits the transcodes running at once and delays their start while the
+// host is saturated. A single Admission is shared by the transcoders of a process
+// with SetAdmission, or by those of a Config
+type Admission struct {
+	config AdmissionConfig
+	slots  chan struct{}
+
+	mu      sync.Mutex
+	running int
+	waiting int
+}
+
+// NewAdmission ...
+func NewAdmission(config AdmissionConfig) *Admission {
+	if config.Interval <= 0 {
+		config.Interval = 5 * time.Second
+	}
+	a := &Admission{config: config}
+	if config.MaxConcurrent > 0 {
+		a.slots = make(chan struct{}, config.MaxConcurrent)
+	}
+	return a
+}
+
+// Acquire waits for a slot and a host able to take one more process, then returns
+// the function releasing the slot once the process exited. a may be nil
+func (a *Admission) Acquire(ctx context.Context) (release func(), err error) {
+	if a == nil {
+		return func() {}, nil
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	a.mu.Lock()
+	a.waiting++
+	a.mu.Unlock()
+	defer func() {
+		a.mu.Lock()
+		a.waiting--
+		if err == nil {
+			a.running++
+		}
+		a.mu.Unlock()
+	}()
+	if a.slots != nil {
+		select {
+		case a.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	for !a.admits() {
+		timer := time.NewTimer(a.config.Interval)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			if a.slots != nil {
+				<-a.slots
+			}
+			return nil, ctx.Err()
+		}
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			a.mu.Lock()
+			a.running--
+			a.mu.Unlock()
+			if a.slots != nil {
+				<-a.slots
+			}
+		})
+	}, nil
+}
+
+// admits reports whether the host is under the load and memory thresholds
+func (a *Admission) admits() bool {
+	if a.config.MaxLoad > 0 {
+		if load, ok := loadAverage(); ok && load/float64(runtime.NumCPU()) > a.config.MaxLoad {
+			return false
+		}
+	}
+	if a.config.MinFreeMemory > 0 {
+		if free, ok := freeMemory(); ok && free < a.config.MinFreeMemory {
+			return false
+		}
+	}
+	return true
+}
+
+// Running returns the number of admitted processes still running
+func (a *Admission) Running() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.running
+}
+
+// Waiting returns the number of processes waiting to start
+func (a *Admission) Waiting() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.waiting
+}
+
+var (
+	admissionMu sync.RWMutex
+	admission   *Admission
+)
+
+// SetAdmission sets the Admission of the transcoders whose Config has none, nil
+// removing it
+func SetAdmission(a *Admission) {
+	admissionMu.Lock()
+	admission = a
+	admissionMu.Unlock()
+}
+
+// DefaultAdmission returns the Admission set with SetAdmission, nil when there is none
+func DefaultAdmission() *Admission {
+	admissionMu.RLock()
+	defer admissionMu.RUnlock()
+	return admission
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package transcoder
+
+// loadAverage is unknown outside of Linux, the check is skipped
+func loadAverage() (float64, bool) {
+	return 0, false
+}
+
+// freeMemory is unknown outside of Linux, the check is skipped
+func freeMemory() (int64, bool) {
+	return 0, false
+}
@@ -0,0 +1,119 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/admpub/transcoder/utils"
+)
+
+// AnimationOptions configures an animated image
+type AnimationOptions struct {
+	Start    time.Duration
+	Duration time.Duration
+	// FPS defaults to 10 for gif, 15 otherwise
+	FPS int
+	// Width defaults to 480, the height follows the aspect ratio
+	Width int
+	// Loop count, 0 loops forever and -1 plays once
+	Loop int
+	// Dither is the paletteuse dithering of gif, defaults to sierra2_4a. bayer gives smaller files
+	Dither string
+	// Quality from 1 (worst) to 100 (best) for webp and avif, defaults to 75
+	Quality int
+	// Format is gif, webp or avif, guessed from the output extension
+	Format string
+}
+
+// format ...
+func (o AnimationOptions) format(output string) (string, error) {
+	format := strings.ToLower(o.Format)
+	if len(format) == 0 {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(output)), ".")
+	}
+	switch format {
+	case "gif", "webp", "avif":
+		return format, nil
+	}
+	return "", fmt.Errorf("unsupported animation format %q", format)
+}
+
+// ToGIF renders duration seconds of input from start as an animated image. Gif uses a
+// palette generated for the clip, other formats (webp, avif) are picked from the output extension
+func ToGIF(ctx context.Context, cfg *Config, input, output string, start, duration time.Duration, fps, width int) error {
+	return ToAnimation(ctx, cfg, input, output, AnimationOptions{Start: start, Duration: duration, FPS: fps, Width: width})
+}
+
+// ToAnimation renders an animated gif, webp or avif image in a single ffmpeg call
+func ToAnimation(ctx context.Context, cfg *Config, input, output string, opts AnimationOptions) error {
+	if len(input) == 0 {
+		return errors.New("missing input option")
+	}
+	if len(output) == 0 {
+		return errors.New("missing output option")
+	}
+	if opts.Duration <= 0 {
+		return errors.New("animation duration must be positive")
+	}
+	format, err := opts.format(output)
+	if err != nil {
+		return err
+	}
+	fps := opts.FPS
+	if fps <= 0 {
+		fps = 15
+		if format == "gif" {
+			fps = 10
+		}
+	}
+	width := opts.Width
+	if width <= 0 {
+		width = 480
+	}
+	quality := opts.Quality
+	if quality <= 0 || quality > 100 {
+		quality = 75
+	}
+	// the gif muxer plays once with -1, webp and avif count the plays
+	loop := opts.Loop
+	if loop < 0 && format != "gif" {
+		loop = 1
+	}
+	scale := fmt.Sprintf("fps=%d,scale=%d:-2:flags=lanczos", fps, width)
+
+	args := []string{"-y"}
+	if opts.Start > 0 {
+		args = append(args, "-ss", utils.SecToDur(opts.Start.Seconds()))
+	}
+	args = append(args, "-t", strconv.FormatFloat(opts.Duration.Seconds(), 'f', 3, 64), "-i", input, "-an", "-sn")
+
+	switch format {
+	case "gif":
+		dither := opts.Dither
+		if len(dither) == 0 {
+			dither = "sierra2_4a"
+		}
+		// a palette computed for the clip instead of the fixed 256 colors of the gif encoder
+		filter := scale + ",split[a][b];[a]palettegen=stats_mode=diff[p];[b][p]paletteuse=dither=" + dither + ":diff_mode=rectangle"
+		args = append(args, "-filter_complex", filter, "-loop", strconv.Itoa(loop), "-f", "gif")
+	case "webp":
+		args = append(args, "-vf", scale, "-c:v", "libwebp", "-lossless", "0", "-quality", strconv.Itoa(quality),
+			"-compression_level", "6", "-loop", strconv.Itoa(loop), "-f", "webp")
+	case "avif":
+		// map quality 1..100 to crf 63..10
+		crf := 63 - (quality-1)*53/99
+		args = append(args, "-vf", scale+",format=yuv420p", "-c:v", "libaom-av1", "-crf", strconv.Itoa(crf), "-b:v", "0",
+			"-cpu-used", "6", "-row-mt", "1", "-f", "avif")
+		if opts.Loop != 0 {
+			args = append(args, "-loop", strconv.Itoa(loop))
+		}
+	}
+	args = append(args, output)
+	_, err = run(ctx, cfg, args...)
+	return err
+}
@@ -0,0 +1,189 @@
+package ffmpeg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// artifactWidth and artifactHeight are the size frames are analyzed at
+const (
+	artifactWidth  = 640
+	artifactHeight = 360
+)
+
+// ArtifactOptions configures DetectArtifacts
+type ArtifactOptions struct {
+	// Interval the scores are averaged over, defaults to 10s
+	Interval time.Duration
+	// FPS is the number of frames analyzed per second, defaults to 2
+	FPS float64
+}
+
+// ArtifactScores are the artifact scores of an interval, higher is worse
+type ArtifactScores struct {
+	Start time.Duration `json:"start"`
+	End   time.Duration `json:"end"`
+	// Blockiness is the blockdetect score, around 1 for clean video
+	Blockiness float64 `json:"blockiness"`
+	// Blur is the blurdetect score, the width of edges in pixels
+	Blur float64 `json:"blur"`
+	// Banding is the ratio of the picture made of flat bands one or two levels apart
+	Banding float64 `json:"banding"`
+}
+
+// ArtifactReport holds the scores of each interval and of the whole input
+type ArtifactReport struct {
+	Intervals []ArtifactScores `json:"intervals"`
+	Overall   ArtifactScores   `json:"overall"`
+}
+
+// frameMetadata is the metadata of a frame printed by a metadata filter
+type frameMetadata struct {
+	Time   float64
+	Values map[string]float64
+}
+
+// parseFrameMetadata reads the frames printed by the metadata filters of a graph, in order
+func parseFrameMetadata(stderr []byte) []frameMetadata {
+	var frames []frameMetadata
+	scanner := bufio.NewScanner(bytes.NewReader(stderr))
+	for scanner.Scan() {
+		m := reMetadataLine.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		line := m[2]
+		if strings.HasPrefix(line, "frame:") {
+			f := frameMetadata{Values: map[string]float64{}}
+			for _, field := range strings.Fields(line) {
+				if strings.HasPrefix(field, "pts_time:") {
+					f.Time, _ = strconv.ParseFloat(strings.TrimPrefix(field, "pts_time:"), 64)
+				}
+			}
+			frames = append(frames, f)
+			continue
+		}
+		if i := strings.IndexByte(line, '='); i > 0 && len(frames) > 0 {
+			if v, err := strconv.ParseFloat(line[i+1:], 64); err == nil {
+				frames[len(frames)-1].Values[line[:i]] = v
+			}
+		}
+	}
+	return frames
+}
+
+// banding returns the ratio of the pixels of a gray frame lying in flat runs of at
+// least 8 pixels next to another flat run one or two levels apart, along rows and columns
+func banding(frame []byte, width, height int) float64 {
+	const minRun = 8
+	banded := 0
+	scan := func(n, length int, at func(line, i int) byte) {
+		for line := 0; line < n; line++ {
+			prevLen, prevValue, prevMarked := 0, byte(0), false
+			start := 0
+			for i := 1; i <= length; i++ {
+				if i < length && at(line, i) == at(line, start) {
+					continue
+				}
+				runLen, value := i-start, at(line, start)
+				marked := false
+				if runLen >= minRun && prevLen >= minRun {
+					if d := int(value) - int(prevValue); d != 0 && d >= -2 && d <= 2 {
+						marked = true
+						if !prevMarked {
+							banded += prevLen
+						}
+					}
+				}
+				if marked {
+					banded += runLen
+				}
+				prevLen, prevValue, prevMarked = runLen, value, marked
+				start = i
+			}
+		}
+	}
+	scan(height, width, func(y, x int) byte { return frame[y*width+x] })
+	scan(width, height, func(x, y int) byte { return frame[y*width+x] })
+	return float64(banded) / float64(2*width*height)
+}
+
+// DetectArtifacts samples frames of the first video stream of input and scores their
+// blockiness, blur and banding per interval, to spot where an encode degrades. It needs
+// ffmpeg 5.1 or newer for the blockdetect and blurdetect filters
+func DetectArtifacts(ctx context.Context, cfg *Config, input string, opts ArtifactOptions) (*ArtifactReport, error) {
+	interval, fps := opts.Interval, opts.FPS
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	if fps <= 0 {
+		fps = 2
+	}
+	if v, err := DetectVersion(ctx, cfg); err == nil && !v.AtLeast(5, 1) {
+		return nil, ErrUnsupportedVersion
+	}
+	sample := "fps=" + strconv.FormatFloat(fps, 'f', -1, 64) + ",scale=" +
+		strconv.Itoa(artifactWidth) + ":" + strconv.Itoa(artifactHeight) + ",format=gray"
+	stderr, err := run(ctx, cfg, "-i", input, "-map", "0:v:0", "-an", "-sn",
+		"-vf", sample+",blockdetect,blurdetect,metadata=mode=print", "-f", "null", "-")
+	if err != nil {
+		return nil, err
+	}
+	frames := parseFrameMetadata(stderr)
+	raw, err := runOutput(ctx, cfg, "-i", input, "-map", "0:v:0", "-an", "-sn", "-vf", sample, "-f", "rawvideo")
+	if err != nil {
+		return nil, err
+	}
+	size := artifactWidth * artifactHeight
+	if len(frames) == 0 || len(raw) < size {
+		return nil, errors.New("no video frame to analyze")
+	}
+
+	report := &ArtifactReport{}
+	var current *ArtifactScores
+	count, total := 0, 0
+	flush := func() {
+		if current != nil && count > 0 {
+			current.Blockiness /= float64(count)
+			current.Blur /= float64(count)
+			current.Banding /= float64(count)
+			report.Intervals = append(report.Intervals, *current)
+		}
+	}
+	for i, f := range frames {
+		at := time.Duration(f.Time * float64(time.Second))
+		if current == nil || at >= current.End {
+			flush()
+			start := at.Truncate(interval)
+			current, count = &ArtifactScores{Start: start, End: start + interval}, 0
+		}
+		scores := ArtifactScores{Blockiness: f.Values["lavfi.block"], Blur: f.Values["lavfi.blur"]}
+		// the raw frames are the same sampled frames, in the same order
+		if (i+1)*size <= len(raw) {
+			scores.Banding = banding(raw[i*size:(i+1)*size], artifactWidth, artifactHeight)
+		}
+		current.Blockiness += scores.Blockiness
+		current.Blur += scores.Blur
+		current.Banding += scores.Banding
+		report.Overall.Blockiness += scores.Blockiness
+		report.Overall.Blur += scores.Blur
+		report.Overall.Banding += scores.Banding
+		count++
+		total++
+	}
+	flush()
+	report.Overall.Start = report.Intervals[0].Start
+	report.Overall.End = time.Duration((frames[len(frames)-1].Time + 1/fps) * float64(time.Second))
+	report.Overall.Blockiness /= float64(total)
+	report.Overall.Blur /= float64(total)
+	report.Overall.Banding /= float64(total)
+	if last := &report.Intervals[len(report.Intervals)-1]; last.End > report.Overall.End {
+		last.End = report.Overall.End
+	}
+	return report, nil
+}
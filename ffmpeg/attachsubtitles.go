@@ -0,0 +1,95 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// AttachSubtitlesOptions configures AttachSubtitles
+type AttachSubtitlesOptions struct {
+	// Default is the language of the track marked default
+	Default string
+	// Titles are the track titles by language
+	Titles map[string]string
+	// KeepSubtitles keeps the subtitles of the input after the attached tracks
+	KeepSubtitles bool
+}
+
+// subtitleCodec returns the subtitle codec the container of output stores file with
+func subtitleCodec(output, file string) (string, error) {
+	switch strings.ToLower(filepath.Ext(output)) {
+	case ".mp4", ".m4v", ".mov":
+		return "mov_text", nil
+	case ".webm":
+		return "webvtt", nil
+	case ".mkv", ".mka", ".mks":
+		switch strings.ToLower(filepath.Ext(file)) {
+		case ".ass", ".ssa":
+			return "ass", nil
+		case ".vtt":
+			return "webvtt", nil
+		}
+		return "srt", nil
+	}
+	return "", fmt.Errorf("unsupported soft subtitle container %q", filepath.Ext(output))
+}
+
+// AttachSubtitles writes input to output with the subtitle files muxed as soft subtitle
+// tracks, files and forced being keyed by ISO 639-2 language. The subtitle codec follows
+// the container: mov_text for MP4, WebVTT for WebM, SRT or ASS for Matroska
+func AttachSubtitles(ctx context.Context, cfg *Config, input, output string, files map[string]string, forced map[string]bool, opts AttachSubtitlesOptions) error {
+	if len(output) == 0 {
+		return errors.New("missing output option")
+	}
+	if len(files) == 0 {
+		return errors.New("no subtitle file given")
+	}
+	if _, ok := files[opts.Default]; len(opts.Default) > 0 && !ok {
+		return fmt.Errorf("no subtitle file for the default language %q", opts.Default)
+	}
+	languages := make([]string, 0, len(files))
+	for lang := range files {
+		languages = append(languages, lang)
+	}
+	sort.Strings(languages)
+
+	args := []string{"-y", "-i", input}
+	for _, lang := range languages {
+		args = append(args, "-i", files[lang])
+	}
+	args = append(args, "-map", "0:v?", "-map", "0:a?", "-c", "copy", "-map_metadata", "0")
+	for i, lang := range languages {
+		codec, err := subtitleCodec(output, files[lang])
+		if err != nil {
+			return err
+		}
+		n := strconv.Itoa(i)
+		args = append(args, "-map", strconv.Itoa(i+1)+":s:0", "-c:s:"+n, codec, "-metadata:s:s:"+n, "language="+lang)
+		if title := opts.Titles[lang]; len(title) > 0 {
+			args = append(args, "-metadata:s:s:"+n, "title="+title)
+		}
+	}
+	if opts.KeepSubtitles {
+		args = append(args, "-map", "0:s?")
+	}
+	args = append(args, "-disposition:s", "0")
+	for i, lang := range languages {
+		var flags []string
+		if lang == opts.Default {
+			flags = append(flags, "default")
+		}
+		if forced[lang] {
+			flags = append(flags, "forced")
+		}
+		if len(flags) > 0 {
+			args = append(args, "-disposition:s:"+strconv.Itoa(i), strings.Join(flags, "+"))
+		}
+	}
+	_, err := run(ctx, cfg, append(args, output)...)
+	return err
+}
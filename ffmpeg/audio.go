@@ -0,0 +1,126 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// audioFormat holds the encoder defaults of an audio output format
+type audioFormat struct {
+	ext   string
+	muxer string
+	args  []string
+}
+
+// audioFormats ...
+var audioFormats = map[string]audioFormat{
+	"m4a":  {ext: "m4a", muxer: "ipod", args: []string{"-c:a", "aac", "-b:a", "192k"}},
+	"mp3":  {ext: "mp3", muxer: "mp3", args: []string{"-c:a", "libmp3lame", "-q:a", "2"}},
+	"flac": {ext: "flac", muxer: "flac", args: []string{"-c:a", "flac"}},
+	"wav":  {ext: "wav", muxer: "wav", args: []string{"-c:a", "pcm_s16le"}},
+	"opus": {ext: "opus", muxer: "opus", args: []string{"-c:a", "libopus", "-b:a", "128k"}},
+}
+
+// AudioTrack is an audio stream written to its own file
+type AudioTrack struct {
+	// Index of the stream in the input
+	Index    int
+	Language string
+	Title    string
+	Path     string
+}
+
+// audioFormatOf returns the defaults of format, guessed from the extension of output when empty
+func audioFormatOf(format, output string) (audioFormat, error) {
+	format = strings.ToLower(format)
+	if len(format) == 0 {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(output)), ".")
+	}
+	if format == "aac" {
+		format = "m4a"
+	}
+	f, ok := audioFormats[format]
+	if !ok {
+		return audioFormat{}, fmt.Errorf("unsupported audio format %q", format)
+	}
+	return f, nil
+}
+
+// ExtractAudio writes the first audio stream of input to output in format
+// (m4a, mp3, flac, wav or opus), guessed from the output extension when empty
+func ExtractAudio(ctx context.Context, cfg *Config, input, output, format string) error {
+	if len(output) == 0 {
+		return errors.New("missing output option")
+	}
+	f, err := audioFormatOf(format, output)
+	if err != nil {
+		return err
+	}
+	args := []string{"-y", "-i", input, "-map", "0:a:0", "-vn", "-sn", "-dn", "-map_metadata", "0"}
+	args = append(args, f.args...)
+	_, err = run(ctx, cfg, append(args, "-f", f.muxer, output)...)
+	return err
+}
+
+// SplitAudioTracks writes each audio stream of input to its own file in dir,
+// named after the input, the stream position and its language when tagged
+func SplitAudioTracks(ctx context.Context, cfg *Config, input, dir, format string) ([]AudioTrack, error) {
+	if len(dir) == 0 {
+		return nil, errors.New("missing audio tracks output directory")
+	}
+	if len(format) == 0 {
+		format = "m4a"
+	}
+	f, err := audioFormatOf(format, "")
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := probe(ctx, cfg, input)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	base := strings.TrimSuffix(filepath.Base(input), filepath.Ext(input))
+	args := []string{"-y", "-i", input}
+	var tracks []AudioTrack
+	for _, s := range metadata.GetStreams() {
+		if s.GetCodecType() != "audio" {
+			continue
+		}
+		track := AudioTrack{Index: s.GetIndex(), Language: s.GetTags()["language"], Title: s.GetTags()["title"]}
+		name := base + "_track" + strconv.Itoa(len(tracks)+1)
+		if len(track.Language) > 0 && track.Language != "und" {
+			name += "_" + track.Language
+		}
+		track.Path = filepath.Join(dir, name+"."+f.ext)
+		// each output carries the global and stream tags of its source stream
+		args = append(args, "-map", "0:"+strconv.Itoa(track.Index), "-map_metadata", "0", "-map_metadata:s:a:0", "0:s:"+strconv.Itoa(track.Index))
+		args = append(args, f.args...)
+		args = append(args, "-f", f.muxer, track.Path)
+		tracks = append(tracks, track)
+	}
+	if len(tracks) == 0 {
+		return nil, fmt.Errorf("no audio stream in %s", input)
+	}
+	if _, err := run(ctx, cfg, args...); err != nil {
+		return nil, err
+	}
+	return tracks, nil
+}
+
+// audioOutputArguments returns the encoding arguments of output, the defaults of its
+// format for an audio file, otherwise the video is copied and the audio encoded to AAC
+func audioOutputArguments(output string) []string {
+	if f, err := audioFormatOf("", output); err == nil {
+		return append(append([]string{}, f.args...), "-f", f.muxer)
+	}
+	return []string{"-c:v", "copy", "-c:a", "aac", "-b:a", "192k"}
+}
@@ -0,0 +1,148 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// AudiobookChapter is a file of an audiobook, each file is a chapter
+type AudiobookChapter struct {
+	File string
+	// Title defaults to the file name without its extension
+	Title string
+}
+
+// AudiobookOptions configures AssembleAudiobook
+type AudiobookOptions struct {
+	Title    string
+	Author   string
+	Narrator string
+	Album    string
+	Year     string
+	Genre    string
+	Comment  string
+	// Cover is a jpg or png image attached as cover art
+	Cover string
+	// Bitrate of the AAC audio, defaults to 64k
+	Bitrate string
+	// SampleRate defaults to 44100
+	SampleRate int
+	// Podcast tags the file as a podcast instead of an audiobook
+	Podcast bool
+}
+
+// escapeFFMetadata escapes a value of an FFMETADATA file
+func escapeFFMetadata(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, "=", `\=`, ";", `\;`, "#", `\#`, "\n", "\\\n")
+	return r.Replace(s)
+}
+
+// ffmetadata returns the FFMETADATA file of the tags and chapters, chapter
+// durations being in seconds
+func ffmetadata(tags [][2]string, titles []string, durations []float64) string {
+	var b strings.Builder
+	b.WriteString(";FFMETADATA1\n")
+	for _, t := range tags {
+		if len(t[1]) > 0 {
+			b.WriteString(t[0] + "=" + escapeFFMetadata(t[1]) + "\n")
+		}
+	}
+	start := int64(0)
+	total := 0.0
+	for i, title := range titles {
+		total += durations[i]
+		end := int64(total * 1000)
+		fmt.Fprintf(&b, "\n[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=%s\n", start, end, escapeFFMetadata(title))
+		start = end
+	}
+	return b.String()
+}
+
+// AssembleAudiobook concatenates the chapter files into an M4B (or M4A) output with
+// a chapter marker at the start of each file, the tags and the cover art
+func AssembleAudiobook(ctx context.Context, cfg *Config, chapters []AudiobookChapter, output string, opts AudiobookOptions) error {
+	if len(output) == 0 {
+		return errors.New("missing output option")
+	}
+	if len(chapters) == 0 {
+		return errors.New("no chapter given")
+	}
+	titles := make([]string, len(chapters))
+	durations := make([]float64, len(chapters))
+	for i, c := range chapters {
+		duration, _, err := probeDuration(ctx, cfg, c.File)
+		if err != nil {
+			return err
+		}
+		durations[i] = duration
+		titles[i] = c.Title
+		if len(titles[i]) == 0 {
+			titles[i] = strings.TrimSuffix(filepath.Base(c.File), filepath.Ext(c.File))
+		}
+	}
+
+	mediaType := "2"
+	if opts.Podcast {
+		mediaType = "21"
+	}
+	tags := [][2]string{
+		{"title", opts.Title},
+		{"album", opts.Album},
+		{"artist", opts.Author},
+		{"album_artist", opts.Author},
+		{"composer", opts.Narrator},
+		{"date", opts.Year},
+		{"genre", opts.Genre},
+		{"comment", opts.Comment},
+		{"media_type", mediaType},
+	}
+	if len(opts.Album) == 0 {
+		tags[1][1] = opts.Title
+	}
+	f, err := ioutil.TempFile("", "audiobook-*.txt")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(ffmetadata(tags, titles, durations))
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	sampleRate := opts.SampleRate
+	if sampleRate <= 0 {
+		sampleRate = 44100
+	}
+	bitrate := opts.Bitrate
+	if len(bitrate) == 0 {
+		bitrate = "64k"
+	}
+	args := []string{"-y"}
+	var graph []string
+	labels := ""
+	for i, c := range chapters {
+		args = append(args, "-i", c.File)
+		graph = append(graph, fmt.Sprintf("[%d:a:0]aresample=%d[a%d]", i, sampleRate, i))
+		labels += fmt.Sprintf("[a%d]", i)
+	}
+	graph = append(graph, fmt.Sprintf("%sconcat=n=%d:v=0:a=1[out]", labels, len(chapters)))
+	meta := strconv.Itoa(len(chapters))
+	args = append(args, "-f", "ffmetadata", "-i", f.Name())
+	if len(opts.Cover) > 0 {
+		args = append(args, "-i", opts.Cover)
+	}
+	args = append(args, "-filter_complex", strings.Join(graph, ";"), "-map", "[out]", "-map_metadata", meta, "-map_chapters", meta)
+	if len(opts.Cover) > 0 {
+		args = append(args, "-map", strconv.Itoa(len(chapters)+1)+":v:0", "-c:v", "copy", "-disposition:v:0", "attached_pic")
+	}
+	args = append(args, "-c:a", "aac", "-b:a", bitrate, "-movflags", "+faststart", "-f", "mp4", output)
+	_, err = run(ctx, cfg, args...)
+	return err
+}
@@ -0,0 +1,187 @@
+package ffmpeg
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// ShiftAudioOptions configures ShiftAudio
+type ShiftAudioOptions struct {
+	// Copy shifts the audio timestamps without encoding, players may ignore the
+	// resulting start offset, especially for negative offsets
+	Copy bool
+	// AudioCodec and AudioBitrate when encoding, default to aac at 192k
+	AudioCodec   string
+	AudioBitrate string
+}
+
+// SyncOptions configures the estimation of an audio offset
+type SyncOptions struct {
+	// Window is the length of audio compared from the start, defaults to 60s
+	Window time.Duration
+	// MaxOffset is the largest offset searched, defaults to 5s
+	MaxOffset time.Duration
+}
+
+// envelopeRate is the sample rate in Hz of the envelopes correlated by EstimateAudioOffset
+const envelopeRate = 1000
+
+// ShiftAudio writes input to output with its audio shifted by offset against the video,
+// a positive offset delaying the audio and a negative one advancing it
+func ShiftAudio(ctx context.Context, cfg *Config, input, output string, offset time.Duration, opts ShiftAudioOptions) error {
+	if len(output) == 0 {
+		return errors.New("missing output option")
+	}
+	var args []string
+	if opts.Copy {
+		// the second reading of input provides the shifted audio
+		args = []string{"-y", "-i", input, "-itsoffset", seconds(offset), "-i", input,
+			"-map", "0:v?", "-map", "1:a", "-map", "0:s?", "-c", "copy", output}
+		_, err := run(ctx, cfg, args...)
+		return err
+	}
+
+	var filter string
+	switch {
+	case offset > 0:
+		ms := strconv.FormatInt(offset.Milliseconds(), 10)
+		filter = "adelay=" + ms + ":all=1"
+		if v, err := DetectVersion(ctx, cfg); err == nil && !v.AtLeast(4, 2) {
+			// before 4.2 adelay takes one delay per channel, extra delays are ignored
+			filter = "adelay=" + ms + "|" + ms + "|" + ms + "|" + ms + "|" + ms + "|" + ms + "|" + ms + "|" + ms
+		}
+	case offset < 0:
+		filter = "atrim=start=" + seconds(-offset) + ",asetpts=PTS-STARTPTS"
+	default:
+		filter = "anull"
+	}
+	codec, bitrate := opts.AudioCodec, opts.AudioBitrate
+	if len(codec) == 0 {
+		codec = "aac"
+	}
+	if len(bitrate) == 0 {
+		bitrate = "192k"
+	}
+	args = []string{"-y", "-i", input, "-map", "0:v?", "-map", "0:a", "-map", "0:s?", "-c", "copy",
+		"-af", filter, "-c:a", codec, "-b:a", bitrate, output}
+	_, err := run(ctx, cfg, args...)
+	return err
+}
+
+// envelope decodes the first window of the audio of input and returns its
+// amplitude envelope at envelopeRate
+func envelope(ctx context.Context, cfg *Config, input string, window time.Duration) ([]float64, error) {
+	if cfg.FfmpegBinPath == "" {
+		return nil, errors.New("ffmpeg binary path not found")
+	}
+	const rate = 8000
+	args := []string{"-nostdin", "-hide_banner", "-i", input, "-t", seconds(window), "-map", "0:a:0",
+		"-ac", "1", "-ar", strconv.Itoa(rate), "-f", "s16le", "-c:a", "pcm_s16le", "-"}
+	var stdout, stderr bytes.Buffer
+	cmd := command(ctx, cfg, cfg.FfmpegBinPath, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("failed to execute (%s) with args (%s) with error %w | message: %s", cfg.FfmpegBinPath, redact(args), err, tail(stderr.Bytes()))
+	}
+	samples := make([]int16, stdout.Len()/2)
+	if err := binary.Read(&stdout, binary.LittleEndian, samples); err != nil {
+		return nil, err
+	}
+	block := rate / envelopeRate
+	env := make([]float64, len(samples)/block)
+	for i := range env {
+		sum := 0.0
+		for _, s := range samples[i*block : (i+1)*block] {
+			sum += math.Abs(float64(s))
+		}
+		env[i] = sum / float64(block)
+	}
+	return env, nil
+}
+
+// EstimateAudioOffset estimates how late the audio of input is against the audio of
+// reference by cross-correlating their envelopes, with a resolution of 1ms. The
+// confidence is the normalized correlation at that offset, from -1 to 1
+func EstimateAudioOffset(ctx context.Context, cfg *Config, reference, input string, opts SyncOptions) (time.Duration, float64, error) {
+	window, maxOffset := opts.Window, opts.MaxOffset
+	if window <= 0 {
+		window = time.Minute
+	}
+	if maxOffset <= 0 {
+		maxOffset = 5 * time.Second
+	}
+	ref, err := envelope(ctx, cfg, reference, window)
+	if err != nil {
+		return 0, 0, err
+	}
+	in, err := envelope(ctx, cfg, input, window+maxOffset)
+	if err != nil {
+		return 0, 0, err
+	}
+	maxLag := int(maxOffset.Milliseconds() * envelopeRate / 1000)
+	lag, score := correlate(ref, in, maxLag)
+	if lag == 0 && score == 0 {
+		return 0, 0, errors.New("audio too short or silent to estimate an offset")
+	}
+	return time.Duration(lag) * time.Second / envelopeRate, score, nil
+}
+
+// correlate returns the lag in [-maxLag, maxLag] maximizing the normalized
+// correlation of a[i] with b[i+lag], and that correlation
+func correlate(a, b []float64, maxLag int) (int, float64) {
+	bestLag, best := 0, 0.0
+	found := false
+	for lag := -maxLag; lag <= maxLag; lag++ {
+		start, end := 0, len(a)
+		if lag < 0 {
+			start = -lag
+		}
+		if len(b)-lag < end {
+			end = len(b) - lag
+		}
+		// at least one second of overlap
+		if end-start < envelopeRate {
+			continue
+		}
+		var sa, sb float64
+		for i := start; i < end; i++ {
+			sa += a[i]
+			sb += b[i+lag]
+		}
+		n := float64(end - start)
+		ma, mb := sa/n, sb/n
+		var cov, va, vb float64
+		for i := start; i < end; i++ {
+			da, db := a[i]-ma, b[i+lag]-mb
+			cov += da * db
+			va += da * da
+			vb += db * db
+		}
+		if va == 0 || vb == 0 {
+			continue
+		}
+		r := cov / math.Sqrt(va*vb)
+		if !found || r > best {
+			bestLag, best, found = lag, r, true
+		}
+	}
+	return bestLag, best
+}
+
+// AutoShiftAudio estimates the offset of the audio of input against reference, audio
+// known to be in sync with the video (e.g. a camera scratch track), and writes input
+// to output with that offset corrected. It returns the applied shift
+func AutoShiftAudio(ctx context.Context, cfg *Config, input, reference, output string, sync SyncOptions, opts ShiftAudioOptions) (time.Duration, error) {
+	offset, _, err := EstimateAudioOffset(ctx, cfg, reference, input, sync)
+	if err != nil {
+		return 0, err
+	}
+	return -offset, ShiftAudio(ctx, cfg, input, output, -offset, opts)
+}
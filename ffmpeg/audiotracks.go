@@ -0,0 +1,140 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// AudioTrackInput is an audio track muxed by MuxAudioTracks
+type AudioTrackInput struct {
+	// Input is the file holding the track
+	Input string
+	// Stream selects the stream of Input, defaults to a:0
+	Stream string
+	// Language is the ISO 639-2 code of the track (eng, fra, deu...)
+	Language string
+	Title    string
+	// Codec of the track, defaults to copy
+	Codec   string
+	Bitrate string
+	// Default marks the track played when the player has no language preference
+	Default bool
+	// Forced, Dub, Original, Comment and VisualImpaired set the matching dispositions
+	Forced         bool
+	Dub            bool
+	Original       bool
+	Comment        bool
+	VisualImpaired bool
+}
+
+// MuxAudioTracksOptions configures MuxAudioTracks
+type MuxAudioTracksOptions struct {
+	// KeepAudio keeps the audio of the video input after the added tracks
+	KeepAudio bool
+	// KeepSubtitles copies the subtitles of the video input
+	KeepSubtitles bool
+	// VideoCodec defaults to copy
+	VideoCodec string
+}
+
+// disposition returns the -disposition value of the track
+func (a AudioTrackInput) disposition() string {
+	var flags []string
+	for _, f := range []struct {
+		set  bool
+		name string
+	}{
+		{a.Default, "default"},
+		{a.Forced, "forced"},
+		{a.Dub, "dub"},
+		{a.Original, "original"},
+		{a.Comment, "comment"},
+		{a.VisualImpaired, "visual_impaired"},
+	} {
+		if f.set {
+			flags = append(flags, f.name)
+		}
+	}
+	if len(flags) == 0 {
+		return "0"
+	}
+	return strings.Join(flags, "+")
+}
+
+// audioTrackArguments returns the input, map, codec, metadata and disposition arguments
+// of tracks, the first being input number first of the command
+func audioTrackArguments(first int, tracks []AudioTrackInput) ([]string, []string, error) {
+	var inputs, outputs []string
+	defaults := 0
+	for i, track := range tracks {
+		if len(track.Input) == 0 {
+			return nil, nil, fmt.Errorf("missing input of audio track %d", i)
+		}
+		if track.Default {
+			defaults++
+		}
+		stream := track.Stream
+		if len(stream) == 0 {
+			stream = "a:0"
+		}
+		codec := track.Codec
+		if len(codec) == 0 {
+			codec = "copy"
+		}
+		n := strconv.Itoa(i)
+		inputs = append(inputs, "-i", track.Input)
+		outputs = append(outputs, "-map", strconv.Itoa(first+i)+":"+stream, "-c:a:"+n, codec)
+		if len(track.Bitrate) > 0 {
+			outputs = append(outputs, "-b:a:"+n, track.Bitrate)
+		}
+		if len(track.Language) > 0 {
+			outputs = append(outputs, "-metadata:s:a:"+n, "language="+track.Language)
+		}
+		if len(track.Title) > 0 {
+			outputs = append(outputs, "-metadata:s:a:"+n, "title="+track.Title)
+		}
+	}
+	if defaults > 1 {
+		return nil, nil, errors.New("more than one default audio track")
+	}
+	// dispositions are reset first so no kept or copied flag conflicts with the requested ones
+	outputs = append(outputs, "-disposition:a", "0")
+	for i, track := range tracks {
+		outputs = append(outputs, "-disposition:a:"+strconv.Itoa(i), track.disposition())
+	}
+	return inputs, outputs, nil
+}
+
+// MuxAudioTracks writes the video of video together with tracks to output, each track
+// tagged with its language, title and dispositions
+func MuxAudioTracks(ctx context.Context, cfg *Config, video, output string, tracks []AudioTrackInput, opts MuxAudioTracksOptions) error {
+	if len(output) == 0 {
+		return errors.New("missing output option")
+	}
+	if len(tracks) == 0 {
+		return errors.New("no audio track given")
+	}
+	inputs, outputs, err := audioTrackArguments(1, tracks)
+	if err != nil {
+		return err
+	}
+	videoCodec := opts.VideoCodec
+	if len(videoCodec) == 0 {
+		videoCodec = "copy"
+	}
+	args := append([]string{"-y", "-i", video}, inputs...)
+	// the per track codecs override the copy of the kept audio
+	args = append(args, "-map", "0:v?", "-map_metadata", "0", "-c:v", videoCodec, "-c:a", "copy")
+	args = append(args, outputs...)
+	if opts.KeepAudio {
+		args = append(args, "-map", "0:a?")
+	}
+	if opts.KeepSubtitles {
+		args = append(args, "-map", "0:s?", "-c:s", "copy")
+	}
+	_, err = run(ctx, cfg, append(args, output)...)
+	return err
+}
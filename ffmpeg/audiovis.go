@@ -0,0 +1,114 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// WaveformOptions configures a waveform image
+type WaveformOptions struct {
+	// Width and Height default to 1800x140
+	Width  int
+	Height int
+	// Colors of the channels, e.g. "#3b82f6" or "white|red" for stereo, defaults to gray
+	Colors string
+	// SplitChannels draws each channel in its own row
+	SplitChannels bool
+	// Scale is the amplitude scale: lin (default), log, sqrt or cbrt
+	Scale string
+	// Filter is average (default, smoother) or peak
+	Filter string
+	// Mono mixes the channels down before drawing
+	Mono bool
+}
+
+// SpectrogramOptions configures a spectrogram image
+type SpectrogramOptions struct {
+	// Width and Height default to 1024x512
+	Width  int
+	Height int
+	// Color is the color scheme (intensity, rainbow, magma, viridis...), defaults to intensity
+	Color string
+	// Scale is the intensity scale: log (default), lin, sqrt, cbrt, 4thrt or 5thrt
+	Scale string
+	// SplitChannels draws each channel separately, otherwise they are combined
+	SplitChannels bool
+	// Legend draws the time and frequency axes
+	Legend bool
+}
+
+// colorValue converts CSS style #rrggbb colors to the 0xrrggbb notation of ffmpeg
+func colorValue(color string) string {
+	parts := strings.Split(color, "|")
+	for i, p := range parts {
+		if strings.HasPrefix(p, "#") {
+			parts[i] = "0x" + p[1:]
+		}
+	}
+	return strings.Join(parts, "|")
+}
+
+// imageSize ...
+func imageSize(width, height, defaultWidth, defaultHeight int) string {
+	if width <= 0 {
+		width = defaultWidth
+	}
+	if height <= 0 {
+		height = defaultHeight
+	}
+	return fmt.Sprintf("%dx%d", width, height)
+}
+
+// Waveform renders the waveform of the audio of input as a PNG image
+func Waveform(ctx context.Context, cfg *Config, input, output string, opts WaveformOptions) error {
+	if len(output) == 0 {
+		return errors.New("missing output option")
+	}
+	colors := opts.Colors
+	if len(colors) == 0 {
+		colors = "gray"
+	}
+	filter := "showwavespic=s=" + imageSize(opts.Width, opts.Height, 1800, 140) + ":colors=" + colorValue(colors)
+	if opts.SplitChannels && !opts.Mono {
+		filter += ":split_channels=1"
+	}
+	if len(opts.Scale) > 0 {
+		filter += ":scale=" + opts.Scale
+	}
+	if len(opts.Filter) > 0 {
+		filter += ":filter=" + opts.Filter
+	}
+	if opts.Mono {
+		filter = "aformat=channel_layouts=mono," + filter
+	}
+	_, err := run(ctx, cfg, "-y", "-i", input, "-filter_complex", "[0:a:0]"+filter, "-frames:v", "1", "-c:v", "png", "-f", "image2", output)
+	return err
+}
+
+// Spectrogram renders the spectrogram of the audio of input as a PNG image
+func Spectrogram(ctx context.Context, cfg *Config, input, output string, opts SpectrogramOptions) error {
+	if len(output) == 0 {
+		return errors.New("missing output option")
+	}
+	color := opts.Color
+	if len(color) == 0 {
+		color = "intensity"
+	}
+	scale := opts.Scale
+	if len(scale) == 0 {
+		scale = "log"
+	}
+	mode := "combined"
+	if opts.SplitChannels {
+		mode = "separate"
+	}
+	legend := "0"
+	if opts.Legend {
+		legend = "1"
+	}
+	filter := "showspectrumpic=s=" + imageSize(opts.Width, opts.Height, 1024, 512) + ":mode=" + mode + ":color=" + color + ":scale=" + scale + ":legend=" + legend
+	_, err := run(ctx, cfg, "-y", "-i", input, "-filter_complex", "[0:a:0]"+filter, "-frames:v", "1", "-c:v", "png", "-f", "image2", output)
+	return err
+}
@@ -0,0 +1,156 @@
+package ffmpeg
+
+import (
+	"context"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// BatchJob is a tiny job, such as a thumbnail or a short clip, run by a Batcher
+// along others in a single ffmpeg process
+type BatchJob struct {
+	// InputArgs are the options of the input, such as -ss
+	InputArgs []string
+	Input     string
+	// Streams are the stream specifiers of the input mapped to the output, such as
+	// v:0, every stream when empty. -map must not be part of Args
+	Streams []string
+	// Args are the options of the output, such as -frames:v 1
+	Args   []string
+	Output string
+}
+
+// BatchConfig ...
+type BatchConfig struct {
+	// MaxJobs is the number of jobs of a batch, defaults to 32
+	MaxJobs int
+	// MaxWait is the time the first job of a batch waits for others, defaults to 50ms
+	MaxWait time.Duration
+	// Workers is the number of batches running at once, defaults to the number of CPUs
+	Workers int
+}
+
+// Batcher amortizes the startup of ffmpeg over many sub-second jobs: ffmpeg takes no
+// job once started, so the jobs submitted together run as the inputs and outputs of
+// one process. When a batch fails its jobs run one by one, so that a bad input only
+// fails its own job
+type Batcher struct {
+	cfg     *Config
+	config  BatchConfig
+	workers chan struct{}
+
+	mu      sync.Mutex
+	pending []*batchEntry
+	timer   *time.Timer
+}
+
+// batchEntry is a submitted job
+type batchEntry struct {
+	job  BatchJob
+	done chan error
+}
+
+// NewBatcher ...
+func NewBatcher(cfg *Config, config BatchConfig) *Batcher {
+	if config.MaxJobs <= 0 {
+		config.MaxJobs = 32
+	}
+	if config.MaxWait <= 0 {
+		config.MaxWait = 50 * time.Millisecond
+	}
+	if config.Workers <= 0 {
+		config.Workers = runtime.NumCPU()
+	}
+	return &Batcher{cfg: cfg, config: config, workers: make(chan struct{}, config.Workers)}
+}
+
+// Do runs job in the next batch and waits for it. The batch goes on when ctx is
+// done, only the wait being abandoned
+func (b *Batcher) Do(ctx context.Context, job BatchJob) error {
+	entry := &batchEntry{job: job, done: make(chan error, 1)}
+	b.mu.Lock()
+	b.pending = append(b.pending, entry)
+	if len(b.pending) >= b.config.MaxJobs {
+		b.flushLocked()
+	} else if b.timer == nil {
+		b.timer = time.AfterFunc(b.config.MaxWait, b.flush)
+	}
+	b.mu.Unlock()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	select {
+	case err := <-entry.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// flush runs the pending jobs
+func (b *Batcher) flush() {
+	b.mu.Lock()
+	b.flushLocked()
+	b.mu.Unlock()
+}
+
+// flushLocked runs the pending jobs, b.mu being held
+func (b *Batcher) flushLocked() {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if len(b.pending) == 0 {
+		return
+	}
+	batch := b.pending
+	b.pending = nil
+	go func() {
+		b.workers <- struct{}{}
+		defer func() { <-b.workers }()
+		b.run(batch)
+	}()
+}
+
+// run runs a batch, then its jobs one by one when it failed
+func (b *Batcher) run(batch []*batchEntry) {
+	jobs := make([]BatchJob, len(batch))
+	for i, entry := range batch {
+		jobs[i] = entry.job
+	}
+	_, err := run(context.Background(), b.cfg, batchArgs(jobs)...)
+	if err == nil || len(batch) == 1 {
+		for _, entry := range batch {
+			entry.done <- err
+		}
+		return
+	}
+	for _, entry := range batch {
+		_, err := run(context.Background(), b.cfg, batchArgs([]BatchJob{entry.job})...)
+		entry.done <- err
+	}
+}
+
+// batchArgs returns the arguments running jobs in one process, the input of each
+// job being mapped to its output
+func batchArgs(jobs []BatchJob) []string {
+	args := []string{"-y"}
+	for _, job := range jobs {
+		args = append(args, job.InputArgs...)
+		args = append(args, "-i", job.Input)
+	}
+	for i, job := range jobs {
+		index := strconv.Itoa(i)
+		if len(job.Streams) == 0 {
+			args = append(args, "-map", index)
+		}
+		for _, s := range job.Streams {
+			args = append(args, "-map", index+":"+s)
+		}
+		args = append(args, job.Args...)
+		args = append(args, job.Output)
+	}
+	return args
+}
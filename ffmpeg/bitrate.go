@@ -0,0 +1,166 @@
+package ffmpeg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// BitrateOptions configures AnalyzeBitrate
+type BitrateOptions struct {
+	// Stream is the analyzed stream specifier, defaults to "v:0"
+	Stream string
+	// Window is the length of the bitrate samples, defaults to 1s
+	Window time.Duration
+	// FrameTypes decodes the stream to count its I, P and B frames, which takes about as
+	// long as a decode of the stream
+	FrameTypes bool
+}
+
+// GOP is a group of pictures, from a keyframe to the next
+type GOP struct {
+	Start    time.Duration `json:"start"`
+	Duration time.Duration `json:"duration"`
+	Frames   int           `json:"frames"`
+	Size     int64         `json:"size"` // bytes
+}
+
+// BitrateAnalysis is the bitrate and GOP structure of a stream
+type BitrateAnalysis struct {
+	Duration time.Duration `json:"duration"`
+	// Bitrates are the bitrates in bits per second of each window
+	Bitrates       []int64 `json:"bitrates"`
+	AverageBitrate int64   `json:"average_bitrate"`
+	PeakBitrate    int64   `json:"peak_bitrate"`
+	GOPs           []GOP   `json:"gops"`
+	MinGOP         int     `json:"min_gop"`
+	MaxGOP         int     `json:"max_gop"`
+	// KeyframeInterval is the mean interval between keyframes, the last GOP excluded,
+	// with its standard deviation
+	KeyframeInterval          time.Duration `json:"keyframe_interval"`
+	KeyframeIntervalDeviation time.Duration `json:"keyframe_interval_deviation"`
+	// FixedGOP is set when every GOP but the last has the same number of frames,
+	// as ABR renditions segmented on keyframes require
+	FixedGOP bool `json:"fixed_gop"`
+	// FrameTypes counts the frames by picture type (I, P, B...), with BitrateOptions.FrameTypes
+	FrameTypes map[string]int `json:"frame_types,omitempty"`
+}
+
+// probeFrameTypes counts the decoded frames of stream by picture type
+func probeFrameTypes(ctx context.Context, cfg *Config, input, stream string) (map[string]int, error) {
+	out, err := probeEntries(ctx, cfg, input, "-select_streams", stream,
+		"-show_entries", "frame=pict_type", "-of", "csv=p=0")
+	if err != nil {
+		return nil, err
+	}
+	types := map[string]int{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		if t := strings.Trim(strings.TrimSpace(scanner.Text()), ","); len(t) > 0 {
+			types[t]++
+		}
+	}
+	return types, scanner.Err()
+}
+
+// AnalyzeBitrate returns the bitrate over time and the GOP structure of a stream of
+// input, read from its packets without decoding
+func AnalyzeBitrate(ctx context.Context, cfg *Config, input string, opts BitrateOptions) (*BitrateAnalysis, error) {
+	stream, window := opts.Stream, opts.Window
+	if len(stream) == 0 {
+		stream = "v:0"
+	}
+	if window <= 0 {
+		window = time.Second
+	}
+	packets, err := probePackets(ctx, cfg, input, stream)
+	if err != nil {
+		return nil, err
+	}
+	if len(packets) == 0 {
+		return nil, fmt.Errorf("no packets in stream %s of %s", stream, input)
+	}
+	duration := func(s float64) time.Duration { return time.Duration(s * float64(time.Second)) }
+	// GOPs follow the decoding order, bitrates the presentation order
+	a := &BitrateAnalysis{}
+	start, end := math.Inf(1), math.Inf(-1)
+	for _, p := range packets {
+		start = math.Min(start, p.PTS)
+		end = math.Max(end, p.PTS+p.Duration)
+	}
+	for _, p := range packets {
+		if p.Key || len(a.GOPs) == 0 {
+			a.GOPs = append(a.GOPs, GOP{Start: duration(p.PTS - start)})
+		}
+		g := &a.GOPs[len(a.GOPs)-1]
+		g.Frames++
+		g.Size += int64(p.Size)
+	}
+	for i := range a.GOPs {
+		if i+1 < len(a.GOPs) {
+			a.GOPs[i].Duration = a.GOPs[i+1].Start - a.GOPs[i].Start
+		} else {
+			a.GOPs[i].Duration = duration(end-start) - a.GOPs[i].Start
+		}
+	}
+
+	a.Duration = duration(end - start)
+	bins := make([]int64, int(math.Ceil(a.Duration.Seconds()/window.Seconds())))
+	if len(bins) == 0 {
+		bins = make([]int64, 1)
+	}
+	var total int64
+	for _, p := range packets {
+		i := int((p.PTS - start) / window.Seconds())
+		if i >= len(bins) {
+			i = len(bins) - 1
+		}
+		bins[i] += int64(p.Size) * 8
+		total += int64(p.Size) * 8
+	}
+	a.Bitrates = make([]int64, len(bins))
+	for i, b := range bins {
+		a.Bitrates[i] = int64(float64(b) / window.Seconds())
+		if a.Bitrates[i] > a.PeakBitrate {
+			a.PeakBitrate = a.Bitrates[i]
+		}
+	}
+	if a.Duration > 0 {
+		a.AverageBitrate = int64(float64(total) / a.Duration.Seconds())
+	}
+
+	// the last GOP is usually cut short by the end of the stream
+	full := a.GOPs
+	if len(full) > 1 {
+		full = full[:len(full)-1]
+	}
+	a.MinGOP, a.MaxGOP = math.MaxInt32, 0
+	var sum, squares float64
+	for _, g := range full {
+		if g.Frames < a.MinGOP {
+			a.MinGOP = g.Frames
+		}
+		if g.Frames > a.MaxGOP {
+			a.MaxGOP = g.Frames
+		}
+		sum += g.Duration.Seconds()
+	}
+	mean := sum / float64(len(full))
+	for _, g := range full {
+		squares += (g.Duration.Seconds() - mean) * (g.Duration.Seconds() - mean)
+	}
+	a.KeyframeInterval = duration(mean)
+	a.KeyframeIntervalDeviation = duration(math.Sqrt(squares / float64(len(full))))
+	a.FixedGOP = a.MinGOP == a.MaxGOP
+
+	if opts.FrameTypes {
+		if a.FrameTypes, err = probeFrameTypes(ctx, cfg, input, stream); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
@@ -0,0 +1,306 @@
+package ffmpeg
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/admpub/transcoder"
+)
+
+// BundleConfig records a reproducibility bundle for each transcode, holding what is
+// needed to replay a failure reported days later:
+//
+//	command.json  argv, Config.Env redacted, directory, exit code, error and usage
+//	command.sh    the command line, with the secrets redacted
+//	version.txt   the output of ffmpeg -version
+//	probe.json    the ffprobe output of the input, unless the probe is skipped, redacted
+//	stderr.log    the end of what ffmpeg wrote on stderr
+type BundleConfig struct {
+	// Dir receives a bundle per run, named after the job.id log field when the
+	// context has one, see transcoder.WithLogFields, and the start time
+	Dir string
+	// Tar writes each bundle as a <name>.tar file instead of a directory
+	Tar bool
+	// FailuresOnly records the failed runs only
+	FailuresOnly bool
+	// MaxStderr is the amount of stderr kept, the end of it, defaults to 1MiB
+	MaxStderr int
+}
+
+// BundleCommand is the command.json file of a bundle
+type BundleCommand struct {
+	Started   time.Time     `json:"started"`
+	Finished  time.Time     `json:"finished"`
+	Binary    string        `json:"binary"`
+	Args      []string      `json:"args"`
+	Env       []string      `json:"env"`
+	Dir       string        `json:"dir,omitempty"`
+	Input     string        `json:"input"`
+	Outputs   []string      `json:"outputs"`
+	Version   string        `json:"version,omitempty"`
+	LogFields []interface{} `json:"log_fields,omitempty"`
+	ExitCode  int           `json:"exit_code"`
+	// Usage is the resources used by the process
+	Usage *transcoder.Usage `json:"usage,omitempty"`
+	Error string            `json:"error,omitempty"`
+}
+
+// bundleSeq makes the names of the bundles started in the same second unique
+var bundleSeq uint64
+
+var banners = struct {
+	sync.Mutex
+	cache map[string]string
+}{cache: map[string]string{}}
+
+// unsafeName matches the characters replaced in bundle names
+var unsafeName = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// tailBuffer keeps the last max bytes written to it
+type tailBuffer struct {
+	mu  sync.Mutex
+	max int
+	buf []byte
+}
+
+// Write ...
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > b.max {
+		b.buf = append(b.buf[:0], b.buf[len(b.buf)-b.max:]...)
+	}
+	return len(p), nil
+}
+
+// Bytes ...
+func (b *tailBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte{}, b.buf...)
+}
+
+// bundleRecorder records the bundle of a run
+type bundleRecorder struct {
+	config  BundleConfig
+	ctx     context.Context
+	cfg     *Config
+	command BundleCommand
+	probe   []byte
+	stderr  *tailBuffer
+}
+
+// recorder returns the recorder of the run of cmd, nil when no bundle is configured
+func (t *Transcoder) recorder(cmd *exec.Cmd, args []string) *bundleRecorder {
+	if t.config.Bundle == nil || len(t.config.Bundle.Dir) == 0 {
+		return nil
+	}
+	config := *t.config.Bundle
+	if config.MaxStderr <= 0 {
+		config.MaxStderr = 1 << 20
+	}
+	return &bundleRecorder{
+		config: config,
+		ctx:    t.commandContext,
+		cfg:    t.config,
+		command: BundleCommand{
+			Started:   time.Now(),
+			Binary:    t.config.FfmpegBinPath,
+			Args:      redact(args, t.secrets...),
+			Env:       redactEnv(t.config.Env, t.secrets...),
+			Dir:       cmd.Dir,
+			Input:     redact([]string{t.input}, t.secrets...)[0],
+			Outputs:   redact(t.output, t.secrets...),
+			LogFields: transcoder.LogFields(t.commandContext),
+		},
+		probe:  redactProbe(t.probeJSON, t.input, t.secrets...),
+		stderr: &tailBuffer{max: config.MaxStderr},
+	}
+}
+
+// redactProbe returns the ffprobe output with the input URL, which format.filename
+// holds, and the secrets masked
+func redactProbe(probe []byte, input string, secrets ...string) []byte {
+	masked := redact([]string{input}, secrets...)[0]
+	if len(probe) == 0 {
+		return probe
+	}
+	probe = bytes.Replace(probe, []byte(input), []byte(masked), -1)
+	// the input as escaped in a JSON string
+	if escaped, err := json.Marshal(input); err == nil {
+		masked, _ := json.Marshal(masked)
+		probe = bytes.Replace(probe, escaped[1:len(escaped)-1], masked[1:len(masked)-1], -1)
+	}
+	for _, secret := range secrets {
+		if len(secret) > 0 {
+			probe = bytes.Replace(probe, []byte(secret), []byte(redacted), -1)
+		}
+	}
+	return probe
+}
+
+// teeReader returns stderr copied into the bundle as it is read
+func (r *bundleRecorder) teeReader(stderr io.ReadCloser) io.ReadCloser {
+	if r == nil {
+		return stderr
+	}
+	return teeReadCloser(stderr, r.stderr)
+}
+
+// writer returns w, which may be nil, copied into the bundle
+func (r *bundleRecorder) writer(w io.Writer) io.Writer {
+	if r == nil {
+		return w
+	}
+	if w == nil {
+		return r.stderr
+	}
+	return io.MultiWriter(w, r.stderr)
+}
+
+// finish writes the bundle of the run that ended with err, logging the failures to
+// write it. r may be nil
+func (r *bundleRecorder) finish(err error) {
+	if r == nil || (err == nil && r.config.FailuresOnly) {
+		return
+	}
+	r.command.Finished = time.Now()
+	r.command.ExitCode = 0
+	if err != nil {
+		r.command.ExitCode, r.command.Error = exitCode(err), err.Error()
+	}
+	version := banner(r.cfg)
+	if i := strings.IndexByte(version, '\n'); i > 0 {
+		r.command.Version = version[:i]
+	} else {
+		r.command.Version = version
+	}
+	path, werr := r.write(version)
+	log := logger(r.ctx, r.cfg)
+	if werr != nil {
+		log.Log(transcoder.LevelWarn, "failed to write reproducibility bundle", "error", werr)
+		return
+	}
+	log.Log(transcoder.LevelDebug, "wrote reproducibility bundle", "path", path)
+}
+
+// name returns the name of the bundle
+func (r *bundleRecorder) name() string {
+	name := r.command.Started.UTC().Format("20060102T150405Z") + "-" + strconv.FormatUint(atomic.AddUint64(&bundleSeq, 1), 10)
+	fields := r.command.LogFields
+	for i := 0; i+1 < len(fields); i += 2 {
+		if fields[i] == "job.id" {
+			name = fmt.Sprint(fields[i+1]) + "-" + name
+			break
+		}
+	}
+	return unsafeName.ReplaceAllString(name, "_")
+}
+
+// write writes the bundle and returns its path
+func (r *bundleRecorder) write(version string) (string, error) {
+	command, err := json.MarshalIndent(r.command, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{"command.json", command},
+		{"command.sh", []byte("#!/bin/sh\n" + shellJoin(append([]string{r.command.Binary}, r.command.Args...)) + "\n")},
+		{"version.txt", []byte(version)},
+		{"probe.json", r.probe},
+		{"stderr.log", r.stderr.Bytes()},
+	}
+	if err := os.MkdirAll(r.config.Dir, os.ModePerm); err != nil {
+		return "", err
+	}
+	path := filepath.Join(r.config.Dir, r.name())
+	if !r.config.Tar {
+		if err := os.Mkdir(path, os.ModePerm); err != nil {
+			return "", err
+		}
+		for _, f := range files {
+			if f.data == nil {
+				continue
+			}
+			if err := ioutil.WriteFile(filepath.Join(path, f.name), f.data, 0644); err != nil {
+				return "", err
+			}
+		}
+		return path, nil
+	}
+
+	path += ".tar"
+	var b bytes.Buffer
+	w := tar.NewWriter(&b)
+	for _, f := range files {
+		if f.data == nil {
+			continue
+		}
+		header := &tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.data)), ModTime: r.command.Finished}
+		if err := w.WriteHeader(header); err != nil {
+			return "", err
+		}
+		if _, err := w.Write(f.data); err != nil {
+			return "", err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return path, ioutil.WriteFile(path, b.Bytes(), 0644)
+}
+
+// banner returns the output of ffmpeg -version, with the build configuration, cached
+// per binary
+func banner(cfg *Config) string {
+	banners.Lock()
+	b, ok := banners.cache[cfg.FfmpegBinPath]
+	banners.Unlock()
+	if ok {
+		return b
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	output, err := command(ctx, cfg, cfg.FfmpegBinPath, "-version").Output()
+	if err != nil {
+		return fmt.Sprintf("failed to execute (%s) with args (-version) with error %v", cfg.FfmpegBinPath, err)
+	}
+	b = string(bytes.TrimSpace(output))
+	banners.Lock()
+	banners.cache[cfg.FfmpegBinPath] = b
+	banners.Unlock()
+	return b
+}
+
+// shellJoin returns args as a POSIX shell command line
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		if len(a) > 0 && strings.IndexFunc(a, func(r rune) bool {
+			return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:=,+@%", r))
+		}) < 0 {
+			quoted[i] = a
+			continue
+		}
+		quoted[i] = "'" + strings.Replace(a, "'", `'\''`, -1) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,93 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/admpub/transcoder"
+)
+
+// HasClosedCaptions reports whether a video stream of metadata carries embedded
+// CEA-608/708 captions (A/53 side data)
+func HasClosedCaptions(metadata transcoder.Metadata) bool {
+	for _, s := range metadata.GetStreams() {
+		if s.GetCodecType() == "video" && s.GetClosedCaptions() > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// ClosedCaptionsOptions controls the embedded captions of a transcode. It implements
+// transcoder.Options and goes with the other options of the output
+type ClosedCaptionsOptions struct {
+	// Strip removes the captions, they are preserved otherwise
+	Strip bool
+	// VideoCodec is the output video encoder, copy or empty when the video is copied
+	VideoCodec string
+	// SourceCodec is the codec of the source video (h264, hevc), used to strip captions from copied video
+	SourceCodec string
+}
+
+// GetStrArguments ...
+func (o ClosedCaptionsOptions) GetStrArguments() []string {
+	a53 := "1"
+	if o.Strip {
+		a53 = "0"
+	}
+	switch o.VideoCodec {
+	case "libx264", "libx265", "h264_nvenc", "hevc_nvenc", "h264_qsv", "hevc_qsv", "h264_vaapi", "hevc_vaapi":
+		// these encoders write the decoded A/53 side data as SEI messages
+		return []string{"-a53cc", a53}
+	case "", "copy":
+		if !o.Strip {
+			return nil
+		}
+		// the captions are SEI NAL units, removing them drops the other SEI messages too
+		switch o.SourceCodec {
+		case "h264":
+			return []string{"-bsf:v", "filter_units=remove_types=6"}
+		case "hevc":
+			return []string{"-bsf:v", "filter_units=remove_types=39|40"}
+		}
+	}
+	return nil
+}
+
+// ExtractClosedCaptions writes the embedded captions of the first video stream of input
+// to output, as SRT, WebVTT or SCC following its extension. SCC keeps the raw CEA-608
+// data and needs ffmpeg 4.4 or later
+func ExtractClosedCaptions(ctx context.Context, cfg *Config, input, output string) error {
+	if len(output) == 0 {
+		return errors.New("missing output option")
+	}
+	metadata, err := probe(ctx, cfg, input)
+	if err != nil {
+		return err
+	}
+	if !HasClosedCaptions(metadata) {
+		return fmt.Errorf("no closed captions in %s", input)
+	}
+	var codec []string
+	switch strings.ToLower(filepath.Ext(output)) {
+	case ".srt":
+		codec = []string{"-c:s", "srt", "-f", "srt"}
+	case ".vtt":
+		codec = []string{"-c:s", "webvtt", "-f", "webvtt"}
+	case ".scc":
+		if v, err := DetectVersion(ctx, cfg); err == nil && !v.AtLeast(4, 4) {
+			return ErrUnsupportedVersion
+		}
+		codec = []string{"-c:s", "copy", "-f", "scc"}
+	default:
+		return fmt.Errorf("unsupported closed captions format %q", filepath.Ext(output))
+	}
+	// the movie source exposes the captions of the video as the subcc output
+	graph := "movie=filename=" + filterPath(input) + "[out0+subcc]"
+	args := []string{"-y", "-f", "lavfi", "-i", graph, "-map", "0:s:0"}
+	_, err = run(ctx, cfg, append(append(args, codec...), output)...)
+	return err
+}
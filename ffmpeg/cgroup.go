@@ -0,0 +1,47 @@
+package ffmpeg
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrCgroupUnsupported is returned by the transcodes with a Cgroup on a platform
+// without cgroup v2
+var ErrCgroupUnsupported = errors.New("cgroup v2 is only supported on linux")
+
+// Cgroup places each transcode in its own cgroup v2, capping its CPU and memory
+type Cgroup struct {
+	// Parent is the directory of the delegated cgroup the transcodes are created
+	// under, such as /sys/fs/cgroup/transcoder.slice, with the cpu and memory
+	// controllers available. They are enabled for its children when they are not
+	Parent string
+	// CPUs is the cpu.max of a transcode in CPUs, 1.5 being one and a half core, 0
+	// leaving it unlimited
+	CPUs float64
+	// MemoryMax is the memory.max of a transcode in bytes, the kernel killing ffmpeg
+	// beyond it. 0 leaves it unlimited
+	MemoryMax int64
+	// SwapMax is the memory.swap.max in bytes, 0 leaving it unchanged and a negative
+	// value disabling swap
+	SwapMax int64
+}
+
+// OOMError is the error of a transcode killed for exceeding the MemoryMax of its
+// cgroup
+type OOMError struct {
+	MemoryMax int64
+	// Peak is the highest memory usage of the cgroup in bytes, 0 when the kernel does
+	// not report it
+	Peak int64
+	Err  error
+}
+
+// Error ...
+func (e *OOMError) Error() string {
+	return fmt.Sprintf("ffmpeg killed for exceeding memory.max %d bytes: %v", e.MemoryMax, e.Err)
+}
+
+// Unwrap ...
+func (e *OOMError) Unwrap() error {
+	return e.Err
+}
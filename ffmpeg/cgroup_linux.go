@@ -0,0 +1,138 @@
+//go:build linux && go1.20
+// +build linux,go1.20
+
+package ffmpeg
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// cgroupPeriod is the cpu.max period in microseconds
+const cgroupPeriod = 100000
+
+// cgroupSeq numbers the cgroups of this process
+var cgroupSeq int64
+
+// cgroup is the cgroup of a transcode
+type cgroup struct {
+	config *Cgroup
+	path   string
+	dir    *os.File
+}
+
+// newCgroup creates a cgroup under config.Parent with the limits of config
+func newCgroup(config *Cgroup) (*cgroup, error) {
+	// enabling the controllers fails when they are already, or when the parent holds
+	// processes, writing the limits tells
+	ioutil.WriteFile(filepath.Join(config.Parent, "cgroup.subtree_control"), []byte("+cpu +memory"), 0644)
+
+	name := fmt.Sprintf("ffmpeg-%d-%d", os.Getpid(), atomic.AddInt64(&cgroupSeq, 1))
+	c := &cgroup{config: config, path: filepath.Join(config.Parent, name)}
+	if err := os.Mkdir(c.path, 0755); err != nil {
+		return nil, fmt.Errorf("failed creating cgroup (%s) with error %w", c.path, err)
+	}
+	limits := map[string]string{}
+	if config.CPUs > 0 {
+		limits["cpu.max"] = strconv.Itoa(int(config.CPUs*cgroupPeriod)) + " " + strconv.Itoa(cgroupPeriod)
+	}
+	if config.MemoryMax > 0 {
+		limits["memory.max"] = strconv.FormatInt(config.MemoryMax, 10)
+		// kills the whole cgroup rather than a thread of ffmpeg
+		limits["memory.oom.group"] = "1"
+	}
+	if config.SwapMax < 0 {
+		limits["memory.swap.max"] = "0"
+	} else if config.SwapMax > 0 {
+		limits["memory.swap.max"] = strconv.FormatInt(config.SwapMax, 10)
+	}
+	for file, value := range limits {
+		if err := ioutil.WriteFile(filepath.Join(c.path, file), []byte(value), 0644); err != nil {
+			c.remove()
+			return nil, fmt.Errorf("failed setting %s of cgroup (%s) with error %w", file, c.path, err)
+		}
+	}
+	dir, err := os.Open(c.path)
+	if err != nil {
+		c.remove()
+		return nil, fmt.Errorf("failed opening cgroup (%s) with error %w", c.path, err)
+	}
+	c.dir = dir
+	return c, nil
+}
+
+// attach makes cmd start in the cgroup, so that none of its threads escapes it
+func (c *cgroup) attach(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(c.dir.Fd())
+}
+
+// exited removes the cgroup once its process exited with err, returning an OOMError
+// when the kernel killed it for memory. c may be nil
+func (c *cgroup) exited(err error) error {
+	if c == nil {
+		return err
+	}
+	if err != nil && c.config.MemoryMax > 0 && c.event("oom_kill") > 0 {
+		err = &OOMError{MemoryMax: c.config.MemoryMax, Peak: c.peak(), Err: err}
+	}
+	c.remove()
+	return err
+}
+
+// event returns the count of a memory.events entry
+func (c *cgroup) event(name string) int64 {
+	f, err := os.Open(filepath.Join(c.path, "memory.events"))
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == name {
+			n, _ := strconv.ParseInt(fields[1], 10, 64)
+			return n
+		}
+	}
+	return 0
+}
+
+// peak returns memory.peak, present since Linux 5.19
+func (c *cgroup) peak() int64 {
+	b, err := ioutil.ReadFile(filepath.Join(c.path, "memory.peak"))
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	return n
+}
+
+// remove deletes the cgroup, retrying while the kernel reaps its processes. c may be
+// nil
+func (c *cgroup) remove() {
+	if c == nil {
+		return
+	}
+	if c.dir != nil {
+		c.dir.Close()
+	}
+	for i := 0; i < 10; i++ {
+		if err := os.Remove(c.path); err == nil || os.IsNotExist(err) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
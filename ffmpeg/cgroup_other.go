@@ -0,0 +1,25 @@
+//go:build !linux || !go1.20
+// +build !linux !go1.20
+
+package ffmpeg
+
+import "os/exec"
+
+// cgroup is the cgroup of a transcode, unsupported here
+type cgroup struct{}
+
+// newCgroup returns ErrCgroupUnsupported
+func newCgroup(config *Cgroup) (*cgroup, error) {
+	return nil, ErrCgroupUnsupported
+}
+
+// attach ...
+func (c *cgroup) attach(cmd *exec.Cmd) {}
+
+// exited returns err
+func (c *cgroup) exited(err error) error {
+	return err
+}
+
+// remove ...
+func (c *cgroup) remove() {}
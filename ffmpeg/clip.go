@@ -0,0 +1,262 @@
+package ffmpeg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ClipOptions configures Clip
+type ClipOptions struct {
+	// Start and End of the clip in the source timeline
+	Start time.Duration
+	End   time.Duration
+	// Output is the clip file (mp4) or media playlist (hls)
+	Output string
+	// Format is "mp4" (default) or "hls"
+	Format string
+	// SegmentDuration of a repackaged HLS clip, in seconds
+	SegmentDuration int
+	// Copy stream copies the clip. Boundaries are then moved outwards to segment
+	// boundaries for HLS sources and to the keyframes of the video for DASH sources,
+	// so that the clip starts on a decodable frame and contains the requested range
+	Copy bool
+	// Options are the encoding options used when Copy is false
+	Options *Options
+	// MaxBandwidth selects the best variant of an HLS master playlist not above
+	// this bandwidth, 0 selects the best variant
+	MaxBandwidth int64
+}
+
+// ClipResult describes the produced clip
+type ClipResult struct {
+	Output string
+	// Start and End are the actual boundaries of the clip in the source timeline
+	Start time.Duration
+	End   time.Duration
+}
+
+// fetch reads a local playlist or downloads a remote one
+func fetch(ctx context.Context, location string) ([]byte, error) {
+	u, err := url.Parse(location)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ioutil.ReadFile(location)
+	}
+	req, err := http.NewRequest(http.MethodGet, location, nil)
+	if err != nil {
+		return nil, err
+	}
+	if ctx != nil {
+		req = req.WithContext(ctx)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", location, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// resolve returns ref relative to the playlist at base
+func resolve(base, ref string) string {
+	if u, err := url.Parse(base); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		if r, err := u.Parse(ref); err == nil {
+			return r.String()
+		}
+		return ref
+	}
+	if filepath.IsAbs(ref) || strings.Contains(ref, "://") {
+		return ref
+	}
+	return filepath.Join(filepath.Dir(base), filepath.FromSlash(ref))
+}
+
+// selectVariant returns the URI of the best variant of a master playlist, or an empty
+// string when data is a media playlist
+func selectVariant(data []byte, maxBandwidth int64) string {
+	var best string
+	var bestBandwidth int64 = -1
+	var pending int64 = -1
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#EXT-X-STREAM-INF:") {
+			pending = 0
+			for _, attr := range strings.Split(strings.TrimPrefix(line, "#EXT-X-STREAM-INF:"), ",") {
+				if strings.HasPrefix(attr, "BANDWIDTH=") {
+					pending, _ = strconv.ParseInt(strings.TrimPrefix(attr, "BANDWIDTH="), 10, 64)
+				}
+			}
+			continue
+		}
+		if pending < 0 || len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		if (maxBandwidth <= 0 || pending <= maxBandwidth) && pending > bestBandwidth {
+			best, bestBandwidth = line, pending
+		}
+		pending = -1
+	}
+	return best
+}
+
+// segmentBoundaries returns the start time of every segment of an HLS source plus its end
+func segmentBoundaries(ctx context.Context, source string, maxBandwidth int64) ([]float64, error) {
+	data, err := fetch(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	if variant := selectVariant(data, maxBandwidth); len(variant) > 0 {
+		if data, err = fetch(ctx, resolve(source, variant)); err != nil {
+			return nil, err
+		}
+	}
+	entries := parseMediaPlaylist(data)
+	if len(entries) == 0 {
+		return nil, errors.New("no segments found in HLS playlist")
+	}
+	boundaries := make([]float64, 0, len(entries)+1)
+	var t float64
+	for _, e := range entries {
+		boundaries = append(boundaries, t)
+		t += e.duration
+	}
+	return append(boundaries, t), nil
+}
+
+// snap moves start and end outwards to the closest boundaries
+func snap(boundaries []float64, start, end float64) (float64, float64) {
+	s, e := boundaries[0], boundaries[len(boundaries)-1]
+	for _, b := range boundaries {
+		if b <= start {
+			s = b
+		}
+		if b >= end {
+			e = b
+			break
+		}
+	}
+	return s, e
+}
+
+func seconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+func isHLS(source string) bool {
+	return hasManifestExt(source, ".m3u8")
+}
+
+func isDASH(source string) bool {
+	return hasManifestExt(source, ".mpd")
+}
+
+// hasManifestExt reports whether the path of the source URL ends with ext
+func hasManifestExt(source, ext string) bool {
+	u, err := url.Parse(source)
+	if err == nil {
+		source = u.Path
+	}
+	return strings.HasSuffix(strings.ToLower(source), ext)
+}
+
+// keyframeBoundaries returns the keyframe times of the video of a DASH source from
+// that before start, ffprobe seeking to it, and end when no keyframe follows it
+func keyframeBoundaries(ctx context.Context, cfg *Config, source string, start, end time.Duration) ([]float64, error) {
+	// the keyframes are at most a segment apart
+	packets, err := probePackets(ctx, cfg, source, "v:0", "-read_intervals", seconds(start)+"%"+seconds(end+time.Minute))
+	if err != nil {
+		return nil, err
+	}
+	var boundaries []float64
+	for _, p := range packets {
+		if p.Key {
+			boundaries = append(boundaries, p.PTS)
+		}
+	}
+	sort.Float64s(boundaries)
+	if len(boundaries) == 0 {
+		return nil, fmt.Errorf("no keyframe in %s", source)
+	}
+	if boundaries[len(boundaries)-1] < end.Seconds() {
+		boundaries = append(boundaries, end.Seconds())
+	}
+	return boundaries, nil
+}
+
+// Clip cuts [Start, End) out of an HLS or DASH source (URL or local manifest) into an MP4
+// file or a repackaged HLS playlist
+func Clip(ctx context.Context, cfg *Config, source string, opts ClipOptions) (*ClipResult, error) {
+	if len(opts.Output) == 0 {
+		return nil, errors.New("missing output option")
+	}
+	if opts.End <= opts.Start {
+		return nil, errors.New("clip end must be after its start")
+	}
+	result := &ClipResult{Output: opts.Output, Start: opts.Start, End: opts.End}
+
+	if opts.Copy && (isHLS(source) || isDASH(source)) {
+		var boundaries []float64
+		var err error
+		if isHLS(source) {
+			boundaries, err = segmentBoundaries(ctx, source, opts.MaxBandwidth)
+		} else {
+			boundaries, err = keyframeBoundaries(ctx, cfg, source, opts.Start, opts.End)
+		}
+		if err != nil {
+			return nil, err
+		}
+		s, e := snap(boundaries, opts.Start.Seconds(), opts.End.Seconds())
+		result.Start = time.Duration(s * float64(time.Second))
+		result.End = time.Duration(e * float64(time.Second))
+	}
+
+	args := []string{"-y",
+		"-protocol_whitelist", "file,http,https,tcp,tls,crypto,data",
+		"-ss", seconds(result.Start),
+		"-i", source,
+		"-t", seconds(result.End - result.Start),
+	}
+	if opts.Copy {
+		args = append(args, "-c", "copy", "-avoid_negative_ts", "make_zero")
+	} else if opts.Options != nil {
+		args = append(args, opts.Options.GetStrArguments()...)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(opts.Output), os.ModePerm); err != nil {
+		return nil, err
+	}
+	switch opts.Format {
+	case "", "mp4":
+		args = append(args, "-f", "mp4", "-movflags", "+faststart")
+	case "hls":
+		duration := opts.SegmentDuration
+		if duration <= 0 {
+			duration = 6
+		}
+		args = append(args, "-f", "hls", "-hls_time", strconv.Itoa(duration),
+			"-hls_playlist_type", "vod", "-hls_list_size", "0")
+	default:
+		return nil, fmt.Errorf("unsupported clip format %q", opts.Format)
+	}
+	args = append(args, opts.Output)
+
+	if _, err := run(ctx, cfg, args...); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
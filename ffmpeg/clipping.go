@@ -0,0 +1,176 @@
+package ffmpeg
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/admpub/transcoder"
+)
+
+// ClippingOptions configures DetectClipping
+type ClippingOptions struct {
+	// Interval the counts are reported for, defaults to 1s
+	Interval time.Duration
+	// ClipLevel is the absolute sample value from which a sample is clipped, defaults
+	// to 32767/32768, the highest 16 bit value
+	ClipLevel float64
+	// MaxTruePeak in dBTP over which a true peak is an over, defaults to -1
+	MaxTruePeak float64
+}
+
+// ClippingInterval holds the clipping measurements of an interval
+type ClippingInterval struct {
+	Start time.Duration `json:"start"`
+	End   time.Duration `json:"end"`
+	// ClippedSamples counts the clipped samples of every channel
+	ClippedSamples int `json:"clipped_samples"`
+	// TruePeak in dBTP, silence being at minPeak
+	TruePeak float64 `json:"true_peak"`
+	// Overs counts the oversampled samples above the maximum true peak
+	Overs int `json:"overs"`
+}
+
+// ClippingReport is the result of DetectClipping
+type ClippingReport struct {
+	ClippedSamples int     `json:"clipped_samples"`
+	TruePeak       float64 `json:"true_peak"`
+	Overs          int     `json:"overs"`
+	// Intervals lists the intervals with clipped samples or overs
+	Intervals []ClippingInterval `json:"intervals,omitempty"`
+}
+
+// minPeak is the level in dB reported for digital silence, under the 24 bit noise floor
+const minPeak = -144
+
+// clampLevel returns the level v in dB, minPeak when it is lower, -inf included
+func clampLevel(v float64) float64 {
+	if math.IsNaN(v) || v < minPeak {
+		return minPeak
+	}
+	return v
+}
+
+// audioFormatOfStream returns the sample rate and channel count of the first audio stream
+func audioFormatOfStream(metadata transcoder.Metadata) (int, int, error) {
+	for _, s := range metadata.GetStreams() {
+		if s.GetCodecType() != "audio" {
+			continue
+		}
+		rate, _ := strconv.Atoi(s.GetSampleRate())
+		if rate <= 0 || s.GetChannels() <= 0 {
+			return 0, 0, errors.New("audio stream without sample rate or channels")
+		}
+		return rate, s.GetChannels(), nil
+	}
+	return 0, 0, errors.New("source has no audio stream")
+}
+
+// scanSamples decodes the first audio stream of input to interleaved 32 bit floats at
+// rate and calls fn with the samples of each block of block samples
+func scanSamples(ctx context.Context, cfg *Config, input string, rate, block int, fn func(i int, samples []float32)) error {
+	args := []string{"-i", input, "-map", "0:a:0", "-vn", "-sn", "-ar", strconv.Itoa(rate), "-c:a", "pcm_f32le", "-f", "f32le"}
+	return runStream(ctx, cfg, func(r io.Reader) error {
+		reader := bufio.NewReaderSize(r, 1<<16)
+		buf := make([]byte, block*4)
+		samples := make([]float32, block)
+		for i := 0; ; i++ {
+			n, err := io.ReadFull(reader, buf)
+			n /= 4
+			for j := 0; j < n; j++ {
+				samples[j] = math.Float32frombits(binary.LittleEndian.Uint32(buf[j*4:]))
+			}
+			if n > 0 {
+				fn(i, samples[:n])
+			}
+			if err != nil {
+				return nil
+			}
+		}
+	}, args...)
+}
+
+// DetectClipping counts the clipped samples of the first audio stream of input and
+// measures its true peaks, on audio oversampled 4 times as BS.1770 specifies
+func DetectClipping(ctx context.Context, cfg *Config, input string, opts ClippingOptions) (*ClippingReport, error) {
+	interval, clip, maxPeak := opts.Interval, opts.ClipLevel, opts.MaxTruePeak
+	if interval <= 0 {
+		interval = time.Second
+	}
+	if clip <= 0 {
+		clip = 32767.0 / 32768
+	}
+	if maxPeak == 0 {
+		maxPeak = -1
+	}
+	_, metadata, err := probeDuration(ctx, cfg, input)
+	if err != nil {
+		return nil, err
+	}
+	rate, channels, err := audioFormatOfStream(metadata)
+	if err != nil {
+		return nil, err
+	}
+	var intervals []ClippingInterval
+	get := func(i int) *ClippingInterval {
+		for len(intervals) <= i {
+			start := time.Duration(len(intervals)) * interval
+			intervals = append(intervals, ClippingInterval{Start: start, End: start + interval, TruePeak: minPeak})
+		}
+		return &intervals[i]
+	}
+	block := func(rate int) int {
+		return int(math.Max(1, math.Round(float64(rate)*interval.Seconds()))) * channels
+	}
+
+	report := &ClippingReport{TruePeak: minPeak}
+	err = scanSamples(ctx, cfg, input, rate, block(rate), func(i int, samples []float32) {
+		n := 0
+		for _, s := range samples {
+			if math.Abs(float64(s)) >= clip {
+				n++
+			}
+		}
+		get(i).ClippedSamples += n
+		report.ClippedSamples += n
+	})
+	if err != nil {
+		return nil, err
+	}
+	over := math.Pow(10, maxPeak/20)
+	oversampled := rate * 4
+	if rate >= 96000 {
+		oversampled = rate * 2
+	}
+	err = scanSamples(ctx, cfg, input, oversampled, block(oversampled), func(i int, samples []float32) {
+		peak, overs := 0.0, 0
+		for _, s := range samples {
+			v := math.Abs(float64(s))
+			peak = math.Max(peak, v)
+			if v > over {
+				overs++
+			}
+		}
+		c := get(i)
+		c.Overs += overs
+		if peak > 0 {
+			c.TruePeak = math.Max(minPeak, 20*math.Log10(peak))
+		}
+		report.TruePeak = math.Max(report.TruePeak, c.TruePeak)
+		report.Overs += overs
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range intervals {
+		if c.ClippedSamples > 0 || c.Overs > 0 {
+			report.Intervals = append(report.Intervals, c)
+		}
+	}
+	return report, nil
+}
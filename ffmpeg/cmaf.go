@@ -0,0 +1,103 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+
+	"github.com/admpub/transcoder"
+)
+
+// CMAFOptions configures a CMAF output: fMP4 segments written once and referenced
+// by both a DASH MPD and HLS playlists
+type CMAFOptions struct {
+	// Dir is the output directory
+	Dir string
+	// ManifestName defaults to "manifest.mpd", HLSMasterName to "master.m3u8"
+	ManifestName  string
+	HLSMasterName string
+	// SegmentDuration is the segment duration in seconds
+	SegmentDuration float64
+	// Live writes dynamic manifests updated while encoding, LowLatency adds chunked transfer signaling
+	Live       bool
+	LowLatency bool
+	WindowSize int
+	// LowLatencyHLS enables partial segments and preload hints, live only
+	LowLatencyHLS *LowLatencyHLSOptions
+	// Encryption encrypts the shared segments with CENC
+	Encryption *Encryption
+	// AdaptationSets describe the renditions, see DASHAdaptationSets to build them from a ladder
+	AdaptationSets []DASHAdaptationSet
+}
+
+// CMAF packages an input into CMAF segments with DASH and HLS manifests
+type CMAF struct {
+	dash *DASH
+	opts CMAFOptions
+}
+
+// NewCMAF ...
+func NewCMAF(cfg *Config, input string, opts CMAFOptions) *CMAF {
+	manifest := opts.ManifestName
+	if len(manifest) == 0 {
+		manifest = "manifest.mpd"
+	}
+	master := opts.HLSMasterName
+	if len(master) == 0 {
+		master = "master.m3u8"
+	}
+	dash := NewDASH(cfg, input, DASHOptions{
+		Manifest:        filepath.Join(opts.Dir, manifest),
+		SegmentDuration: opts.SegmentDuration,
+		// one init segment per rendition, media segments shared by both manifests
+		InitSegmentName:  "init-$RepresentationID$.m4s",
+		MediaSegmentName: "chunk-$RepresentationID$-$Number%05d$.m4s",
+		SegmentType:      "mp4",
+		UseTimeline:      !opts.Live,
+		Live:             opts.Live,
+		LowLatency:       opts.LowLatency,
+		WindowSize:       opts.WindowSize,
+		HLSPlaylist:      true,
+		HLSMasterName:    master,
+		Encryption:       opts.Encryption,
+		AdaptationSets:   opts.AdaptationSets,
+	})
+	if ll := opts.LowLatencyHLS; ll != nil {
+		dash.options.PartDuration = ll.PartDuration
+		dash.options.PreloadHints = ll.PreloadHints
+	}
+	return &CMAF{dash: dash, opts: opts}
+}
+
+// LowLatencyHLSOptions configures low latency HLS on a CMAF output. ffmpeg writes the
+// partial segments (CMAF chunks) and preload hints, blocking playlist reload is a
+// server feature provided by BlockingPlaylistHandler
+type LowLatencyHLSOptions struct {
+	// PartDuration is the partial segment duration in seconds, typically 0.2 to 1
+	PartDuration float64
+	// PreloadHints announces the segment currently written so players can request it early
+	PreloadHints bool
+}
+
+// WithContext is to be used *before Starting* to pass in a context.Context
+// object that can be used to kill the running ffmpeg process
+func (c *CMAF) WithContext(ctx context.Context) *CMAF {
+	c.dash.WithContext(ctx)
+	return c
+}
+
+// Arguments returns the ffmpeg arguments of the packaging run
+func (c *CMAF) Arguments() Args {
+	return c.dash.Arguments()
+}
+
+// Start runs the segmenter
+func (c *CMAF) Start() (<-chan transcoder.Progress, error) {
+	if len(c.opts.Dir) == 0 {
+		return nil, errors.New("missing CMAF output directory")
+	}
+	if c.opts.LowLatencyHLS != nil && !c.opts.Live {
+		return nil, errors.New("low latency HLS requires a live output")
+	}
+	return c.dash.Start()
+}
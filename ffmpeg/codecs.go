@@ -0,0 +1,148 @@
+package ffmpeg
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseBitrate converts an ffmpeg bitrate such as "3000k", "2.5M" or "128000" to bits per second
+func parseBitrate(rate string) int64 {
+	rate = strings.TrimSpace(rate)
+	if len(rate) == 0 {
+		return 0
+	}
+	multiplier := 1.0
+	switch rate[len(rate)-1] {
+	case 'k', 'K':
+		multiplier = 1000
+	case 'm', 'M':
+		multiplier = 1000 * 1000
+	case 'g', 'G':
+		multiplier = 1000 * 1000 * 1000
+	}
+	if multiplier > 1 {
+		rate = rate[:len(rate)-1]
+	}
+	v, err := strconv.ParseFloat(rate, 64)
+	if err != nil {
+		return 0
+	}
+	return int64(v * multiplier)
+}
+
+// parseResolution converts an ffmpeg frame size such as "1280x720" to width and height
+func parseResolution(size string) (width int, height int) {
+	parts := strings.SplitN(strings.ToLower(size), "x", 2)
+	if len(parts) != 2 {
+		return 0, 0
+	}
+	width, _ = strconv.Atoi(parts[0])
+	height, _ = strconv.Atoi(parts[1])
+	return width, height
+}
+
+func strValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func boolValue(b *bool) bool {
+	return b != nil && *b
+}
+
+// VideoCodecString returns the RFC 6381 codec string for the video stream produced by opts,
+// or an empty string when it cannot be derived from the options (e.g. stream copy)
+func VideoCodecString(opts Options) string {
+	if boolValue(opts.SkipVideo) {
+		return ""
+	}
+	codec := strings.ToLower(strValue(opts.VideoCodec))
+	profile := strings.ToLower(strValue(opts.VideoProfile))
+	level, _ := strconv.ParseFloat(strValue(opts.VideoLevel), 64)
+
+	switch {
+	case codec == "" || strings.Contains(codec, "264"):
+		// profile_idc and constraint flags as used by Apple's authoring specification
+		profileIdc := "6400"
+		switch profile {
+		case "baseline":
+			profileIdc = "42E0"
+		case "main":
+			profileIdc = "4D40"
+		case "high10":
+			profileIdc = "6E00"
+		case "high422":
+			profileIdc = "7A00"
+		case "high444", "high444p":
+			profileIdc = "F400"
+		}
+		if level <= 0 {
+			level = 4.0
+		}
+		return fmt.Sprintf("avc1.%s%02X", profileIdc, int(level*10+0.5))
+	case strings.Contains(codec, "265") || strings.Contains(codec, "hevc"):
+		if level <= 0 {
+			level = 4.0
+		}
+		if profile == "main10" {
+			return fmt.Sprintf("hvc1.2.4.L%d.90", int(level*30+0.5))
+		}
+		return fmt.Sprintf("hvc1.1.6.L%d.90", int(level*30+0.5))
+	case strings.Contains(codec, "vp9"):
+		if level <= 0 {
+			level = 4.0
+		}
+		return fmt.Sprintf("vp09.00.%02d.08", int(level*10+0.5))
+	case strings.Contains(codec, "av1") || strings.Contains(codec, "aom") || strings.Contains(codec, "svt"):
+		return "av01.0.08M.08"
+	}
+	return ""
+}
+
+// AudioCodecString returns the RFC 6381 codec string for the audio stream produced by opts,
+// or an empty string when it cannot be derived from the options
+func AudioCodecString(opts Options) string {
+	if boolValue(opts.SkipAudio) {
+		return ""
+	}
+	codec := strings.ToLower(strValue(opts.AudioCodec))
+	profile := strings.ToLower(strValue(opts.AudioProfile))
+
+	switch {
+	case codec == "" || strings.Contains(codec, "aac"):
+		switch profile {
+		case "aac_he":
+			return "mp4a.40.5"
+		case "aac_he_v2":
+			return "mp4a.40.29"
+		}
+		return "mp4a.40.2"
+	case strings.Contains(codec, "mp3"):
+		return "mp4a.40.34"
+	case codec == "ac3":
+		return "ac-3"
+	case codec == "eac3":
+		return "ec-3"
+	case strings.Contains(codec, "opus"):
+		return "opus"
+	case codec == "flac":
+		return "fLaC"
+	}
+	return ""
+}
+
+// CodecsString returns the comma separated codec list used by the HLS CODECS attribute
+// and the DASH codecs attribute
+func CodecsString(opts Options) string {
+	var codecs []string
+	if v := VideoCodecString(opts); len(v) > 0 {
+		codecs = append(codecs, v)
+	}
+	if a := AudioCodecString(opts); len(a) > 0 {
+		codecs = append(codecs, a)
+	}
+	return strings.Join(codecs, ",")
+}
@@ -1,14 +1,61 @@
 package ffmpeg
 
-import "github.com/admpub/transcoder"
+import (
+	"io"
+	"os"
+
+	"github.com/admpub/transcoder"
+)
 
 // Config ...
 type Config struct {
 	FfmpegBinPath   string
 	FfprobeBinPath  string
 	ProgressEnabled bool
-	Verbose         bool
-	Env             []string
-	Dir             string
-	OnMetadata      func(transcoder.Metadata) error
+	// Verbose copies what ffmpeg writes on stderr to VerboseOutput, os.Stdout when it
+	// is nil, progress still being reported
+	Verbose       bool
+	VerboseOutput io.Writer
+	Env           []string
+	Dir           string
+	OnMetadata    func(transcoder.Metadata) error
+	// Tracer traces the ffmpeg and ffprobe processes and the packaging runs when set
+	Tracer transcoder.Tracer
+	// Logger receives the logs, the standard logger receives the errors when it is nil
+	Logger transcoder.Logger
+	// Limits applies to every ffmpeg and ffprobe process when set, see
+	// Transcoder.WithLimits for a transcode
+	Limits *Limits
+	// GPUPool assigns a GPU to the transcodes encoding with NVENC or decoding with
+	// CUDA when set, except those choosing theirs with -gpu or -hwaccel_device
+	GPUPool *GPUPool
+	// DiskSpace refuses the transcodes whose outputs would not fit and aborts those
+	// running out of space when set
+	DiskSpace *DiskSpace
+	// Throttle caps the bandwidth of the outputs of the transcodes when set
+	Throttle *Throttle
+	// Watchdog terminates the runaway transcodes when set, see Transcoder.WithWatchdog
+	// for a transcode
+	Watchdog *Watchdog
+	// Admission delays the transcodes while too many run or the host is saturated,
+	// transcoder.DefaultAdmission when it is nil
+	Admission *transcoder.Admission
+	// Bundle records a reproducibility bundle for each transcode when set
+	Bundle *BundleConfig
+}
+
+// verboseOutput returns the writer receiving stderr in verbose mode
+func (c *Config) verboseOutput() io.Writer {
+	if c.VerboseOutput != nil {
+		return c.VerboseOutput
+	}
+	return os.Stdout
+}
+
+// admission returns the Admission of the transcodes, nil when unlimited
+func (c *Config) admission() *transcoder.Admission {
+	if c.Admission != nil {
+		return c.Admission
+	}
+	return transcoder.DefaultAdmission()
 }
@@ -0,0 +1,143 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/admpub/transcoder"
+	"github.com/admpub/transcoder/utils"
+)
+
+// ContactSheetOptions configures a contact sheet
+type ContactSheetOptions struct {
+	// Output is the image file, its extension selects the format
+	Output string
+	// Width of each tile, defaults to 320
+	Width int
+	// Header draws the file name, duration and stream information above the grid
+	Header bool
+	// Timestamps draws the time of each tile, defaults to true
+	Timestamps *bool
+	// FontFile is the font of the texts, found through fontconfig when empty
+	FontFile string
+	// Background color, defaults to black
+	Background string
+	// Accurate decodes every frame to take tiles at exact times instead of the nearest keyframe
+	Accurate bool
+}
+
+// filterQuote quotes a filter option value, escaping it for both the filtergraph
+// parser and the option parser, which removes one level of escaping each
+func filterQuote(v string) string {
+	v = strings.Replace(v, ":", `\:`, -1)
+	// a quote ends the quoted part, is escaped for the option parser then for the graph
+	return "'" + strings.Replace(v, "'", `'\\\''`, -1) + "'"
+}
+
+// filterPath quotes a file path used as a filter option value, Windows drive letters
+// holding a colon and back slashes being handled
+func filterPath(p string) string {
+	return filterQuote(filepath.ToSlash(p))
+}
+
+// contactSheetHeader returns the header lines describing input
+func contactSheetHeader(input string, duration float64, metadata transcoder.Metadata) []string {
+	lines := []string{filepath.Base(input)}
+	info := "Duration " + utils.SecToDur(duration)
+	if size, err := strconv.ParseInt(metadata.GetFormat().GetSize(), 10, 64); err == nil && size > 0 {
+		info += fmt.Sprintf("  Size %.1f MiB", float64(size)/(1<<20))
+	}
+	if bitrate, err := strconv.ParseInt(metadata.GetFormat().GetBitRate(), 10, 64); err == nil && bitrate > 0 {
+		info += fmt.Sprintf("  Bitrate %d kb/s", bitrate/1000)
+	}
+	lines = append(lines, info)
+	var streams []string
+	for _, s := range metadata.GetStreams() {
+		switch s.GetCodecType() {
+		case "video":
+			streams = append(streams, fmt.Sprintf("Video %s %dx%d %s fps", s.GetCodecName(), s.GetWidth(), s.GetHeight(), s.GetAvgFrameRate()))
+		case "audio":
+			streams = append(streams, "Audio "+s.GetCodecName())
+		}
+	}
+	if len(streams) > 0 {
+		lines = append(lines, strings.Join(streams, "  "))
+	}
+	return lines
+}
+
+// ContactSheet renders a grid of columns x rows frames spread over input
+// in a single image, for quality control and library browsing
+func ContactSheet(ctx context.Context, cfg *Config, input string, columns, rows int, opts ContactSheetOptions) error {
+	if len(opts.Output) == 0 {
+		return errors.New("missing output option")
+	}
+	if columns <= 0 || rows <= 0 {
+		return errors.New("contact sheet columns and rows must be positive")
+	}
+	width := opts.Width
+	if width <= 0 {
+		width = 320
+	}
+	background := opts.Background
+	if len(background) == 0 {
+		background = "black"
+	}
+	duration, metadata, err := probeDuration(ctx, cfg, input)
+	if err != nil {
+		return err
+	}
+
+	font := ""
+	if len(opts.FontFile) > 0 {
+		font = "fontfile=" + filterPath(opts.FontFile) + ":"
+	}
+	count := columns * rows
+	step := duration / float64(count)
+	num := func(f float64) string {
+		return strconv.FormatFloat(f, 'f', 3, 64)
+	}
+	filters := []string{
+		fmt.Sprintf("select='gte(t\\,%s)*(isnan(prev_selected_t)+gte(t-prev_selected_t\\,%s))'", num(step/2), num(step)),
+		fmt.Sprintf("scale=%d:-2", width),
+	}
+	if opts.Timestamps == nil || *opts.Timestamps {
+		filters = append(filters, "drawtext="+font+"text='%{pts\\:hms}':x=w-tw-6:y=h-th-6:fontsize=16:fontcolor=white:box=1:boxcolor=black@0.6:boxborderw=3")
+	}
+	filters = append(filters, fmt.Sprintf("tile=%dx%d:padding=4:margin=4:color=%s", columns, rows, background))
+
+	if opts.Header {
+		lines := contactSheetHeader(input, duration, metadata)
+		const lineHeight = 24
+		height := lineHeight*len(lines) + 12
+		filters = append(filters, fmt.Sprintf("pad=iw:ih+%d:0:%d:color=%s", height, height, background))
+		// file names and tags are read from files rather than escaped in the graph
+		for i, line := range lines {
+			f, err := ioutil.TempFile("", "contactsheet-*.txt")
+			if err != nil {
+				return err
+			}
+			defer os.Remove(f.Name())
+			_, err = f.WriteString(line)
+			f.Close()
+			if err != nil {
+				return err
+			}
+			filters = append(filters, fmt.Sprintf("drawtext=%stextfile=%s:expansion=none:x=8:y=%d:fontsize=18:fontcolor=white", font, filterPath(f.Name()), 8+i*lineHeight))
+		}
+	}
+
+	args := []string{"-y"}
+	if !opts.Accurate {
+		args = append(args, "-skip_frame", "nokey")
+	}
+	args = append(args, "-i", input, "-an", "-sn", "-vf", strings.Join(filters, ","), "-frames:v", "1", "-f", "image2", "-update", "1", opts.Output)
+	_, err = run(ctx, cfg, args...)
+	return err
+}
@@ -0,0 +1,365 @@
+package ffmpeg
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/admpub/transcoder"
+)
+
+// DASH adaptation set content types
+const (
+	DASHVideo = "video"
+	DASHAudio = "audio"
+)
+
+// DASHRepresentation is one encoding of an adaptation set
+type DASHRepresentation struct {
+	// Source is the input stream mapped into the representation, defaults to
+	// the first video (or audio) stream of the input, e.g. "0:v:0"
+	Source string
+	// Options are the encoding options of the representation, only the
+	// flags applying to the adaptation set content type are used
+	Options Options
+}
+
+// Codecs returns the RFC 6381 codec string of the representation
+func (r DASHRepresentation) Codecs(contentType string) string {
+	if contentType == DASHAudio {
+		return AudioCodecString(r.Options)
+	}
+	return VideoCodecString(r.Options)
+}
+
+// DASHAdaptationSet groups interchangeable representations of the same content
+type DASHAdaptationSet struct {
+	// ContentType is DASHVideo or DASHAudio
+	ContentType     string
+	Representations []DASHRepresentation
+}
+
+// DASHOptions configures a DASH output
+type DASHOptions struct {
+	// Manifest is the path of the MPD file, segments are written next to it
+	Manifest string
+	// SegmentDuration is the segment duration in seconds (seg_duration)
+	SegmentDuration float64
+	// InitSegmentName is the init segment template, defaults to "init-$RepresentationID$.$ext$"
+	InitSegmentName string
+	// MediaSegmentName is the media segment template, defaults to "chunk-$RepresentationID$-$Number%05d$.$ext$"
+	MediaSegmentName string
+	// SegmentType is "mp4" (default), "webm" or "auto"
+	SegmentType string
+	// UseTimeline writes a SegmentTimeline instead of a plain SegmentTemplate duration
+	UseTimeline bool
+	// SingleFile stores all segments of a representation in one file addressed by byte ranges
+	SingleFile bool
+	// Live writes a dynamic MPD updated while encoding (on-the-fly), otherwise a static MPD is
+	// written. WindowSize and ExtraWindowSize are only used by live manifests
+	Live            bool
+	WindowSize      int
+	ExtraWindowSize int
+	// LowLatency enables chunked CMAF segments and low latency DASH signaling (live only)
+	LowLatency bool
+	// PartDuration splits segments into fragments of this duration in seconds (frag_duration),
+	// the partial segments of low latency HLS and DASH. Requires ffmpeg 4.3
+	PartDuration float64
+	// PreloadHints announces the segment being written in the HLS playlists (lhls), live only.
+	// Requires ffmpeg 4.2
+	PreloadHints bool
+	// HLSPlaylist also writes HLS playlists referencing the same segments, HLSMasterName
+	// is the master playlist name (defaults to ffmpeg's "master.m3u8")
+	HLSPlaylist   bool
+	HLSMasterName string
+	// Encryption encrypts the fMP4 segments with CENC (EncryptionCENC), the
+	// ContentProtection elements of the key being added to the manifest once the
+	// muxer finished. It cannot be used with Live
+	Encryption *Encryption
+	// OnSegment is called for every completed segment while encoding continues, segments
+	// are also reported as SegmentProgress values. It implies HLSPlaylist, since segments
+	// are discovered through the HLS media playlists (media_<representation>.m3u8)
+	OnSegment      func(Segment) error
+	AdaptationSets []DASHAdaptationSet
+}
+
+// DASH packages an input into a DASH output with ffmpeg's dash muxer
+type DASH struct {
+	config         *Config
+	input          string
+	options        DASHOptions
+	commandContext context.Context
+}
+
+// NewDASH ...
+func NewDASH(cfg *Config, input string, opts DASHOptions) *DASH {
+	return &DASH{config: cfg, input: input, options: opts}
+}
+
+// WithContext is to be used *before Starting* to pass in a context.Context
+// object that can be used to kill the running ffmpeg process
+func (d *DASH) WithContext(ctx context.Context) *DASH {
+	d.commandContext = ctx
+	return d
+}
+
+// validate ...
+func (d *DASH) validate() error {
+	if len(d.options.Manifest) == 0 {
+		return errors.New("missing DASH manifest path")
+	}
+	if len(d.options.AdaptationSets) == 0 {
+		return errors.New("missing DASH adaptation sets")
+	}
+	for index, set := range d.options.AdaptationSets {
+		if set.ContentType != DASHVideo && set.ContentType != DASHAudio {
+			return fmt.Errorf("adaptation set at index %d has invalid content type %q", index, set.ContentType)
+		}
+		if len(set.Representations) == 0 {
+			return fmt.Errorf("adaptation set at index %d has no representations", index)
+		}
+	}
+	if d.options.Encryption != nil && d.options.Live {
+		// the dash muxer writes no ContentProtection, which is added once it finished
+		return errors.New("encryption cannot be used with a live DASH output")
+	}
+	if d.options.PreloadHints && (!d.options.Live || !(d.options.HLSPlaylist || d.options.OnSegment != nil)) {
+		return errors.New("preload hints require a live output with HLS playlists")
+	}
+	return nil
+}
+
+// Arguments returns the stream mapping, per stream encoding and dash muxer arguments
+func (d *DASH) Arguments() Args {
+	o := d.options
+	args := Args{}
+	var sets []string
+	var streamIndex, videoIndex, audioIndex int
+
+	for setIndex, set := range o.AdaptationSets {
+		var streams []string
+		for _, r := range set.Representations {
+			source := r.Source
+			if set.ContentType == DASHAudio {
+				if len(source) == 0 {
+					source = "0:a:0"
+				}
+				args = append(args, "-map", source)
+				args = append(args, streamArguments(r.Options, 'a', audioIndex)...)
+				audioIndex++
+			} else {
+				if len(source) == 0 {
+					source = "0:v:0"
+				}
+				args = append(args, "-map", source)
+				args = append(args, streamArguments(r.Options, 'v', videoIndex)...)
+				videoIndex++
+			}
+			streams = append(streams, strconv.Itoa(streamIndex))
+			streamIndex++
+		}
+		sets = append(sets, fmt.Sprintf("id=%d,streams=%s", setIndex, strings.Join(streams, ",")))
+	}
+
+	initName := o.InitSegmentName
+	if len(initName) == 0 {
+		initName = "init-$RepresentationID$.$ext$"
+	}
+	mediaName := o.MediaSegmentName
+	if len(mediaName) == 0 {
+		mediaName = "chunk-$RepresentationID$-$Number%05d$.$ext$"
+	}
+	segmentType := o.SegmentType
+	if len(segmentType) == 0 {
+		segmentType = "mp4"
+	}
+
+	args = append(args,
+		"-f", "dash",
+		"-adaptation_sets", strings.Join(sets, " "),
+		"-dash_segment_type", segmentType,
+		"-init_seg_name", initName,
+		"-media_seg_name", mediaName,
+		"-use_template", "1",
+		"-use_timeline", boolFlag(o.UseTimeline),
+	)
+	if o.SegmentDuration > 0 {
+		args = append(args, "-seg_duration", strconv.FormatFloat(o.SegmentDuration, 'f', -1, 64))
+	}
+	if o.PartDuration > 0 {
+		args = append(args, "-frag_type", "duration", "-frag_duration", strconv.FormatFloat(o.PartDuration, 'f', -1, 64))
+	}
+	if o.SingleFile {
+		args = append(args, "-single_file", "1")
+	}
+	if o.HLSPlaylist || o.OnSegment != nil {
+		args = append(args, "-hls_playlist", "1")
+		if len(o.HLSMasterName) > 0 {
+			args = append(args, "-hls_master_name", o.HLSMasterName)
+		}
+	}
+	if o.Live {
+		args = append(args, "-streaming", "1")
+		if o.WindowSize > 0 {
+			args = append(args, "-window_size", strconv.Itoa(o.WindowSize))
+		}
+		if o.ExtraWindowSize > 0 {
+			args = append(args, "-extra_window_size", strconv.Itoa(o.ExtraWindowSize))
+		}
+		if o.LowLatency {
+			args = append(args, "-ldash", "1")
+			if o.PartDuration <= 0 {
+				args = append(args, "-frag_type", "every_frame")
+			}
+		}
+		if o.PreloadHints {
+			args = append(args, "-lhls", "1")
+		}
+	} else {
+		// keep every segment and mark the manifest static once encoding is done
+		args = append(args, "-window_size", "0")
+	}
+	return args
+}
+
+// checkVersion verifies the configured ffmpeg supports the requested muxer options
+func (d *DASH) checkVersion(ctx context.Context) error {
+	o := d.options
+	if o.PartDuration <= 0 && !o.PreloadHints && !o.LowLatency {
+		return nil
+	}
+	v, err := DetectVersion(ctx, d.config)
+	if err != nil {
+		return err
+	}
+	if o.PreloadHints {
+		if err := v.require("low latency HLS preload hints", 4, 2); err != nil {
+			return err
+		}
+	}
+	if o.PartDuration > 0 || o.LowLatency {
+		return v.require("partial segments", 4, 3)
+	}
+	return nil
+}
+
+// boolFlag renders a boolean AVOption value
+func boolFlag(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+// Start runs the dash muxer. Progress is reported like a regular Transcoder
+func (d *DASH) Start() (<-chan transcoder.Progress, error) {
+	if err := d.validate(); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(d.options.Manifest), os.ModePerm); err != nil {
+		return nil, err
+	}
+	ctx := d.commandContext
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, span := startSpan(ctx, d.config, "package.dash", map[string]interface{}{
+		"input":  d.input,
+		"output": d.options.Manifest,
+	})
+	in, err := d.run(ctx)
+	return traceProgress(span, in, err)
+}
+
+// run runs the dash muxer, then signals the CENC encryption in the manifest
+func (d *DASH) run(ctx context.Context) (<-chan transcoder.Progress, error) {
+	in, err := d.mux(ctx)
+	e := d.options.Encryption
+	if e == nil || err != nil {
+		return in, err
+	}
+	if !d.config.ProgressEnabled {
+		return in, e.protectManifest(d.options.Manifest)
+	}
+	out := make(chan transcoder.Progress)
+	go func() {
+		defer close(out)
+		var failed error
+		for msg := range in {
+			if msg.GetError() != nil {
+				failed = msg.GetError()
+			}
+			out <- msg
+		}
+		if failed == nil {
+			if err := e.protectManifest(d.options.Manifest); err != nil {
+				out <- Progress{Error: err}
+			}
+		}
+	}()
+	return out, nil
+}
+
+// mux runs the dash muxer
+func (d *DASH) mux(ctx context.Context) (<-chan transcoder.Progress, error) {
+	if err := d.checkVersion(ctx); err != nil {
+		return nil, err
+	}
+	watch := d.options.HLSPlaylist || d.options.OnSegment != nil
+	cfg := d.config
+	if watch {
+		cfg = progressConfig(d.config)
+	}
+	t := New(cfg).
+		Input(d.input).
+		Output(d.options.Manifest).
+		WithOptions(d.Arguments())
+	if d.options.Encryption != nil {
+		args, err := d.options.Encryption.cencArguments(ctx)
+		if err != nil {
+			return nil, err
+		}
+		t.WithAdditionalOptions(args)
+		t.(*Transcoder).WithSecrets(hex.EncodeToString(d.options.Encryption.Key().Key))
+	}
+	if !watch {
+		return t.WithContext(ctx).Start(Options{})
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	in, err := t.WithContext(ctx).Start(Options{})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	var watchers []*segmentWatcher
+	dir := filepath.Dir(d.options.Manifest)
+	index := 0
+	for _, set := range d.options.AdaptationSets {
+		for range set.Representations {
+			watchers = append(watchers, &segmentWatcher{
+				rendition: strconv.Itoa(index),
+				playlist:  filepath.Join(dir, fmt.Sprintf("media_%d.m3u8", index)),
+			})
+			index++
+		}
+	}
+	in = watchSegments(in, watchers, d.options.OnSegment, cancel)
+	if d.config.ProgressEnabled {
+		return in, nil
+	}
+	defer cancel()
+	out := make(chan transcoder.Progress)
+	close(out)
+	for msg := range in {
+		if err == nil {
+			err = msg.GetError()
+		}
+	}
+	return out, err
+}
@@ -0,0 +1,198 @@
+package ffmpeg
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/admpub/transcoder"
+	"github.com/admpub/transcoder/utils"
+)
+
+// ErrDiskFull is matched by the DiskFullError of the transcodes refused or aborted
+// for lack of disk space
+var ErrDiskFull = errors.New("not enough disk space")
+
+// DiskFullError is the error of a transcode refused or aborted for lack of disk space
+type DiskFullError struct {
+	Path string
+	Free int64
+	// Required is the space estimated for the outputs before starting, 0 when the
+	// free space fell under MinFree while encoding
+	Required int64
+}
+
+// Error ...
+func (e *DiskFullError) Error() string {
+	if e.Required > 0 {
+		return fmt.Sprintf("%v on %s: %d bytes free, %d bytes required", ErrDiskFull, e.Path, e.Free, e.Required)
+	}
+	return fmt.Sprintf("%v on %s: %d bytes free", ErrDiskFull, e.Path, e.Free)
+}
+
+// Is matches ErrDiskFull
+func (e *DiskFullError) Is(target error) bool {
+	return target == ErrDiskFull
+}
+
+// DiskSpace checks the free space of the filesystems of the outputs before and
+// while transcoding. URLs and pipes are skipped
+type DiskSpace struct {
+	// MinFree is the space kept free on the filesystems, the transcode being aborted
+	// under it. Defaults to 100MiB
+	MinFree int64
+	// Margin multiplies the estimated size of the outputs, defaults to 1.1
+	Margin float64
+	// Scratch are directories of temporary files checked along the outputs, such as
+	// that of the two pass logs
+	Scratch []string
+	// Interval is the interval between two checks while transcoding, which needs
+	// ProgressEnabled. Defaults to 10s
+	Interval time.Duration
+}
+
+// withDefaults ...
+func (d DiskSpace) withDefaults() DiskSpace {
+	if d.MinFree <= 0 {
+		d.MinFree = 100 << 20
+	}
+	if d.Margin <= 0 {
+		d.Margin = 1.1
+	}
+	if d.Interval <= 0 {
+		d.Interval = 10 * time.Second
+	}
+	return d
+}
+
+// diskDirs returns the directories of the local outputs and of the scratch space
+func diskDirs(dir string, outputs []string, scratch []string) []string {
+	var dirs []string
+	seen := map[string]bool{}
+	add := func(path string) {
+		if !filepath.IsAbs(path) && len(dir) > 0 {
+			path = filepath.Join(dir, path)
+		}
+		path, _ = filepath.Abs(path)
+		if !seen[path] {
+			seen[path] = true
+			dirs = append(dirs, path)
+		}
+	}
+	for _, output := range outputs {
+		if localFile(output) {
+			add(filepath.Dir(output))
+		}
+	}
+	for _, s := range scratch {
+		add(s)
+	}
+	return dirs
+}
+
+// localFile reports whether output is a file rather than a pipe or a URL
+func localFile(output string) bool {
+	return len(output) > 0 && output != "-" && !strings.HasPrefix(output, "pipe:") && !strings.Contains(output, "://")
+}
+
+// estimateOutputSize returns the bytes the outputs of args need, from the duration
+// and the bitrates they ask for, or those of the input. 0 when unknown
+func estimateOutputSize(args []string, metadata transcoder.Metadata, outputs int) int64 {
+	var duration float64
+	if metadata != nil {
+		duration, _ = strconv.ParseFloat(metadata.GetFormat().GetDuration(), 64)
+	}
+	for i := 0; i+1 < len(args); i++ {
+		if args[i] != "-t" {
+			continue
+		}
+		if d, err := strconv.ParseFloat(args[i+1], 64); err == nil {
+			duration = d
+		} else if d := utils.DurToSec(args[i+1]); d > 0 {
+			duration = d
+		}
+	}
+	return int64(duration * float64(outputBitrate(args, metadata, outputs)) / 8)
+}
+
+// outputBitrate returns the bits per second of the outputs of args, the sum of the
+// bitrates they ask for or that of the input for each output. 0 when unknown
+func outputBitrate(args []string, metadata transcoder.Metadata, outputs int) int64 {
+	var video, audio, maxrate int64
+	for i := 0; i+1 < len(args); i++ {
+		switch args[i] {
+		case "-b:v":
+			video += parseBitrate(args[i+1])
+		case "-b:a", "-ab":
+			audio += parseBitrate(args[i+1])
+		case "-maxrate":
+			maxrate += parseBitrate(args[i+1])
+		}
+	}
+	// the peaks of capped VBR, the average is unknown
+	if video == 0 {
+		video = maxrate
+	}
+	requested := video + audio
+	if requested > 0 || metadata == nil {
+		return requested
+	}
+	bitrate, _ := strconv.ParseInt(metadata.GetFormat().GetBitRate(), 10, 64)
+	return bitrate * int64(outputs)
+}
+
+// checkDiskSpace returns a DiskFullError when a directory has less than required
+// plus MinFree free
+func checkDiskSpace(d DiskSpace, dirs []string, required int64) error {
+	for _, dir := range dirs {
+		free, ok := freeSpace(dir)
+		if ok && free < required+d.MinFree {
+			return &DiskFullError{Path: dir, Free: free, Required: required}
+		}
+	}
+	return nil
+}
+
+// preflight refuses the transcode when its outputs would not fit
+func (t *Transcoder) preflight(args []string) error {
+	d := t.config.DiskSpace.withDefaults()
+	required := int64(float64(estimateOutputSize(args, t.metadata, len(t.output))) * d.Margin)
+	return checkDiskSpace(d, diskDirs(t.config.Dir, t.output, d.Scratch), required)
+}
+
+// watchDisk forwards in, calling cancel when a directory has less than MinFree
+// free. The error of the killed process is then replaced by a DiskFullError
+func watchDisk(in <-chan transcoder.Progress, d DiskSpace, dirs []string, cancel func()) <-chan transcoder.Progress {
+	out := make(chan transcoder.Progress)
+	go func() {
+		defer close(out)
+		defer cancel()
+		ticker := time.NewTicker(d.Interval)
+		defer ticker.Stop()
+		var full error
+		for {
+			select {
+			case msg, ok := <-in:
+				if !ok {
+					if full != nil {
+						out <- Progress{Error: full}
+					}
+					return
+				}
+				if full == nil || msg.GetError() == nil {
+					out <- msg
+				}
+			case <-ticker.C:
+				if full == nil {
+					if full = checkDiskSpace(d, dirs, 0); full != nil {
+						cancel()
+					}
+				}
+			}
+		}
+	}()
+	return out
+}
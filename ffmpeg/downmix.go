@@ -0,0 +1,160 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Downmix layouts
+const (
+	ToStereo = "stereo"
+	ToMono   = "mono"
+)
+
+// DownmixOptions configures the downmix matrix, zero values select the ITU-R BS.775 coefficients
+type DownmixOptions struct {
+	// CenterMix is the gain of the center channel, defaults to 0.707 (-3 dB)
+	CenterMix float64
+	// SurroundMix is the gain of the surround channels, defaults to 0.707 (-3 dB)
+	SurroundMix float64
+	// LFEMix is the gain of the LFE channel, 0 drops it as most standards do
+	LFEMix float64
+	// DialogueBoost raises the center channel and lowers the surrounds,
+	// for sources where a plain downmix makes the dialogue hard to hear
+	DialogueBoost bool
+	// Limiter limits the peaks of the mix to -1 dBFS, its gains adding up above 1 so
+	// that loud passages may clip
+	Limiter bool
+	// Source is the channel layout of the input, such as "stereo", no downmix being
+	// needed when it is the target one. Downmix probes it when empty
+	Source string
+	// AudioCodec and AudioBitrate of the output of Downmix, the video is copied
+	AudioCodec   string
+	AudioBitrate string
+}
+
+// ChannelRoute routes an input channel to an output channel, by name (FL, FR, FC, LFE, SL...) or index
+type ChannelRoute struct {
+	From string
+	To   string
+}
+
+// coefficients returns the center, surround and LFE gains
+func (o DownmixOptions) coefficients() (float64, float64, float64) {
+	center, surround := o.CenterMix, o.SurroundMix
+	if center <= 0 {
+		center = 0.707
+	}
+	if surround <= 0 {
+		surround = 0.707
+	}
+	if o.DialogueBoost {
+		center *= 1.414
+		surround *= 0.5
+	}
+	return center, surround, o.LFEMix
+}
+
+// DownmixFilter returns the audio filter downmixing any layout up to 7.1 to layout (ToStereo
+// or ToMono), empty when opts.Source is layout already. The input is first converted to
+// 5.1(side), folding back channels into the sides, then mixed with pan using the gains as
+// they are, which pan would otherwise renormalize, optionally followed by a limiter
+func DownmixFilter(layout string, opts DownmixOptions) (string, error) {
+	if len(layout) == 0 {
+		layout = ToStereo
+	}
+	if layout != ToStereo && layout != ToMono {
+		return "", fmt.Errorf("unsupported downmix layout %q", layout)
+	}
+	if opts.Source == layout {
+		return "", nil
+	}
+	center, surround, lfe := opts.coefficients()
+	num := func(f float64) string {
+		return strconv.FormatFloat(f, 'f', 3, 64)
+	}
+	term := func(gain float64, channel string) string {
+		if gain <= 0 {
+			return ""
+		}
+		return "+" + num(gain) + "*" + channel
+	}
+	var pan string
+	if layout == ToStereo {
+		left := "FL" + term(center, "FC") + term(surround, "SL") + term(lfe, "LFE")
+		right := "FR" + term(center, "FC") + term(surround, "SR") + term(lfe, "LFE")
+		pan = "pan=stereo|FL=" + left + "|FR=" + right
+	} else {
+		// the average of the stereo downmix
+		pan = "pan=mono|c0=0.5*FL+0.5*FR" + term(center, "FC") + term(surround/2, "SL") + term(surround/2, "SR") + term(lfe, "LFE")
+	}
+	filter := "aformat=channel_layouts=5.1(side)," + pan
+	if opts.Limiter {
+		filter += ",alimiter=limit=0.891:level=false"
+	}
+	return filter, nil
+}
+
+// UpmixFilter returns the audio filter upmixing stereo to layout (5.1, 7.1...)
+func UpmixFilter(layout string) string {
+	return "surround=chl_in=stereo:chl_out=" + layout
+}
+
+// ChannelMapFilter returns the audio filter rearranging channels into layout, for
+// swapped or mislabeled channels
+func ChannelMapFilter(layout string, routes ...ChannelRoute) (string, error) {
+	if len(routes) == 0 {
+		return "", errors.New("no channel route given")
+	}
+	mapping := make([]string, len(routes))
+	for i, r := range routes {
+		if len(r.From) == 0 || len(r.To) == 0 {
+			return "", fmt.Errorf("invalid channel route %q to %q", r.From, r.To)
+		}
+		mapping[i] = r.From + "-" + r.To
+	}
+	filter := "channelmap=map=" + strings.Join(mapping, "|")
+	if len(layout) > 0 {
+		filter += ":channel_layout=" + layout
+	}
+	return filter, nil
+}
+
+// Downmix writes input to output with its audio downmixed to layout (ToStereo or ToMono)
+func Downmix(ctx context.Context, cfg *Config, input, output, layout string, opts DownmixOptions) error {
+	if len(output) == 0 {
+		return errors.New("missing output option")
+	}
+	if len(opts.Source) == 0 {
+		metadata, err := probe(ctx, cfg, input)
+		if err != nil {
+			return err
+		}
+		for _, s := range metadata.GetStreams() {
+			if s.GetCodecType() == "audio" {
+				opts.Source = s.GetChannelLayout()
+				break
+			}
+		}
+	}
+	filter, err := DownmixFilter(layout, opts)
+	if err != nil {
+		return err
+	}
+	args := []string{"-y", "-i", input, "-map", "0", "-c", "copy"}
+	if len(filter) > 0 {
+		codec := opts.AudioCodec
+		if len(codec) == 0 {
+			codec = "aac"
+		}
+		args = append(args, "-af", filter, "-c:a", codec)
+		if len(opts.AudioBitrate) > 0 {
+			args = append(args, "-b:a", opts.AudioBitrate)
+		}
+	}
+	_, err = run(ctx, cfg, append(args, output)...)
+	return err
+}
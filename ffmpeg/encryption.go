@@ -0,0 +1,243 @@
+package ffmpeg
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Encryption schemes
+const (
+	EncryptionAES128    = "AES-128"
+	EncryptionSampleAES = "SAMPLE-AES"
+	EncryptionCENC      = "cenc"
+	EncryptionCBCS      = "cbcs"
+)
+
+// ErrUnsupportedEncryption is returned for schemes ffmpeg's muxers cannot produce
+var ErrUnsupportedEncryption = errors.New("encryption scheme is not supported by ffmpeg's muxers")
+
+// Key is a content encryption key
+type Key struct {
+	// ID is the 16 byte key ID (KID) used by CENC
+	ID []byte
+	// Key is the 16 byte AES key
+	Key []byte
+	// IV is the optional 16 byte initialization vector, ffmpeg derives it from the segment sequence when empty
+	IV []byte
+	// URI is where players fetch the key from (HLS EXT-X-KEY URI)
+	URI string
+}
+
+// GenerateKey returns a random key with a random key ID and IV
+func GenerateKey() (*Key, error) {
+	buf := make([]byte, 48)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	return &Key{ID: buf[:16], Key: buf[16:32], IV: buf[32:]}, nil
+}
+
+// validate ...
+func (k *Key) validate() error {
+	if len(k.Key) != 16 {
+		return fmt.Errorf("encryption key must be 16 bytes, got %d", len(k.Key))
+	}
+	if len(k.IV) != 0 && len(k.IV) != 16 {
+		return fmt.Errorf("encryption IV must be 16 bytes, got %d", len(k.IV))
+	}
+	if len(k.ID) != 0 && len(k.ID) != 16 {
+		return fmt.Errorf("encryption key ID must be 16 bytes, got %d", len(k.ID))
+	}
+	return nil
+}
+
+// KeyProvider supplies content keys, e.g. from a KMS or DRM key server
+type KeyProvider interface {
+	Key(ctx context.Context, contentID string) (*Key, error)
+}
+
+// KeyProviderFunc adapts a function to KeyProvider
+type KeyProviderFunc func(ctx context.Context, contentID string) (*Key, error)
+
+// Key ...
+func (f KeyProviderFunc) Key(ctx context.Context, contentID string) (*Key, error) {
+	return f(ctx, contentID)
+}
+
+// Encryption configures content encryption of HLS and DASH outputs
+type Encryption struct {
+	// Scheme is EncryptionAES128 for HLS or EncryptionCENC for DASH/CMAF
+	Scheme string
+	// Provider supplies the key, a random key is generated when nil
+	Provider  KeyProvider
+	ContentID string
+	// KeyURI is written into the HLS playlists, it overrides Key.URI
+	KeyURI string
+	// KeyDir receives the key file referenced by the HLS key info file, a temporary
+	// directory is used when empty. It must not be publicly served unless KeyURI points to it
+	KeyDir string
+
+	key *Key
+}
+
+// resolve fetches (or generates) the key once
+func (e *Encryption) resolve(ctx context.Context) (*Key, error) {
+	if e.key != nil {
+		return e.key, nil
+	}
+	var key *Key
+	var err error
+	if e.Provider != nil {
+		key, err = e.Provider.Key(ctx, e.ContentID)
+	} else {
+		key, err = GenerateKey()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := key.validate(); err != nil {
+		return nil, err
+	}
+	e.key = key
+	return key, nil
+}
+
+// Key returns the resolved key, nil before the output was started
+func (e *Encryption) Key() *Key {
+	return e.key
+}
+
+// hlsKeyInfo writes the key file and the key info file read by the hls muxer
+// (hls_key_info_file), keeping the key itself off the command line. The returned
+// cleanup removes the files that are not meant to outlive the encode
+func (e *Encryption) hlsKeyInfo(ctx context.Context) (keyInfoFile string, cleanup func(), err error) {
+	switch e.Scheme {
+	case "", EncryptionAES128:
+	case EncryptionSampleAES, EncryptionCBCS:
+		return "", nil, fmt.Errorf("%s: %w", e.Scheme, ErrUnsupportedEncryption)
+	default:
+		return "", nil, fmt.Errorf("encryption scheme %q cannot be used with HLS", e.Scheme)
+	}
+	key, err := e.resolve(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	uri := e.KeyURI
+	if len(uri) == 0 {
+		uri = key.URI
+	}
+	if len(uri) == 0 {
+		return "", nil, errors.New("missing encryption key URI")
+	}
+
+	tmp, err := ioutil.TempDir("", "transcoder-key")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup = func() {
+		os.RemoveAll(tmp)
+	}
+	keyDir := e.KeyDir
+	if len(keyDir) == 0 {
+		keyDir = tmp
+	} else if err := os.MkdirAll(keyDir, 0700); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+
+	keyFile := filepath.Join(keyDir, "enc.key")
+	if err := ioutil.WriteFile(keyFile, key.Key, 0600); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	info := uri + "\n" + keyFile + "\n"
+	if len(key.IV) > 0 {
+		info += hex.EncodeToString(key.IV) + "\n"
+	}
+	keyInfoFile = filepath.Join(tmp, "enc.keyinfo")
+	if err := ioutil.WriteFile(keyInfoFile, []byte(info), 0600); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return keyInfoFile, cleanup, nil
+}
+
+// cencArguments returns the dash muxer options encrypting the fMP4 segments with CENC,
+// passed to the mp4 muxer of the segments by -format_options. The key is redacted
+// from every log line and error message produced by this package
+func (e *Encryption) cencArguments(ctx context.Context) (Args, error) {
+	switch e.Scheme {
+	case EncryptionCENC:
+	case EncryptionCBCS, EncryptionSampleAES:
+		return nil, fmt.Errorf("%s: %w", e.Scheme, ErrUnsupportedEncryption)
+	default:
+		return nil, fmt.Errorf("encryption scheme %q cannot be used with DASH", e.Scheme)
+	}
+	key, err := e.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(key.ID) != 16 {
+		return nil, errors.New("CENC requires a 16 byte key ID")
+	}
+	return Args{
+		"-format_options", "encryption_scheme=cenc-aes-ctr" +
+			":encryption_key=" + hex.EncodeToString(key.Key) +
+			":encryption_kid=" + hex.EncodeToString(key.ID),
+	}, nil
+}
+
+// cencNamespace is the namespace of the default_KID attribute of ContentProtection
+const cencNamespace = "urn:mpeg:cenc:2013"
+
+// protectManifest adds to every adaptation set of the MPD at manifest the
+// ContentProtection element signalling CENC and the key ID, which players need to
+// request a license. Manifests already signalling it are left unchanged
+func (e *Encryption) protectManifest(manifest string) error {
+	key := e.Key()
+	if key == nil {
+		return errors.New("missing CENC key")
+	}
+	b, err := ioutil.ReadFile(manifest)
+	if err != nil {
+		return err
+	}
+	mpd := string(b)
+	if strings.Contains(mpd, "urn:mpeg:dash:mp4protection:2011") {
+		return nil
+	}
+	kid := hex.EncodeToString(key.ID)
+	kid = kid[:8] + "-" + kid[8:12] + "-" + kid[12:16] + "-" + kid[16:20] + "-" + kid[20:]
+	element := `<ContentProtection schemeIdUri="urn:mpeg:dash:mp4protection:2011" value="cenc" cenc:default_KID="` + kid + `"/>`
+
+	var out strings.Builder
+	for {
+		i := strings.Index(mpd, "<AdaptationSet")
+		if i < 0 {
+			break
+		}
+		end := strings.Index(mpd[i:], ">")
+		if end < 0 {
+			break
+		}
+		end += i + 1
+		out.WriteString(mpd[:end])
+		if mpd[end-2] != '/' {
+			out.WriteString("\n\t\t\t" + element)
+		}
+		mpd = mpd[end:]
+	}
+	out.WriteString(mpd)
+	mpd = out.String()
+	if !strings.Contains(mpd, `xmlns:cenc=`) {
+		mpd = strings.Replace(mpd, "<MPD", `<MPD xmlns:cenc="`+cencNamespace+`"`, 1)
+	}
+	return ioutil.WriteFile(manifest, []byte(mpd), 0644)
+}
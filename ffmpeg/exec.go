@@ -0,0 +1,145 @@
+package ffmpeg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+
+	"github.com/admpub/transcoder"
+)
+
+// maxErrorOutput is the amount of stderr kept in error messages
+const maxErrorOutput = 4096
+
+// command returns an exec.Cmd running bin with the environment, directory and limits
+// of cfg
+func command(ctx context.Context, cfg *Config, bin string, args ...string) *exec.Cmd {
+	cmd := limitedCommand(ctx, cfg.Limits, bin, args...)
+	cmd.Env = append(cfg.Env, os.Environ()...)
+	cmd.Dir = cfg.Dir
+	return cmd
+}
+
+// tail returns the end of b, where ffmpeg prints the reason of a failure
+func tail(b []byte) string {
+	if len(b) > maxErrorOutput {
+		b = b[len(b)-maxErrorOutput:]
+	}
+	return string(bytes.TrimSpace(b))
+}
+
+// run executes ffmpeg with args until it exits and returns what it wrote on stderr,
+// which is where ffmpeg prints the results of analysis filters
+func run(ctx context.Context, cfg *Config, args ...string) ([]byte, error) {
+	if cfg.FfmpegBinPath == "" {
+		return nil, errors.New("ffmpeg binary path not found")
+	}
+	var stderr bytes.Buffer
+	ctx, span := startSpan(ctx, cfg, "ffmpeg", commandAttributes(cfg.FfmpegBinPath, args))
+	cmd := command(ctx, cfg, cfg.FfmpegBinPath, append([]string{"-nostdin", "-hide_banner"}, args...)...)
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	endSpan(span, err)
+	if err != nil {
+		return stderr.Bytes(), fmt.Errorf("failed to execute (%s) with args (%s) with error %w | message: %s", cfg.FfmpegBinPath, redact(args), err, tail(stderr.Bytes()))
+	}
+	return stderr.Bytes(), nil
+}
+
+// probe returns the metadata of input
+func probe(ctx context.Context, cfg *Config, input string) (transcoder.Metadata, error) {
+	t := New(cfg).Input(input)
+	if ctx != nil {
+		t.WithContext(ctx)
+	}
+	return t.GetMetadata()
+}
+
+// probeDuration returns the duration of input in seconds
+func probeDuration(ctx context.Context, cfg *Config, input string) (float64, transcoder.Metadata, error) {
+	metadata, err := probe(ctx, cfg, input)
+	if err != nil {
+		return 0, nil, err
+	}
+	duration, _ := strconv.ParseFloat(metadata.GetFormat().GetDuration(), 64)
+	if duration <= 0 {
+		return 0, metadata, fmt.Errorf("unknown duration of %s", input)
+	}
+	return duration, metadata, nil
+}
+
+// probeEntries runs ffprobe with args on input and returns its stdout, used for the
+// packet and frame level entries GetMetadata does not read
+func probeEntries(ctx context.Context, cfg *Config, input string, args ...string) ([]byte, error) {
+	if cfg.FfprobeBinPath == "" {
+		return nil, errors.New("ffprobe binary path not found")
+	}
+	args = append([]string{"-v", "error"}, append(args, "-i", input)...)
+	var stdout, stderr bytes.Buffer
+	ctx, span := startSpan(ctx, cfg, "ffprobe", commandAttributes(cfg.FfprobeBinPath, args))
+	cmd := command(ctx, cfg, cfg.FfprobeBinPath, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	endSpan(span, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute (%s) with args (%s) with error %w | message: %s", cfg.FfprobeBinPath, redact(args), err, tail(stderr.Bytes()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// runOutput executes ffmpeg with args ending with an output written to stdout, and returns what it wrote there
+func runOutput(ctx context.Context, cfg *Config, args ...string) ([]byte, error) {
+	if cfg.FfmpegBinPath == "" {
+		return nil, errors.New("ffmpeg binary path not found")
+	}
+	args = append([]string{"-nostdin", "-hide_banner"}, append(args, "-")...)
+	var stdout, stderr bytes.Buffer
+	ctx, span := startSpan(ctx, cfg, "ffmpeg", commandAttributes(cfg.FfmpegBinPath, args))
+	cmd := command(ctx, cfg, cfg.FfmpegBinPath, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	endSpan(span, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute (%s) with args (%s) with error %w | message: %s", cfg.FfmpegBinPath, redact(args), err, tail(stderr.Bytes()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// runStream executes ffmpeg with args ending with an output written to stdout, and
+// hands stdout to fn while ffmpeg runs, for outputs too large to be buffered
+func runStream(ctx context.Context, cfg *Config, fn func(io.Reader) error, args ...string) error {
+	if cfg.FfmpegBinPath == "" {
+		return errors.New("ffmpeg binary path not found")
+	}
+	args = append([]string{"-nostdin", "-hide_banner"}, append(args, "-")...)
+	var stderr bytes.Buffer
+	ctx, span := startSpan(ctx, cfg, "ffmpeg", commandAttributes(cfg.FfmpegBinPath, args))
+	cmd := command(ctx, cfg, cfg.FfmpegBinPath, args...)
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		endSpan(span, err)
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		endSpan(span, err)
+		return err
+	}
+	fnErr := fn(stdout)
+	// ffmpeg blocks until its output is read
+	io.Copy(ioutil.Discard, stdout)
+	err = cmd.Wait()
+	endSpan(span, err)
+	if err != nil {
+		return fmt.Errorf("failed to execute (%s) with args (%s) with error %w | message: %s", cfg.FfmpegBinPath, redact(args), err, tail(stderr.Bytes()))
+	}
+	return fnErr
+}
@@ -0,0 +1,129 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// DuckOptions configures the ducking of music under a voice
+type DuckOptions struct {
+	// Threshold is the voice level in dB above which the music is lowered, defaults to -30
+	Threshold float64
+	// Ratio of the compression, defaults to 8
+	Ratio float64
+	// Attack and Release of the compression, default to 20ms and 400ms
+	Attack  time.Duration
+	Release time.Duration
+	// MusicVolume is the gain of the music before ducking, defaults to 1
+	MusicVolume float64
+}
+
+// FadeFilter returns the audio filter fading in over in and out over out, duration
+// being the length of the audio the fade out ends at. A zero duration skips that fade
+func FadeFilter(in, out, duration time.Duration) string {
+	var filters []string
+	if in > 0 {
+		filters = append(filters, "afade=t=in:st=0:d="+seconds(in))
+	}
+	if out > 0 && duration > out {
+		filters = append(filters, "afade=t=out:st="+seconds(duration-out)+":d="+seconds(out))
+	}
+	return strings.Join(filters, ",")
+}
+
+// CrossfadeFilter returns the filtergraph node crossfading the labeled streams a into b
+// over duration, writing the result to the label out
+func CrossfadeFilter(a, b, out string, duration time.Duration) string {
+	return fmt.Sprintf("[%s][%s]acrossfade=d=%s:c1=tri:c2=tri[%s]", a, b, seconds(duration), out)
+}
+
+// DuckFilter returns the filtergraph nodes mixing the labeled voice over music lowered
+// whenever the voice is present, writing the result to the label out. The output lasts
+// as long as the voice
+func DuckFilter(music, voice, out string, opts DuckOptions) string {
+	threshold := opts.Threshold
+	if threshold == 0 {
+		threshold = -30
+	}
+	ratio := opts.Ratio
+	if ratio <= 0 {
+		ratio = 8
+	}
+	attack, release := opts.Attack, opts.Release
+	if attack <= 0 {
+		attack = 20 * time.Millisecond
+	}
+	if release <= 0 {
+		release = 400 * time.Millisecond
+	}
+	volume := opts.MusicVolume
+	if volume <= 0 {
+		volume = 1
+	}
+	return fmt.Sprintf("[%s]asplit=2[%s_main][%s_sc];"+
+		"[%s]volume=%g[%s_music];"+
+		"[%s_music][%s_sc]sidechaincompress=threshold=%s:ratio=%g:attack=%g:release=%g[%s_ducked];"+
+		// amix halves each input, the volume restores the voice level
+		"[%s_main][%s_ducked]amix=inputs=2:duration=first:dropout_transition=0,volume=2[%s]",
+		voice, out, out,
+		music, volume, out,
+		out, out, dbToLinear(threshold), ratio, float64(attack)/float64(time.Millisecond), float64(release)/float64(time.Millisecond), out,
+		out, out, out)
+}
+
+// dbToLinear formats a level in dB as the linear value sidechaincompress expects
+func dbToLinear(db float64) string {
+	return fmt.Sprintf("%g", math.Pow(10, db/20))
+}
+
+// Fade writes input to output with its audio faded in and out
+func Fade(ctx context.Context, cfg *Config, input, output string, in, out time.Duration) error {
+	if len(output) == 0 {
+		return errors.New("missing output option")
+	}
+	if in <= 0 && out <= 0 {
+		return errors.New("no fade duration given")
+	}
+	var duration float64
+	if out > 0 {
+		var err error
+		if duration, _, err = probeDuration(ctx, cfg, input); err != nil {
+			return err
+		}
+	}
+	filter := FadeFilter(in, out, time.Duration(duration*float64(time.Second)))
+	args := []string{"-y", "-i", input, "-map", "0", "-c", "copy", "-af", filter}
+	args = append(args, audioOutputArguments(output)...)
+	_, err := run(ctx, cfg, append(args, output)...)
+	return err
+}
+
+// Crossfade writes the audio of a followed by the audio of b to output, overlapping
+// them over duration
+func Crossfade(ctx context.Context, cfg *Config, a, b, output string, duration time.Duration) error {
+	if len(output) == 0 {
+		return errors.New("missing output option")
+	}
+	if duration <= 0 {
+		return errors.New("crossfade duration must be positive")
+	}
+	args := []string{"-y", "-i", a, "-i", b, "-filter_complex", CrossfadeFilter("0:a:0", "1:a:0", "out", duration), "-map", "[out]"}
+	args = append(args, audioOutputArguments(output)...)
+	_, err := run(ctx, cfg, append(args, output)...)
+	return err
+}
+
+// Duck writes voice mixed over music to output, the music being lowered while the voice speaks
+func Duck(ctx context.Context, cfg *Config, music, voice, output string, opts DuckOptions) error {
+	if len(output) == 0 {
+		return errors.New("missing output option")
+	}
+	args := []string{"-y", "-i", music, "-i", voice, "-filter_complex", DuckFilter("0:a:0", "1:a:0", "out", opts), "-map", "[out]"}
+	args = append(args, audioOutputArguments(output)...)
+	_, err := run(ctx, cfg, append(args, output)...)
+	return err
+}
@@ -0,0 +1,289 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/admpub/transcoder"
+)
+
+// SwitchoverProgress is sent on the progress channel when a Failover switches to
+// another input. Cause is nil when it switches back to a recovered primary
+type SwitchoverProgress struct {
+	Progress
+	From string
+	To   string
+	// Index of the new input, 0 is the primary
+	Index int
+	Cause error
+}
+
+// Failover runs a live transcode from a primary input, switching to the next
+// backup when the current input fails or stalls
+type Failover struct {
+	config         *Config
+	inputs         []string
+	output         []string
+	options        []transcoder.Options
+	inputOptions   []transcoder.Options
+	stallTimeout   time.Duration
+	primaryRetry   time.Duration
+	backoff        Backoff
+	commandContext context.Context
+}
+
+// FailoverInputs returns a Failover reading primary, then backups in order
+func FailoverInputs(cfg *Config, primary string, backups ...string) *Failover {
+	return &Failover{
+		config:       cfg,
+		inputs:       append([]string{primary}, backups...),
+		stallTimeout: 10 * time.Second,
+	}
+}
+
+// Output ...
+func (f *Failover) Output(arg string) *Failover {
+	f.output = append(f.output, arg)
+	return f
+}
+
+// WithOptions Sets the options object
+func (f *Failover) WithOptions(opts transcoder.Options) *Failover {
+	f.options = []transcoder.Options{opts}
+	return f
+}
+
+// WithAdditionalOptions Appends an additional options object
+func (f *Failover) WithAdditionalOptions(opts transcoder.Options) *Failover {
+	f.options = append(f.options, opts)
+	return f
+}
+
+// WithInputOptions Appends options placed before every input
+func (f *Failover) WithInputOptions(opts transcoder.Options) *Failover {
+	f.inputOptions = append(f.inputOptions, opts)
+	return f
+}
+
+// WithStallTimeout switches input when the stream time does not advance for timeout, defaults to 10s
+func (f *Failover) WithStallTimeout(timeout time.Duration) *Failover {
+	f.stallTimeout = timeout
+	return f
+}
+
+// WithPrimaryRetry probes the primary every interval while running from a backup
+// and switches back once it answers. 0 stays on the backup
+func (f *Failover) WithPrimaryRetry(interval time.Duration) *Failover {
+	f.primaryRetry = interval
+	return f
+}
+
+// WithBackoff sets the delay applied once every input failed in a row,
+// MaxAttempts counts such rounds
+func (f *Failover) WithBackoff(backoff Backoff) *Failover {
+	f.backoff = backoff
+	return f
+}
+
+// WithContext is to be used *before Starting*, cancelling the context stops the transcode
+func (f *Failover) WithContext(ctx context.Context) *Failover {
+	f.commandContext = ctx
+	return f
+}
+
+// validate ...
+func (f *Failover) validate() error {
+	for _, input := range f.inputs {
+		if len(input) == 0 {
+			return errors.New("missing input option")
+		}
+	}
+	if len(f.output) == 0 {
+		return errors.New("missing output option")
+	}
+	return nil
+}
+
+// displayInput removes the credentials of input
+func displayInput(input string) string {
+	u, err := url.Parse(input)
+	if err != nil || u.User == nil {
+		return input
+	}
+	u.User = nil
+	return u.String()
+}
+
+// attempt starts the transcode from input
+func (f *Failover) attempt(ctx context.Context, input string) (<-chan transcoder.Progress, error) {
+	t := New(progressConfig(f.config)).(*Transcoder)
+	t.WithContext(ctx)
+	if u, err := url.Parse(input); err == nil && (u.Scheme == "rtsp" || u.Scheme == "rtsps") {
+		if _, err := t.InputRTSP(input, RTSPOptions{}); err != nil {
+			return nil, err
+		}
+	} else {
+		t.Input(input)
+		if u != nil && u.User != nil {
+			if password, ok := u.User.Password(); ok {
+				t.WithSecrets(password)
+			}
+		}
+	}
+	for _, o := range f.inputOptions {
+		t.WithInputOptions(o)
+	}
+	for _, o := range f.output {
+		t.Output(o)
+	}
+	for _, o := range f.options {
+		t.WithAdditionalOptions(o)
+	}
+	if f.stallTimeout > 0 {
+		t.WithStallTimeout(f.stallTimeout)
+	}
+	return t.Start(Options{})
+}
+
+// Start transcodes until the context is cancelled, the current input ends or the
+// backoff gives up. SwitchoverProgress values report input switches
+func (f *Failover) Start() (<-chan transcoder.Progress, error) {
+	if err := f.validate(); err != nil {
+		return nil, err
+	}
+	ctx := f.commandContext
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	out := make(chan transcoder.Progress)
+	done := make(chan error, 1)
+	go func() {
+		done <- f.run(ctx, out)
+		close(out)
+	}()
+	if !f.config.ProgressEnabled {
+		for range out {
+		}
+		return out, <-done
+	}
+	progress := make(chan transcoder.Progress)
+	go func() {
+		defer close(progress)
+		for msg := range out {
+			progress <- msg
+		}
+		if err := <-done; err != nil {
+			progress <- Progress{Error: err}
+		}
+	}()
+	return progress, nil
+}
+
+// run rotates through the inputs
+func (f *Failover) run(ctx context.Context, out chan<- transcoder.Progress) error {
+	backoff := f.backoff.withDefaults()
+	delay := backoff.Initial
+	failures := 0
+	current := 0
+
+	for {
+		attemptCtx, cancel := context.WithCancel(ctx)
+		began := time.Now()
+		connected, reverting := false, false
+
+		var retry <-chan time.Time
+		var ticker *time.Ticker
+		if current != 0 && f.primaryRetry > 0 {
+			ticker = time.NewTicker(f.primaryRetry)
+			retry = ticker.C
+		}
+		primaryUp := make(chan struct{}, 1)
+
+		ch, err := f.attempt(attemptCtx, f.inputs[current])
+		if err == nil {
+		forward:
+			for {
+				select {
+				case msg, ok := <-ch:
+					if !ok {
+						break forward
+					}
+					if e := msg.GetError(); e != nil {
+						err = e
+						continue
+					}
+					connected = true
+					out <- msg
+				case <-retry:
+					go func() {
+						probeCtx, cancel := context.WithTimeout(attemptCtx, 10*time.Second)
+						defer cancel()
+						if _, err := probe(probeCtx, f.config, f.inputs[0]); err == nil {
+							select {
+							case primaryUp <- struct{}{}:
+							default:
+							}
+						}
+					}()
+				case <-primaryUp:
+					if !reverting {
+						reverting = true
+						cancel()
+					}
+				}
+			}
+		}
+		cancel()
+		if ticker != nil {
+			ticker.Stop()
+		}
+
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err == nil && !reverting {
+			// the input ended
+			return nil
+		}
+
+		next := (current + 1) % len(f.inputs)
+		cause := err
+		if reverting {
+			next, cause = 0, nil
+			failures = 0
+			delay = backoff.Initial
+		} else {
+			if connected && time.Since(began) >= backoff.ResetAfter {
+				failures = 0
+				delay = backoff.Initial
+			}
+			failures++
+		}
+		out <- SwitchoverProgress{
+			From:  displayInput(f.inputs[current]),
+			To:    displayInput(f.inputs[next]),
+			Index: next,
+			Cause: cause,
+		}
+		current = next
+
+		// every input failed in a row
+		if failures > 0 && failures%len(f.inputs) == 0 {
+			if backoff.MaxAttempts > 0 && failures >= backoff.MaxAttempts*len(f.inputs) {
+				return err
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(delay):
+			}
+			delay = time.Duration(float64(delay) * backoff.Multiplier)
+			if delay > backoff.Max {
+				delay = backoff.Max
+			}
+		}
+	}
+}
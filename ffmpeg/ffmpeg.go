@@ -8,12 +8,10 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"os"
-	"os/exec"
-	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/admpub/transcoder"
 	"github.com/admpub/transcoder/utils"
@@ -31,6 +29,22 @@ type Transcoder struct {
 	inputPipeWriter  io.WriteCloser
 	outputPipeWriter io.WriteCloser
 	commandContext   context.Context
+	inputOptions     []transcoder.Options
+	secrets          []string
+	skipProbe        bool
+	stallTimeout     time.Duration
+	onLine           func(string)
+	onProcess        func(*os.Process)
+	speedSLO         *SpeedSLO
+	onUsage          func(transcoder.Usage)
+	warnings         bool
+	onMetadata       func(transcoder.Metadata)
+	onWarning        func(Warning)
+	probeJSON        []byte
+	limits           *Limits
+	watchdog         *Watchdog
+	smartCopy        bool
+	onSmartCopy      func(SmartCopyReport)
 }
 
 // New ...
@@ -51,18 +65,36 @@ func (t *Transcoder) Start(opts transcoder.Options) (<-chan transcoder.Progress,
 	}
 
 	// Get file metadata
-	metadata, err := t.GetMetadata()
-	if err != nil {
-		return nil, err
-	}
-	if t.config.OnMetadata != nil {
-		if err := t.config.OnMetadata(metadata); err != nil {
+	var err error
+	if !t.skipProbe {
+		var metadata transcoder.Metadata
+		metadata, err = t.GetMetadata()
+		if err != nil {
 			return nil, err
 		}
+		if t.config.OnMetadata != nil {
+			if err := t.config.OnMetadata(metadata); err != nil {
+				return nil, err
+			}
+		}
+		if t.onMetadata != nil {
+			t.onMetadata(metadata)
+		}
 	}
 
-	// Append input file and standard options
-	args := append([]string{"-i", t.input}, opts.GetStrArguments()...)
+	opts = t.applySmartCopy(opts)
+
+	// Append input options, input file and standard options
+	limits := t.limit()
+	args := limits.filterArgs()
+	args = append(args, limits.threadArgs()...)
+	for _, o := range t.inputOptions {
+		args = append(args, o.GetStrArguments()...)
+	}
+	inputAt := len(args)
+	args = append(args, "-i", t.input)
+	args = append(args, limits.threadArgs()...)
+	args = append(args, opts.GetStrArguments()...)
 	outputLength := len(t.output)
 	optionsLength := len(t.options)
 
@@ -73,6 +105,9 @@ func (t *Transcoder) Start(opts transcoder.Options) (<-chan transcoder.Progress,
 		arguments := make([][]string, len(t.options))
 		for i, o := range t.options {
 			arguments[i] = o.GetStrArguments()
+			if i > 0 {
+				arguments[i] = append(limits.threadArgs(), arguments[i]...)
+			}
 		}
 		for index, out := range t.output {
 			// Get executable flags
@@ -91,60 +126,168 @@ func (t *Transcoder) Start(opts transcoder.Options) (<-chan transcoder.Progress,
 		}
 	}
 
+	if readRate := t.config.Throttle.readRateArgs(args, t); readRate != nil {
+		args = append(append(append([]string{}, args[:inputAt]...), readRate...), args[inputAt:]...)
+	}
+
+	var gpu *GPULease
+	if t.config.GPUPool != nil && !gpuPinned(args) {
+		if sessions, ok := gpuSessions(args); ok {
+			gpu, err = t.config.GPUPool.Acquire(t.commandContext, sessions)
+			if err != nil {
+				return nil, fmt.Errorf("failed waiting for a gpu with error %w", err)
+			}
+			args = gpuArgs(args, gpu.Device)
+		}
+	}
+	admitted, err := t.config.admission().Acquire(t.commandContext)
+	if err != nil {
+		gpu.Release()
+		return nil, fmt.Errorf("failed waiting for admission with error %w", err)
+	}
+	release := func() {
+		admitted()
+		gpu.Release()
+	}
+	if t.config.DiskSpace != nil {
+		if err := t.preflight(args); err != nil {
+			release()
+			return nil, err
+		}
+	}
+
 	// Initialize command
 	// If a context object was supplied to this Transcoder before
 	// starting, use this context when creating the command to allow
 	// the command to be killed when the context expires
-	var cmd *exec.Cmd
-	if t.commandContext == nil {
-		cmd = exec.Command(t.config.FfmpegBinPath, args...)
-	} else {
-		cmd = exec.CommandContext(t.commandContext, t.config.FfmpegBinPath, args...)
+	commandContext := t.commandContext
+	var stop context.CancelFunc
+	if (t.stallTimeout > 0 || t.config.DiskSpace != nil) && t.config.ProgressEnabled {
+		if commandContext == nil {
+			commandContext = context.Background()
+		}
+		var cancel context.CancelFunc
+		commandContext, cancel = context.WithCancel(commandContext)
+		stop = cancel
 	}
+	cmd := limitedCommand(commandContext, limits, t.config.FfmpegBinPath, args...)
 	cmd.Env = append(t.config.Env, os.Environ()...)
 	cmd.Dir = t.config.Dir
+	var group *cgroup
+	if limits != nil && limits.Cgroup != nil {
+		group, err = newCgroup(limits.Cgroup)
+		if err != nil {
+			if stop != nil {
+				stop()
+			}
+			release()
+			return nil, err
+		}
+		group.attach(cmd)
+	}
 
 	// If progresss enabled, get stderr pipe and start progress process
-	if t.config.ProgressEnabled && !t.config.Verbose {
+	if t.config.ProgressEnabled {
 		stderrIn, err = cmd.StderrPipe()
 		if err != nil {
-			return nil, fmt.Errorf("failed getting transcoding progress (%s) with args (%s) with error %w", t.config.FfmpegBinPath, args, err)
+			if stop != nil {
+				stop()
+			}
+			group.remove()
+			release()
+			return nil, fmt.Errorf("failed getting transcoding progress (%s) with args (%s) with error %w", t.config.FfmpegBinPath, redact(args, t.secrets...), err)
 		}
 	}
 
+	// the progress parser reads stderr, the verbose output and the bundle get a copy
 	if t.config.Verbose {
-		cmd.Stderr = os.Stdout
+		if stderrIn != nil {
+			stderrIn = teeReadCloser(stderrIn, t.config.verboseOutput())
+		} else {
+			cmd.Stderr = t.config.verboseOutput()
+		}
+	}
+	bundle := t.recorder(cmd, args)
+	watch := newWatcher(t.watchdogConfig(), t.config.Dir, t.output)
+	if stderrIn != nil {
+		stderrIn = watch.stderrReader(bundle.teeReader(stderrIn))
+	} else {
+		cmd.Stderr = watch.stderrWriter(bundle.writer(cmd.Stderr))
 	}
 
+	attributes := commandAttributes(t.config.FfmpegBinPath, args, t.secrets...)
+	attributes["input"], attributes["output"] = redact([]string{t.input}, t.secrets...)[0], strings.Join(redact(t.output, t.secrets...), ",")
+	_, span := startSpan(t.commandContext, t.config, "ffmpeg", metadataAttributes(attributes, t.metadata))
+	log := logger(t.commandContext, t.config).With("input", redact([]string{t.input}, t.secrets...)[0], "output", strings.Join(redact(t.output, t.secrets...), ","))
+	log.Log(transcoder.LevelDebug, "starting transcoding", "args", strings.Join(redact(args, t.secrets...), " "))
+
 	// Start process
 	err = cmd.Start()
 	if err != nil {
-		return nil, fmt.Errorf("failed starting transcoding (%s) with args (%s) with error %w", t.config.FfmpegBinPath, args, err)
+		if stop != nil {
+			stop()
+		}
+		endSpan(span, err)
+		bundle.finish(err)
+		group.remove()
+		release()
+		return nil, fmt.Errorf("failed starting transcoding (%s) with args (%s) with error %w", t.config.FfmpegBinPath, redact(args, t.secrets...), err)
+	}
+	watch.start(cmd.Process)
+	if t.onProcess != nil {
+		t.onProcess(cmd.Process)
+	}
+	var sampler *usageSampler
+	if t.onUsage != nil || bundle != nil {
+		sampler = sampleUsage(cmd.Process)
+	}
+	finish := func(err error) {
+		release()
+		endSpan(span, err)
+		if usage, ok := sampler.finish(cmd.ProcessState); ok {
+			if bundle != nil {
+				bundle.command.Usage = &usage
+			}
+			if t.onUsage != nil {
+				t.onUsage(usage)
+			}
+		}
+		bundle.finish(err)
 	}
 
 	out := make(chan transcoder.Progress)
-	if t.config.ProgressEnabled && !t.config.Verbose {
-		done := make(chan struct{})
-		go func() {
-			t.progress(stderrIn, out)
-			done <- struct{}{}
-			close(done)
-		}()
-
+	if t.config.ProgressEnabled {
 		go func() {
 			defer close(out)
-			err = cmd.Wait()
+			// Wait closes stderr, which is read to the end first
+			t.progress(stderrIn, out)
+			err := watch.finish(group.exited(cmd.Wait()))
 			if err != nil {
-				err = fmt.Errorf("failed to transcoding (%s) with args (%s) with error %w", t.config.FfmpegBinPath, args, err)
-				log.Println(err)
+				err = fmt.Errorf("failed to transcoding (%s) with args (%s) with error %w", t.config.FfmpegBinPath, redact(args, t.secrets...), err)
+				log.Log(transcoder.LevelError, "transcoding failed", "error", err)
 				out <- &Progress{Error: err}
 			}
-			<-done
+			finish(err)
 		}()
+		if stop != nil || t.speedSLO != nil {
+			var progress <-chan transcoder.Progress = out
+			if t.config.DiskSpace != nil {
+				d := t.config.DiskSpace.withDefaults()
+				progress = watchDisk(progress, d, diskDirs(t.config.Dir, t.output, d.Scratch), stop)
+			}
+			if t.stallTimeout > 0 {
+				progress = watchStall(progress, t.stallTimeout, stop)
+			}
+			if t.speedSLO != nil {
+				progress = watchSpeed(progress, *t.speedSLO, log)
+			}
+			return progress, nil
+		}
 	} else {
-		err = cmd.Wait()
+		err = watch.finish(group.exited(cmd.Wait()))
+		finish(err)
 		if err != nil {
-			return nil, fmt.Errorf("failed to transcoding (%s) with args (%s) with error %w", t.config.FfmpegBinPath, args, err)
+			return nil, fmt.Errorf("failed to transcoding (%s) with args (%s) with error %w", t.config.FfmpegBinPath, redact(args, t.secrets...), err)
 		}
 	}
 
@@ -193,6 +336,53 @@ func (t *Transcoder) WithAdditionalOptions(opts transcoder.Options) transcoder.T
 	return t
 }
 
+// WithInputOptions Appends options placed before the input (e.g. -re, -ss, -f)
+func (t *Transcoder) WithInputOptions(opts transcoder.Options) *Transcoder {
+	t.inputOptions = append(t.inputOptions, opts)
+	return t
+}
+
+// WithSecrets registers values (stream keys, passphrases...) masked in error messages
+func (t *Transcoder) WithSecrets(secrets ...string) *Transcoder {
+	t.secrets = append(t.secrets, secrets...)
+	return t
+}
+
+// WithoutProbe skips probing the input before starting, for live inputs that
+// cannot be opened twice (e.g. listening sockets). Progress then has no percentage
+func (t *Transcoder) WithoutProbe() *Transcoder {
+	t.skipProbe = true
+	return t
+}
+
+// WithStallTimeout kills the process when the progress time does not advance for
+// timeout, Start then reports ErrStalled. It needs ProgressEnabled
+func (t *Transcoder) WithStallTimeout(timeout time.Duration) *Transcoder {
+	t.stallTimeout = timeout
+	return t
+}
+
+// WithSpeedSLO reports a HealthProgress when the encoding speed of a live transcode
+// stays under slo.MinSpeed, the encoder then falls behind the source. It needs
+// ProgressEnabled
+func (t *Transcoder) WithSpeedSLO(slo SpeedSLO) *Transcoder {
+	t.speedSLO = &slo
+	return t
+}
+
+// WithMetadataHook registers fn, called with the metadata of the input once probed
+func (t *Transcoder) WithMetadataHook(fn func(transcoder.Metadata)) transcoder.Transcoder {
+	t.onMetadata = fn
+	return t
+}
+
+// WithProcessHook registers fn, called with the ffmpeg process once started, for
+// instance to suspend it. A suspended process trips the stall timeout
+func (t *Transcoder) WithProcessHook(fn func(*os.Process)) transcoder.Transcoder {
+	t.onProcess = fn
+	return t
+}
+
 // WithContext is to be used on a Transcoder *before Starting* to
 // pass in a context.Context object that can be used to kill
 // a running transcoder process. Usage of this method is optional
@@ -234,6 +424,17 @@ func (t *Transcoder) validate() error {
 
 // GetMetadata Returns metadata for the specified input file
 func (t *Transcoder) GetMetadata() (transcoder.Metadata, error) {
+	_, span := startSpan(t.commandContext, t.config, "ffprobe", map[string]interface{}{"input": redact([]string{t.input}, t.secrets...)[0]})
+	metadata, err := t.readMetadata()
+	if span != nil && err == nil {
+		span.SetAttributes(metadataAttributes(map[string]interface{}{}, metadata))
+	}
+	endSpan(span, err)
+	return metadata, err
+}
+
+// readMetadata runs ffprobe on the input
+func (t *Transcoder) readMetadata() (transcoder.Metadata, error) {
 
 	if len(t.config.FfprobeBinPath) > 0 {
 		var outb, errb bytes.Buffer
@@ -253,20 +454,24 @@ func (t *Transcoder) GetMetadata() (transcoder.Metadata, error) {
 			"-show_error",
 		}
 
-		var cmd *exec.Cmd
-		if t.commandContext == nil {
-			cmd = exec.Command(t.config.FfprobeBinPath, args...)
-		} else {
-			cmd = exec.CommandContext(t.commandContext, t.config.FfprobeBinPath, args...)
-		}
+		limits := t.limit()
+		cmd := limitedCommand(t.commandContext, limits, t.config.FfprobeBinPath, args...)
 		cmd.Stdout = &outb
 		cmd.Stderr = &errb
 		cmd.Env = append(t.config.Env, os.Environ()...)
 		cmd.Dir = t.config.Dir
+		var group *cgroup
+		if limits != nil && limits.Cgroup != nil {
+			var err error
+			if group, err = newCgroup(limits.Cgroup); err != nil {
+				return nil, err
+			}
+			group.attach(cmd)
+		}
 
-		err := cmd.Run()
+		err := group.exited(cmd.Run())
 		if err != nil {
-			return nil, fmt.Errorf("error executing (%s) with args (%s) | error: %s | message: %s %s", t.config.FfprobeBinPath, args, err, outb.String(), errb.String())
+			return nil, fmt.Errorf("error executing (%s) with args (%s) | error: %s | message: %s %s", t.config.FfprobeBinPath, redact(args, t.secrets...), err, redact([]string{outb.String()}, t.secrets...)[0], redact([]string{errb.String()}, t.secrets...)[0])
 		}
 
 		var metadata Metadata
@@ -276,6 +481,7 @@ func (t *Transcoder) GetMetadata() (transcoder.Metadata, error) {
 		}
 
 		t.metadata = metadata
+		t.probeJSON = outb.Bytes()
 
 		return metadata, nil
 	}
@@ -283,96 +489,115 @@ func (t *Transcoder) GetMetadata() (transcoder.Metadata, error) {
 	return nil, errors.New("ffprobe binary not found")
 }
 
-var reEQ = regexp.MustCompile(`=\s+`)
 var mgError = `Error `
 var mgFailed = `Conversion failed!`
 
-// progress sends through given channel the transcoding status
-func (t *Transcoder) progress(stream io.ReadCloser, out chan transcoder.Progress) {
+// progressBufferSize is the initial size of the progress scanner buffer, larger than
+// the stats lines so that it does not grow
+const progressBufferSize = 4096
 
-	defer stream.Close()
+// splitLines splits stderr on \n and on the \r ending the stats lines
+func splitLines(data []byte, atEOF bool) (advance int, token []byte, spliterror error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		return i + 1, data[0:i], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
 
-	split := func(data []byte, atEOF bool) (advance int, token []byte, spliterror error) {
-		if atEOF && len(data) == 0 {
-			return 0, nil, nil
+// parseStats sets the fields of p from a stats line such as
+// "frame=  10 fps=0.0 ... time=00:00:01.00 bitrate=N/A speed=1x", the values being
+// substrings of line
+func parseStats(line string, p *Progress) {
+	for i := 0; i < len(line); {
+		eq := strings.IndexByte(line[i:], '=')
+		if eq < 0 {
+			return
 		}
-		if i := bytes.IndexByte(data, '\n'); i >= 0 {
-			// We have a full newline-terminated line.
-			return i + 1, data[0:i], nil
+		eq += i
+		key := line[i:eq]
+		if sp := strings.LastIndexByte(key, ' '); sp >= 0 {
+			key = key[sp+1:]
 		}
-		if i := bytes.IndexByte(data, '\r'); i >= 0 {
-			// We have a cr terminated line
-			return i + 1, data[0:i], nil
+		// ffmpeg pads the values with spaces
+		start := eq + 1
+		for start < len(line) && line[start] == ' ' {
+			start++
 		}
-		if atEOF {
-			return len(data), data, nil
+		end := start
+		for end < len(line) && line[end] != ' ' {
+			end++
 		}
-
-		return 0, nil, nil
+		value := line[start:end]
+		switch key {
+		case "frame":
+			p.FramesProcessed = value
+		case "time":
+			p.CurrentTime = value
+		case "bitrate":
+			p.CurrentBitrate = value
+		case "speed":
+			p.Speed = value
+		}
+		i = end
 	}
+}
+
+// progress sends through given channel the transcoding status
+func (t *Transcoder) progress(stream io.ReadCloser, out chan transcoder.Progress) {
+
+	defer stream.Close()
 
 	scanner := bufio.NewScanner(stream)
-	scanner.Split(split)
+	scanner.Split(splitLines)
+	scanner.Buffer(make([]byte, progressBufferSize), bufio.MaxScanTokenSize)
 
-	buf := make([]byte, 2)
-	scanner.Buffer(buf, bufio.MaxScanTokenSize)
+	var dursec float64
+	if t.metadata != nil {
+		dursec, _ = strconv.ParseFloat(t.metadata.GetFormat().GetDuration(), 64)
+	}
 
 	var isFailed bool
 	var errMessages []string
+	var last Progress
 
 	for scanner.Scan() {
-		Progress := new(Progress)
-		line := scanner.Text()
-		//println(`========>`, `[`+line+`]`)
-		if strings.HasPrefix(line, mgError) {
-			errMessages = append(errMessages, line)
-		} else if strings.HasPrefix(line, mgFailed) {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		stats := bytes.Contains(line, []byte("time=")) && bytes.Contains(line, []byte("bitrate="))
+		// the lines ignored by the parser are only converted for the hooks
+		if !stats && t.onLine == nil && !t.warnings && !bytes.HasPrefix(line, []byte(mgError)) && !bytes.HasPrefix(line, []byte(mgFailed)) {
+			continue
+		}
+		text := string(line)
+		if t.onLine != nil {
+			t.onLine(text)
+		}
+		if t.warnings {
+			t.warning(text, last, out)
+		}
+		if strings.HasPrefix(text, mgError) {
+			errMessages = append(errMessages, text)
+		} else if strings.HasPrefix(text, mgFailed) {
 			isFailed = true
-		} else if strings.Contains(line, "time=") && strings.Contains(line, "bitrate=") {
-			st := reEQ.ReplaceAllString(line, `=`)
-			f := strings.Fields(st)
-			var framesProcessed string
-			var currentTime string
-			var currentBitrate string
-			var currentSpeed string
-
-			for j := 0; j < len(f); j++ {
-				field := f[j]
-				fieldSplit := strings.Split(field, "=")
-
-				if len(fieldSplit) > 1 {
-					fieldname := strings.Split(field, "=")[0]
-					fieldvalue := strings.Split(field, "=")[1]
-
-					if fieldname == "frame" {
-						framesProcessed = fieldvalue
-					}
-
-					if fieldname == "time" {
-						currentTime = fieldvalue
-					}
-
-					if fieldname == "bitrate" {
-						currentBitrate = fieldvalue
-					}
-					if fieldname == "speed" {
-						currentSpeed = fieldvalue
-					}
-				}
-			}
-
-			timesec := utils.DurToSec(currentTime)
-			dursec, _ := strconv.ParseFloat(t.metadata.GetFormat().GetDuration(), 64)
-
-			progress := (timesec * 100) / dursec
-			Progress.Progress = progress
+		} else if stats {
+			var progress Progress
+			parseStats(text, &progress)
 
-			Progress.CurrentBitrate = currentBitrate
-			Progress.FramesProcessed = framesProcessed
-			Progress.CurrentTime = currentTime
-			Progress.Speed = currentSpeed
+			// live inputs have no duration
+			if dursec > 0 {
+				progress.Progress = (utils.DurToSec(progress.CurrentTime) * 100) / dursec
+			}
 
-			out <- *Progress
+			last = progress
+			out <- progress
 		}
 	}
 	if isFailed && len(errMessages) > 0 {
@@ -380,6 +605,14 @@ func (t *Transcoder) progress(stream io.ReadCloser, out chan transcoder.Progress
 	}
 }
 
+// teeReadCloser returns r copying what is read to w
+func teeReadCloser(r io.ReadCloser, w io.Writer) io.ReadCloser {
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.TeeReader(r, w), r}
+}
+
 // closePipes Closes pipes if opened
 func (t *Transcoder) closePipes() {
 	if t.inputPipeReader != nil {
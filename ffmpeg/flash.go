@@ -0,0 +1,182 @@
+package ffmpeg
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// flashWidth and flashHeight are the size frames are analyzed at
+const (
+	flashWidth  = 64
+	flashHeight = 36
+)
+
+// FlashOptions configures AnalyzeFlashes, the defaults follow the general flash
+// thresholds of ITU-R BT.1702 and Ofcom
+type FlashOptions struct {
+	// FPS the video is analyzed at, defaults to the frame rate of the input
+	FPS float64
+	// MaxFlashes is the number of flashes allowed in any one second, defaults to 3
+	MaxFlashes int
+	// MinChange is the relative luminance change making a transition, defaults to 0.1
+	MinChange float64
+	// MinArea is the ratio of the screen a transition must cover, defaults to 0.25
+	MinArea float64
+}
+
+// FlashSequence is a part of the input flashing more than allowed
+type FlashSequence struct {
+	Start time.Duration `json:"start"`
+	End   time.Duration `json:"end"`
+	// Flashes is the highest number of flashes in one second of the sequence
+	Flashes int `json:"flashes"`
+}
+
+// FlashReport is the result of AnalyzeFlashes
+type FlashReport struct {
+	Passed bool `json:"passed"`
+	// Transitions is the number of luminance transitions found, two making a flash
+	Transitions int `json:"transitions"`
+	// MaxFlashes is the highest number of flashes in one second
+	MaxFlashes int             `json:"max_flashes"`
+	Sequences  []FlashSequence `json:"sequences,omitempty"`
+}
+
+// relativeLuminance maps 8 bit luma values to linear relative luminance
+var relativeLuminance = func() [256]float64 {
+	var t [256]float64
+	for i := range t {
+		t[i] = math.Pow(float64(i)/255, 2.2)
+	}
+	return t
+}()
+
+// transitionArea returns the ratio of pixels changing from a to b by at least minChange
+// in the direction of sign, the darker state being under 0.8 as the guidelines require
+func transitionArea(a, b []byte, sign, minChange float64) float64 {
+	n := 0
+	for i := range a {
+		la, lb := relativeLuminance[a[i]], relativeLuminance[b[i]]
+		if (lb-la)*sign >= minChange && math.Min(la, lb) < 0.8 {
+			n++
+		}
+	}
+	return float64(n) / float64(len(a))
+}
+
+// meanLuminance returns the mean relative luminance of a frame
+func meanLuminance(frame []byte) float64 {
+	sum := 0.0
+	for _, v := range frame {
+		sum += relativeLuminance[v]
+	}
+	return sum / float64(len(frame))
+}
+
+// AnalyzeFlashes decodes every frame of the first video stream of input and flags the
+// sequences with more flashes per second than allowed, a flash being a pair of opposing
+// transitions of the luminance of a large part of the screen. It covers general flashes
+// only, red flashes and spatial patterns are not analyzed
+func AnalyzeFlashes(ctx context.Context, cfg *Config, input string, opts FlashOptions) (*FlashReport, error) {
+	fps, maxFlashes, minChange, minArea := opts.FPS, opts.MaxFlashes, opts.MinChange, opts.MinArea
+	if fps <= 0 {
+		_, metadata, err := probeDuration(ctx, cfg, input)
+		if err != nil {
+			return nil, err
+		}
+		if _, _, fps, _, err = sourceVideo(metadata); err != nil {
+			return nil, err
+		}
+		if fps <= 0 {
+			fps = 25
+		}
+	}
+	if maxFlashes <= 0 {
+		maxFlashes = 3
+	}
+	if minChange <= 0 {
+		minChange = 0.1
+	}
+	if minArea <= 0 {
+		minArea = 0.25
+	}
+	args := []string{"-i", input, "-map", "0:v:0", "-an", "-sn",
+		"-vf", fmt.Sprintf("fps=%s,scale=%d:%d:flags=area,format=gray", strconv.FormatFloat(fps, 'f', -1, 64), flashWidth, flashHeight),
+		"-f", "rawvideo"}
+
+	// the frames are streamed, only the previous frame and the last extreme are kept
+	size := flashWidth * flashHeight
+	var transitions []int
+	err := runStream(ctx, cfg, func(stdout io.Reader) error {
+		reader := bufio.NewReaderSize(stdout, size*16)
+		var (
+			previous, extreme       = make([]byte, size), make([]byte, size)
+			frame                   = make([]byte, size)
+			previousMean, direction float64
+			n                       int
+		)
+		for ; ; n++ {
+			if _, err := io.ReadFull(reader, frame); err != nil {
+				break
+			}
+			m := meanLuminance(frame)
+			if n > 0 {
+				d := m - previousMean
+				if d != 0 && direction != 0 && math.Signbit(d) != math.Signbit(direction) {
+					// previous is the end of a monotonic luminance change started at extreme
+					if transitionArea(extreme, previous, direction, minChange) >= minArea {
+						transitions = append(transitions, n-1)
+					}
+					copy(extreme, previous)
+				}
+				if d != 0 {
+					direction = d
+				}
+			} else {
+				copy(extreme, frame)
+			}
+			copy(previous, frame)
+			previousMean = m
+		}
+		if n > 1 && direction != 0 && transitionArea(extreme, previous, direction, minChange) >= minArea {
+			transitions = append(transitions, n-1)
+		}
+		return nil
+	}, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &FlashReport{Passed: true, Transitions: len(transitions)}
+	at := func(frame int) time.Duration { return time.Duration(float64(frame) / fps * float64(time.Second)) }
+	window := int(math.Round(fps))
+	first := 0
+	for i, t := range transitions {
+		for t-transitions[first] >= window {
+			first++
+		}
+		flashes := (i - first + 1) / 2
+		if flashes > report.MaxFlashes {
+			report.MaxFlashes = flashes
+		}
+		if flashes <= maxFlashes {
+			continue
+		}
+		report.Passed = false
+		start, end := at(transitions[first]), at(t+1)
+		if last := len(report.Sequences) - 1; last >= 0 && start <= report.Sequences[last].End {
+			report.Sequences[last].End = end
+			if flashes > report.Sequences[last].Flashes {
+				report.Sequences[last].Flashes = flashes
+			}
+			continue
+		}
+		report.Sequences = append(report.Sequences, FlashSequence{Start: start, End: end, Flashes: flashes})
+	}
+	return report, nil
+}
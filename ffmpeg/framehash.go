@@ -0,0 +1,177 @@
+package ffmpeg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Frame hash algorithms
+const (
+	HashMD5    = "md5"
+	HashCRC    = "crc"
+	HashSHA256 = "sha256"
+)
+
+// FrameHashOptions configures FrameHashes
+type FrameHashOptions struct {
+	// Algorithm defaults to HashMD5, HashCRC is the fastest, any other value is passed to
+	// the framehash muxer (sha256, murmur3...)
+	Algorithm string
+	// Copy hashes the compressed packets instead of the decoded frames, to verify a
+	// bit exact remux rather than an identical picture and sound
+	Copy bool
+	// Maps are the hashed streams (e.g. "0:v:0"), all the streams by default
+	Maps []string
+}
+
+// FrameHash is the hash of a frame, timestamps being in the time base of its stream
+type FrameHash struct {
+	Stream   int    `json:"stream"`
+	DTS      int64  `json:"dts"`
+	PTS      int64  `json:"pts"`
+	Duration int64  `json:"duration"`
+	Size     int    `json:"size"`
+	Hash     string `json:"hash"`
+}
+
+// FrameHashReport holds the frame hashes of an input
+type FrameHashReport struct {
+	Algorithm string `json:"algorithm"`
+	// TimeBases of the hashed streams, by output stream index
+	TimeBases map[int]string `json:"time_bases"`
+	Frames    []FrameHash    `json:"frames"`
+}
+
+// FrameMismatch is a frame differing between two reports, A or B being nil when
+// the frame is missing from one of them
+type FrameMismatch struct {
+	Stream int        `json:"stream"`
+	Frame  int        `json:"frame"`
+	A      *FrameHash `json:"a,omitempty"`
+	B      *FrameHash `json:"b,omitempty"`
+}
+
+// parseFrameHashes reads the output of the framemd5, framecrc and framehash muxers
+func parseFrameHashes(out []byte) (*FrameHashReport, error) {
+	report := &FrameHashReport{TimeBases: map[int]string{}}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			var stream int
+			var tb string
+			if _, err := fmt.Sscanf(line, "#tb %d: %s", &stream, &tb); err == nil {
+				report.TimeBases[stream] = tb
+			} else if strings.HasPrefix(line, "#hash: ") {
+				report.Algorithm = strings.ToLower(strings.TrimPrefix(line, "#hash: "))
+			}
+			continue
+		}
+		fields := strings.Split(line, ",")
+		if len(fields) < 6 {
+			return nil, fmt.Errorf("invalid frame hash line %q", line)
+		}
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+		var f FrameHash
+		var err error
+		if f.Stream, err = strconv.Atoi(fields[0]); err != nil {
+			return nil, fmt.Errorf("invalid frame hash line %q", line)
+		}
+		f.DTS, _ = strconv.ParseInt(fields[1], 10, 64)
+		f.PTS, _ = strconv.ParseInt(fields[2], 10, 64)
+		f.Duration, _ = strconv.ParseInt(fields[3], 10, 64)
+		f.Size, _ = strconv.Atoi(fields[4])
+		f.Hash = fields[5]
+		report.Frames = append(report.Frames, f)
+	}
+	return report, scanner.Err()
+}
+
+// FrameHashes returns a hash of every frame of input, to verify that a remux or an
+// archive migration did not alter the content
+func FrameHashes(ctx context.Context, cfg *Config, input string, opts FrameHashOptions) (*FrameHashReport, error) {
+	args := []string{"-i", input}
+	for _, m := range opts.Maps {
+		args = append(args, "-map", m)
+	}
+	if len(opts.Maps) == 0 {
+		// every stream, ffmpeg would otherwise hash a single video and audio stream
+		args = append(args, "-map", "0")
+	}
+	if opts.Copy {
+		args = append(args, "-c", "copy")
+	}
+	switch opts.Algorithm {
+	case "", HashMD5:
+		args = append(args, "-f", "framemd5")
+	case HashCRC:
+		args = append(args, "-f", "framecrc")
+	default:
+		args = append(args, "-f", "framehash", "-hash", opts.Algorithm)
+	}
+	out, err := runOutput(ctx, cfg, args...)
+	if err != nil {
+		return nil, err
+	}
+	report, err := parseFrameHashes(out)
+	if err != nil {
+		return nil, err
+	}
+	if len(report.Algorithm) == 0 {
+		report.Algorithm = HashCRC
+		if len(opts.Algorithm) == 0 || opts.Algorithm == HashMD5 {
+			report.Algorithm = HashMD5
+		}
+	}
+	return report, nil
+}
+
+// CompareFrameHashes returns the frames of each stream differing between a and b,
+// compared in order by hash, empty when the content is identical
+func CompareFrameHashes(a, b *FrameHashReport) []FrameMismatch {
+	split := func(r *FrameHashReport) map[int][]FrameHash {
+		streams := map[int][]FrameHash{}
+		for _, f := range r.Frames {
+			streams[f.Stream] = append(streams[f.Stream], f)
+		}
+		return streams
+	}
+	sa, sb := split(a), split(b)
+	var streams []int
+	for s := range sa {
+		streams = append(streams, s)
+	}
+	for s := range sb {
+		if _, ok := sa[s]; !ok {
+			streams = append(streams, s)
+		}
+	}
+	sort.Ints(streams)
+	var mismatches []FrameMismatch
+	for _, s := range streams {
+		fa, fb := sa[s], sb[s]
+		for i := 0; i < len(fa) || i < len(fb); i++ {
+			m := FrameMismatch{Stream: s, Frame: i}
+			if i < len(fa) {
+				m.A = &fa[i]
+			}
+			if i < len(fb) {
+				m.B = &fb[i]
+			}
+			if m.A == nil || m.B == nil || m.A.Hash != m.B.Hash {
+				mismatches = append(mismatches, m)
+			}
+		}
+	}
+	return mismatches
+}
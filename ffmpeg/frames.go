@@ -0,0 +1,115 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FramesOptions configures a frame export
+type FramesOptions struct {
+	// Dir receives the images
+	Dir string
+	// Pattern is the printf name of the images formatted with the frame number,
+	// defaults to frame_%08d.png. The extension selects the format
+	Pattern string
+	// Width and Height of the images, 0 follows the aspect ratio, both 0 keep the source size
+	Width  int
+	Height int
+	// Quality from 1 (worst) to 100 (best) for jpg and webp, defaults to 90
+	Quality int
+}
+
+// ExtractedFrame is an exported frame
+type ExtractedFrame struct {
+	Frame int64
+	Path  string
+}
+
+// maxSelectTerms bounds the size of a select expression, longer lists run in several passes
+const maxSelectTerms = 500
+
+// ExtractFrames exports the frames of input with the given numbers (0 based, in
+// decoding order) named after their number, for dataset extraction
+func ExtractFrames(ctx context.Context, cfg *Config, input string, frameNumbers []int64, opts FramesOptions) ([]ExtractedFrame, error) {
+	if len(opts.Dir) == 0 {
+		return nil, errors.New("missing frames output directory")
+	}
+	pattern := opts.Pattern
+	if len(pattern) == 0 {
+		pattern = "frame_%08d.png"
+	}
+	format, err := ScreenshotOptions{Output: pattern}.format()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[int64]bool{}
+	var frames []int64
+	for _, n := range frameNumbers {
+		if n < 0 {
+			return nil, fmt.Errorf("invalid frame number %d", n)
+		}
+		if !seen[n] {
+			seen[n] = true
+			frames = append(frames, n)
+		}
+	}
+	if len(frames) == 0 {
+		return nil, errors.New("no frame number given")
+	}
+	sort.Slice(frames, func(i, j int) bool { return frames[i] < frames[j] })
+	if err := os.MkdirAll(opts.Dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	vsync := []string{"-fps_mode", "passthrough"}
+	if v, err := DetectVersion(ctx, cfg); err == nil && !v.AtLeast(5, 1) {
+		vsync = []string{"-vsync", "passthrough"}
+	}
+	var extracted []ExtractedFrame
+	for start := 0; start < len(frames); start += maxSelectTerms {
+		end := start + maxSelectTerms
+		if end > len(frames) {
+			end = len(frames)
+		}
+		chunk := frames[start:end]
+		terms := make([]string, len(chunk))
+		for i, n := range chunk {
+			terms[i] = "eq(n\\," + strconv.FormatInt(n, 10) + ")"
+		}
+		filter := "select='" + strings.Join(terms, "+") + "'"
+		if scale := screenshotScale(opts.Width, opts.Height); len(scale) > 0 {
+			filter += "," + scale
+		}
+
+		// the muxer numbers the images in selection order, they are renamed after their frame number
+		tmp := filepath.Join(opts.Dir, ".extract_%08d."+strings.TrimPrefix(filepath.Ext(pattern), "."))
+		args := []string{"-y", "-i", input, "-an", "-sn", "-vf", filter}
+		args = append(args, vsync...)
+		args = append(args, "-frames:v", strconv.Itoa(len(chunk)))
+		args = append(args, encoderArguments(format, opts.Quality)...)
+		args = append(args, "-f", "image2", "-start_number", "0", tmp)
+		if _, err := run(ctx, cfg, args...); err != nil {
+			return extracted, err
+		}
+		for i, n := range chunk {
+			from := fmt.Sprintf(tmp, i)
+			if _, err := os.Stat(from); err != nil {
+				// past the end of the input
+				continue
+			}
+			to := filepath.Join(opts.Dir, fmt.Sprintf(pattern, n))
+			if err := os.Rename(from, to); err != nil {
+				return extracted, err
+			}
+			extracted = append(extracted, ExtractedFrame{Frame: n, Path: to})
+		}
+	}
+	return extracted, nil
+}
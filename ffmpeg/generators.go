@@ -0,0 +1,172 @@
+package ffmpeg
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Test patterns of TestVideo
+const (
+	PatternTestsrc2    = "testsrc2"
+	PatternSMPTEBars   = "smptebars"
+	PatternSMPTEHDBars = "smptehdbars"
+	PatternColor       = "color"
+)
+
+// LavfiSource is a lavfi source filter generating audio or video
+type LavfiSource interface {
+	Source() string
+}
+
+// sourceDuration returns the duration option of a source, empty for an endless source
+func sourceDuration(d time.Duration) string {
+	if d <= 0 {
+		return ""
+	}
+	return ":d=" + seconds(d)
+}
+
+// Tone generates a sine wave
+type Tone struct {
+	// Frequency in Hz, defaults to 1000
+	Frequency float64
+	// SampleRate defaults to 48000
+	SampleRate int
+	// BeepFactor adds a beep every second at Frequency times the factor, for A/V sync checks
+	BeepFactor int
+	// Duration, 0 generates an endless tone
+	Duration time.Duration
+}
+
+// Source ...
+func (s Tone) Source() string {
+	frequency, rate := s.Frequency, s.SampleRate
+	if frequency <= 0 {
+		frequency = 1000
+	}
+	if rate <= 0 {
+		rate = 48000
+	}
+	src := "sine=frequency=" + strconv.FormatFloat(frequency, 'f', -1, 64) + ":sample_rate=" + strconv.Itoa(rate)
+	if s.BeepFactor > 0 {
+		src += ":beep_factor=" + strconv.Itoa(s.BeepFactor)
+	}
+	return src + sourceDuration(s.Duration)
+}
+
+// Noise generates noise
+type Noise struct {
+	// Color is white (default), pink, brown, blue, violet or velvet
+	Color string
+	// Amplitude from 0 to 1, defaults to 1
+	Amplitude  float64
+	SampleRate int
+	Duration   time.Duration
+}
+
+// Source ...
+func (s Noise) Source() string {
+	color, rate := s.Color, s.SampleRate
+	if len(color) == 0 {
+		color = "white"
+	}
+	if rate <= 0 {
+		rate = 48000
+	}
+	src := "anoisesrc=color=" + color + ":sample_rate=" + strconv.Itoa(rate)
+	if s.Amplitude > 0 {
+		src += ":amplitude=" + strconv.FormatFloat(s.Amplitude, 'f', -1, 64)
+	}
+	return src + sourceDuration(s.Duration)
+}
+
+// AudioSilence generates silence
+type AudioSilence struct {
+	// ChannelLayout defaults to stereo
+	ChannelLayout string
+	SampleRate    int
+	Duration      time.Duration
+}
+
+// Source ...
+func (s AudioSilence) Source() string {
+	layout, rate := s.ChannelLayout, s.SampleRate
+	if len(layout) == 0 {
+		layout = "stereo"
+	}
+	if rate <= 0 {
+		rate = 48000
+	}
+	src := "anullsrc=channel_layout=" + layout + ":sample_rate=" + strconv.Itoa(rate)
+	if s.Duration > 0 {
+		// anullsrc has no duration option
+		src += ",atrim=duration=" + seconds(s.Duration)
+	}
+	return src
+}
+
+// TestVideo generates a test pattern or a solid color
+type TestVideo struct {
+	// Pattern defaults to PatternTestsrc2
+	Pattern string
+	// Width and Height default to 1280x720
+	Width  int
+	Height int
+	// FrameRate defaults to 25
+	FrameRate float64
+	// Color of PatternColor, defaults to black
+	Color    string
+	Duration time.Duration
+}
+
+// Source ...
+func (s TestVideo) Source() string {
+	width, height, rate := s.Width, s.Height, s.FrameRate
+	if width <= 0 || height <= 0 {
+		width, height = 1280, 720
+	}
+	if rate <= 0 {
+		rate = 25
+	}
+	pattern := s.Pattern
+	if len(pattern) == 0 {
+		pattern = PatternTestsrc2
+	}
+	src := fmt.Sprintf("%s=size=%dx%d:rate=%s", pattern, width, height, strconv.FormatFloat(rate, 'f', -1, 64))
+	if pattern == PatternColor {
+		color := s.Color
+		if len(color) == 0 {
+			color = "black"
+		}
+		src += ":color=" + color
+	}
+	return src + sourceDuration(s.Duration)
+}
+
+// lavfiGraph returns the lavfi input of sources, each source being an output of the input
+func lavfiGraph(sources []LavfiSource) string {
+	if len(sources) == 1 {
+		return sources[0].Source()
+	}
+	nodes := make([]string, len(sources))
+	for i, s := range sources {
+		nodes[i] = s.Source() + "[out" + strconv.Itoa(i) + "]"
+	}
+	return strings.Join(nodes, ";")
+}
+
+// InputLavfi uses generated sources as the input, typically a TestVideo and a Tone.
+// Endless sources need a duration in the output options (e.g. Duration)
+func (t *Transcoder) InputLavfi(sources ...LavfiSource) (*Transcoder, error) {
+	if len(sources) == 0 {
+		return t, errors.New("no lavfi source given")
+	}
+	t.Input(lavfiGraph(sources))
+	t.WithInputOptions(Args{"-f", "lavfi"})
+	// ffprobe cannot open a lavfi graph without its format
+	t.WithoutProbe()
+	return t, nil
+}
@@ -0,0 +1,169 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrGPUSessions is returned when a transcode needs more NVENC sessions than any GPU
+// of the pool has
+var ErrGPUSessions = errors.New("transcode needs more nvenc sessions than a gpu of the pool has")
+
+// GPU is a device of a GPUPool
+type GPU struct {
+	// Index is the CUDA index of the device, passed as -gpu and -hwaccel_device
+	Index int
+	// MaxSessions is the number of NVENC sessions the device runs at once, the
+	// GeForce drivers capping it at 8. 0 leaves it unlimited
+	MaxSessions int
+}
+
+// GPUPool assigns the hardware transcodes to the least loaded GPU, queuing them while
+// every GPU runs its maximum of NVENC sessions. A pool is shared by the Configs of
+// the transcoders using the same GPUs
+type GPUPool struct {
+	mu       sync.Mutex
+	devices  []GPU
+	sessions []int
+	// released is closed and replaced when sessions end
+	released chan struct{}
+}
+
+// NewGPUPool ...
+func NewGPUPool(devices ...GPU) *GPUPool {
+	return &GPUPool{devices: devices, sessions: make([]int, len(devices)), released: make(chan struct{})}
+}
+
+// GPULease is a device assigned to a transcode
+type GPULease struct {
+	pool     *GPUPool
+	slot     int
+	sessions int
+	once     sync.Once
+	// Device is the index of the GPU
+	Device int
+}
+
+// Acquire waits for a GPU able to run sessions more NVENC sessions and assigns it,
+// that with the fewest sessions running first
+func (p *GPUPool) Acquire(ctx context.Context, sessions int) (*GPULease, error) {
+	if len(p.devices) == 0 {
+		return nil, errors.New("gpu pool has no device")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	fits := false
+	for _, d := range p.devices {
+		if d.MaxSessions <= 0 || sessions <= d.MaxSessions {
+			fits = true
+			break
+		}
+	}
+	if !fits {
+		return nil, ErrGPUSessions
+	}
+	for {
+		p.mu.Lock()
+		slot := -1
+		for i, d := range p.devices {
+			if d.MaxSessions > 0 && p.sessions[i]+sessions > d.MaxSessions {
+				continue
+			}
+			if slot < 0 || p.sessions[i] < p.sessions[slot] {
+				slot = i
+			}
+		}
+		if slot >= 0 {
+			p.sessions[slot] += sessions
+			p.mu.Unlock()
+			return &GPULease{pool: p, slot: slot, sessions: sessions, Device: p.devices[slot].Index}, nil
+		}
+		released := p.released
+		p.mu.Unlock()
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Release ends the sessions of the lease, once the transcode exited. l may be nil
+func (l *GPULease) Release() {
+	if l == nil {
+		return
+	}
+	l.once.Do(func() {
+		p := l.pool
+		p.mu.Lock()
+		p.sessions[l.slot] -= l.sessions
+		close(p.released)
+		p.released = make(chan struct{})
+		p.mu.Unlock()
+	})
+}
+
+// Sessions returns the number of NVENC sessions running on each GPU, by index
+func (p *GPUPool) Sessions() map[int]int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sessions := make(map[int]int, len(p.devices))
+	for i, d := range p.devices {
+		sessions[d.Index] = p.sessions[i]
+	}
+	return sessions
+}
+
+// isCodecFlag reports whether flag sets a video encoder
+func isCodecFlag(flag string) bool {
+	return flag == "-vcodec" || flag == "-c:v" || flag == "-codec:v" ||
+		strings.HasPrefix(flag, "-c:v:") || strings.HasPrefix(flag, "-codec:v:")
+}
+
+// gpuSessions returns the NVENC sessions ffmpeg opens with args, and whether it uses
+// the GPU at all, decoding included
+func gpuSessions(args []string) (int, bool) {
+	sessions, hardware := 0, false
+	for i := 0; i+1 < len(args); i++ {
+		switch {
+		case isCodecFlag(args[i]) && strings.HasSuffix(args[i+1], "_nvenc"):
+			sessions++
+			hardware = true
+		case args[i] == "-hwaccel" && (args[i+1] == "cuda" || args[i+1] == "nvdec" || args[i+1] == "cuvid"):
+			hardware = true
+		}
+	}
+	return sessions, hardware
+}
+
+// gpuPinned reports whether args already choose the GPU
+func gpuPinned(args []string) bool {
+	for _, arg := range args {
+		if arg == "-gpu" || arg == "-hwaccel_device" {
+			return true
+		}
+	}
+	return false
+}
+
+// gpuArgs returns args running on device, -hwaccel_device following the -hwaccel and
+// -gpu preceding the NVENC encoders
+func gpuArgs(args []string, device int) []string {
+	index := strconv.Itoa(device)
+	out := make([]string, 0, len(args)+4)
+	for i := 0; i < len(args); i++ {
+		if i+1 < len(args) && isCodecFlag(args[i]) && strings.HasSuffix(args[i+1], "_nvenc") {
+			out = append(out, "-gpu", index)
+		}
+		out = append(out, args[i])
+		if args[i] == "-hwaccel" && i+1 < len(args) {
+			i++
+			out = append(out, args[i], "-hwaccel_device", index)
+		}
+	}
+	return out
+}
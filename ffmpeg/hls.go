@@ -0,0 +1,753 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/admpub/transcoder"
+)
+
+// HLS segment types
+const (
+	HLSSegmentMpegts = "mpegts"
+	HLSSegmentFmp4   = "fmp4"
+)
+
+// HLS muxer flags (hls_flags)
+const (
+	HLSFlagSingleFile              = "single_file"
+	HLSFlagDeleteSegments          = "delete_segments"
+	HLSFlagAppendList              = "append_list"
+	HLSFlagRoundDurations          = "round_durations"
+	HLSFlagDiscontStart            = "discont_start"
+	HLSFlagOmitEndlist             = "omit_endlist"
+	HLSFlagPeriodicRekey           = "periodic_rekey"
+	HLSFlagIndependentSegments     = "independent_segments"
+	HLSFlagIframesOnly             = "iframes_only"
+	HLSFlagSplitByTime             = "split_by_time"
+	HLSFlagProgramDateTime         = "program_date_time"
+	HLSFlagSecondLevelSegmentIndex = "second_level_segment_index"
+	HLSFlagTempFile                = "temp_file"
+)
+
+// HLSRendition is one variant stream of an HLS output
+type HLSRendition struct {
+	// Name identifies the rendition, it is used as the rendition sub directory
+	Name string
+	// Options are the encoding options of the rendition, the master playlist
+	// attributes (BANDWIDTH, RESOLUTION, CODECS...) are computed from them
+	Options Options
+}
+
+// HLSAudioRendition is an audio only rendition listed as EXT-X-MEDIA TYPE=AUDIO
+type HLSAudioRendition struct {
+	// Name identifies the rendition like HLSRendition.Name
+	Name string
+	// GroupID defaults to "audio"
+	GroupID string
+	// Label is the NAME attribute shown by players, defaults to Name
+	Label    string
+	Language string
+	Default  bool
+	Channels string
+	// Source is the input audio stream, defaults to "0:a:0"
+	Source  string
+	Options Options
+}
+
+// HLSSubtitleRendition is a WebVTT rendition listed as EXT-X-MEDIA TYPE=SUBTITLES
+type HLSSubtitleRendition struct {
+	// Name identifies the rendition like HLSRendition.Name
+	Name string
+	// GroupID defaults to "subs"
+	GroupID string
+	// Label is the NAME attribute shown by players, defaults to Name
+	Label    string
+	Language string
+	Default  bool
+	Forced   bool
+	// Source is either a subtitle stream of the input (e.g. "0:s:0") or a subtitle file,
+	// SRT and WebVTT files being segmented by SegmentWebVTT
+	Source string
+}
+
+func (r HLSAudioRendition) groupID() string {
+	if len(r.GroupID) > 0 {
+		return r.GroupID
+	}
+	return "audio"
+}
+
+func (r HLSSubtitleRendition) groupID() string {
+	if len(r.GroupID) > 0 {
+		return r.GroupID
+	}
+	return "subs"
+}
+
+func label(l, name string) string {
+	if len(l) > 0 {
+		return l
+	}
+	return name
+}
+
+// HLSOptions configures an HLS output
+type HLSOptions struct {
+	// Dir is the output directory, each rendition is written into Dir/<rendition name>
+	Dir string
+	// MasterPlaylistName defaults to "master.m3u8"
+	MasterPlaylistName string
+	// PlaylistName is the media playlist name of each rendition, defaults to "index.m3u8"
+	PlaylistName string
+	// SegmentDuration is the target segment duration in seconds (hls_time)
+	SegmentDuration int
+	// PlaylistType is "vod" or "event", empty for a live sliding window
+	PlaylistType string
+	// SegmentType is HLSSegmentMpegts (default) or HLSSegmentFmp4
+	SegmentType string
+	// PlaylistName and SegmentFilename may contain %v, replaced by the rendition name.
+	// SegmentFilename is relative to the rendition directory and defaults to
+	// "segment_%05d.ts" (or .m4s for fMP4)
+	SegmentFilename string
+	// Flat writes every rendition into Dir instead of per rendition sub directories,
+	// PlaylistName then defaults to "%v.m3u8", segments are prefixed with "%v_" and
+	// the fMP4 init segments are named "%v_init.mp4"
+	Flat bool
+	// SingleFile stores each rendition in one media file addressed by EXT-X-BYTERANGE
+	SingleFile bool
+	// Strftime expands strftime patterns in SegmentFilename (e.g. %Y%m%d-%H%M%S),
+	// creating the directories it names
+	Strftime bool
+	// Flags are additional hls_flags, see the HLSFlag constants
+	Flags []string
+	// BaseURL is prepended to every segment URI of the media playlists
+	BaseURL string
+	// Concurrency is the number of renditions encoded at the same time, defaults to 1
+	Concurrency int
+	// SinglePass encodes every rendition from one decode in a single ffmpeg process,
+	// see SinglePass. Progress events are then plain Progress values
+	SinglePass  bool
+	MaxBranches int
+	// Encryption encrypts the segments with AES-128, the key is passed to ffmpeg
+	// through a key info file and never appears on the command line
+	Encryption *Encryption
+	// TrickPlay generates trick play assets once the renditions are encoded, its Dir
+	// defaults to Dir/trickplay. I-frame playlists are added to the master playlist
+	TrickPlay *TrickPlayOptions
+	// OnSegment is called for every completed segment while encoding continues, e.g. to
+	// upload it to a CDN. Returning an error aborts the output. Completed segments are
+	// also reported on the progress channel as SegmentProgress values
+	OnSegment  func(Segment) error
+	Renditions []HLSRendition
+	// AudioRenditions are demuxed audio renditions, when set the variant streams are
+	// encoded without audio and reference the audio group
+	AudioRenditions []HLSAudioRendition
+	// Subtitles are segmented WebVTT renditions referenced by every variant stream
+	Subtitles []HLSSubtitleRendition
+
+	keyInfoFile string
+}
+
+// HLS encodes an input into a multi bitrate HLS output
+type HLS struct {
+	config         *Config
+	input          string
+	options        HLSOptions
+	commandContext context.Context
+}
+
+// NewHLS ...
+func NewHLS(cfg *Config, input string, opts HLSOptions) *HLS {
+	return &HLS{config: cfg, input: input, options: opts}
+}
+
+// WithContext is to be used *before Starting* to pass in a context.Context
+// object that can be used to kill the running ffmpeg processes
+func (h *HLS) WithContext(ctx context.Context) *HLS {
+	h.commandContext = ctx
+	return h
+}
+
+func (o HLSOptions) masterPlaylistName() string {
+	if len(o.MasterPlaylistName) > 0 {
+		return o.MasterPlaylistName
+	}
+	return "master.m3u8"
+}
+
+func (o HLSOptions) playlistName() string {
+	if len(o.PlaylistName) > 0 {
+		return o.PlaylistName
+	}
+	if o.Flat {
+		return "%v.m3u8"
+	}
+	return "index.m3u8"
+}
+
+func (o HLSOptions) segmentFilename() string {
+	if len(o.SegmentFilename) > 0 {
+		return o.SegmentFilename
+	}
+	ext := ".ts"
+	if o.SegmentType == HLSSegmentFmp4 {
+		ext = ".m4s"
+	}
+	var name string
+	switch {
+	case o.SingleFile:
+		name = "stream"
+	case o.Strftime:
+		name = "segment_%Y%m%d-%H%M%S"
+	default:
+		name = "segment_%05d"
+	}
+	if o.Flat {
+		name = "%v_" + name
+	}
+	return name + ext
+}
+
+// substitute replaces the %v variant placeholder with the rendition name
+func substitute(template string, r HLSRendition) string {
+	return strings.Replace(template, "%v", r.Name, -1)
+}
+
+// renditionDir returns the directory receiving the rendition playlist and segments
+func (o HLSOptions) renditionDir(r HLSRendition) string {
+	if o.Flat {
+		return o.Dir
+	}
+	return filepath.Join(o.Dir, r.Name)
+}
+
+// playlistURI returns the media playlist URI relative to the master playlist
+func (o HLSOptions) playlistURI(r HLSRendition) string {
+	name := substitute(o.playlistName(), r)
+	if o.Flat {
+		return name
+	}
+	return path.Join(r.Name, name)
+}
+
+// playlistPath returns the media playlist file of the rendition
+func (o HLSOptions) playlistPath(r HLSRendition) string {
+	return filepath.Join(o.renditionDir(r), substitute(o.playlistName(), r))
+}
+
+// flags returns the hls_flags value
+func (o HLSOptions) flags() string {
+	flags := append([]string{}, o.Flags...)
+	if o.SingleFile {
+		flags = append(flags, HLSFlagSingleFile)
+	}
+	return strings.Join(flags, "+")
+}
+
+// muxerOptions returns the hls muxer options of the rendition
+func (o HLSOptions) muxerOptions(r HLSRendition) Options {
+	format := "hls"
+	segmentFilename := filepath.Join(o.renditionDir(r), substitute(o.segmentFilename(), r))
+	opts := Options{
+		OutputFormat:       &format,
+		HlsSegmentFilename: &segmentFilename,
+	}
+	if o.SegmentDuration > 0 {
+		duration := o.SegmentDuration
+		opts.HlsSegmentDuration = &duration
+	}
+	if len(o.PlaylistType) > 0 {
+		playlistType := o.PlaylistType
+		opts.HlsPlaylistType = &playlistType
+	}
+	if len(o.SegmentType) > 0 {
+		segmentType := o.SegmentType
+		opts.HlsSegmentType = &segmentType
+	}
+	if o.Flat && o.SegmentType == HLSSegmentFmp4 {
+		// the renditions would overwrite the init.mp4 of each other
+		initFilename := substitute("%v_init.mp4", r)
+		opts.HlsFmp4InitFilename = &initFilename
+	}
+	if o.PlaylistType == "vod" || o.PlaylistType == "event" {
+		listSize := 0
+		opts.HlsListSize = &listSize
+	}
+	if len(o.keyInfoFile) > 0 {
+		keyInfoFile := o.keyInfoFile
+		opts.EncryptionKey = &keyInfoFile
+	}
+	if flags := o.flags(); len(flags) > 0 {
+		opts.HlsFlags = &flags
+	}
+	if o.Strftime {
+		enabled := 1
+		opts.HlsStrftime = &enabled
+		opts.HlsStrftimeMkdir = &enabled
+	}
+	if len(o.BaseURL) > 0 {
+		baseURL := o.BaseURL
+		opts.HlsBaseURL = &baseURL
+	}
+	return opts
+}
+
+// MasterPlaylist returns the master playlist referencing every rendition
+func (h *HLS) MasterPlaylist() *MasterPlaylist {
+	m := &MasterPlaylist{Version: 3, IndependentSegments: true}
+	if h.options.SegmentType == HLSSegmentFmp4 {
+		m.Version = 7
+	} else if h.options.SingleFile {
+		// EXT-X-BYTERANGE
+		m.Version = 4
+	}
+
+	var audioGroup, audioCodecs string
+	var audioBandwidth int64
+	for _, a := range h.options.AudioRenditions {
+		m.Media = append(m.Media, Media{
+			Type:       MediaAudio,
+			GroupID:    a.groupID(),
+			Name:       label(a.Label, a.Name),
+			Language:   a.Language,
+			Default:    a.Default,
+			AutoSelect: true,
+			Channels:   a.Channels,
+			URI:        h.options.playlistURI(HLSRendition{Name: a.Name}),
+		})
+		if len(audioGroup) == 0 {
+			audioGroup = a.groupID()
+			audioCodecs = AudioCodecString(a.Options)
+		}
+		if a.groupID() == audioGroup {
+			if rate := parseBitrate(strValue(a.Options.AudioBitrate)); rate > audioBandwidth {
+				audioBandwidth = rate
+			}
+		}
+	}
+	var subtitleGroup string
+	for _, sub := range h.options.Subtitles {
+		m.Media = append(m.Media, Media{
+			Type:     MediaSubtitles,
+			GroupID:  sub.groupID(),
+			Name:     label(sub.Label, sub.Name),
+			Language: sub.Language,
+			Default:  sub.Default,
+			Forced:   sub.Forced,
+			URI:      h.options.playlistURI(HLSRendition{Name: sub.Name}),
+		})
+		if len(subtitleGroup) == 0 {
+			subtitleGroup = sub.groupID()
+		}
+	}
+
+	for _, r := range h.videoRenditions() {
+		uri := h.options.playlistURI(r)
+		v := variantFromOptions(uri, r.Options)
+		if len(audioGroup) > 0 {
+			v.Audio = audioGroup
+			v.Bandwidth += audioBandwidth
+			v.AverageBandwidth += audioBandwidth
+			if len(audioCodecs) > 0 {
+				if len(v.Codecs) > 0 {
+					v.Codecs += ","
+				}
+				v.Codecs += audioCodecs
+			}
+		}
+		v.Subtitles = subtitleGroup
+		m.Variants = append(m.Variants, v)
+	}
+	return m
+}
+
+// videoRenditions returns the variant stream renditions, without audio when audio is demuxed
+func (h *HLS) videoRenditions() []HLSRendition {
+	if len(h.options.AudioRenditions) == 0 {
+		return h.options.Renditions
+	}
+	renditions := make([]HLSRendition, len(h.options.Renditions))
+	for i, r := range h.options.Renditions {
+		skip := true
+		r.Options.SkipAudio = &skip
+		r.Options.AudioCodec = nil
+		r.Options.AudioBitrate = nil
+		renditions[i] = r
+	}
+	return renditions
+}
+
+// finish generates the trick play assets and writes the master playlist
+func (h *HLS) finish(ctx context.Context, master string) error {
+	if h.options.SinglePass && (len(h.options.AudioRenditions) > 0 || len(h.options.Subtitles) > 0) {
+		jobs, err := h.jobs(false, true)
+		if err != nil {
+			return err
+		}
+		if err := runRenditions(ctx, jobs, h.options.Concurrency, nil); err != nil {
+			return err
+		}
+	}
+	m := h.MasterPlaylist()
+	if h.options.TrickPlay != nil {
+		opts := *h.options.TrickPlay
+		if len(opts.Dir) == 0 {
+			opts.Dir = filepath.Join(h.options.Dir, "trickplay")
+		}
+		result, err := TrickPlay(ctx, h.config, h.input, opts)
+		if err != nil {
+			return err
+		}
+		if result.IFrameVariant != nil {
+			variant := *result.IFrameVariant
+			if rel, err := filepath.Rel(h.options.Dir, filepath.Join(opts.Dir, filepath.FromSlash(variant.URI))); err == nil {
+				variant.URI = filepath.ToSlash(rel)
+			}
+			m.IFrameVariants = append(m.IFrameVariants, variant)
+			if m.Version < 4 {
+				m.Version = 4
+			}
+		}
+	}
+	return m.WriteFile(master)
+}
+
+// validate ...
+func (h *HLS) validate() error {
+	if len(h.options.Dir) == 0 {
+		return errors.New("missing HLS output directory")
+	}
+	if len(h.options.Renditions) == 0 {
+		return errors.New("missing HLS renditions")
+	}
+	names := map[string]bool{}
+	for index, r := range h.options.Renditions {
+		if len(r.Name) == 0 {
+			return fmt.Errorf("rendition at index %d has no name", index)
+		}
+		if names[r.Name] {
+			return fmt.Errorf("duplicate rendition name %q", r.Name)
+		}
+		names[r.Name] = true
+	}
+	return nil
+}
+
+// jobs returns one ffmpeg process per variant stream rendition and/or per audio
+// and subtitle rendition
+func (h *HLS) jobs(variants, media bool) ([]renditionJob, error) {
+	cfg := progressConfig(h.config)
+	var jobs []renditionJob
+	var renditions []HLSRendition
+	if variants {
+		renditions = h.videoRenditions()
+	}
+	for _, r := range renditions {
+		job, err := h.renditionJob(cfg, r, nil)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	if !media {
+		return jobs, nil
+	}
+	for _, a := range h.options.AudioRenditions {
+		source := a.Source
+		if len(source) == 0 {
+			source = "0:a:0"
+		}
+		opts := a.Options
+		skip := true
+		opts.SkipVideo = &skip
+		job, err := h.renditionJob(cfg, HLSRendition{Name: a.Name, Options: opts}, Args{"-map", source})
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	for _, sub := range h.options.Subtitles {
+		job, err := h.subtitleJob(sub)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// renditionJob returns the ffmpeg process encoding r into its media playlist
+func (h *HLS) renditionJob(cfg *Config, r HLSRendition, mapping transcoder.Options) (renditionJob, error) {
+	if err := os.MkdirAll(h.options.renditionDir(r), os.ModePerm); err != nil {
+		return renditionJob{}, err
+	}
+	playlist := h.options.playlistPath(r)
+	return renditionJob{
+		name: r.Name,
+		start: func(ctx context.Context) (<-chan transcoder.Progress, error) {
+			ctx, cancel := context.WithCancel(ctx)
+			t := New(cfg).
+				Input(h.input).
+				Output(playlist)
+			if mapping != nil {
+				t.WithAdditionalOptions(mapping)
+			}
+			ch, err := t.
+				WithAdditionalOptions(r.Options).
+				WithAdditionalOptions(h.options.muxerOptions(r)).
+				WithContext(ctx).
+				Start(Options{})
+			if err != nil {
+				cancel()
+				return nil, err
+			}
+			watchers := []*segmentWatcher{{rendition: r.Name, playlist: playlist}}
+			return watchSegments(ch, watchers, h.options.OnSegment, cancel), nil
+		},
+	}, nil
+}
+
+// subtitleJob returns the ffmpeg process segmenting a subtitle rendition into WebVTT
+func (h *HLS) subtitleJob(sub HLSSubtitleRendition) (renditionJob, error) {
+	r := HLSRendition{Name: sub.Name}
+	if err := os.MkdirAll(h.options.renditionDir(r), os.ModePerm); err != nil {
+		return renditionJob{}, err
+	}
+	input, stream := sub.Source, "0:s:0"
+	if len(input) == 0 || isStreamSpecifier(input) {
+		if len(input) > 0 {
+			stream = input
+		}
+		input = h.input
+	}
+	duration := h.options.SegmentDuration
+	if duration <= 0 {
+		duration = 2
+	}
+	name := "%05d.vtt"
+	if h.options.Flat {
+		name = "%v_" + name
+	}
+	if ext := strings.ToLower(filepath.Ext(input)); input == sub.Source && (ext == ".srt" || ext == ".vtt") {
+		return h.webvttJob(sub, r, duration, substitute(name, r)), nil
+	}
+	args := []string{"-y", "-i", input, "-map", stream, "-c:s", "webvtt",
+		"-f", "segment", "-segment_format", "webvtt",
+		"-segment_time", strconv.Itoa(duration),
+		"-segment_list_type", "m3u8",
+		"-segment_list", h.options.playlistPath(r),
+		filepath.Join(h.options.renditionDir(r), substitute(name, r)),
+	}
+	return renditionJob{
+		name: sub.Name,
+		start: func(ctx context.Context) (<-chan transcoder.Progress, error) {
+			out := make(chan transcoder.Progress, 1)
+			go func() {
+				defer close(out)
+				if _, err := run(ctx, h.config, args...); err != nil {
+					out <- Progress{Error: err}
+					return
+				}
+				out <- Progress{Progress: 100}
+			}()
+			return out, nil
+		},
+	}, nil
+}
+
+// webvttJob returns the job segmenting a subtitle file in Go, which keeps the segments
+// aligned with the video segments where the segment muxer drifts
+func (h *HLS) webvttJob(sub HLSSubtitleRendition, r HLSRendition, duration int, pattern string) renditionJob {
+	return renditionJob{
+		name: sub.Name,
+		start: func(ctx context.Context) (<-chan transcoder.Progress, error) {
+			out := make(chan transcoder.Progress, 1)
+			go func() {
+				defer close(out)
+				opts := WebVTTSegmentOptions{
+					Pattern:  pattern,
+					Playlist: substitute(h.options.playlistName(), r),
+				}
+				// the segments cover the whole video, not only up to the last cue
+				if d, _, err := probeDuration(ctx, h.config, h.input); err == nil {
+					opts.Duration = time.Duration(d * float64(time.Second))
+				}
+				if _, err := SegmentWebVTT(sub.Source, h.options.renditionDir(r), time.Duration(duration)*time.Second, opts); err != nil {
+					out <- Progress{Error: err}
+					return
+				}
+				out <- Progress{Progress: 100}
+			}()
+			return out, nil
+		},
+	}
+}
+
+// isStreamSpecifier reports whether s selects an input stream (e.g. "0:s:1") rather than naming a file
+func isStreamSpecifier(s string) bool {
+	if len(s) < 3 || s[0] < '0' || s[0] > '9' {
+		return false
+	}
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return false
+	}
+	_, err := strconv.Atoi(s[:i])
+	return err == nil
+}
+
+// Start encodes every rendition and writes the master playlist once all of them succeeded.
+// Progress events are RenditionProgress values aggregated across renditions
+func (h *HLS) Start() (<-chan transcoder.Progress, error) {
+	if err := h.validate(); err != nil {
+		return nil, err
+	}
+	ctx := h.commandContext
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, span := startSpan(ctx, h.config, "package.hls", map[string]interface{}{
+		"input":      h.input,
+		"output":     h.options.Dir,
+		"renditions": len(h.options.Renditions),
+	})
+	in, err := h.run(ctx)
+	return traceProgress(span, in, err)
+}
+
+// run runs the packaging after the encryption keys are ready
+func (h *HLS) run(ctx context.Context) (<-chan transcoder.Progress, error) {
+	cleanup := func() {}
+	if h.options.Encryption != nil {
+		keyInfoFile, remove, err := h.options.Encryption.hlsKeyInfo(ctx)
+		if err != nil {
+			return nil, err
+		}
+		h.options.keyInfoFile = keyInfoFile
+		cleanup = remove
+	}
+
+	in, err := h.start(ctx)
+	if err != nil || !h.config.ProgressEnabled {
+		cleanup()
+		return in, err
+	}
+
+	out := make(chan transcoder.Progress)
+	go func() {
+		defer close(out)
+		defer cleanup()
+		for msg := range in {
+			out <- msg
+		}
+	}()
+	return out, nil
+}
+
+// start runs the renditions
+func (h *HLS) start(ctx context.Context) (<-chan transcoder.Progress, error) {
+	jobs, err := h.jobs(!h.options.SinglePass, !h.options.SinglePass)
+	if err != nil {
+		return nil, err
+	}
+	master := filepath.Join(h.options.Dir, h.options.masterPlaylistName())
+
+	if h.options.SinglePass {
+		return h.startSinglePass(ctx, master)
+	}
+
+	out := make(chan transcoder.Progress)
+	if !h.config.ProgressEnabled {
+		defer close(out)
+		if err := runRenditions(ctx, jobs, h.options.Concurrency, nil); err != nil {
+			return out, err
+		}
+		return out, h.finish(ctx, master)
+	}
+
+	go func() {
+		defer close(out)
+		err := runRenditions(ctx, jobs, h.options.Concurrency, out)
+		if err == nil {
+			err = h.finish(ctx, master)
+		}
+		if err != nil {
+			out <- Progress{Error: err}
+		}
+	}()
+	return out, nil
+}
+
+// startSinglePass encodes the renditions with SinglePass and writes the master playlist on success
+func (h *HLS) startSinglePass(ctx context.Context, master string) (<-chan transcoder.Progress, error) {
+	opts := SinglePassOptions{
+		MaxBranches: h.options.MaxBranches,
+		Concurrency: h.options.Concurrency,
+	}
+	for _, r := range h.videoRenditions() {
+		if err := os.MkdirAll(h.options.renditionDir(r), os.ModePerm); err != nil {
+			return nil, err
+		}
+		opts.Outputs = append(opts.Outputs, SinglePassOutput{
+			Name:    r.Name,
+			Output:  h.options.playlistPath(r),
+			Options: r.Options,
+			Muxer:   h.options.muxerOptions(r),
+		})
+	}
+
+	var watchers []*segmentWatcher
+	for _, r := range h.options.Renditions {
+		watchers = append(watchers, &segmentWatcher{rendition: r.Name, playlist: h.options.playlistPath(r)})
+	}
+	encodeCtx, cancel := context.WithCancel(ctx)
+	in, err := NewSinglePass(progressConfig(h.config), h.input, opts).WithContext(encodeCtx).Start()
+	if err == nil {
+		in = watchSegments(in, watchers, h.options.OnSegment, cancel)
+	}
+	if err == nil && !h.config.ProgressEnabled {
+		// drain the events, the hooks still run
+		for msg := range in {
+			if err == nil {
+				err = msg.GetError()
+			}
+		}
+	}
+	if err != nil || !h.config.ProgressEnabled {
+		cancel()
+		if err == nil {
+			err = h.finish(ctx, master)
+		}
+		out := make(chan transcoder.Progress)
+		close(out)
+		return out, err
+	}
+
+	out := make(chan transcoder.Progress)
+	go func() {
+		defer close(out)
+		defer cancel()
+		failed := false
+		for msg := range in {
+			if msg.GetError() != nil {
+				failed = true
+			}
+			out <- msg
+		}
+		if failed {
+			return
+		}
+		if err := h.finish(ctx, master); err != nil {
+			out <- Progress{Error: err}
+		}
+	}()
+	return out, nil
+}
@@ -0,0 +1,122 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Image resize modes
+const (
+	// FitContain scales the image to fit inside the box, keeping its aspect ratio
+	FitContain = "contain"
+	// FitCover scales the image to cover the box and crops what overflows
+	FitCover = "cover"
+	// FitFill stretches the image to the box
+	FitFill = "fill"
+)
+
+// ImageOptions configures an image conversion
+type ImageOptions struct {
+	// Format is jpg, png, webp or avif, guessed from the output extension
+	Format string
+	// Width and Height of the box the image is resized to, 0 follows the aspect ratio
+	Width  int
+	Height int
+	// Fit defaults to FitContain
+	Fit string
+	// Quality from 1 (worst) to 100 (best) for lossy formats, defaults to 90
+	Quality int
+	// StripMetadata removes EXIF, XMP and other tags
+	StripMetadata bool
+}
+
+// Images converts still images (HEIC, AVIF, WebP, JPEG, PNG...) with ffmpeg,
+// for services already relying on it for video
+type Images struct {
+	config *Config
+}
+
+// NewImages ...
+func NewImages(cfg *Config) *Images {
+	return &Images{config: cfg}
+}
+
+// imageFormat ...
+func imageFormat(format, output string) (string, error) {
+	format = strings.ToLower(format)
+	if len(format) == 0 {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(output)), ".")
+	}
+	switch format {
+	case "jpg", "jpeg":
+		return "jpg", nil
+	case "png", "webp", "avif":
+		return format, nil
+	}
+	return "", fmt.Errorf("unsupported image format %q", format)
+}
+
+// resizeFilter returns the scale filter of a resize, empty to keep the size
+func resizeFilter(width, height int, fit string) (string, error) {
+	if width <= 0 && height <= 0 {
+		return "", nil
+	}
+	if width <= 0 || height <= 0 {
+		return screenshotScale(width, height), nil
+	}
+	switch fit {
+	case "", FitContain:
+		return fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease", width, height), nil
+	case FitCover:
+		return fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=increase,crop=%d:%d", width, height, width, height), nil
+	case FitFill:
+		return fmt.Sprintf("scale=%d:%d", width, height), nil
+	}
+	return "", fmt.Errorf("invalid image fit %q", fit)
+}
+
+// Convert converts input to output, resizing it when a size is set
+func (i *Images) Convert(ctx context.Context, input, output string, opts ImageOptions) error {
+	if len(input) == 0 {
+		return errors.New("missing input option")
+	}
+	if len(output) == 0 {
+		return errors.New("missing output option")
+	}
+	format, err := imageFormat(opts.Format, output)
+	if err != nil {
+		return err
+	}
+	filter, err := resizeFilter(opts.Width, opts.Height, opts.Fit)
+	if err != nil {
+		return err
+	}
+	args := []string{"-y", "-i", input, "-frames:v", "1"}
+	if len(filter) > 0 {
+		args = append(args, "-vf", filter)
+	}
+	if opts.StripMetadata {
+		args = append(args, "-map_metadata", "-1")
+	}
+	args = append(args, encoderArguments(format, opts.Quality)...)
+	if format == "avif" {
+		args = append(args, "-f", "avif")
+	} else {
+		args = append(args, "-f", "image2", "-update", "1")
+	}
+	_, err = run(ctx, i.config, append(args, output)...)
+	return err
+}
+
+// Resize resizes input to fit inside width x height
+func (i *Images) Resize(ctx context.Context, input, output string, width, height int) error {
+	return i.Convert(ctx, input, output, ImageOptions{Width: width, Height: height})
+}
+
+// StripMetadata rewrites input without its metadata
+func (i *Images) StripMetadata(ctx context.Context, input, output string) error {
+	return i.Convert(ctx, input, output, ImageOptions{StripMetadata: true})
+}
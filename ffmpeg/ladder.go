@@ -0,0 +1,316 @@
+package ffmpeg
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/admpub/transcoder"
+	"github.com/admpub/transcoder/utils"
+)
+
+// LadderRung is a rendition template of an ABR ladder, bitrates are for a 16:9 frame at up to 30fps
+type LadderRung struct {
+	Height       int
+	VideoBitrate int64 // bits per second
+	AudioBitrate int64 // bits per second
+}
+
+// DefaultLadder is a conventional H.264 ladder
+var DefaultLadder = []LadderRung{
+	{Height: 2160, VideoBitrate: 16000000, AudioBitrate: 192000},
+	{Height: 1440, VideoBitrate: 9000000, AudioBitrate: 192000},
+	{Height: 1080, VideoBitrate: 5000000, AudioBitrate: 128000},
+	{Height: 720, VideoBitrate: 2800000, AudioBitrate: 128000},
+	{Height: 480, VideoBitrate: 1400000, AudioBitrate: 128000},
+	{Height: 360, VideoBitrate: 800000, AudioBitrate: 96000},
+	{Height: 240, VideoBitrate: 400000, AudioBitrate: 64000},
+}
+
+// LadderPolicy controls how BuildLadder derives renditions from the source
+type LadderPolicy struct {
+	// Rungs defaults to DefaultLadder, rungs taller than the source are skipped
+	Rungs []LadderRung
+	// VideoCodec defaults to libx264, AudioCodec to aac
+	VideoCodec string
+	AudioCodec string
+	// Preset is the encoder preset of every rendition
+	Preset string
+	// MaxFrameRate caps the output frame rate, 0 keeps the source frame rate
+	MaxFrameRate float64
+	// HighFrameRateFactor scales bitrates of sources above 30fps, defaults to 1.5
+	HighFrameRateFactor float64
+	// KeyframeInterval is the GOP duration in seconds, defaults to 2
+	KeyframeInterval float64
+	// MaxRateFactor and BufferFactor set maxrate/bufsize relative to the bitrate, default to 1.1 and 2
+	MaxRateFactor float64
+	BufferFactor  float64
+	// MaxRenditions limits the number of renditions, keeping the highest ones, 0 means no limit
+	MaxRenditions int
+}
+
+// Rendition is an output of an ABR ladder
+type Rendition struct {
+	Name         string
+	Width        int
+	Height       int
+	FrameRate    float64
+	VideoBitrate int64
+	AudioBitrate int64
+	Options      Options
+}
+
+// h264Levels lists H.264 levels with their max macroblock rate and frame size
+var h264Levels = []struct {
+	level string
+	mbps  int
+	fs    int
+}{
+	{"3.0", 40500, 1620}, {"3.1", 108000, 3600}, {"3.2", 216000, 5120},
+	{"4.0", 245760, 8192}, {"4.2", 522240, 8704}, {"5.0", 589824, 22080},
+	{"5.1", 983040, 36864}, {"5.2", 2073600, 36864},
+}
+
+// h264Level returns the lowest H.264 level able to carry the given frame size and rate
+func h264Level(width, height int, fps float64) string {
+	fs := ((width + 15) / 16) * ((height + 15) / 16)
+	mbps := int(math.Ceil(float64(fs) * fps))
+	for _, l := range h264Levels {
+		if fs <= l.fs && mbps <= l.mbps {
+			return l.level
+		}
+	}
+	return "5.2"
+}
+
+// sourceVideo returns the display dimensions and frame rate of the first video stream
+func sourceVideo(metadata transcoder.Metadata) (width, height int, fps float64, hasAudio bool, err error) {
+	var video transcoder.Streams
+	for _, s := range metadata.GetStreams() {
+		switch s.GetCodecType() {
+		case "video":
+			if video == nil {
+				video = s
+			}
+		case "audio":
+			hasAudio = true
+		}
+	}
+	if video == nil {
+		return 0, 0, 0, hasAudio, errors.New("source has no video stream")
+	}
+	width, height = video.GetWidth(), video.GetHeight()
+	if width <= 0 || height <= 0 {
+		return 0, 0, 0, hasAudio, errors.New("source video stream has no dimensions")
+	}
+	// anamorphic sources are displayed with their sample aspect ratio applied
+	if sar := utils.ParseRate(strings.Replace(video.GetSampleAspectRatio(), ":", "/", 1)); sar > 0 && sar != 1 {
+		width = int(float64(width) * sar)
+	}
+	if rotation(video) == 90 {
+		width, height = height, width
+	}
+	fps = utils.ParseRate(video.GetAvgFrameRate())
+	if fps <= 0 {
+		fps = utils.ParseRate(video.GetRFrameRrate())
+	}
+	return width, height, fps, hasAudio, nil
+}
+
+// rotation returns 90 when the stream is displayed rotated by a quarter turn, 0 otherwise
+func rotation(s transcoder.Streams) int {
+	deg, _ := strconv.Atoi(s.GetTags()["rotate"])
+	for _, side := range s.GetSideDataList() {
+		if r, ok := side["rotation"].(float64); ok {
+			deg = int(r)
+		}
+	}
+	if deg < 0 {
+		deg = -deg
+	}
+	if deg%180 == 90 {
+		return 90
+	}
+	return 0
+}
+
+// even rounds n to the nearest even number, as required by 4:2:0 chroma subsampling
+func even(n float64) int {
+	return int(math.Round(n/2)) * 2
+}
+
+// BuildLadder derives ABR renditions from the probed source: rungs taller than the
+// source are skipped (never upscaling), widths follow the source aspect ratio and
+// the source frame rate is kept (capped by policy.MaxFrameRate)
+func BuildLadder(metadata transcoder.Metadata, policy LadderPolicy) ([]Rendition, error) {
+	if metadata == nil {
+		return nil, errors.New("missing source metadata")
+	}
+	srcWidth, srcHeight, srcFps, hasAudio, err := sourceVideo(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	rungs := policy.Rungs
+	if len(rungs) == 0 {
+		rungs = DefaultLadder
+	}
+	videoCodec := policy.VideoCodec
+	if len(videoCodec) == 0 {
+		videoCodec = "libx264"
+	}
+	audioCodec := policy.AudioCodec
+	if len(audioCodec) == 0 {
+		audioCodec = "aac"
+	}
+	hfrFactor := policy.HighFrameRateFactor
+	if hfrFactor <= 0 {
+		hfrFactor = 1.5
+	}
+	keyint := policy.KeyframeInterval
+	if keyint <= 0 {
+		keyint = 2
+	}
+	maxRateFactor := policy.MaxRateFactor
+	if maxRateFactor <= 0 {
+		maxRateFactor = 1.1
+	}
+	bufferFactor := policy.BufferFactor
+	if bufferFactor <= 0 {
+		bufferFactor = 2
+	}
+
+	fps := srcFps
+	if policy.MaxFrameRate > 0 && fps > policy.MaxFrameRate {
+		fps = policy.MaxFrameRate
+	}
+	aspect := float64(srcWidth) / float64(srcHeight)
+
+	var selected []LadderRung
+	for _, rung := range rungs {
+		if rung.Height <= srcHeight {
+			selected = append(selected, rung)
+		}
+	}
+	if len(selected) == 0 {
+		// source is smaller than the lowest rung: keep a single rendition at source size
+		lowest := rungs[len(rungs)-1]
+		scale := float64(srcHeight) / float64(lowest.Height)
+		selected = []LadderRung{{
+			Height:       srcHeight,
+			VideoBitrate: int64(float64(lowest.VideoBitrate) * scale * scale),
+			AudioBitrate: lowest.AudioBitrate,
+		}}
+	}
+	if policy.MaxRenditions > 0 && len(selected) > policy.MaxRenditions {
+		selected = selected[:policy.MaxRenditions]
+	}
+
+	renditions := make([]Rendition, 0, len(selected))
+	for _, rung := range selected {
+		height := even(float64(rung.Height))
+		width := even(float64(height) * aspect)
+
+		// rung bitrates assume 16:9, scale with the pixel count of other aspect ratios
+		bitrate := float64(rung.VideoBitrate) * (aspect / (16.0 / 9.0))
+		if fps > 30 {
+			bitrate *= hfrFactor
+		}
+		video := int64(bitrate)
+
+		r := Rendition{
+			Name:         fmt.Sprintf("%dp", height),
+			Width:        width,
+			Height:       height,
+			FrameRate:    fps,
+			VideoBitrate: video,
+		}
+		if hasAudio {
+			r.AudioBitrate = rung.AudioBitrate
+		}
+		r.Options = r.options(policy, videoCodec, audioCodec, keyint, maxRateFactor, bufferFactor)
+		if fps < srcFps {
+			rate := int(math.Round(fps))
+			r.Options.FrameRate = &rate
+		}
+		renditions = append(renditions, r)
+	}
+	return renditions, nil
+}
+
+// options returns the encoding options of the rendition
+func (r Rendition) options(policy LadderPolicy, videoCodec, audioCodec string, keyint, maxRateFactor, bufferFactor float64) Options {
+	resolution := fmt.Sprintf("%dx%d", r.Width, r.Height)
+	videoBitrate := strconv.FormatInt(r.VideoBitrate/1000, 10) + "k"
+	maxRate := int(float64(r.VideoBitrate) * maxRateFactor)
+	bufSize := int(float64(r.VideoBitrate) * bufferFactor)
+	profile := "high"
+	if r.Height < 720 {
+		profile = "main"
+	}
+	opts := Options{
+		Resolution:      &resolution,
+		VideoCodec:      &videoCodec,
+		VideoBitRate:    &videoBitrate,
+		VideoMaxBitRate: &maxRate,
+		BufferSize:      &bufSize,
+		VideoProfile:    &profile,
+	}
+	if len(policy.Preset) > 0 {
+		preset := policy.Preset
+		opts.Preset = &preset
+	}
+	if r.FrameRate > 0 {
+		gop := int(math.Round(r.FrameRate * keyint))
+		opts.KeyframeInterval = &gop
+		if strings.HasPrefix(VideoCodecString(Options{VideoCodec: &videoCodec}), "avc1.") {
+			level := h264Level(r.Width, r.Height, r.FrameRate)
+			opts.VideoLevel = &level
+		}
+	}
+	if r.AudioBitrate > 0 {
+		audioBitrate := strconv.FormatInt(r.AudioBitrate/1000, 10) + "k"
+		opts.AudioCodec = &audioCodec
+		opts.AudioBitrate = &audioBitrate
+	} else {
+		skip := true
+		opts.SkipAudio = &skip
+	}
+	return opts
+}
+
+// HLSRenditions converts ladder renditions for NewHLS
+func HLSRenditions(renditions []Rendition) []HLSRendition {
+	out := make([]HLSRendition, len(renditions))
+	for i, r := range renditions {
+		out[i] = HLSRendition{Name: r.Name, Options: r.Options}
+	}
+	return out
+}
+
+// DASHAdaptationSets converts ladder renditions for NewDASH: one video adaptation set
+// with every rendition and, when the source has audio, one audio adaptation set
+// encoded at the highest audio bitrate of the ladder
+func DASHAdaptationSets(renditions []Rendition) []DASHAdaptationSet {
+	video := DASHAdaptationSet{ContentType: DASHVideo}
+	var audio *Options
+	var audioBitrate int64
+	for _, r := range renditions {
+		video.Representations = append(video.Representations, DASHRepresentation{Options: r.Options})
+		if r.AudioBitrate > audioBitrate {
+			opts := r.Options
+			audio = &opts
+			audioBitrate = r.AudioBitrate
+		}
+	}
+	sets := []DASHAdaptationSet{video}
+	if audio != nil {
+		sets = append(sets, DASHAdaptationSet{
+			ContentType:     DASHAudio,
+			Representations: []DASHRepresentation{{Options: *audio}},
+		})
+	}
+	return sets
+}
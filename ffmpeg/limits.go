@@ -0,0 +1,84 @@
+package ffmpeg
+
+import (
+	"context"
+	"os/exec"
+	"strconv"
+)
+
+// I/O scheduling classes of Limits
+const (
+	// IOClassBestEffort shares the disk by IOPriority, the default of Linux
+	IOClassBestEffort = 2
+	// IOClassIdle only gets the disk when no other process uses it
+	IOClassIdle = 3
+)
+
+// Limits keeps the ffmpeg processes from starving the other services of the host
+type Limits struct {
+	// Threads is passed as -threads to the decoders and encoders and as
+	// -filter_threads, 0 lets ffmpeg use every core. The Threads of the options win
+	Threads int
+	// Nice is the niceness of the processes, from -20 to 19, the negative values
+	// needing privileges. It maps to a priority class on Windows: idle from 15, below
+	// normal from 1, above normal under 0 and high from -15. 0 leaves it unchanged
+	Nice int
+	// IOClass is the I/O scheduling class, IOClassBestEffort or IOClassIdle, 0 leaves
+	// it unchanged. Linux only, through ionice
+	IOClass int
+	// IOPriority is the priority in IOClassBestEffort, from 0 (highest) to 7
+	IOPriority int
+	// CPUs are the indexes of the CPUs the processes may run on, every CPU when
+	// empty. Linux only, through taskset
+	CPUs []int
+	// Cgroup places each transcode in a cgroup v2 capping its CPU and memory when
+	// set, the other ffmpeg processes being left out. Linux only
+	Cgroup *Cgroup
+}
+
+// threadArgs returns the -threads arguments, applying to what follows them
+func (l *Limits) threadArgs() []string {
+	if l == nil || l.Threads <= 0 {
+		return nil
+	}
+	return []string{"-threads", strconv.Itoa(l.Threads)}
+}
+
+// filterArgs returns the -filter_threads argument
+func (l *Limits) filterArgs() []string {
+	if l == nil || l.Threads <= 0 {
+		return nil
+	}
+	return []string{"-filter_threads", strconv.Itoa(l.Threads)}
+}
+
+// limitedCommand returns an exec.Cmd running bin with args under l, which may be nil
+func limitedCommand(ctx context.Context, l *Limits, bin string, args ...string) *exec.Cmd {
+	if l != nil {
+		bin, args = l.wrap(bin, args)
+	}
+	var cmd *exec.Cmd
+	if ctx == nil {
+		cmd = exec.Command(bin, args...)
+	} else {
+		cmd = exec.CommandContext(ctx, bin, args...)
+	}
+	if l != nil {
+		l.prepare(cmd)
+	}
+	return cmd
+}
+
+// WithLimits sets the limits of this transcode, instead of those of the Config
+func (t *Transcoder) WithLimits(l Limits) *Transcoder {
+	t.limits = &l
+	return t
+}
+
+// limit returns the limits of the transcode, nil when unlimited
+func (t *Transcoder) limit() *Limits {
+	if t.limits != nil {
+		return t.limits
+	}
+	return t.config.Limits
+}
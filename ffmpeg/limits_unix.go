@@ -0,0 +1,40 @@
+//go:build !windows
+// +build !windows
+
+package ffmpeg
+
+import (
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// wrap returns the command running bin with args through taskset, ionice and nice,
+// which exec it so that the pid stays that of ffmpeg
+func (l *Limits) wrap(bin string, args []string) (string, []string) {
+	var prefix []string
+	if runtime.GOOS == "linux" && len(l.CPUs) > 0 {
+		cpus := make([]string, len(l.CPUs))
+		for i, cpu := range l.CPUs {
+			cpus[i] = strconv.Itoa(cpu)
+		}
+		prefix = append(prefix, "taskset", "-c", strings.Join(cpus, ","))
+	}
+	if runtime.GOOS == "linux" && l.IOClass > 0 {
+		prefix = append(prefix, "ionice", "-c", strconv.Itoa(l.IOClass))
+		if l.IOClass == IOClassBestEffort {
+			prefix = append(prefix, "-n", strconv.Itoa(l.IOPriority))
+		}
+	}
+	if l.Nice != 0 {
+		prefix = append(prefix, "nice", "-n", strconv.Itoa(l.Nice))
+	}
+	if len(prefix) == 0 {
+		return bin, args
+	}
+	return prefix[0], append(append(prefix[1:], bin), args...)
+}
+
+// prepare sets the attributes of cmd applying l, wrap does it all on Unix
+func (l *Limits) prepare(cmd *exec.Cmd) {}
@@ -0,0 +1,40 @@
+package ffmpeg
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// priority classes of CreateProcess
+const (
+	idlePriorityClass        = 0x00000040
+	belowNormalPriorityClass = 0x00004000
+	aboveNormalPriorityClass = 0x00008000
+	highPriorityClass        = 0x00000080
+)
+
+// wrap returns bin and args, Windows has no wrapper commands
+func (l *Limits) wrap(bin string, args []string) (string, []string) {
+	return bin, args
+}
+
+// prepare sets the priority class of cmd from Nice
+func (l *Limits) prepare(cmd *exec.Cmd) {
+	var class uint32
+	switch {
+	case l.Nice >= 15:
+		class = idlePriorityClass
+	case l.Nice > 0:
+		class = belowNormalPriorityClass
+	case l.Nice <= -15:
+		class = highPriorityClass
+	case l.Nice < 0:
+		class = aboveNormalPriorityClass
+	default:
+		return
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= class
+}
@@ -0,0 +1,70 @@
+package ffmpeg
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// blockingPollInterval is how often a blocked playlist request re-reads the playlist
+var blockingPollInterval = 100 * time.Millisecond
+
+// BlockingPlaylistHandler serves an HLS output directory and implements blocking playlist
+// reload: a media playlist request carrying _HLS_msn=N is held until the playlist lists
+// media sequence N, or timeout elapsed. Partial segment delivery directives (_HLS_part)
+// are accepted but resolved at segment granularity
+func BlockingPlaylistHandler(dir string, timeout time.Duration) http.Handler {
+	if timeout <= 0 {
+		timeout = 6 * time.Second
+	}
+	files := http.FileServer(http.Dir(dir))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		msn := r.URL.Query().Get("_HLS_msn")
+		if len(msn) == 0 || !strings.HasSuffix(r.URL.Path, ".m3u8") {
+			files.ServeHTTP(w, r)
+			return
+		}
+		want, err := strconv.Atoi(msn)
+		if err != nil || want < 0 {
+			http.Error(w, "invalid _HLS_msn", http.StatusBadRequest)
+			return
+		}
+		name := filepath.Join(dir, filepath.FromSlash(path.Clean("/"+r.URL.Path)))
+		deadline := time.Now().Add(timeout)
+		for {
+			data, err := ioutil.ReadFile(name)
+			if err != nil {
+				http.NotFound(w, r)
+				return
+			}
+			entries := parseMediaPlaylist(data)
+			if n := len(entries); n > 0 {
+				last := entries[n-1].sequence
+				if last >= want {
+					w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+					w.Header().Set("Cache-Control", "no-cache")
+					w.Write(data)
+					return
+				}
+				// the specification lets servers reject requests too far in the future
+				if want > last+2 {
+					http.Error(w, "_HLS_msn too far in the future", http.StatusBadRequest)
+					return
+				}
+			}
+			if time.Now().After(deadline) {
+				http.Error(w, "playlist update timed out", http.StatusServiceUnavailable)
+				return
+			}
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(blockingPollInterval):
+			}
+		}
+	})
+}
@@ -0,0 +1,19 @@
+package ffmpeg
+
+import (
+	"context"
+
+	"github.com/admpub/transcoder"
+)
+
+// defaultLogger logs the errors with the standard logger when Config.Logger is nil
+var defaultLogger = transcoder.NewStdLogger(nil, transcoder.LevelInfo)
+
+// logger returns the logger of cfg with the log fields of ctx
+func logger(ctx context.Context, cfg *Config) transcoder.Logger {
+	l := defaultLogger
+	if cfg != nil && cfg.Logger != nil {
+		l = cfg.Logger
+	}
+	return transcoder.ContextLogger(ctx, l)
+}
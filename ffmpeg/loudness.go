@@ -0,0 +1,161 @@
+package ffmpeg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// LoudnessOptions configures a loudness normalization, zero values select the EBU R128 targets
+type LoudnessOptions struct {
+	// Integrated is the target loudness in LUFS, defaults to -23
+	Integrated float64
+	// TruePeak is the maximum true peak in dBTP, defaults to -1
+	TruePeak float64
+	// Range is the target loudness range in LU, defaults to 7
+	Range float64
+	// Dynamic runs a single pass with dynamic normalization, faster but it alters the dynamics
+	Dynamic bool
+	// SampleRate of the output, defaults to 48000 (loudnorm works at 192 kHz)
+	SampleRate int
+	// AudioCodec and AudioBitrate of the output, the video is copied
+	AudioCodec   string
+	AudioBitrate string
+}
+
+// Loudness is a loudness measurement
+type Loudness struct {
+	// Integrated loudness in LUFS, TruePeak in dBTP, Range in LU and Threshold in LUFS
+	Integrated float64
+	TruePeak   float64
+	Range      float64
+	Threshold  float64
+}
+
+// LoudnessReport describes a loudness normalization
+type LoudnessReport struct {
+	Before Loudness
+	After  Loudness
+	// Linear is set when the gain was applied linearly, loudnorm falls back to
+	// dynamic normalization when the target cannot be met without clipping
+	Linear bool
+}
+
+// loudnormStats is the JSON printed by loudnorm
+type loudnormStats struct {
+	InputI            string `json:"input_i"`
+	InputTP           string `json:"input_tp"`
+	InputLRA          string `json:"input_lra"`
+	InputThresh       string `json:"input_thresh"`
+	OutputI           string `json:"output_i"`
+	OutputTP          string `json:"output_tp"`
+	OutputLRA         string `json:"output_lra"`
+	OutputThresh      string `json:"output_thresh"`
+	NormalizationType string `json:"normalization_type"`
+	TargetOffset      string `json:"target_offset"`
+}
+
+// parseLoudnorm extracts the JSON statistics loudnorm prints at the end of stderr
+func parseLoudnorm(stderr []byte) (*loudnormStats, error) {
+	start := bytes.LastIndex(stderr, []byte("{"))
+	end := bytes.LastIndex(stderr, []byte("}"))
+	if start < 0 || end < start {
+		return nil, errors.New("loudnorm statistics not found")
+	}
+	stats := &loudnormStats{}
+	if err := json.Unmarshal(stderr[start:end+1], stats); err != nil {
+		return nil, fmt.Errorf("failed to parse loudnorm statistics with error %w", err)
+	}
+	return stats, nil
+}
+
+// loudness converts loudnorm values, "-inf" for silence is kept as -Inf
+func loudness(i, tp, lra, thresh string) Loudness {
+	f := func(s string) float64 {
+		v, _ := strconv.ParseFloat(s, 64)
+		return v
+	}
+	return Loudness{Integrated: f(i), TruePeak: f(tp), Range: f(lra), Threshold: f(thresh)}
+}
+
+// MeasureLoudness measures the loudness of the audio of input
+func MeasureLoudness(ctx context.Context, cfg *Config, input string) (Loudness, error) {
+	stderr, err := run(ctx, cfg, "-i", input, "-vn", "-sn", "-af", "loudnorm=print_format=json", "-f", "null", "-")
+	if err != nil {
+		return Loudness{}, err
+	}
+	stats, err := parseLoudnorm(stderr)
+	if err != nil {
+		return Loudness{}, err
+	}
+	return loudness(stats.InputI, stats.InputTP, stats.InputLRA, stats.InputThresh), nil
+}
+
+// NormalizeLoudness normalizes the audio of input to the target loudness. The two pass
+// mode measures the input first, so the second pass applies a constant gain
+func NormalizeLoudness(ctx context.Context, cfg *Config, input, output string, opts LoudnessOptions) (*LoudnessReport, error) {
+	if len(output) == 0 {
+		return nil, errors.New("missing output option")
+	}
+	integrated, truePeak, lra := opts.Integrated, opts.TruePeak, opts.Range
+	if integrated == 0 {
+		integrated = -23
+	}
+	if truePeak == 0 {
+		truePeak = -1
+	}
+	if lra == 0 {
+		lra = 7
+	}
+	num := func(f float64) string {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	filter := "loudnorm=I=" + num(integrated) + ":TP=" + num(truePeak) + ":LRA=" + num(lra)
+
+	report := &LoudnessReport{}
+	if !opts.Dynamic {
+		stderr, err := run(ctx, cfg, "-i", input, "-vn", "-sn", "-af", filter+":print_format=json", "-f", "null", "-")
+		if err != nil {
+			return nil, err
+		}
+		measured, err := parseLoudnorm(stderr)
+		if err != nil {
+			return nil, err
+		}
+		filter += ":measured_I=" + measured.InputI +
+			":measured_TP=" + measured.InputTP +
+			":measured_LRA=" + measured.InputLRA +
+			":measured_thresh=" + measured.InputThresh +
+			":offset=" + measured.TargetOffset +
+			":linear=true"
+	}
+
+	sampleRate := opts.SampleRate
+	if sampleRate <= 0 {
+		sampleRate = 48000
+	}
+	args := []string{"-y", "-i", input, "-map", "0", "-c", "copy", "-af", filter + ":print_format=json", "-ar", strconv.Itoa(sampleRate)}
+	codec := opts.AudioCodec
+	if len(codec) == 0 {
+		codec = "aac"
+	}
+	args = append(args, "-c:a", codec)
+	if len(opts.AudioBitrate) > 0 {
+		args = append(args, "-b:a", opts.AudioBitrate)
+	}
+	stderr, err := run(ctx, cfg, append(args, output)...)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := parseLoudnorm(stderr)
+	if err != nil {
+		return nil, err
+	}
+	report.Before = loudness(stats.InputI, stats.InputTP, stats.InputLRA, stats.InputThresh)
+	report.After = loudness(stats.OutputI, stats.OutputTP, stats.OutputLRA, stats.OutputThresh)
+	report.Linear = stats.NormalizationType == "linear"
+	return report, nil
+}
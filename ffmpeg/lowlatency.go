@@ -0,0 +1,118 @@
+package ffmpeg
+
+import (
+	"math"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// LowLatencyOptions tunes a LowLatency profile, zero values select the defaults
+type LowLatencyOptions struct {
+	// VideoCodec defaults to libx264
+	VideoCodec string
+	// Preset defaults to veryfast
+	Preset string
+	// FrameRate of the output, used to size the GOP, defaults to 30
+	FrameRate int
+	// GOP is the keyframe interval, defaults to 1s. Players can only join on a keyframe
+	GOP time.Duration
+	// AnalyzeDuration bounds the input analysis at startup, defaults to 500ms
+	AnalyzeDuration time.Duration
+	// AudioCodec defaults to aac, "-" disables audio
+	AudioCodec string
+}
+
+// LowLatencyProfile holds the arguments of a low latency live transcode:
+// Input goes before the input, Options and Output after it
+type LowLatencyProfile struct {
+	Input   Args
+	Options Options
+	Output  Args
+}
+
+// LowLatency returns the profile of a low latency live transcode to output. The
+// muxer and its flush options are chosen from the URL scheme of output
+func LowLatency(output string, opts LowLatencyOptions) LowLatencyProfile {
+	videoCodec := opts.VideoCodec
+	if len(videoCodec) == 0 {
+		videoCodec = "libx264"
+	}
+	frameRate := opts.FrameRate
+	if frameRate <= 0 {
+		frameRate = 30
+	}
+	gop := opts.GOP
+	if gop <= 0 {
+		gop = time.Second
+	}
+	analyze := opts.AnalyzeDuration
+	if analyze <= 0 {
+		analyze = 500 * time.Millisecond
+	}
+	keyint := int(math.Max(1, math.Round(gop.Seconds()*float64(frameRate))))
+	bframes := 0
+	pixFmt := "yuv420p"
+
+	p := LowLatencyProfile{
+		Input: Args{
+			"-fflags", "nobuffer",
+			"-flags", "low_delay",
+			"-analyzeduration", strconv.FormatInt(analyze.Microseconds(), 10),
+		},
+		Options: Options{
+			VideoCodec:       &videoCodec,
+			FrameRate:        &frameRate,
+			KeyframeInterval: &keyint,
+			Bframe:           &bframes,
+			PixFmt:           &pixFmt,
+		},
+	}
+	switch videoCodec {
+	case "libx264", "libx265":
+		preset, tune := opts.Preset, "zerolatency"
+		if len(preset) == 0 {
+			preset = "veryfast"
+		}
+		p.Options.Preset = &preset
+		p.Options.Tune = &tune
+	}
+	switch opts.AudioCodec {
+	case "-":
+		skip := true
+		p.Options.SkipAudio = &skip
+	case "":
+		audioCodec := "aac"
+		p.Options.AudioCodec = &audioCodec
+	default:
+		audioCodec := opts.AudioCodec
+		p.Options.AudioCodec = &audioCodec
+	}
+
+	// a fixed GOP keeps segment and keyframe boundaries where players expect them
+	p.Output = Args{"-keyint_min", strconv.Itoa(keyint), "-sc_threshold", "0"}
+	scheme := ""
+	if u, err := url.Parse(output); err == nil {
+		scheme = u.Scheme
+	}
+	switch scheme {
+	case "rtmp", "rtmps", "rtmpt", "rtmpe", "rtmpte", "rtmpts":
+		p.Output = append(p.Output, "-f", "flv", "-flvflags", "no_duration_filesize", "-flush_packets", "1")
+	case "srt", "udp", "tcp", "rist":
+		p.Output = append(p.Output, "-f", "mpegts", "-muxdelay", "0", "-muxpreload", "0", "-flush_packets", "1")
+	case "rtsp", "rtsps":
+		p.Output = append(p.Output, "-f", "rtsp", "-rtsp_transport", "tcp", "-muxdelay", "0.1")
+	default:
+		// files and pipes: write packets as soon as they are muxed
+		p.Output = append(p.Output, "-flush_packets", "1")
+	}
+	return p
+}
+
+// Apply sets the profile on t, replacing its options
+func (p LowLatencyProfile) Apply(t *Transcoder) *Transcoder {
+	t.WithInputOptions(p.Input)
+	t.WithOptions(p.Options)
+	t.WithAdditionalOptions(p.Output)
+	return t
+}
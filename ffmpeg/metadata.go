@@ -55,11 +55,17 @@ type Streams struct {
 	BitRate            string                   `json:"bit_rate"`
 	Tags               map[string]string        `json:"tags"`
 	SideDataList       []map[string]interface{} `json:"side_data_list"`
+	ClosedCaptions     int                      `json:"closed_captions"`
+	SampleRate         string                   `json:"sample_rate"`
+	Channels           int                      `json:"channels"`
+	ChannelLayout      string                   `json:"channel_layout"`
+	FieldOrder         string                   `json:"field_order"`
 }
 
 // Tags ...
 type Tags struct {
-	Encoder string `json:"ENCODER"`
+	Encoder  string `json:"ENCODER"`
+	Timecode string `json:"timecode"`
 }
 
 // Disposition ...
@@ -144,6 +150,11 @@ func (t Tags) GetEncoder() string {
 	return t.Encoder
 }
 
+// GetTimecode ...
+func (t Tags) GetTimecode() string {
+	return t.Timecode
+}
+
 //GetIndex ...
 func (s Streams) GetIndex() int {
 	return s.Index
@@ -297,6 +308,31 @@ func (s Streams) GetSideDataList() []map[string]interface{} {
 	return s.SideDataList
 }
 
+//GetClosedCaptions ...
+func (s Streams) GetClosedCaptions() int {
+	return s.ClosedCaptions
+}
+
+//GetSampleRate ...
+func (s Streams) GetSampleRate() string {
+	return s.SampleRate
+}
+
+//GetChannels ...
+func (s Streams) GetChannels() int {
+	return s.Channels
+}
+
+//GetChannelLayout ...
+func (s Streams) GetChannelLayout() string {
+	return s.ChannelLayout
+}
+
+//GetFieldOrder ...
+func (s Streams) GetFieldOrder() string {
+	return s.FieldOrder
+}
+
 //GetDefault ...
 func (d Disposition) GetDefault() int {
 	return d.Default
@@ -0,0 +1,76 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// MixAudioOptions configures MixAudio
+type MixAudioOptions struct {
+	// Replace drops the audio of the video instead of mixing the music with it
+	Replace bool
+	// FadeOut fades the music out over the end of the video
+	FadeOut time.Duration
+	// AudioCodec and AudioBitrate of the output, default to aac at 192k, the video is copied
+	AudioCodec   string
+	AudioBitrate string
+}
+
+// MixAudio writes video to output with music mixed at musicVolume (1 keeps its level)
+// over its audio. The music is looped when loop is set, padded with silence otherwise,
+// and cut at the end of the video
+func MixAudio(ctx context.Context, cfg *Config, video, music, output string, musicVolume float64, loop bool, opts MixAudioOptions) error {
+	if len(output) == 0 {
+		return errors.New("missing output option")
+	}
+	if musicVolume < 0 {
+		return errors.New("music volume must not be negative")
+	}
+	duration, metadata, err := probeDuration(ctx, cfg, video)
+	if err != nil {
+		return err
+	}
+	hasAudio := false
+	for _, s := range metadata.GetStreams() {
+		if s.GetCodecType() == "audio" {
+			hasAudio = true
+			break
+		}
+	}
+
+	d := strconv.FormatFloat(duration, 'f', 3, 64)
+	filter := fmt.Sprintf("[1:a:0]volume=%g,aresample=async=1", musicVolume)
+	if !loop {
+		filter += ",apad"
+	}
+	filter += ",atrim=0:" + d + ",asetpts=PTS-STARTPTS"
+	if opts.FadeOut > 0 {
+		filter += "," + FadeFilter(0, opts.FadeOut, time.Duration(duration*float64(time.Second)))
+	}
+	if hasAudio && !opts.Replace {
+		// amix halves each input, the volume restores the original level
+		filter += "[music];[0:a:0][music]amix=inputs=2:duration=first:dropout_transition=0,volume=2[out]"
+	} else {
+		filter += "[out]"
+	}
+
+	codec := opts.AudioCodec
+	if len(codec) == 0 {
+		codec = "aac"
+	}
+	bitrate := opts.AudioBitrate
+	if len(bitrate) == 0 {
+		bitrate = "192k"
+	}
+	args := []string{"-y", "-i", video}
+	if loop {
+		args = append(args, "-stream_loop", "-1")
+	}
+	args = append(args, "-i", music, "-filter_complex", filter,
+		"-map", "0:v?", "-map", "[out]", "-c:v", "copy", "-c:a", codec, "-b:a", bitrate, "-t", d, output)
+	_, err = run(ctx, cfg, args...)
+	return err
+}
@@ -0,0 +1,141 @@
+package ffmpeg
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UDPOptions are the options of a UDP (usually multicast) output
+type UDPOptions struct {
+	// PacketSize defaults to 1316, 7 MPEG-TS packets fitting an Ethernet frame
+	PacketSize int
+	// TTL of multicast packets, the number of routers they may cross
+	TTL int
+	// LocalAddr selects the interface multicast is sent from
+	LocalAddr string
+	// BufferSize is the socket send buffer in bytes
+	BufferSize int
+	// Bitrate paces the packets (bits per second), for receivers without a large buffer
+	Bitrate int64
+	// BurstBits is the amount of bits sent at once when Bitrate is set
+	BurstBits int64
+}
+
+// URL returns the udp:// URL of address (host:port)
+func (o UDPOptions) URL(address string) (string, error) {
+	address = strings.TrimPrefix(address, "udp://")
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", fmt.Errorf("invalid UDP address %q: %w", address, err)
+	}
+	if _, err := strconv.Atoi(port); err != nil {
+		return "", fmt.Errorf("invalid UDP port %q", port)
+	}
+	query := url.Values{}
+	size := o.PacketSize
+	if size <= 0 {
+		size = 1316
+	}
+	query.Set("pkt_size", strconv.Itoa(size))
+	if o.TTL > 0 {
+		if ip := net.ParseIP(host); ip == nil || !ip.IsMulticast() {
+			return "", fmt.Errorf("TTL needs a multicast address, got %q", host)
+		}
+		query.Set("ttl", strconv.Itoa(o.TTL))
+	}
+	if len(o.LocalAddr) > 0 {
+		query.Set("localaddr", o.LocalAddr)
+	}
+	if o.BufferSize > 0 {
+		query.Set("buffer_size", strconv.Itoa(o.BufferSize))
+	}
+	if o.Bitrate > 0 {
+		query.Set("bitrate", strconv.FormatInt(o.Bitrate, 10))
+		if o.BurstBits > 0 {
+			query.Set("burst_bits", strconv.FormatInt(o.BurstBits, 10))
+		}
+	}
+	return "udp://" + address + "?" + query.Encode(), nil
+}
+
+// MpegtsOptions are the options of the mpegts muxer, including the SI/PSI tables
+// IPTV headends expect. It implements transcoder.Options
+type MpegtsOptions struct {
+	// ServiceName and ServiceProvider are written in the SDT
+	ServiceName     string
+	ServiceProvider string
+	// ServiceID is the program number
+	ServiceID         int
+	TransportStreamID int
+	OriginalNetworkID int
+	// PMTStartPID is the PID of the first PMT, StartPID the PID of the first elementary stream
+	PMTStartPID int
+	StartPID    int
+	// StreamPIDs sets the PID of output streams by index, overriding StartPID
+	StreamPIDs map[int]int
+	// MuxRate makes a constant bitrate stream (bits per second) padded with null packets
+	MuxRate int64
+	// PCRPeriod defaults to 20ms in ffmpeg
+	PCRPeriod time.Duration
+	// Flags are mpegts_flags, e.g. resend_headers, latm, system_b
+	Flags []string
+}
+
+// GetStrArguments ...
+func (o MpegtsOptions) GetStrArguments() []string {
+	args := []string{"-f", "mpegts"}
+	if len(o.ServiceName) > 0 {
+		args = append(args, "-metadata", "service_name="+o.ServiceName)
+	}
+	if len(o.ServiceProvider) > 0 {
+		args = append(args, "-metadata", "service_provider="+o.ServiceProvider)
+	}
+	ints := []struct {
+		flag  string
+		value int
+	}{
+		{"-mpegts_service_id", o.ServiceID},
+		{"-mpegts_transport_stream_id", o.TransportStreamID},
+		{"-mpegts_original_network_id", o.OriginalNetworkID},
+		{"-mpegts_pmt_start_pid", o.PMTStartPID},
+		{"-mpegts_start_pid", o.StartPID},
+	}
+	for _, v := range ints {
+		if v.value > 0 {
+			args = append(args, v.flag, strconv.Itoa(v.value))
+		}
+	}
+	indexes := make([]int, 0, len(o.StreamPIDs))
+	for index := range o.StreamPIDs {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	for _, index := range indexes {
+		args = append(args, "-streamid", strconv.Itoa(index)+":"+strconv.Itoa(o.StreamPIDs[index]))
+	}
+	if o.MuxRate > 0 {
+		args = append(args, "-muxrate", strconv.FormatInt(o.MuxRate, 10))
+	}
+	if o.PCRPeriod > 0 {
+		args = append(args, "-pcr_period", strconv.FormatInt(o.PCRPeriod.Milliseconds(), 10))
+	}
+	if len(o.Flags) > 0 {
+		args = append(args, "-mpegts_flags", "+"+strings.Join(o.Flags, "+"))
+	}
+	return args
+}
+
+// OutputUDP appends a UDP output. Use MpegtsOptions as the options of this output
+func (t *Transcoder) OutputUDP(address string, opts UDPOptions) (*Transcoder, error) {
+	u, err := opts.URL(address)
+	if err != nil {
+		return t, err
+	}
+	t.Output(u)
+	return t, nil
+}
@@ -0,0 +1,149 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"time"
+)
+
+// NoReferenceOptions configures EstimateQuality
+type NoReferenceOptions struct {
+	// Frames is the number of frames sampled over the input, defaults to 20
+	Frames int
+}
+
+// FrameQuality holds the features and quality estimate of a sampled frame
+type FrameQuality struct {
+	Time time.Duration `json:"time"`
+	// Sharpness is the variance of the Laplacian of the luma
+	Sharpness float64 `json:"sharpness"`
+	// Noise is the estimated standard deviation of the luma noise
+	Noise float64 `json:"noise"`
+	// Blockiness is the ratio of the gradients on the 8 pixel grid to the others, 1 without blocks
+	Blockiness float64 `json:"blockiness"`
+	// Contrast is the standard deviation of the luma
+	Contrast float64 `json:"contrast"`
+	// Score from 0 (bad) to 100 (excellent)
+	Score float64 `json:"score"`
+}
+
+// NoReferenceReport is the result of EstimateQuality
+type NoReferenceReport struct {
+	// Score is the mean of the frame scores
+	Score  float64        `json:"score"`
+	Frames []FrameQuality `json:"frames"`
+}
+
+// frameFeatures measures the quality features of a gray frame
+func frameFeatures(frame []byte, width, height int) FrameQuality {
+	at := func(x, y int) float64 { return float64(frame[y*width+x]) }
+	var sum, squares float64
+	for _, v := range frame {
+		sum += float64(v)
+		squares += float64(v) * float64(v)
+	}
+	n := float64(len(frame))
+	mean := sum / n
+	q := FrameQuality{Contrast: math.Sqrt(math.Max(0, squares/n-mean*mean))}
+
+	var lapSum, lapSquares, noise float64
+	for y := 1; y < height-1; y++ {
+		for x := 1; x < width-1; x++ {
+			c := at(x, y)
+			lap := at(x-1, y) + at(x+1, y) + at(x, y-1) + at(x, y+1) - 4*c
+			lapSum += lap
+			lapSquares += lap * lap
+			// Immerkær's fast noise estimation mask
+			m := at(x-1, y-1) - 2*at(x, y-1) + at(x+1, y-1) -
+				2*at(x-1, y) + 4*c - 2*at(x+1, y) +
+				at(x-1, y+1) - 2*at(x, y+1) + at(x+1, y+1)
+			noise += math.Abs(m)
+		}
+	}
+	inner := float64((width - 2) * (height - 2))
+	lapMean := lapSum / inner
+	q.Sharpness = lapSquares/inner - lapMean*lapMean
+	q.Noise = math.Sqrt(math.Pi/2) * noise / (6 * inner)
+
+	var edge, inside float64
+	var edges, insides int
+	for y := 0; y < height; y++ {
+		for x := 0; x < width-1; x++ {
+			d := math.Abs(at(x+1, y) - at(x, y))
+			if x%8 == 7 {
+				edge += d
+				edges++
+			} else {
+				inside += d
+				insides++
+			}
+		}
+	}
+	q.Blockiness = 1
+	if edges > 0 && inside > 0 {
+		q.Blockiness = (edge / float64(edges)) / (inside / float64(insides))
+	}
+	q.score()
+	return q
+}
+
+// score maps the features of a frame to a 0 to 100 estimate. The weights are
+// heuristic: blur, noise and blocking lower the score, flat frames are not penalized
+// for their lack of detail
+func (q *FrameQuality) score() {
+	score := 100.0
+	if q.Contrast > 10 {
+		// sharp natural frames have a Laplacian variance in the hundreds
+		score -= 35 * math.Max(0, 1-math.Log10(1+q.Sharpness)/2.5)
+	}
+	score -= math.Min(30, 3*math.Max(0, q.Noise-1.5))
+	score -= math.Min(35, 70*math.Max(0, q.Blockiness-1.05))
+	q.Score = math.Max(0, math.Min(100, score))
+}
+
+// EstimateQuality scores the first video stream of input without a reference, from
+// sharpness, noise and blocking features of frames sampled at their native size. The
+// estimate ranks uploads against each other, it is not calibrated against MOS
+func EstimateQuality(ctx context.Context, cfg *Config, input string, opts NoReferenceOptions) (*NoReferenceReport, error) {
+	count := opts.Frames
+	if count <= 0 {
+		count = 20
+	}
+	duration, metadata, err := probeDuration(ctx, cfg, input)
+	if err != nil {
+		return nil, err
+	}
+	width, height := 0, 0
+	for _, s := range metadata.GetStreams() {
+		if s.GetCodecType() == "video" {
+			width, height = s.GetWidth(), s.GetHeight()
+			break
+		}
+	}
+	if width < 3 || height < 3 {
+		return nil, errors.New("source has no video stream")
+	}
+	step := duration / float64(count)
+	rate := strconv.FormatFloat(1/step, 'f', 6, 64)
+	// frames keep their stored size, rotated uploads included
+	raw, err := runOutput(ctx, cfg, "-noautorotate", "-i", input, "-map", "0:v:0", "-an", "-sn",
+		"-vf", "fps="+rate+":start_time=0:round=down,format=gray", "-frames:v", strconv.Itoa(count), "-f", "rawvideo")
+	if err != nil {
+		return nil, err
+	}
+	size := width * height
+	if len(raw) < size {
+		return nil, errors.New("no video frame to analyze")
+	}
+	report := &NoReferenceReport{}
+	for i := 0; (i+1)*size <= len(raw); i++ {
+		q := frameFeatures(raw[i*size:(i+1)*size], width, height)
+		q.Time = time.Duration(float64(i) * step * float64(time.Second))
+		report.Frames = append(report.Frames, q)
+		report.Score += q.Score
+	}
+	report.Score /= float64(len(report.Frames))
+	return report, nil
+}
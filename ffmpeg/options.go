@@ -29,6 +29,7 @@ type Options struct {
 	Tune                  *string           `flag:"-tune"`
 	AudioProfile          *string           `flag:"-profile:a"`
 	VideoProfile          *string           `flag:"-profile:v"`
+	VideoLevel            *string           `flag:"-level:v"`
 	Target                *string           `flag:"-target"`
 	Duration              *string           `flag:"-t"`
 	Qscale                *uint32           `flag:"-qscale"`
@@ -49,6 +50,13 @@ type Options struct {
 	HlsSegmentDuration    *int              `flag:"-hls_time"`
 	HlsMasterPlaylistName *string           `flag:"-master_pl_name"`
 	HlsSegmentFilename    *string           `flag:"-hls_segment_filename"`
+	HlsSegmentType        *string           `flag:"-hls_segment_type"`
+	HlsFmp4InitFilename   *string           `flag:"-hls_fmp4_init_filename"`
+	HlsFlags              *string           `flag:"-hls_flags"`
+	HlsStrftime           *int              `flag:"-strftime"`
+	HlsStrftimeMkdir      *int              `flag:"-strftime_mkdir"`
+	HlsBaseURL            *string           `flag:"-hls_base_url"`
+	HlsVarStreamMap       *string           `flag:"-var_stream_map"`
 	HTTPMethod            *string           `flag:"-method"`
 	HTTPKeepAlive         *bool             `flag:"-multiple_requests"`
 	Hwaccel               *string           `flag:"-hwaccel"`
@@ -68,12 +76,19 @@ type Options struct {
 	ExtraArgs             map[string]interface{}
 }
 
-// GetStrArguments ...
-func (opts Options) GetStrArguments() []string {
+// argument is a single flag of Options with its optional value
+type argument struct {
+	flag     string
+	value    string
+	hasValue bool
+}
+
+// arguments returns the flags set on opts in declaration order
+func (opts Options) arguments() []argument {
 	f := reflect.TypeOf(opts)
 	v := reflect.ValueOf(opts)
 
-	values := []string{}
+	values := []argument{}
 
 	for i := 0; i < f.NumField(); i++ {
 		flag := f.Field(i).Tag.Get("flag")
@@ -83,32 +98,32 @@ func (opts Options) GetStrArguments() []string {
 		if !rv.IsNil() {
 
 			if _, ok := value.(*bool); ok {
-				values = append(values, flag)
+				values = append(values, argument{flag: flag})
 				continue
 			}
 
 			if vs, ok := value.(*string); ok {
-				values = append(values, flag, *vs)
+				values = append(values, argument{flag: flag, value: *vs, hasValue: true})
 				continue
 			}
 
 			if va, ok := value.([]string); ok {
 				for i := 0; i < len(va); i++ {
 					item := va[i]
-					values = append(values, flag, item)
+					values = append(values, argument{flag: flag, value: item, hasValue: true})
 				}
 				continue
 			}
 
 			if vm, ok := value.(map[string]interface{}); ok {
 				for k, v := range vm {
-					values = append(values, k, fmt.Sprintf("%v", v))
+					values = append(values, argument{flag: k, value: fmt.Sprintf("%v", v), hasValue: true})
 				}
 				continue
 			}
 
 			if vi, ok := value.(*int); ok {
-				values = append(values, flag, fmt.Sprintf("%d", *vi))
+				values = append(values, argument{flag: flag, value: fmt.Sprintf("%d", *vi), hasValue: true})
 				continue
 			}
 
@@ -117,3 +132,25 @@ func (opts Options) GetStrArguments() []string {
 
 	return values
 }
+
+// GetStrArguments ...
+func (opts Options) GetStrArguments() []string {
+	values := []string{}
+
+	for _, arg := range opts.arguments() {
+		values = append(values, arg.flag)
+		if arg.hasValue {
+			values = append(values, arg.value)
+		}
+	}
+
+	return values
+}
+
+// Args is a raw list of ffmpeg arguments, passed through unchanged
+type Args []string
+
+// GetStrArguments ...
+func (a Args) GetStrArguments() []string {
+	return a
+}
@@ -0,0 +1,416 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrBadJoin is returned by ParallelEncode when a join point of the output is not seamless
+var ErrBadJoin = errors.New("parallel encode produced a discontinuous join")
+
+// Chunk is a part of the source encoded on its own
+type Chunk struct {
+	Index int
+	// Input is the chunk cut from the source without re-encoding, Output its encode
+	Input  string
+	Output string
+	Start  time.Duration
+	// Frames is the number of video frames of the chunk
+	Frames int
+	// Args are the encoding arguments
+	Args []string
+	// Speculative is set on the duplicate encode launched for a straggler, whose
+	// Output differs from the one of the chunk
+	Speculative bool
+	// Duplicated is set when a duplicate encode was launched, SpeculativeWon when it
+	// finished first
+	Duplicated     bool
+	SpeculativeWon bool
+}
+
+// ChunkEncoder encodes chunk.Input to chunk.Output with chunk.Args, for instance by
+// submitting a job to workers sharing the storage of the chunks
+type ChunkEncoder func(ctx context.Context, chunk Chunk) error
+
+// ParallelOptions configures ParallelEncode
+type ParallelOptions struct {
+	// Chunks is the number of parts the source is split into, defaults to the number of CPUs
+	Chunks int
+	// Concurrency is the number of chunks encoded at once, defaults to Chunks
+	Concurrency int
+	// Options are the encoding options of the video chunks and of the audio, which is
+	// encoded in one piece to avoid gaps between chunks
+	Options Options
+	// Encoder encodes a chunk, defaults to a local ffmpeg run
+	Encoder ChunkEncoder
+	// Dir holds the chunks, defaults to a temporary directory removed at the end
+	Dir string
+	// Speculate launches a duplicate encode of the chunks running longer than
+	// StragglerFactor times the time the finished chunks took per frame, once no chunk
+	// waits for a slot. The first encode to finish is kept and the other canceled
+	Speculate bool
+	// StragglerFactor defaults to 1.5
+	StragglerFactor float64
+}
+
+// JoinCheck is the continuity check of a join between two chunks
+type JoinCheck struct {
+	Time time.Duration `json:"time"`
+	// Gap is the distance between the frames around the join, minus the frame duration
+	Gap time.Duration `json:"gap"`
+	// Keyframe is set when the first frame after the join is a keyframe
+	Keyframe bool `json:"keyframe"`
+	OK       bool `json:"ok"`
+}
+
+// ParallelResult describes a parallel encode
+type ParallelResult struct {
+	Chunks []Chunk     `json:"chunks"`
+	Joins  []JoinCheck `json:"joins"`
+}
+
+// splitPoints returns up to n-1 keyframe times splitting keyframes (sorted) into parts
+// of about duration/n
+func splitPoints(keyframes []float64, duration float64, n int) []float64 {
+	var points []float64
+	for i := 1; i < n; i++ {
+		target := duration * float64(i) / float64(n)
+		j := sort.SearchFloat64s(keyframes, target)
+		if j > 0 && (j == len(keyframes) || target-keyframes[j-1] < keyframes[j]-target) {
+			j--
+		}
+		if j <= 0 || j >= len(keyframes) {
+			continue
+		}
+		if len(points) == 0 || keyframes[j] > points[len(points)-1] {
+			points = append(points, keyframes[j])
+		}
+	}
+	return points
+}
+
+// ParallelEncode splits the video of input at keyframes into chunks, encodes them
+// concurrently and concatenates the encodes without re-encoding into output, the audio
+// being encoded separately. The joins are then checked for gaps and missing keyframes
+func ParallelEncode(ctx context.Context, cfg *Config, input, output string, opts ParallelOptions) (*ParallelResult, error) {
+	n, concurrency := opts.Chunks, opts.Concurrency
+	if n <= 0 {
+		n = runtime.NumCPU()
+	}
+	if concurrency <= 0 {
+		concurrency = n
+	}
+	duration, metadata, err := probeDuration(ctx, cfg, input)
+	if err != nil {
+		return nil, err
+	}
+	_, hasAudio := hasStreams(metadata)
+	packets, err := probePackets(ctx, cfg, input, "v:0")
+	if err != nil {
+		return nil, err
+	}
+	var keyframes []float64
+	for _, p := range packets {
+		if p.Key {
+			keyframes = append(keyframes, p.PTS)
+		}
+	}
+	sort.Float64s(keyframes)
+	if len(keyframes) == 0 {
+		return nil, fmt.Errorf("no keyframe in %s", input)
+	}
+	dir := opts.Dir
+	if len(dir) == 0 {
+		if dir, err = ioutil.TempDir("", "parallel"); err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(dir)
+	} else if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	// the segment muxer cuts on the keyframes at or after the split points
+	points := splitPoints(keyframes, duration, n)
+	times := make([]string, len(points))
+	for i, p := range points {
+		times[i] = strconv.FormatFloat(p-keyframes[0], 'f', 6, 64)
+	}
+	args := []string{"-y", "-i", input, "-map", "0:v:0", "-an", "-sn", "-c", "copy",
+		"-f", "segment", "-segment_format", "matroska", "-reset_timestamps", "1"}
+	if len(times) > 0 {
+		args = append(args, "-segment_times", strings.Join(times, ","))
+	} else {
+		args = append(args, "-segment_time", strconv.FormatFloat(duration+1, 'f', 0, 64))
+	}
+	if _, err := run(ctx, cfg, append(args, filepath.Join(dir, "source_%05d.mkv"))...); err != nil {
+		return nil, err
+	}
+	sources, err := filepath.Glob(filepath.Join(dir, "source_*.mkv"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(sources)
+
+	encoding := opts.Options.GetStrArguments()
+	result := &ParallelResult{}
+	starts := append([]float64{keyframes[0]}, points...)
+	for i, s := range sources {
+		chunk := Chunk{Index: i, Input: s, Output: filepath.Join(dir, fmt.Sprintf("encoded_%05d.mkv", i)), Args: encoding}
+		if i < len(starts) {
+			chunk.Start = time.Duration((starts[i] - keyframes[0]) * float64(time.Second))
+		}
+		chunkPackets, err := probePackets(ctx, cfg, s, "v:0")
+		if err != nil {
+			return nil, err
+		}
+		chunk.Frames = len(chunkPackets)
+		result.Chunks = append(result.Chunks, chunk)
+	}
+
+	encoder := opts.Encoder
+	if encoder == nil {
+		encoder = func(ctx context.Context, chunk Chunk) error {
+			args := append([]string{"-y", "-i", chunk.Input, "-map", "0:v:0"}, chunk.Args...)
+			_, err := run(ctx, cfg, append(args, "-an", "-sn", "-f", "matroska", chunk.Output)...)
+			return err
+		}
+	}
+	if err := encodeChunks(ctx, result.Chunks, encoder, concurrency, opts); err != nil {
+		return nil, err
+	}
+	for _, chunk := range result.Chunks {
+		encoded, err := probePackets(ctx, cfg, chunk.Output, "v:0")
+		if err != nil {
+			return nil, err
+		}
+		if len(encoded) != chunk.Frames {
+			return result, fmt.Errorf("%w: chunk %d has %d frames instead of %d", ErrBadJoin, chunk.Index, len(encoded), chunk.Frames)
+		}
+	}
+
+	var list strings.Builder
+	for _, chunk := range result.Chunks {
+		// ffmpeg resolves relative paths against the directory of cfg
+		abs := chunk.Output
+		if !filepath.IsAbs(abs) && len(cfg.Dir) > 0 {
+			abs = filepath.Join(cfg.Dir, abs)
+		}
+		if abs, err = filepath.Abs(abs); err != nil {
+			return nil, err
+		}
+		list.WriteString("file '" + strings.Replace(filepath.ToSlash(abs), "'", `'\''`, -1) + "'\n")
+	}
+	listFile := filepath.Join(dir, "chunks.txt")
+	if err := ioutil.WriteFile(listFile, []byte(list.String()), 0644); err != nil {
+		return nil, err
+	}
+	args = []string{"-y", "-f", "concat", "-safe", "0", "-i", listFile}
+	if hasAudio && (opts.Options.SkipAudio == nil || !*opts.Options.SkipAudio) {
+		// the video options were applied to the chunks already
+		o := opts.Options
+		audio := Options{AudioCodec: o.AudioCodec, AudioBitrate: o.AudioBitrate, AudioRate: o.AudioRate,
+			AudioChannels: o.AudioChannels, AudioProfile: o.AudioProfile, AudioFilter: o.AudioFilter}
+		args = append(args, "-i", input, "-map", "0:v:0", "-map", "1:a", "-c:v", "copy")
+		args = append(args, audio.GetStrArguments()...)
+	} else {
+		args = append(args, "-map", "0:v:0", "-c", "copy")
+	}
+	if _, err := run(ctx, cfg, append(args, output)...); err != nil {
+		return nil, err
+	}
+
+	joined, err := probePackets(ctx, cfg, output, "v:0")
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(joined, func(i, j int) bool { return joined[i].PTS < joined[j].PTS })
+	frame := 0
+	bad := false
+	for i, chunk := range result.Chunks {
+		frame += chunk.Frames
+		if i == len(result.Chunks)-1 || frame >= len(joined) {
+			break
+		}
+		before, after := joined[frame-1], joined[frame]
+		step := before.Duration
+		if step <= 0 {
+			step = after.PTS - before.PTS
+		}
+		gap := after.PTS - before.PTS - step
+		check := JoinCheck{
+			Time:     time.Duration((after.PTS - joined[0].PTS) * float64(time.Second)),
+			Gap:      time.Duration(gap * float64(time.Second)),
+			Keyframe: after.Key,
+		}
+		// half a frame of rounding is tolerated
+		check.OK = check.Keyframe && math.Abs(gap) <= step/2
+		bad = bad || !check.OK
+		result.Joins = append(result.Joins, check)
+	}
+	if bad {
+		return result, ErrBadJoin
+	}
+	return result, nil
+}
+
+// chunkRun is the state of the encodes of a chunk
+type chunkRun struct {
+	started time.Time
+	cancels []context.CancelFunc
+	running int
+	done    bool
+}
+
+// encodeChunks encodes chunks with at most concurrency encodes at once, duplicating the
+// stragglers when opts.Speculate is set
+func encodeChunks(ctx context.Context, chunks []Chunk, encoder ChunkEncoder, concurrency int, opts ParallelOptions) error {
+	factor := opts.StragglerFactor
+	if factor <= 0 {
+		factor = 1.5
+	}
+	type outcome struct {
+		chunk       int
+		speculative bool
+		err         error
+	}
+	var (
+		outcomes = make(chan outcome)
+		runs     = make([]chunkRun, len(chunks))
+		pending  = len(chunks)
+		next     = 0
+		inflight = 0
+		perFrame []float64
+		firstErr error
+	)
+	launch := func(i int, speculative bool) {
+		chunk := chunks[i]
+		if speculative {
+			ext := filepath.Ext(chunk.Output)
+			chunk.Output = strings.TrimSuffix(chunk.Output, ext) + "_speculative" + ext
+			chunk.Speculative = true
+			chunks[i].Duplicated = true
+		} else {
+			runs[i].started = time.Now()
+		}
+		runCtx, cancel := context.WithCancel(ctx)
+		runs[i].cancels = append(runs[i].cancels, cancel)
+		runs[i].running++
+		inflight++
+		go func() {
+			defer cancel()
+			outcomes <- outcome{i, speculative, encoder(runCtx, chunk)}
+		}()
+	}
+	// straggler returns a running chunk late compared to the finished ones
+	straggler := func() int {
+		if len(perFrame) == 0 {
+			return -1
+		}
+		sorted := append([]float64{}, perFrame...)
+		sort.Float64s(sorted)
+		median := sorted[len(sorted)/2]
+		for i := range runs {
+			r := runs[i]
+			if r.running != 1 || r.done || chunks[i].Duplicated {
+				continue
+			}
+			frames := chunks[i].Frames
+			if frames <= 0 {
+				frames = 1
+			}
+			if time.Since(r.started).Seconds() > factor*median*float64(frames) {
+				return i
+			}
+		}
+		return -1
+	}
+	var tick <-chan time.Time
+	if opts.Speculate {
+		ticker := time.NewTicker(500 * time.Millisecond)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for pending > 0 || inflight > 0 {
+		if firstErr == nil {
+			for inflight < concurrency && next < len(chunks) {
+				launch(next, false)
+				next++
+			}
+			if opts.Speculate && next == len(chunks) {
+				for inflight < concurrency {
+					i := straggler()
+					if i < 0 {
+						break
+					}
+					launch(i, true)
+				}
+			}
+		}
+		if inflight == 0 {
+			break
+		}
+		var o outcome
+		select {
+		case o = <-outcomes:
+		case <-tick:
+			continue
+		}
+		inflight--
+		r := &runs[o.chunk]
+		r.running--
+		switch {
+		case r.done:
+			// the other encode of the chunk finished first
+		case o.err == nil:
+			r.done = true
+			pending--
+			chunks[o.chunk].SpeculativeWon = o.speculative
+			for _, cancel := range r.cancels {
+				cancel()
+			}
+			frames := chunks[o.chunk].Frames
+			if frames <= 0 {
+				frames = 1
+			}
+			perFrame = append(perFrame, time.Since(r.started).Seconds()/float64(frames))
+		case r.running > 0:
+			// the other encode of the chunk may still succeed
+		case firstErr == nil:
+			firstErr = fmt.Errorf("failed to encode chunk %d with error %w", o.chunk, o.err)
+			for i := range runs {
+				for _, cancel := range runs[i].cancels {
+					cancel()
+				}
+			}
+		}
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+	for _, chunk := range chunks {
+		if !chunk.Duplicated {
+			continue
+		}
+		ext := filepath.Ext(chunk.Output)
+		duplicate := strings.TrimSuffix(chunk.Output, ext) + "_speculative" + ext
+		if chunk.SpeculativeWon {
+			// the canceled encode has exited, its output can be replaced
+			if err := os.Rename(duplicate, chunk.Output); err != nil {
+				return err
+			}
+		} else {
+			os.Remove(duplicate)
+		}
+	}
+	return ctx.Err()
+}
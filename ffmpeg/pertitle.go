@@ -0,0 +1,220 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OptimizeOptions configures OptimizeBitrate
+type OptimizeOptions struct {
+	// Renditions are the sizes to optimize, default to BuildLadder with the default policy
+	Renditions []Rendition
+	// CRFs are the probed quality levels, default to 18, 22, 26, 30 and 34
+	CRFs []int
+	// Samples is the number of segments encoded, spread over the input, defaults to 3
+	Samples int
+	// SampleDuration defaults to 10s
+	SampleDuration time.Duration
+	// VideoCodec is a CRF capable encoder (libx264, libx265, libsvtav1...), defaults to
+	// libx264. The CRFs of the NVENC encoders are their constant quality (-cq) levels
+	VideoCodec string
+	// Preset of the probes, defaults to a fast preset of VideoCodec, such as veryfast for
+	// x264 and x265 or 8 for SVT-AV1. Final encodes with a slower preset reach the same
+	// quality at a slightly lower bitrate
+	Preset string
+}
+
+// probeSpeedArgs returns the options of the probes encoded with codec at preset, or at
+// the fast speed of codec when preset is empty
+func probeSpeedArgs(codec, preset string) []string {
+	if len(preset) > 0 {
+		return []string{"-preset", preset}
+	}
+	switch encoderCodec(codec) {
+	case "h264", "hevc":
+		if strings.HasSuffix(codec, "_nvenc") {
+			return []string{"-preset", "p1"}
+		}
+		return []string{"-preset", "veryfast"}
+	}
+	switch codec {
+	case "libsvtav1":
+		return []string{"-preset", "8"}
+	case "libaom-av1":
+		return []string{"-cpu-used", "8"}
+	case "librav1e":
+		return []string{"-speed", "10"}
+	case "libvpx-vp9":
+		return []string{"-deadline", "good", "-cpu-used", "5"}
+	}
+	return nil
+}
+
+// probeQualityArgs returns the options of the probes encoded with codec at crf
+func probeQualityArgs(codec string, crf int) []string {
+	switch {
+	case strings.HasSuffix(codec, "_nvenc"):
+		// nvenc ignores -crf, its constant quality mode is VBR without a target
+		return []string{"-rc", "vbr", "-cq", strconv.Itoa(crf), "-b:v", "0"}
+	case codec == "libvpx-vp9":
+		// libvpx constrains -crf to the default bitrate otherwise
+		return []string{"-crf", strconv.Itoa(crf), "-b:v", "0"}
+	}
+	return []string{"-crf", strconv.Itoa(crf)}
+}
+
+// RateQualityPoint is a probed point of a rate quality curve
+type RateQualityPoint struct {
+	CRF     int
+	VMAF    float64
+	Bitrate int64 // bits per second
+}
+
+// BitrateRecommendation is the encoding recommended for a rendition
+type BitrateRecommendation struct {
+	Name   string
+	Width  int
+	Height int
+	// CRF reaching the target quality, interpolated between probes
+	CRF float64
+	// VideoBitrate is the expected average bitrate at CRF, usable as the target of a capped encode
+	VideoBitrate int64
+	// VMAF expected at CRF, below the target when even the lowest CRF does not reach it
+	VMAF  float64
+	Curve []RateQualityPoint
+}
+
+// interpolateCRF returns the CRF, bitrate and VMAF of the curve (sorted by CRF) at the
+// target VMAF, bitrates being interpolated on a log scale
+func interpolateCRF(curve []RateQualityPoint, target float64) (float64, int64, float64) {
+	first, last := curve[0], curve[len(curve)-1]
+	if first.VMAF <= target {
+		return float64(first.CRF), first.Bitrate, first.VMAF
+	}
+	for i := 1; i < len(curve); i++ {
+		a, b := curve[i-1], curve[i]
+		if b.VMAF > target {
+			continue
+		}
+		f := 0.0
+		if a.VMAF != b.VMAF {
+			f = (a.VMAF - target) / (a.VMAF - b.VMAF)
+		}
+		crf := float64(a.CRF) + f*float64(b.CRF-a.CRF)
+		rate := math.Exp(math.Log(float64(a.Bitrate)) + f*(math.Log(float64(b.Bitrate))-math.Log(float64(a.Bitrate))))
+		return math.Round(crf*10) / 10, int64(rate), target
+	}
+	// every probe is above the target
+	return float64(last.CRF), last.Bitrate, last.VMAF
+}
+
+// OptimizeBitrate runs quick CRF probes on segments sampled from input for each
+// rendition, builds its rate quality curve and recommends the CRF and bitrate reaching
+// targetVMAF, for per-title encoding. VMAF is measured at the source size and needs
+// ffmpeg built with libvmaf
+func OptimizeBitrate(ctx context.Context, cfg *Config, input string, targetVMAF float64, opts OptimizeOptions) ([]BitrateRecommendation, error) {
+	if targetVMAF <= 0 || targetVMAF > 100 {
+		return nil, fmt.Errorf("invalid target VMAF %g", targetVMAF)
+	}
+	duration, metadata, err := probeDuration(ctx, cfg, input)
+	if err != nil {
+		return nil, err
+	}
+	if duration <= 0 {
+		return nil, fmt.Errorf("%s has no duration to sample", input)
+	}
+	renditions := opts.Renditions
+	if len(renditions) == 0 {
+		if renditions, err = BuildLadder(metadata, LadderPolicy{}); err != nil {
+			return nil, err
+		}
+	}
+	crfs := append([]int{}, opts.CRFs...)
+	if len(crfs) == 0 {
+		crfs = []int{18, 22, 26, 30, 34}
+	}
+	sort.Ints(crfs)
+	samples, sampleDuration := opts.Samples, opts.SampleDuration
+	if samples <= 0 {
+		samples = 3
+	}
+	if sampleDuration <= 0 {
+		sampleDuration = 10 * time.Second
+	}
+	if total := time.Duration(duration * float64(time.Second)); sampleDuration > total {
+		sampleDuration, samples = total, 1
+	}
+	codec := opts.VideoCodec
+	if len(codec) == 0 {
+		codec = "libx264"
+	}
+	speed := probeSpeedArgs(codec, opts.Preset)
+
+	dir, err := ioutil.TempDir("", "pertitle")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	references := make([]string, samples)
+	for i := range references {
+		at := duration * float64(i+1) / float64(samples+1)
+		at = math.Max(0, math.Min(at, duration-sampleDuration.Seconds()))
+		references[i] = filepath.Join(dir, fmt.Sprintf("sample_%d.mkv", i))
+		args := []string{"-y", "-ss", strconv.FormatFloat(at, 'f', 3, 64), "-i", input, "-t", seconds(sampleDuration),
+			"-map", "0:v:0", "-c", "copy", references[i]}
+		if _, err := run(ctx, cfg, args...); err != nil {
+			return nil, err
+		}
+	}
+
+	var recommendations []BitrateRecommendation
+	for _, r := range renditions {
+		if r.Width <= 0 || r.Height <= 0 {
+			return nil, errors.New("rendition width and height must be positive")
+		}
+		rec := BitrateRecommendation{Name: r.Name, Width: r.Width, Height: r.Height}
+		for _, crf := range crfs {
+			var vmaf, bitrate float64
+			for i, ref := range references {
+				encoded := filepath.Join(dir, fmt.Sprintf("probe_%s_%d_%d.mp4", r.Name, crf, i))
+				args := []string{"-y", "-i", ref, "-map", "0:v:0", "-an", "-vf", fmt.Sprintf("scale=%d:%d", r.Width, r.Height), "-c:v", codec}
+				args = append(append(append(args, speed...), probeQualityArgs(codec, crf)...), "-f", "mp4", encoded)
+				if _, err := run(ctx, cfg, args...); err != nil {
+					return nil, err
+				}
+				report, err := CompareQuality(ctx, cfg, ref, encoded, QualityOptions{}, MetricVMAF)
+				if err != nil {
+					return nil, err
+				}
+				info, err := os.Stat(encoded)
+				if err != nil {
+					return nil, err
+				}
+				length, _, err := probeDuration(ctx, cfg, encoded)
+				if err != nil {
+					return nil, err
+				}
+				if length <= 0 {
+					return nil, fmt.Errorf("probe %s has no duration", encoded)
+				}
+				vmaf += report.VMAF.Mean
+				bitrate += float64(info.Size()*8) / length
+				os.Remove(encoded)
+			}
+			n := float64(len(references))
+			rec.Curve = append(rec.Curve, RateQualityPoint{CRF: crf, VMAF: vmaf / n, Bitrate: int64(bitrate / n)})
+		}
+		rec.CRF, rec.VideoBitrate, rec.VMAF = interpolateCRF(rec.Curve, targetVMAF)
+		recommendations = append(recommendations, rec)
+	}
+	return recommendations, nil
+}
@@ -0,0 +1,188 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/bits"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// hashSize is the side of the frames the perceptual hashes are computed on
+const hashSize = 32
+
+// PerceptualHashOptions configures PerceptualHash
+type PerceptualHashOptions struct {
+	// Interval between hashed frames, defaults to 1s
+	Interval time.Duration
+	// Start and Duration restrict the hashed part of the input
+	Start    time.Duration
+	Duration time.Duration
+}
+
+// VideoHash is the sequence of 64 bit pHashes of frames sampled at a regular interval
+type VideoHash struct {
+	Interval time.Duration `json:"interval"`
+	Hashes   []uint64      `json:"hashes"`
+}
+
+// HashMatch is the result of comparing two video hashes
+type HashMatch struct {
+	// Similarity is the ratio of the overlapping frames that match, from 0 to 1
+	Similarity float64 `json:"similarity"`
+	// Offset is where the second video starts in the first one, negative when it starts before
+	Offset time.Duration `json:"offset"`
+	// Frames is the number of overlapping frames compared
+	Frames int `json:"frames"`
+}
+
+// dctTable holds the cosines of the 8 lowest frequencies of a 32 point DCT
+var dctTable = func() [8][hashSize]float64 {
+	var t [8][hashSize]float64
+	for u := range t {
+		for x := range t[u] {
+			t[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / (2 * hashSize))
+		}
+	}
+	return t
+}()
+
+// phash returns the pHash of a hashSize×hashSize gray frame: the signs against their
+// median of the 8×8 lowest frequency DCT coefficients
+func phash(frame []byte) uint64 {
+	var rows [hashSize][8]float64
+	for y := 0; y < hashSize; y++ {
+		for u := 0; u < 8; u++ {
+			sum := 0.0
+			for x := 0; x < hashSize; x++ {
+				sum += float64(frame[y*hashSize+x]) * dctTable[u][x]
+			}
+			rows[y][u] = sum
+		}
+	}
+	coefficients := make([]float64, 0, 64)
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			sum := 0.0
+			for y := 0; y < hashSize; y++ {
+				sum += rows[y][u] * dctTable[v][y]
+			}
+			coefficients = append(coefficients, sum)
+		}
+	}
+	// the DC coefficient only carries the mean brightness
+	sorted := append([]float64{}, coefficients[1:]...)
+	sort.Float64s(sorted)
+	median := sorted[len(sorted)/2]
+	var hash uint64
+	for i, c := range coefficients {
+		if c > median {
+			hash |= 1 << uint(i)
+		}
+	}
+	return hash
+}
+
+// PerceptualHash samples frames of the first video stream of input and returns their
+// pHashes, which survive re-encoding, rescaling and small color changes
+func PerceptualHash(ctx context.Context, cfg *Config, input string, opts PerceptualHashOptions) (*VideoHash, error) {
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	var args []string
+	if opts.Start > 0 {
+		args = append(args, "-ss", seconds(opts.Start))
+	}
+	args = append(args, "-i", input)
+	if opts.Duration > 0 {
+		args = append(args, "-t", seconds(opts.Duration))
+	}
+	size := strconv.Itoa(hashSize)
+	args = append(args, "-map", "0:v:0", "-an", "-sn",
+		"-vf", "fps=1/"+seconds(interval)+",scale="+size+":"+size+":flags=area,format=gray",
+		"-f", "rawvideo")
+	out, err := runOutput(ctx, cfg, args...)
+	if err != nil {
+		return nil, err
+	}
+	frame := hashSize * hashSize
+	if len(out) < frame {
+		return nil, errors.New("no video frame to hash")
+	}
+	h := &VideoHash{Interval: interval, Hashes: make([]uint64, len(out)/frame)}
+	for i := range h.Hashes {
+		h.Hashes[i] = phash(out[i*frame : (i+1)*frame])
+	}
+	return h, nil
+}
+
+// CompareHashes slides b along a and returns the alignment where the most overlapping
+// frames match, frames matching when their hashes differ by at most maxDistance bits
+// (10 when 0). Both hashes must share their interval
+func CompareHashes(a, b *VideoHash, maxDistance int) (HashMatch, error) {
+	if a.Interval != b.Interval {
+		return HashMatch{}, errors.New("video hashes sampled at different intervals")
+	}
+	if maxDistance <= 0 {
+		maxDistance = 10
+	}
+	var best HashMatch
+	for lag := -(len(b.Hashes) - 1); lag < len(a.Hashes); lag++ {
+		matching, frames := 0, 0
+		for j, hb := range b.Hashes {
+			i := j + lag
+			if i < 0 || i >= len(a.Hashes) {
+				continue
+			}
+			frames++
+			if bits.OnesCount64(a.Hashes[i]^hb) <= maxDistance {
+				matching++
+			}
+		}
+		// a single matching frame says little
+		if frames < 3 && frames < len(a.Hashes) && frames < len(b.Hashes) {
+			continue
+		}
+		similarity := float64(matching) / float64(frames)
+		if similarity > best.Similarity || (similarity == best.Similarity && frames > best.Frames) {
+			best = HashMatch{Similarity: similarity, Offset: time.Duration(lag) * a.Interval, Frames: frames}
+		}
+	}
+	return best, nil
+}
+
+// SignatureMatch is a matching sequence found by the MPEG-7 video signature filter
+type SignatureMatch struct {
+	// Start and OtherStart are where the sequence starts in the first and second input
+	Start      time.Duration `json:"start"`
+	OtherStart time.Duration `json:"other_start"`
+	Frames     int           `json:"frames"`
+}
+
+var reSignatureMatch = regexp.MustCompile(`matching of video 0 at ([\d.]+) and 1 at ([\d.]+), (\d+) frames matching`)
+
+// MatchSignatures compares the MPEG-7 video signatures of a and b with the ffmpeg
+// signature filter and returns the matching sequences, empty when the videos differ.
+// The filter is slower than CompareHashes but robust to cropping and overlays
+func MatchSignatures(ctx context.Context, cfg *Config, a, b string) ([]SignatureMatch, error) {
+	stderr, err := run(ctx, cfg, "-i", a, "-i", b, "-filter_complex", "[0:v:0][1:v:0]signature=nb_inputs=2:detectmode=full", "-f", "null", "-")
+	if err != nil {
+		return nil, err
+	}
+	var matches []SignatureMatch
+	for _, m := range reSignatureMatch.FindAllSubmatch(stderr, -1) {
+		start, _ := strconv.ParseFloat(string(m[1]), 64)
+		other, _ := strconv.ParseFloat(string(m[2]), 64)
+		frames, _ := strconv.Atoi(string(m[3]))
+		matches = append(matches, SignatureMatch{
+			Start:      time.Duration(start * float64(time.Second)),
+			OtherStart: time.Duration(other * float64(time.Second)),
+			Frames:     frames,
+		})
+	}
+	return matches, nil
+}
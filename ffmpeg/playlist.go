@@ -0,0 +1,172 @@
+package ffmpeg
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// MasterPlaylist is an HLS multivariant (master) playlist
+type MasterPlaylist struct {
+	Version             int
+	IndependentSegments bool
+	Media               []Media
+	Variants            []Variant
+	IFrameVariants      []Variant
+}
+
+// Media types of EXT-X-MEDIA entries
+const (
+	MediaAudio     = "AUDIO"
+	MediaSubtitles = "SUBTITLES"
+)
+
+// Media is an EXT-X-MEDIA entry (alternative audio or subtitle rendition) of a master playlist
+type Media struct {
+	Type       string
+	GroupID    string
+	Name       string
+	Language   string
+	Default    bool
+	AutoSelect bool
+	Forced     bool
+	Channels   string
+	URI        string
+}
+
+// attributes renders the value of the EXT-X-MEDIA tag
+func (m Media) attributes() string {
+	yesNo := func(b bool) string {
+		if b {
+			return "YES"
+		}
+		return "NO"
+	}
+	attrs := []string{
+		"TYPE=" + m.Type,
+		`GROUP-ID="` + m.GroupID + `"`,
+		`NAME="` + m.Name + `"`,
+	}
+	if len(m.Language) > 0 {
+		attrs = append(attrs, `LANGUAGE="`+m.Language+`"`)
+	}
+	attrs = append(attrs, "DEFAULT="+yesNo(m.Default), "AUTOSELECT="+yesNo(m.AutoSelect || m.Default))
+	if m.Type == MediaSubtitles {
+		attrs = append(attrs, "FORCED="+yesNo(m.Forced))
+	}
+	if len(m.Channels) > 0 {
+		attrs = append(attrs, `CHANNELS="`+m.Channels+`"`)
+	}
+	if len(m.URI) > 0 {
+		attrs = append(attrs, `URI="`+m.URI+`"`)
+	}
+	return strings.Join(attrs, ",")
+}
+
+// Variant is an EXT-X-STREAM-INF entry of a master playlist
+type Variant struct {
+	URI              string
+	Bandwidth        int64
+	AverageBandwidth int64
+	Width            int
+	Height           int
+	Codecs           string
+	FrameRate        float64
+	// Audio and Subtitles are the GROUP-IDs of the EXT-X-MEDIA renditions used by the variant
+	Audio     string
+	Subtitles string
+}
+
+// attributes renders the value of the EXT-X-STREAM-INF tag
+func (v Variant) attributes() string {
+	attrs := []string{"BANDWIDTH=" + strconv.FormatInt(v.Bandwidth, 10)}
+	if v.AverageBandwidth > 0 {
+		attrs = append(attrs, "AVERAGE-BANDWIDTH="+strconv.FormatInt(v.AverageBandwidth, 10))
+	}
+	if v.Width > 0 && v.Height > 0 {
+		attrs = append(attrs, fmt.Sprintf("RESOLUTION=%dx%d", v.Width, v.Height))
+	}
+	if len(v.Codecs) > 0 {
+		attrs = append(attrs, `CODECS="`+v.Codecs+`"`)
+	}
+	if v.FrameRate > 0 {
+		attrs = append(attrs, "FRAME-RATE="+strconv.FormatFloat(v.FrameRate, 'f', 3, 64))
+	}
+	if len(v.Audio) > 0 {
+		attrs = append(attrs, `AUDIO="`+v.Audio+`"`)
+	}
+	if len(v.Subtitles) > 0 {
+		attrs = append(attrs, `SUBTITLES="`+v.Subtitles+`"`)
+	}
+	return strings.Join(attrs, ",")
+}
+
+// WriteTo writes the playlist in m3u8 format
+func (m *MasterPlaylist) WriteTo(w io.Writer) (int64, error) {
+	buf := new(bytes.Buffer)
+	buf.WriteString("#EXTM3U\n")
+	version := m.Version
+	if version <= 0 {
+		version = 3
+	}
+	fmt.Fprintf(buf, "#EXT-X-VERSION:%d\n", version)
+	if m.IndependentSegments {
+		buf.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+	}
+	for _, media := range m.Media {
+		fmt.Fprintf(buf, "#EXT-X-MEDIA:%s\n", media.attributes())
+	}
+	for _, v := range m.Variants {
+		fmt.Fprintf(buf, "#EXT-X-STREAM-INF:%s\n%s\n", v.attributes(), v.URI)
+	}
+	for _, v := range m.IFrameVariants {
+		v.FrameRate = 0
+		v.Audio = ""
+		v.Subtitles = ""
+		fmt.Fprintf(buf, "#EXT-X-I-FRAME-STREAM-INF:%s,URI=\"%s\"\n", v.attributes(), v.URI)
+	}
+	return buf.WriteTo(w)
+}
+
+// WriteFile writes the playlist to the named file
+func (m *MasterPlaylist) WriteFile(filename string) error {
+	buf := new(bytes.Buffer)
+	if _, err := m.WriteTo(buf); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, buf.Bytes(), 0644)
+}
+
+// variantFromOptions computes the EXT-X-STREAM-INF attributes of a rendition encoded with opts
+func variantFromOptions(uri string, opts Options) Variant {
+	v := Variant{
+		URI:    uri,
+		Codecs: CodecsString(opts),
+	}
+	var average, peak int64
+	if !boolValue(opts.SkipVideo) {
+		video := parseBitrate(strValue(opts.VideoBitRate))
+		average += video
+		if opts.VideoMaxBitRate != nil && int64(*opts.VideoMaxBitRate) > video {
+			peak += int64(*opts.VideoMaxBitRate)
+		} else {
+			// BANDWIDTH is the peak segment bitrate, leave headroom over the average
+			peak += video + video/10
+		}
+		v.Width, v.Height = parseResolution(strValue(opts.Resolution))
+		if opts.FrameRate != nil {
+			v.FrameRate = float64(*opts.FrameRate)
+		}
+	}
+	if !boolValue(opts.SkipAudio) {
+		audio := parseBitrate(strValue(opts.AudioBitrate))
+		average += audio
+		peak += audio
+	}
+	v.Bandwidth = peak
+	v.AverageBandwidth = average
+	return v
+}
@@ -0,0 +1,157 @@
+package ffmpeg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PosterOptions configures the poster frame selection
+type PosterOptions struct {
+	// Candidates is the number of keyframes scored, defaults to 20
+	Candidates int
+	// Screenshot are the options of the extracted image
+	Screenshot ScreenshotOptions
+}
+
+// PosterCandidate is a scored frame
+type PosterCandidate struct {
+	Time time.Duration
+	// Luma is the average brightness (0-255), Contrast the spread between the 10th and
+	// 90th luma percentiles and Edges the average edge intensity measuring sharpness
+	Luma     float64
+	Contrast float64
+	Edges    float64
+	Score    float64
+}
+
+// PosterResult is the selected poster frame
+type PosterResult struct {
+	PosterCandidate
+	*ScreenshotResult
+	Candidates []PosterCandidate
+}
+
+var reMetadataLine = regexp.MustCompile(`\[(Parsed_metadata_\d+) @ [^\]]+\]\s+(.*)`)
+
+// score rates a candidate, black, white and flat frames score lowest
+func (c *PosterCandidate) score() {
+	if c.Luma < 25 || c.Luma > 235 {
+		c.Score = 0
+		return
+	}
+	c.Score = c.Edges*2 + c.Contrast/4 - math.Abs(c.Luma-128)/8
+	if c.Score < 0 {
+		c.Score = 0
+	}
+}
+
+// parsePosterStats reads the frame statistics printed by the two metadata filters
+// of the selection graph: the first after signalstats, the second after edgedetect
+func parsePosterStats(stderr []byte) []PosterCandidate {
+	var (
+		filters []string
+		order   []string
+		stats   = map[string]map[string]map[string]float64{}
+		current = map[string]string{}
+	)
+	scanner := bufio.NewScanner(bytes.NewReader(stderr))
+	for scanner.Scan() {
+		m := reMetadataLine.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		filter, line := m[1], m[2]
+		if _, ok := stats[filter]; !ok {
+			stats[filter] = map[string]map[string]float64{}
+			filters = append(filters, filter)
+		}
+		if strings.HasPrefix(line, "frame:") {
+			for _, f := range strings.Fields(line) {
+				if strings.HasPrefix(f, "pts_time:") {
+					current[filter] = strings.TrimPrefix(f, "pts_time:")
+				}
+			}
+			if _, ok := stats[filter][current[filter]]; !ok {
+				stats[filter][current[filter]] = map[string]float64{}
+				if len(filters) > 0 && filter == filters[0] {
+					order = append(order, current[filter])
+				}
+			}
+			continue
+		}
+		if i := strings.IndexByte(line, '='); i > 0 {
+			v, _ := strconv.ParseFloat(line[i+1:], 64)
+			if frame, ok := stats[filter][current[filter]]; ok {
+				frame[strings.TrimPrefix(line[:i], "lavfi.signalstats.")] = v
+			}
+		}
+	}
+	if len(filters) < 2 {
+		return nil
+	}
+	var candidates []PosterCandidate
+	for _, pts := range order {
+		frame, edges := stats[filters[0]][pts], stats[filters[1]][pts]
+		if frame == nil || edges == nil {
+			continue
+		}
+		sec, _ := strconv.ParseFloat(pts, 64)
+		c := PosterCandidate{
+			Time:     time.Duration(sec * float64(time.Second)),
+			Luma:     frame["YAVG"],
+			Contrast: frame["YHIGH"] - frame["YLOW"],
+			Edges:    edges["YAVG"],
+		}
+		c.score()
+		candidates = append(candidates, c)
+	}
+	return candidates
+}
+
+// SelectPoster scores keyframes spread over input, avoiding black, washed out and
+// blurry frames, and extracts the best one
+func SelectPoster(ctx context.Context, cfg *Config, input string, opts PosterOptions) (*PosterResult, error) {
+	count := opts.Candidates
+	if count <= 0 {
+		count = 20
+	}
+	duration, _, err := probeDuration(ctx, cfg, input)
+	if err != nil {
+		return nil, err
+	}
+	step := duration / float64(count+1)
+	num := func(f float64) string {
+		return strconv.FormatFloat(f, 'f', 3, 64)
+	}
+	// only keyframes are decoded, they are also the sharpest frames of a GOP
+	filter := fmt.Sprintf("select='gte(t\\,%s)*(isnan(prev_selected_t)+gte(t-prev_selected_t\\,%s))',scale=320:-2,"+
+		"signalstats,metadata=mode=print,edgedetect,format=yuv420p,signalstats,metadata=mode=print",
+		num(step/2), num(step))
+	stderr, err := run(ctx, cfg, "-skip_frame", "nokey", "-i", input, "-an", "-sn", "-vf", filter, "-frames:v", strconv.Itoa(count), "-f", "null", "-")
+	if err != nil {
+		return nil, err
+	}
+	candidates := parsePosterStats(stderr)
+	if len(candidates) == 0 {
+		return nil, errors.New("no poster candidate found")
+	}
+	best := candidates[0]
+	for _, c := range candidates[1:] {
+		if c.Score > best.Score {
+			best = c
+		}
+	}
+	shot, err := Screenshot(ctx, cfg, input, best.Time, opts.Screenshot)
+	if err != nil {
+		return nil, err
+	}
+	return &PosterResult{PosterCandidate: best, ScreenshotResult: shot, Candidates: candidates}, nil
+}
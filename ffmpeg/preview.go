@@ -0,0 +1,104 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/admpub/transcoder/utils"
+)
+
+// PreviewOptions configures a hover preview clip
+type PreviewOptions struct {
+	// Count of sampled sub-clips, defaults to 5
+	Count int
+	// ClipLength of each sub-clip, defaults to 800ms
+	ClipLength time.Duration
+	// Width defaults to 320, the height follows the aspect ratio
+	Width int
+	// FPS defaults to 24
+	FPS int
+	// Crf of the libx264 encoding, defaults to 28
+	Crf int
+}
+
+// previewStarts spreads count clips of length over duration, skipping the first
+// and last 5% where intros and credits usually are
+func previewStarts(duration float64, count int, length float64) []float64 {
+	from, to := duration*0.05, duration*0.95
+	if to-from < length*float64(count) {
+		from, to = 0, duration
+	}
+	starts := make([]float64, count)
+	step := (to - from) / float64(count)
+	for i := range starts {
+		start := from + step*(float64(i)+0.5) - length/2
+		if start < 0 {
+			start = 0
+		}
+		if start+length > duration {
+			start = duration - length
+		}
+		if start < 0 {
+			start = 0
+		}
+		starts[i] = start
+	}
+	return starts
+}
+
+// Preview renders a short muted clip made of sub-clips sampled across input, the
+// video preview played when hovering a thumbnail
+func Preview(ctx context.Context, cfg *Config, input, output string, opts PreviewOptions) error {
+	if len(output) == 0 {
+		return errors.New("missing output option")
+	}
+	count := opts.Count
+	if count <= 0 {
+		count = 5
+	}
+	length := opts.ClipLength
+	if length <= 0 {
+		length = 800 * time.Millisecond
+	}
+	width := opts.Width
+	if width <= 0 {
+		width = 320
+	}
+	fps := opts.FPS
+	if fps <= 0 {
+		fps = 24
+	}
+	crf := opts.Crf
+	if crf <= 0 {
+		crf = 28
+	}
+	duration, _, err := probeDuration(ctx, cfg, input)
+	if err != nil {
+		return err
+	}
+	if duration < length.Seconds()*float64(count) {
+		// a short input is used whole
+		count, length = 1, time.Duration(duration*float64(time.Second))
+	}
+
+	// one seeking input per sub-clip decodes only what is kept
+	args := []string{"-y"}
+	clip := strconv.FormatFloat(length.Seconds(), 'f', 3, 64)
+	var filters, labels []string
+	for i, start := range previewStarts(duration, count, length.Seconds()) {
+		args = append(args, "-ss", utils.SecToDur(start), "-t", clip, "-i", input)
+		filters = append(filters, fmt.Sprintf("[%d:v]fps=%d,scale=%d:-2,setsar=1,setpts=PTS-STARTPTS[v%d]", i, fps, width, i))
+		labels = append(labels, fmt.Sprintf("[v%d]", i))
+	}
+	filter := strings.Join(filters, ";") + ";" + strings.Join(labels, "") + fmt.Sprintf("concat=n=%d:v=1:a=0[out]", count)
+	args = append(args,
+		"-filter_complex", filter, "-map", "[out]", "-an",
+		"-c:v", "libx264", "-preset", "veryfast", "-crf", strconv.Itoa(crf), "-pix_fmt", "yuv420p",
+		"-movflags", "+faststart", output)
+	_, err = run(ctx, cfg, args...)
+	return err
+}
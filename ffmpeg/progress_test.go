@@ -0,0 +1,81 @@
+package ffmpeg
+
+import (
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/admpub/transcoder"
+)
+
+// regexStats is the regex based parser replaced by parseStats, kept as the reference
+// of its output
+func regexStats(line string) Progress {
+	var p Progress
+	for _, field := range strings.Fields(regexp.MustCompile(`=\s+`).ReplaceAllString(line, `=`)) {
+		kv := strings.Split(field, "=")
+		if len(kv) < 2 {
+			continue
+		}
+		switch kv[0] {
+		case "frame":
+			p.FramesProcessed = kv[1]
+		case "time":
+			p.CurrentTime = kv[1]
+		case "bitrate":
+			p.CurrentBitrate = kv[1]
+		case "speed":
+			p.Speed = kv[1]
+		}
+	}
+	return p
+}
+
+func TestParseStats(t *testing.T) {
+	lines := []string{
+		"frame=  120 fps= 30 q=28.0 size=     512kB time=00:00:04.00 bitrate=1048.6kbits/s speed=1.01x",
+		"frame=    0 fps=0.0 q=0.0 size=       0kB time=00:00:00.00 bitrate=N/A speed=N/A",
+		"size=N/A time=00:01:02.50 bitrate=N/A speed= 120x",
+		"frame=    1 fps=0.0 q=-1.0 Lsize=N/A time=-00:00:00.04 bitrate=N/A speed=",
+		"frame=   10 fps=0.0 q=-1.0 size=     256kB time=00:00:0",
+		"frame=   10 fps=0.0 q=-1.0 size=     256kB time=00:00:01.00 bitrate=",
+		"frame=   10 fps=0.0 q=-1.0 size=     256kB time=00:00:01.00 bitrate= 2097.2kbits/s dup=1 drop=0 speed=0.5x    ",
+		"size=    1024kB time=00:00:10.00 bitrate= 838.9kbits/s",
+		"frame=",
+		"time=",
+		"",
+	}
+	for _, line := range lines {
+		var got Progress
+		parseStats(line, &got)
+		if want := regexStats(line); got != want {
+			t.Errorf("parseStats(%q) = %+v, want %+v", line, got, want)
+		}
+	}
+}
+
+const statsLine = "frame=  120 fps= 30 q=28.0 size=     512kB time=00:00:04.00 bitrate=1048.6kbits/s speed=1.01x"
+
+func BenchmarkParseStats(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var p Progress
+		parseStats(statsLine, &p)
+	}
+}
+
+// BenchmarkProgress measures the stats lines read from stderr, each allocating the
+// string its Progress values are substrings of and the Progress sent as an interface
+func BenchmarkProgress(b *testing.B) {
+	b.ReportAllocs()
+	stderr := strings.Repeat(statsLine+"\r", b.N)
+	out := make(chan transcoder.Progress)
+	go func() {
+		for range out {
+		}
+	}()
+	b.ResetTimer()
+	(&Transcoder{}).progress(ioutil.NopCloser(strings.NewReader(stderr)), out)
+	close(out)
+}
@@ -0,0 +1,398 @@
+package ffmpeg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/admpub/transcoder"
+)
+
+// QC checks
+const (
+	CheckDecodeErrors = "decode_errors"
+	CheckLoudness     = "loudness"
+	CheckClipping     = "clipping"
+	CheckBlack        = "black"
+	CheckSilence      = "silence"
+	CheckFrozen       = "frozen"
+	CheckSync         = "sync"
+	CheckInterlace    = "interlace"
+)
+
+// AllChecks lists every QC check in report order
+var AllChecks = []string{CheckDecodeErrors, CheckLoudness, CheckClipping, CheckBlack, CheckSilence, CheckFrozen, CheckSync, CheckInterlace}
+
+// QCThresholds are the pass/fail limits of the QC checks, zero values select the defaults
+type QCThresholds struct {
+	// MaxDecodeErrors defaults to 0, a negative value allows none either
+	MaxDecodeErrors int
+	// Loudness is the integrated loudness target in LUFS, defaults to -23, with
+	// LoudnessTolerance in LU, defaults to 1
+	Loudness          float64
+	LoudnessTolerance float64
+	// MaxLoudnessRange in LU, defaults to 20
+	MaxLoudnessRange float64
+	// MaxTruePeak in dBTP, defaults to -1
+	MaxTruePeak float64
+	// MaxClippedSamples defaults to 0, true peak overs failing the clipping check too
+	MaxClippedSamples int
+	// MaxBlack, MaxSilence and MaxFrozen are the longest intervals allowed, default to 2s
+	MaxBlack   time.Duration
+	MaxSilence time.Duration
+	MaxFrozen  time.Duration
+	// MaxSyncDrift is the largest audio/video drift allowed, see MeasureSyncDrift, defaults to 40ms
+	MaxSyncDrift time.Duration
+	// MaxInterlaced is the ratio of interlaced frames allowed, defaults to 0.05
+	MaxInterlaced float64
+}
+
+// QCOptions configures QC
+type QCOptions struct {
+	// Checks defaults to AllChecks
+	Checks     []string
+	Thresholds QCThresholds
+	// BlackLevel is the pixel luminance ratio under which a pixel is black, defaults to 0.1
+	BlackLevel float64
+	// SilenceLevel is the level in dB under which audio is silence, defaults to -60
+	SilenceLevel float64
+}
+
+// QCInterval is a detected interval, in seconds from the start
+type QCInterval struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+// QCResult is the outcome of a check
+type QCResult struct {
+	Check  string `json:"check"`
+	Passed bool   `json:"passed"`
+	// Skipped is set when the input has no stream the check applies to
+	Skipped   bool               `json:"skipped,omitempty"`
+	Message   string             `json:"message,omitempty"`
+	Values    map[string]float64 `json:"values,omitempty"`
+	Intervals []QCInterval       `json:"intervals,omitempty"`
+}
+
+// QCReport is the structured result of QC
+type QCReport struct {
+	Input   string     `json:"input"`
+	Passed  bool       `json:"passed"`
+	Results []QCResult `json:"results"`
+}
+
+// JSON returns the indented JSON of the report
+func (r *QCReport) JSON() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
+
+// defaults returns the thresholds with the defaults applied
+func (t QCThresholds) defaults() QCThresholds {
+	if t.MaxDecodeErrors < 0 {
+		t.MaxDecodeErrors = 0
+	}
+	if t.MaxClippedSamples < 0 {
+		t.MaxClippedSamples = 0
+	}
+	if t.Loudness == 0 {
+		t.Loudness = -23
+	}
+	if t.LoudnessTolerance <= 0 {
+		t.LoudnessTolerance = 1
+	}
+	if t.MaxLoudnessRange <= 0 {
+		t.MaxLoudnessRange = 20
+	}
+	if t.MaxTruePeak == 0 {
+		t.MaxTruePeak = -1
+	}
+	if t.MaxBlack <= 0 {
+		t.MaxBlack = 2 * time.Second
+	}
+	if t.MaxSilence <= 0 {
+		t.MaxSilence = 2 * time.Second
+	}
+	if t.MaxFrozen <= 0 {
+		t.MaxFrozen = 2 * time.Second
+	}
+	if t.MaxSyncDrift <= 0 {
+		t.MaxSyncDrift = 40 * time.Millisecond
+	}
+	if t.MaxInterlaced <= 0 {
+		t.MaxInterlaced = 0.05
+	}
+	return t
+}
+
+var (
+	reDecodeError = regexp.MustCompile(`\[error\]`)
+	reBlack       = regexp.MustCompile(`black_start:\s*(-?[\d.]+)\s+black_end:\s*(-?[\d.]+)`)
+	reFreeze      = regexp.MustCompile(`freeze_(start|end):\s*(-?[\d.]+)`)
+	reIdet        = regexp.MustCompile(`Multi frame detection:\s*TFF:\s*(\d+)\s*BFF:\s*(\d+)\s*Progressive:\s*(\d+)`)
+	reEbur128     = regexp.MustCompile(`(?s)Summary:.*I:\s*(-?[\d.]+|-inf) LUFS.*LRA:\s*(-?[\d.]+) LU(?:.*Peak:\s*(-?[\d.]+|-inf) dBFS)?`)
+)
+
+// longest returns the length of the longest interval
+func longest(intervals []QCInterval) float64 {
+	max := 0.0
+	for _, i := range intervals {
+		max = math.Max(max, i.End-i.Start)
+	}
+	return max
+}
+
+// parseFreezes returns the frozen intervals printed by freezedetect
+func parseFreezes(stderr []byte, duration float64) []QCInterval {
+	var intervals []QCInterval
+	open := false
+	for _, m := range reFreeze.FindAllSubmatch(stderr, -1) {
+		t, _ := strconv.ParseFloat(string(m[2]), 64)
+		if string(m[1]) == "start" {
+			intervals = append(intervals, QCInterval{Start: t, End: duration})
+			open = true
+		} else if open {
+			intervals[len(intervals)-1].End = t
+			open = false
+		}
+	}
+	return intervals
+}
+
+// hasStreams reports whether metadata has video and audio streams
+func hasStreams(metadata transcoder.Metadata) (video, audio bool) {
+	for _, s := range metadata.GetStreams() {
+		switch s.GetCodecType() {
+		case "video":
+			video = true
+		case "audio":
+			audio = true
+		}
+	}
+	return video, audio
+}
+
+// QC decodes input once with the analysis filters of the requested checks and
+// evaluates them against the thresholds
+func QC(ctx context.Context, cfg *Config, input string, opts QCOptions) (*QCReport, error) {
+	checks := opts.Checks
+	if len(checks) == 0 {
+		checks = AllChecks
+	}
+	enabled := map[string]bool{}
+	for _, c := range checks {
+		switch c {
+		case CheckDecodeErrors, CheckLoudness, CheckClipping, CheckBlack, CheckSilence, CheckFrozen, CheckSync, CheckInterlace:
+			enabled[c] = true
+		default:
+			return nil, fmt.Errorf("unknown QC check %q", c)
+		}
+	}
+	t := opts.Thresholds.defaults()
+	duration, metadata, err := probeDuration(ctx, cfg, input)
+	if err != nil {
+		return nil, err
+	}
+	hasVideo, hasAudio := hasStreams(metadata)
+
+	blackLevel, silenceLevel := opts.BlackLevel, opts.SilenceLevel
+	if blackLevel <= 0 {
+		blackLevel = 0.1
+	}
+	if silenceLevel == 0 {
+		silenceLevel = -60
+	}
+	var vf, af []string
+	if enabled[CheckBlack] {
+		vf = append(vf, fmt.Sprintf("blackdetect=d=0.1:pix_th=%g", blackLevel))
+	}
+	if enabled[CheckFrozen] {
+		vf = append(vf, "freezedetect=n=-60dB:d=0.5")
+	}
+	if enabled[CheckInterlace] {
+		vf = append(vf, "idet")
+	}
+	if enabled[CheckSilence] {
+		af = append(af, "silencedetect=noise="+threshold(silenceLevel)+":d=0.5")
+	}
+	if enabled[CheckLoudness] {
+		af = append(af, "ebur128=peak=true")
+	}
+	// the level prefix identifies errors among the informational output of the filters
+	args := []string{"-loglevel", "repeat+level+info", "-i", input}
+	if len(vf) > 0 && hasVideo {
+		args = append(args, "-vf", strings.Join(vf, ","))
+	}
+	if len(af) > 0 && hasAudio {
+		args = append(args, "-af", strings.Join(af, ","))
+	}
+	stderr, runErr := run(ctx, cfg, append(args, "-f", "null", "-")...)
+	if runErr != nil && stderr == nil {
+		return nil, runErr
+	}
+
+	report := &QCReport{Input: displayInput(input), Passed: true}
+	add := func(r QCResult) {
+		if !r.Passed && !r.Skipped {
+			report.Passed = false
+		}
+		report.Results = append(report.Results, r)
+	}
+	skip := func(check, message string) {
+		add(QCResult{Check: check, Passed: true, Skipped: true, Message: message})
+	}
+	intervalCheck := func(check string, intervals []QCInterval, max time.Duration) {
+		l := longest(intervals)
+		r := QCResult{Check: check, Passed: l <= max.Seconds(), Intervals: intervals, Values: map[string]float64{"longest": l}}
+		if !r.Passed {
+			r.Message = fmt.Sprintf("interval of %.2fs exceeds %.2fs", l, max.Seconds())
+		}
+		add(r)
+	}
+
+	for _, check := range AllChecks {
+		if !enabled[check] {
+			continue
+		}
+		switch check {
+		case CheckDecodeErrors:
+			n := len(reDecodeError.FindAll(stderr, -1))
+			r := QCResult{Check: check, Passed: n <= t.MaxDecodeErrors && runErr == nil, Values: map[string]float64{"errors": float64(n)}}
+			if runErr != nil {
+				r.Message = runErr.Error()
+			} else if !r.Passed {
+				r.Message = fmt.Sprintf("%d decode errors", n)
+			}
+			add(r)
+		case CheckLoudness:
+			if !hasAudio {
+				skip(check, "no audio stream")
+				continue
+			}
+			m := reEbur128.FindSubmatch(stderr)
+			if m == nil {
+				add(QCResult{Check: check, Message: "no loudness measurement"})
+				continue
+			}
+			integrated, _ := strconv.ParseFloat(string(m[1]), 64)
+			lra, _ := strconv.ParseFloat(string(m[2]), 64)
+			// silence measures -inf, which JSON cannot encode
+			silent := math.IsInf(integrated, -1)
+			integrated = clampLevel(integrated)
+			values := map[string]float64{"integrated": integrated, "range": lra}
+			var problems []string
+			if silent || math.Abs(integrated-t.Loudness) > t.LoudnessTolerance {
+				problems = append(problems, fmt.Sprintf("integrated loudness %.1f LUFS outside %.1f±%.1f", integrated, t.Loudness, t.LoudnessTolerance))
+			}
+			if lra > t.MaxLoudnessRange {
+				problems = append(problems, fmt.Sprintf("loudness range %.1f LU above %.1f", lra, t.MaxLoudnessRange))
+			}
+			if len(m[3]) > 0 {
+				peak, _ := strconv.ParseFloat(string(m[3]), 64)
+				peak = clampLevel(peak)
+				values["true_peak"] = peak
+				if peak > t.MaxTruePeak {
+					problems = append(problems, fmt.Sprintf("true peak %.1f dBTP above %.1f", peak, t.MaxTruePeak))
+				}
+			}
+			add(QCResult{Check: check, Passed: len(problems) == 0, Values: values, Message: strings.Join(problems, ", ")})
+		case CheckClipping:
+			if !hasAudio {
+				skip(check, "no audio stream")
+				continue
+			}
+			clipping, err := DetectClipping(ctx, cfg, input, ClippingOptions{MaxTruePeak: t.MaxTruePeak})
+			if err != nil {
+				add(QCResult{Check: check, Message: err.Error()})
+				continue
+			}
+			r := QCResult{Check: check, Passed: clipping.ClippedSamples <= t.MaxClippedSamples && clipping.Overs == 0, Values: map[string]float64{
+				"clipped_samples": float64(clipping.ClippedSamples),
+				"overs":           float64(clipping.Overs),
+				"true_peak":       clipping.TruePeak,
+			}}
+			for _, c := range clipping.Intervals {
+				r.Intervals = append(r.Intervals, QCInterval{Start: c.Start.Seconds(), End: c.End.Seconds()})
+			}
+			if !r.Passed {
+				r.Message = fmt.Sprintf("%d clipped samples, %d true peak overs", clipping.ClippedSamples, clipping.Overs)
+			}
+			add(r)
+		case CheckBlack:
+			if !hasVideo {
+				skip(check, "no video stream")
+				continue
+			}
+			var intervals []QCInterval
+			for _, m := range reBlack.FindAllSubmatch(stderr, -1) {
+				start, _ := strconv.ParseFloat(string(m[1]), 64)
+				end, _ := strconv.ParseFloat(string(m[2]), 64)
+				intervals = append(intervals, QCInterval{Start: start, End: end})
+			}
+			intervalCheck(check, intervals, t.MaxBlack)
+		case CheckSilence:
+			if !hasAudio {
+				skip(check, "no audio stream")
+				continue
+			}
+			var intervals []QCInterval
+			for _, s := range parseSilences(stderr, time.Duration(duration*float64(time.Second))) {
+				intervals = append(intervals, QCInterval{Start: s.Start.Seconds(), End: s.End.Seconds()})
+			}
+			intervalCheck(check, intervals, t.MaxSilence)
+		case CheckFrozen:
+			if !hasVideo {
+				skip(check, "no video stream")
+				continue
+			}
+			intervalCheck(check, parseFreezes(stderr, duration), t.MaxFrozen)
+		case CheckSync:
+			if !hasVideo || !hasAudio {
+				skip(check, "needs both audio and video")
+				continue
+			}
+			drift, err := MeasureSyncDrift(ctx, cfg, input, SyncDriftOptions{Threshold: t.MaxSyncDrift})
+			if err != nil {
+				add(QCResult{Check: check, Message: err.Error()})
+				continue
+			}
+			r := QCResult{Check: check, Passed: !drift.Degraded, Values: map[string]float64{
+				"max_drift":    drift.MaxDrift.Seconds(),
+				"start_offset": drift.StartOffset.Seconds(),
+				"end_offset":   drift.EndOffset.Seconds(),
+			}}
+			if !r.Passed {
+				r.Message = fmt.Sprintf("audio drifts up to %.3fs from the video", drift.MaxDrift.Seconds())
+			}
+			add(r)
+		case CheckInterlace:
+			if !hasVideo {
+				skip(check, "no video stream")
+				continue
+			}
+			m := reIdet.FindSubmatch(stderr)
+			if m == nil {
+				add(QCResult{Check: check, Message: "no interlacing measurement"})
+				continue
+			}
+			tff, _ := strconv.ParseFloat(string(m[1]), 64)
+			bff, _ := strconv.ParseFloat(string(m[2]), 64)
+			progressive, _ := strconv.ParseFloat(string(m[3]), 64)
+			ratio := 0.0
+			if total := tff + bff + progressive; total > 0 {
+				ratio = (tff + bff) / total
+			}
+			r := QCResult{Check: check, Passed: ratio <= t.MaxInterlaced, Values: map[string]float64{"interlaced": ratio, "tff": tff, "bff": bff, "progressive": progressive}}
+			if !r.Passed {
+				r.Message = fmt.Sprintf("%.0f%% of frames are interlaced", ratio*100)
+			}
+			add(r)
+		}
+	}
+	return report, nil
+}
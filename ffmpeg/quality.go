@@ -0,0 +1,236 @@
+package ffmpeg
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Quality metrics
+const (
+	MetricVMAF = "vmaf"
+	MetricPSNR = "psnr"
+	MetricSSIM = "ssim"
+)
+
+// QualityOptions configures CompareQuality
+type QualityOptions struct {
+	// Width and Height both inputs are scaled to, default to the size of the reference
+	Width  int
+	Height int
+	// Model is the libvmaf model option, e.g. "version=vmaf_4k_v0.6.1". Defaults to the libvmaf default model
+	Model string
+	// Subsample computes VMAF on every Subsample-th frame only
+	Subsample int
+	// Threads used by libvmaf, 0 lets it decide
+	Threads int
+}
+
+// MetricScore holds the per frame and pooled scores of a metric
+type MetricScore struct {
+	Frames []float64
+	Mean   float64
+	Min    float64
+	Max    float64
+	// HarmonicMean penalizes the worst frames, mostly used for VMAF
+	HarmonicMean float64
+}
+
+// QualityReport holds the scores of the requested metrics, the others being nil
+type QualityReport struct {
+	VMAF *MetricScore
+	PSNR *MetricScore
+	SSIM *MetricScore
+}
+
+// pool computes the pooled values of the per frame scores
+func pool(frames []float64) *MetricScore {
+	s := &MetricScore{Frames: frames}
+	if len(frames) == 0 {
+		return s
+	}
+	s.Min, s.Max = math.Inf(1), math.Inf(-1)
+	var sum, inverse float64
+	for _, f := range frames {
+		sum += f
+		inverse += 1 / (f + 1)
+		s.Min = math.Min(s.Min, f)
+		s.Max = math.Max(s.Max, f)
+	}
+	n := float64(len(frames))
+	s.Mean = sum / n
+	// the libvmaf definition, shifted by 1 to accept zero scores
+	s.HarmonicMean = n/inverse - 1
+	return s
+}
+
+// vmafLog is the JSON log of libvmaf, both the 1.x and 2.x layouts
+type vmafLog struct {
+	Frames []struct {
+		Metrics map[string]float64 `json:"metrics"`
+	} `json:"frames"`
+	PooledMetrics map[string]struct {
+		Min          float64 `json:"min"`
+		Max          float64 `json:"max"`
+		Mean         float64 `json:"mean"`
+		HarmonicMean float64 `json:"harmonic_mean"`
+	} `json:"pooled_metrics"`
+}
+
+// parseVMAFLog ...
+func parseVMAFLog(data []byte) (*MetricScore, error) {
+	var log vmafLog
+	if err := json.Unmarshal(data, &log); err != nil {
+		return nil, fmt.Errorf("failed to parse the VMAF log with error %w", err)
+	}
+	frames := make([]float64, 0, len(log.Frames))
+	for _, f := range log.Frames {
+		frames = append(frames, f.Metrics["vmaf"])
+	}
+	score := pool(frames)
+	if p, ok := log.PooledMetrics["vmaf"]; ok {
+		score.Mean, score.Min, score.Max, score.HarmonicMean = p.Mean, p.Min, p.Max, p.HarmonicMean
+	}
+	return score, nil
+}
+
+// parseStatsFile returns the values of key in a psnr or ssim stats file
+func parseStatsFile(data []byte, key string) []float64 {
+	var values []float64
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		for _, field := range strings.Fields(scanner.Text()) {
+			if strings.HasPrefix(field, key+":") {
+				v, err := strconv.ParseFloat(strings.TrimPrefix(field, key+":"), 64)
+				if err != nil {
+					// identical frames have an infinite PSNR
+					v = math.Inf(1)
+				}
+				values = append(values, v)
+				break
+			}
+		}
+	}
+	return values
+}
+
+var (
+	rePSNRSummary = regexp.MustCompile(`PSNR .*average:(\S+) min:(\S+) max:(\S+)`)
+	reSSIMSummary = regexp.MustCompile(`SSIM .*All:(\S+)`)
+)
+
+// CompareQuality scores distorted against reference with the requested metrics (VMAF by
+// default). distorted is scaled to the reference size and both are aligned on their first
+// frame. VMAF needs ffmpeg built with libvmaf
+func CompareQuality(ctx context.Context, cfg *Config, reference, distorted string, opts QualityOptions, metrics ...string) (*QualityReport, error) {
+	if len(metrics) == 0 {
+		metrics = []string{MetricVMAF}
+	}
+	for _, m := range metrics {
+		switch m {
+		case MetricVMAF, MetricPSNR, MetricSSIM:
+		default:
+			return nil, fmt.Errorf("unsupported quality metric %q", m)
+		}
+	}
+	width, height := opts.Width, opts.Height
+	_, metadata, err := probeDuration(ctx, cfg, reference)
+	if err != nil {
+		return nil, err
+	}
+	w, h, fps, _, err := sourceVideo(metadata)
+	if err != nil {
+		return nil, err
+	}
+	if width <= 0 || height <= 0 {
+		width, height = w, h
+	}
+	dir, err := ioutil.TempDir("", "quality")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	n := len(metrics)
+	scale := fmt.Sprintf("scale=%d:%d:flags=bicubic,format=yuv420p", width, height)
+	rate := strconv.FormatFloat(fps, 'f', -1, 64)
+	// the inputs are aligned on their first frame and resampled to the reference frame rate
+	graph := []string{
+		fmt.Sprintf("[0:v:0]setpts=PTS-STARTPTS,fps=%s,%s,split=%d%s", rate, scale, n, graphLabels("d", n)),
+		fmt.Sprintf("[1:v:0]setpts=PTS-STARTPTS,fps=%s,%s,split=%d%s", rate, scale, n, graphLabels("r", n)),
+	}
+	logs := make([]string, n)
+	for i, m := range metrics {
+		logs[i] = filepath.Join(dir, m+".log")
+		var filter string
+		switch m {
+		case MetricVMAF:
+			filter = "libvmaf=log_fmt=json:log_path=" + filterPath(logs[i])
+			if len(opts.Model) > 0 {
+				filter += ":model=" + filterQuote(opts.Model)
+			}
+			if opts.Subsample > 1 {
+				filter += ":n_subsample=" + strconv.Itoa(opts.Subsample)
+			}
+			if opts.Threads > 0 {
+				filter += ":n_threads=" + strconv.Itoa(opts.Threads)
+			}
+		case MetricPSNR:
+			filter = "psnr=stats_file=" + filterPath(logs[i])
+		case MetricSSIM:
+			filter = "ssim=stats_file=" + filterPath(logs[i])
+		}
+		graph = append(graph, fmt.Sprintf("[d%d][r%d]%s[o%d]", i, i, filter, i))
+	}
+	args := []string{"-i", distorted, "-i", reference, "-filter_complex", strings.Join(graph, ";")}
+	for i := range metrics {
+		args = append(args, "-map", "[o"+strconv.Itoa(i)+"]", "-f", "null", "-")
+	}
+	stderr, err := run(ctx, cfg, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &QualityReport{}
+	for i, m := range metrics {
+		data, err := ioutil.ReadFile(logs[i])
+		if err != nil {
+			return nil, fmt.Errorf("missing %s log with error %w", m, err)
+		}
+		switch m {
+		case MetricVMAF:
+			if report.VMAF, err = parseVMAFLog(data); err != nil {
+				return nil, err
+			}
+		case MetricPSNR:
+			report.PSNR = pool(parseStatsFile(data, "psnr_avg"))
+			// the summary averages the MSE, which stays finite when some frames are identical
+			if s := rePSNRSummary.FindSubmatch(stderr); s != nil {
+				report.PSNR.Mean, _ = strconv.ParseFloat(string(s[1]), 64)
+			}
+		case MetricSSIM:
+			report.SSIM = pool(parseStatsFile(data, "All"))
+			if s := reSSIMSummary.FindSubmatch(stderr); s != nil {
+				report.SSIM.Mean, _ = strconv.ParseFloat(string(s[1]), 64)
+			}
+		}
+	}
+	return report, nil
+}
+
+// graphLabels returns n filtergraph labels named prefix followed by their index
+func graphLabels(prefix string, n int) string {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		b.WriteString("[" + prefix + strconv.Itoa(i) + "]")
+	}
+	return b.String()
+}
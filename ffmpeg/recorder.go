@@ -0,0 +1,479 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/admpub/transcoder"
+)
+
+// Recording is a segment file closed by a Recorder. Start and End are observed
+// on the file system, so they are accurate to the polling interval
+type Recording struct {
+	Path  string
+	Size  int64
+	Start time.Time
+	End   time.Time
+}
+
+// RecordingProgress is sent on the progress channel for every closed segment,
+// the embedded Progress is the last progress reported before it was closed
+type RecordingProgress struct {
+	Progress
+	Recording Recording
+}
+
+// RecorderOptions configures a Recorder
+type RecorderOptions struct {
+	// Pattern is the strftime name of the segment files, e.g. cam1/%Y-%m-%d/%H-%M-%S.mp4.
+	// The muxer is guessed from the extension, relative patterns are resolved against Config.Dir
+	Pattern string
+	// SegmentDuration rolls files every SegmentDuration, defaults to 60s
+	SegmentDuration time.Duration
+	// AlignToClock starts segments at multiples of SegmentDuration of the wall clock
+	AlignToClock bool
+	// MaxBytes also rolls a file once it grows past MaxBytes. ffmpeg cannot split on
+	// size, the process is restarted instead, losing up to a GOP
+	MaxBytes int64
+	// Retention deletes segments older than Retention, 0 keeps everything
+	Retention time.Duration
+	// OnSegment is called for every closed segment. A failing OnSegment stops the recording
+	OnSegment func(Recording) error
+	// RTSP are the options of rtsp:// inputs
+	RTSP RTSPOptions
+	// InputOptions are placed before the input
+	InputOptions transcoder.Options
+	// Options are the encoding options, defaults to copying the source streams
+	Options *Options
+	// Backoff controls the reconnection when the source drops
+	Backoff Backoff
+}
+
+// Recorder continuously records a live source to rolling segment files, the
+// usual NVR (network video recorder) pattern
+type Recorder struct {
+	config         *Config
+	input          string
+	options        RecorderOptions
+	commandContext context.Context
+}
+
+// NewRecorder ...
+func NewRecorder(cfg *Config, input string, opts RecorderOptions) *Recorder {
+	return &Recorder{config: cfg, input: input, options: opts}
+}
+
+// WithContext is to be used *before Starting*, cancelling the context stops the recording
+func (r *Recorder) WithContext(ctx context.Context) *Recorder {
+	r.commandContext = ctx
+	return r
+}
+
+// validate ...
+func (r *Recorder) validate() error {
+	if len(r.input) == 0 {
+		return errors.New("missing input option")
+	}
+	if len(r.options.Pattern) == 0 {
+		return errors.New("missing recording pattern")
+	}
+	if len(filepath.Ext(r.options.Pattern)) == 0 {
+		return errors.New("recording pattern needs a file extension")
+	}
+	return nil
+}
+
+// segmentDuration ...
+func (r *Recorder) segmentDuration() time.Duration {
+	if r.options.SegmentDuration <= 0 {
+		return time.Minute
+	}
+	return r.options.SegmentDuration
+}
+
+// pattern returns the pattern resolved against the working directory of ffmpeg
+func (r *Recorder) pattern() string {
+	p := filepath.FromSlash(r.options.Pattern)
+	if !filepath.IsAbs(p) && len(r.config.Dir) > 0 {
+		p = filepath.Join(r.config.Dir, p)
+	}
+	return p
+}
+
+// segmentArguments returns the segment muxer options
+func (r *Recorder) segmentArguments() Args {
+	args := Args{
+		"-map", "0:v?", "-map", "0:a?",
+		"-f", "segment",
+		"-segment_time", strconv.FormatFloat(r.segmentDuration().Seconds(), 'f', -1, 64),
+		"-reset_timestamps", "1",
+		"-strftime", "1",
+	}
+	if r.options.AlignToClock {
+		args = append(args, "-segment_atclocktime", "1")
+	}
+	switch strings.ToLower(filepath.Ext(r.options.Pattern)) {
+	case ".mp4", ".m4v", ".mov":
+		// fragmented files stay playable when the process is killed
+		args = append(args, "-segment_format_options", "movflags=+frag_keyframe+empty_moov+default_base_moof")
+	}
+	return args
+}
+
+// attempt starts one recording process
+func (r *Recorder) attempt(ctx context.Context, attempt int) (<-chan transcoder.Progress, error) {
+	t := New(progressConfig(r.config)).(*Transcoder)
+	if u, err := url.Parse(r.input); err == nil && (u.Scheme == "rtsp" || u.Scheme == "rtsps") {
+		t.WithContext(ctx)
+		if _, err := t.InputRTSP(r.input, r.options.RTSP); err != nil {
+			return nil, err
+		}
+	} else {
+		t.Input(r.input)
+	}
+	if r.options.InputOptions != nil {
+		t.WithInputOptions(r.options.InputOptions)
+	}
+	var encoding Options
+	if r.options.Options != nil {
+		encoding = *r.options.Options
+	} else {
+		codec := "copy"
+		encoding = Options{VideoCodec: &codec, AudioCodec: &codec}
+	}
+	t.Output(filepath.ToSlash(r.options.Pattern)).
+		WithOptions(encoding).
+		WithAdditionalOptions(r.segmentArguments()).
+		WithContext(ctx)
+	return t.Start(Options{})
+}
+
+// Start records until the context is cancelled, the source ends or its reconnection
+// gives up. RecordingProgress values report closed segments, ConnectionProgress values
+// the state of the source
+func (r *Recorder) Start() (<-chan transcoder.Progress, error) {
+	if err := r.validate(); err != nil {
+		return nil, err
+	}
+	base := r.commandContext
+	if base == nil {
+		base = context.Background()
+	}
+	ctx, stop := context.WithCancel(base)
+
+	w := newRecordingWatcher(r.pattern())
+	if err := w.mkdirs(time.Now(), r.segmentDuration()); err != nil {
+		stop()
+		return nil, err
+	}
+
+	out := make(chan transcoder.Progress)
+	done := make(chan error, 1)
+	go func() {
+		defer stop()
+		done <- r.run(ctx, stop, w, out)
+		close(out)
+	}()
+
+	if !r.config.ProgressEnabled {
+		for range out {
+		}
+		return out, <-done
+	}
+	progress := make(chan transcoder.Progress)
+	go func() {
+		defer close(progress)
+		for msg := range out {
+			progress <- msg
+		}
+		if err := <-done; err != nil {
+			progress <- Progress{Error: err}
+		}
+	}()
+	return progress, nil
+}
+
+// run supervises the recording process, restarting it when a file grows past MaxBytes
+func (r *Recorder) run(ctx context.Context, stop context.CancelFunc, w *recordingWatcher, out chan<- transcoder.Progress) error {
+	var last Progress
+	var failed error
+	closed := func(recordings []Recording) {
+		for _, rec := range recordings {
+			if r.options.OnSegment != nil && failed == nil {
+				if err := r.options.OnSegment(rec); err != nil {
+					failed = err
+					stop()
+				}
+			}
+			out <- RecordingProgress{Progress: last, Recording: rec}
+		}
+	}
+	ticker := time.NewTicker(segmentPollInterval)
+	defer ticker.Stop()
+
+	for {
+		attemptCtx, cancel := context.WithCancel(ctx)
+		inner := make(chan transcoder.Progress)
+		done := make(chan error, 1)
+		go func() {
+			done <- supervise(attemptCtx, r.options.Backoff, r.attempt, inner, nil)
+			close(inner)
+		}()
+
+		rolled := false
+	forward:
+		for {
+			select {
+			case msg, ok := <-inner:
+				if !ok {
+					break forward
+				}
+				if c, ok := msg.(ConnectionProgress); ok && rolled && c.State == StateStopped {
+					// restarts caused by MaxBytes are not reported
+					continue
+				}
+				if msg.GetError() == nil {
+					if _, ok := msg.(ConnectionProgress); !ok {
+						last = toProgress(msg)
+					}
+				}
+				out <- msg
+			case now := <-ticker.C:
+				if err := w.mkdirs(now, r.segmentDuration()); err != nil && failed == nil {
+					failed = err
+					stop()
+				}
+				closed(w.poll(false))
+				if r.options.Retention > 0 {
+					w.prune(now.Add(-r.options.Retention))
+				}
+				if !rolled && r.options.MaxBytes > 0 && w.currentSize() >= r.options.MaxBytes {
+					rolled = true
+					cancel()
+				}
+			}
+		}
+		cancel()
+		err := <-done
+		closed(w.poll(true))
+
+		if failed != nil {
+			return failed
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		if !rolled {
+			return err
+		}
+	}
+}
+
+// recordingWatcher detects the segment files opened and closed by the segment muxer
+type recordingWatcher struct {
+	pattern string
+	glob    string
+	known   map[string]bool
+	current *Recording
+}
+
+// newRecordingWatcher ignores the files already matching pattern, they are only pruned
+func newRecordingWatcher(pattern string) *recordingWatcher {
+	w := &recordingWatcher{pattern: pattern, glob: strftimeGlob(pattern), known: map[string]bool{}}
+	matches, _ := filepath.Glob(w.glob)
+	for _, m := range matches {
+		w.known[m] = true
+	}
+	return w
+}
+
+// mkdirs creates the directories of the segments starting from now to now+ahead,
+// the segment muxer does not create them
+func (w *recordingWatcher) mkdirs(now time.Time, ahead time.Duration) error {
+	dir := filepath.Dir(w.pattern)
+	for _, t := range []time.Time{now, now.Add(ahead + 5*time.Second)} {
+		if err := os.MkdirAll(strftime(dir, t), 0755); err != nil {
+			return fmt.Errorf("failed to create recording directory: %w", err)
+		}
+	}
+	return nil
+}
+
+// poll returns the segments closed since the previous call. A segment is closed once
+// the next one appears, or when the process exited (final)
+func (w *recordingWatcher) poll(final bool) []Recording {
+	type file struct {
+		path string
+		mod  time.Time
+	}
+	var fresh []file
+	matches, _ := filepath.Glob(w.glob)
+	for _, m := range matches {
+		if w.known[m] {
+			continue
+		}
+		info, err := os.Stat(m)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		w.known[m] = true
+		fresh = append(fresh, file{path: m, mod: info.ModTime()})
+	}
+	sort.Slice(fresh, func(i, j int) bool {
+		if fresh[i].mod.Equal(fresh[j].mod) {
+			return fresh[i].path < fresh[j].path
+		}
+		return fresh[i].mod.Before(fresh[j].mod)
+	})
+
+	var closed []Recording
+	now := time.Now()
+	for _, f := range fresh {
+		if w.current != nil {
+			closed = append(closed, w.close(now))
+		}
+		w.current = &Recording{Path: f.path, Start: now}
+	}
+	if final && w.current != nil {
+		closed = append(closed, w.close(now))
+	}
+	return closed
+}
+
+// close ends the current segment
+func (w *recordingWatcher) close(now time.Time) Recording {
+	rec := *w.current
+	w.current = nil
+	rec.End = now
+	if info, err := os.Stat(rec.Path); err == nil {
+		rec.Size = info.Size()
+	}
+	return rec
+}
+
+// currentSize returns the size of the segment being written
+func (w *recordingWatcher) currentSize() int64 {
+	if w.current == nil {
+		return 0
+	}
+	info, err := os.Stat(w.current.Path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// prune deletes the segments last written before limit, and their directories once empty
+func (w *recordingWatcher) prune(limit time.Time) {
+	matches, _ := filepath.Glob(w.glob)
+	for _, m := range matches {
+		if w.current != nil && w.current.Path == m {
+			continue
+		}
+		info, err := os.Stat(m)
+		if err != nil || info.IsDir() || !info.ModTime().Before(limit) {
+			continue
+		}
+		if os.Remove(m) != nil {
+			continue
+		}
+		delete(w.known, m)
+		// only directories created from the pattern are removed, failing when not empty
+		for dir := filepath.Dir(m); strings.Contains(filepath.Dir(w.pattern), "%") && dir != staticDir(w.pattern); dir = filepath.Dir(dir) {
+			if os.Remove(dir) != nil {
+				break
+			}
+		}
+	}
+}
+
+// staticDir returns the leading directories of pattern without strftime directives
+func staticDir(pattern string) string {
+	dir := filepath.Dir(pattern)
+	for strings.Contains(dir, "%") {
+		dir = filepath.Dir(dir)
+	}
+	return dir
+}
+
+// strftimeGlob turns strftime directives into wildcards
+func strftimeGlob(pattern string) string {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] == '%' && i+1 < len(pattern) {
+			i++
+			if pattern[i] == '%' {
+				b.WriteByte('%')
+			} else {
+				b.WriteByte('*')
+			}
+			continue
+		}
+		b.WriteByte(pattern[i])
+	}
+	return b.String()
+}
+
+// strftime formats t with the common strftime directives, others are kept as is
+func strftime(layout string, t time.Time) string {
+	var b strings.Builder
+	for i := 0; i < len(layout); i++ {
+		if layout[i] != '%' || i+1 == len(layout) {
+			b.WriteByte(layout[i])
+			continue
+		}
+		i++
+		switch layout[i] {
+		case 'Y':
+			b.WriteString(strconv.Itoa(t.Year()))
+		case 'y':
+			fmt.Fprintf(&b, "%02d", t.Year()%100)
+		case 'm':
+			fmt.Fprintf(&b, "%02d", int(t.Month()))
+		case 'd':
+			fmt.Fprintf(&b, "%02d", t.Day())
+		case 'e':
+			fmt.Fprintf(&b, "%2d", t.Day())
+		case 'j':
+			fmt.Fprintf(&b, "%03d", t.YearDay())
+		case 'H':
+			fmt.Fprintf(&b, "%02d", t.Hour())
+		case 'I':
+			fmt.Fprintf(&b, "%02d", (t.Hour()+11)%12+1)
+		case 'M':
+			fmt.Fprintf(&b, "%02d", t.Minute())
+		case 'S':
+			fmt.Fprintf(&b, "%02d", t.Second())
+		case 'p':
+			b.WriteString(t.Format("PM"))
+		case 'b':
+			b.WriteString(t.Format("Jan"))
+		case 'B':
+			b.WriteString(t.Format("January"))
+		case 'a':
+			b.WriteString(t.Format("Mon"))
+		case 'A':
+			b.WriteString(t.Format("Monday"))
+		case 'Z':
+			b.WriteString(t.Format("MST"))
+		case 'z':
+			b.WriteString(t.Format("-0700"))
+		case 's':
+			b.WriteString(strconv.FormatInt(t.Unix(), 10))
+		case '%':
+			b.WriteByte('%')
+		default:
+			b.WriteByte('%')
+			b.WriteByte(layout[i])
+		}
+	}
+	return b.String()
+}
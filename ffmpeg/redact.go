@@ -0,0 +1,80 @@
+package ffmpeg
+
+import "strings"
+
+// sensitiveFlags are flags whose value must never appear in logs or error messages
+var sensitiveFlags = map[string]bool{
+	"-encryption_key": true,
+	"-decryption_key": true,
+	"-key":            true,
+	"-passphrase":     true,
+	"-authorization":  true,
+}
+
+// sensitiveOptions are the options whose value is masked inside option lists, such
+// as the -format_options of the dash muxer
+var sensitiveOptions = []string{"encryption_key=", "decryption_key=", "passphrase="}
+
+// redacted replaces secret values in logged arguments
+const redacted = "<redacted>"
+
+// redact returns a copy of args with the values of sensitive flags and the given secrets masked
+func redact(args []string, secrets ...string) []string {
+	out := make([]string, len(args))
+	copy(out, args)
+	for i := 0; i < len(out); i++ {
+		if sensitiveFlags[out[i]] && i+1 < len(out) {
+			out[i+1] = redacted
+			i++
+			continue
+		}
+		out[i] = redactOptions(out[i])
+		for _, secret := range secrets {
+			if len(secret) > 0 {
+				out[i] = strings.Replace(out[i], secret, redacted, -1)
+			}
+		}
+	}
+	return out
+}
+
+// redactOptions masks the values of sensitiveOptions in the option list or URL
+// query arg, whose options are separated by ':', ',' or '&'
+func redactOptions(arg string) string {
+	for _, option := range sensitiveOptions {
+		for from := 0; ; {
+			i := strings.Index(arg[from:], option)
+			if i < 0 {
+				break
+			}
+			i += from + len(option)
+			end := strings.IndexAny(arg[i:], ":,&")
+			if end < 0 {
+				end = len(arg) - i
+			}
+			arg = arg[:i] + redacted + arg[i+end:]
+			from = i + len(redacted)
+		}
+	}
+	return arg
+}
+
+// sensitiveEnv are the words of environment variable names whose value is secret
+var sensitiveEnv = []string{"KEY", "SECRET", "TOKEN", "PASSWORD", "PASSWD", "PASS", "CREDENTIAL", "AUTH", "SESSION"}
+
+// redactEnv returns a copy of env with the values of secret variables and the given
+// secrets masked
+func redactEnv(env []string, secrets ...string) []string {
+	out := make([]string, len(env))
+	for i, kv := range env {
+		name := strings.ToUpper(strings.SplitN(kv, "=", 2)[0])
+		for _, word := range sensitiveEnv {
+			if strings.Contains(name, word) {
+				kv = kv[:len(name)] + "=" + redacted
+				break
+			}
+		}
+		out[i] = redact([]string{kv}, secrets...)[0]
+	}
+	return out
+}
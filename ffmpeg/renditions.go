@@ -0,0 +1,121 @@
+package ffmpeg
+
+import (
+	"context"
+	"sync"
+
+	"github.com/admpub/transcoder"
+)
+
+// RenditionProgress is the progress of a multi rendition output.
+// The embedded Progress is aggregated over all renditions, Rendition names
+// the rendition that reported and RenditionProgress is its own percentage
+type RenditionProgress struct {
+	Progress
+	Rendition         string
+	RenditionProgress float64
+}
+
+// renditionJob is one ffmpeg process of a multi rendition output
+type renditionJob struct {
+	name  string
+	start func(ctx context.Context) (<-chan transcoder.Progress, error)
+}
+
+// progressConfig returns a copy of cfg that always reports progress, which the
+// aggregating helpers rely on regardless of the caller's preference
+func progressConfig(cfg *Config) *Config {
+	c := *cfg
+	c.ProgressEnabled = true
+	c.OnMetadata = nil
+	return &c
+}
+
+// runRenditions runs the jobs, at most concurrency at a time, sending aggregated
+// progress to out when it is not nil. The first failure cancels the remaining jobs
+func runRenditions(ctx context.Context, jobs []renditionJob, concurrency int, out chan<- transcoder.Progress) error {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+		percents = make([]float64, len(jobs))
+		sem      = make(chan struct{}, concurrency)
+	)
+
+	fail := func(err error) {
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+		mu.Unlock()
+	}
+
+	for i := range jobs {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			job := jobs[i]
+			ch, err := job.start(ctx)
+			if err != nil {
+				fail(err)
+				return
+			}
+			for msg := range ch {
+				if seg, ok := msg.(SegmentProgress); ok {
+					if out != nil {
+						out <- seg
+					}
+					continue
+				}
+				if err := msg.GetError(); err != nil {
+					fail(err)
+					continue
+				}
+				mu.Lock()
+				percents[i] = msg.GetProgress()
+				var total float64
+				for _, p := range percents {
+					total += p
+				}
+				mu.Unlock()
+				if out == nil {
+					continue
+				}
+				out <- RenditionProgress{
+					Progress: Progress{
+						FramesProcessed: msg.GetFramesProcessed(),
+						CurrentTime:     msg.GetCurrentTime(),
+						CurrentBitrate:  msg.GetCurrentBitrate(),
+						Progress:        total / float64(len(jobs)),
+						Speed:           msg.GetSpeed(),
+					},
+					Rendition:         job.name,
+					RenditionProgress: msg.GetProgress(),
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,113 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ReplayGainReference is the ReplayGain 2.0 reference loudness in LUFS
+const ReplayGainReference = -18.0
+
+// TrackGain is the ReplayGain of a file
+type TrackGain struct {
+	File string
+	// Loudness is the integrated loudness in LUFS
+	Loudness float64
+	// Gain in dB brings the track to the reference loudness
+	Gain float64
+	// Peak is the linear true peak, 1 being full scale
+	Peak float64
+}
+
+// ReplayGainReport is the ReplayGain of a set of files forming an album
+type ReplayGainReport struct {
+	Tracks    []TrackGain
+	AlbumGain float64
+	AlbumPeak float64
+}
+
+// AnalyzeReplayGain computes the ReplayGain 2.0 track gains of files and their
+// album gain, the album loudness being the duration weighted energy mean of the tracks
+func AnalyzeReplayGain(ctx context.Context, cfg *Config, files ...string) (*ReplayGainReport, error) {
+	if len(files) == 0 {
+		return nil, errors.New("no file given")
+	}
+	report := &ReplayGainReport{}
+	var energy, total float64
+	for _, file := range files {
+		duration, _, err := probeDuration(ctx, cfg, file)
+		if err != nil {
+			return nil, err
+		}
+		l, err := MeasureLoudness(ctx, cfg, file)
+		if err != nil {
+			return nil, err
+		}
+		track := TrackGain{
+			File:     file,
+			Loudness: l.Integrated,
+			Gain:     ReplayGainReference - l.Integrated,
+			Peak:     math.Pow(10, l.TruePeak/20),
+		}
+		// silent tracks have no defined loudness and take no part in the album gain
+		if !math.IsInf(l.Integrated, 0) {
+			energy += duration * math.Pow(10, l.Integrated/10)
+			total += duration
+		} else {
+			track.Gain = 0
+		}
+		if track.Peak > report.AlbumPeak {
+			report.AlbumPeak = track.Peak
+		}
+		report.Tracks = append(report.Tracks, track)
+	}
+	if total > 0 {
+		report.AlbumGain = ReplayGainReference - 10*math.Log10(energy/total)
+	}
+	return report, nil
+}
+
+// WriteReplayGainTags writes the REPLAYGAIN_* tags of the report into each file,
+// the streams being copied into a temporary file replacing the original
+func WriteReplayGainTags(ctx context.Context, cfg *Config, report *ReplayGainReport) error {
+	gain := func(g float64) string {
+		return strconv.FormatFloat(g, 'f', 2, 64) + " dB"
+	}
+	peak := func(p float64) string {
+		return strconv.FormatFloat(p, 'f', 6, 64)
+	}
+	for _, track := range report.Tracks {
+		ext := filepath.Ext(track.File)
+		tmp := strings.TrimSuffix(track.File, ext) + ".replaygain" + ext
+		args := []string{"-y", "-i", track.File, "-map", "0", "-c", "copy", "-map_metadata", "0",
+			"-metadata", "REPLAYGAIN_TRACK_GAIN=" + gain(track.Gain),
+			"-metadata", "REPLAYGAIN_TRACK_PEAK=" + peak(track.Peak),
+			"-metadata", "REPLAYGAIN_ALBUM_GAIN=" + gain(report.AlbumGain),
+			"-metadata", "REPLAYGAIN_ALBUM_PEAK=" + peak(report.AlbumPeak),
+		}
+		switch strings.ToLower(ext) {
+		case ".m4a", ".mp4", ".m4b", ".mov":
+			// the mp4 muxer drops unknown tags by default
+			args = append(args, "-movflags", "use_metadata_tags")
+		}
+		if _, err := run(ctx, cfg, append(args, tmp)...); err != nil {
+			return err
+		}
+		// ffmpeg runs in cfg.Dir, relative paths are resolved from there
+		from, to := tmp, track.File
+		if !filepath.IsAbs(to) && len(cfg.Dir) > 0 {
+			from, to = filepath.Join(cfg.Dir, from), filepath.Join(cfg.Dir, to)
+		}
+		if err := os.Rename(from, to); err != nil {
+			os.Remove(from)
+			return fmt.Errorf("failed to replace %s with error %w", track.File, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Resamplers
+const (
+	// ResamplerSWR is the builtin libswresample resampler
+	ResamplerSWR = "swr"
+	// ResamplerSoxr is the SoX resampler, higher quality when ffmpeg is built with libsoxr
+	ResamplerSoxr = "soxr"
+)
+
+// Dither methods
+const (
+	DitherNone              = "none"
+	DitherRectangular       = "rectangular"
+	DitherTriangular        = "triangular"
+	DitherTriangularHP      = "triangular_hp"
+	DitherLipshitz          = "lipshitz"
+	DitherShibata           = "shibata"
+	DitherLowShibata        = "low_shibata"
+	DitherHighShibata       = "high_shibata"
+	DitherFWeighted         = "f_weighted"
+	DitherEWeighted         = "e_weighted"
+	DitherModifiedEWeighted = "modified_e_weighted"
+	DitherImprovedEWeighted = "improved_e_weighted"
+)
+
+// ResampleOptions configures the sample rate and bit depth conversion of the audio.
+// It implements transcoder.Options, setting -af which replaces any other audio filter
+type ResampleOptions struct {
+	// SampleRate of the output, 0 keeps the source rate
+	SampleRate int
+	// BitDepth is 16, 24 or 32, 0 keeps the source format
+	BitDepth int
+	// Float selects 32 bit float samples, BitDepth is then ignored
+	Float bool
+	// Resampler is ResamplerSWR (default) or ResamplerSoxr
+	Resampler string
+	// Precision of soxr in bits, from 15 to 33, defaults to 20 in ffmpeg, 28 is very high quality
+	Precision int
+	// Cheby enables the passband rolloff free Chebyshev mode of soxr at the highest precisions
+	Cheby bool
+	// Dither is applied when reducing the bit depth, defaults to triangular in ffmpeg
+	Dither string
+	// DitherScale scales the dither noise, defaults to 1
+	DitherScale float64
+}
+
+// sampleFormat returns the sample format and the significant bits of the output
+func (o ResampleOptions) sampleFormat() (string, int, error) {
+	if o.Float {
+		return "flt", 0, nil
+	}
+	switch o.BitDepth {
+	case 0:
+		return "", 0, nil
+	case 16:
+		return "s16", 0, nil
+	case 24:
+		// there is no 24 bit sample format, 24 bit samples are stored in 32 bits
+		return "s32", 24, nil
+	case 32:
+		return "s32", 0, nil
+	}
+	return "", 0, fmt.Errorf("unsupported bit depth %d", o.BitDepth)
+}
+
+// Filter returns the aresample filter of the conversion
+func (o ResampleOptions) Filter() (string, error) {
+	format, bits, err := o.sampleFormat()
+	if err != nil {
+		return "", err
+	}
+	var opts []string
+	if o.SampleRate > 0 {
+		opts = append(opts, "osr="+strconv.Itoa(o.SampleRate))
+	}
+	if len(format) > 0 {
+		opts = append(opts, "osf="+format)
+	}
+	if bits > 0 {
+		opts = append(opts, "osb="+strconv.Itoa(bits))
+	}
+	switch o.Resampler {
+	case "", ResamplerSWR:
+	case ResamplerSoxr:
+		opts = append(opts, "resampler=soxr")
+		if o.Precision > 0 {
+			if o.Precision < 15 || o.Precision > 33 {
+				return "", fmt.Errorf("soxr precision %d out of range 15-33", o.Precision)
+			}
+			opts = append(opts, "precision="+strconv.Itoa(o.Precision))
+		}
+		if o.Cheby {
+			opts = append(opts, "cheby=1")
+		}
+	default:
+		return "", fmt.Errorf("unsupported resampler %q", o.Resampler)
+	}
+	if len(o.Dither) > 0 {
+		opts = append(opts, "dither_method="+o.Dither)
+	}
+	if o.DitherScale > 0 {
+		opts = append(opts, "dither_scale="+strconv.FormatFloat(o.DitherScale, 'f', -1, 64))
+	}
+	if len(opts) == 0 {
+		return "aresample", nil
+	}
+	return "aresample=" + strings.Join(opts, ":"), nil
+}
+
+// GetStrArguments ...
+func (o ResampleOptions) GetStrArguments() []string {
+	filter, err := o.Filter()
+	if err != nil {
+		// invalid options are reported by Resample, here they are ignored
+		return nil
+	}
+	args := []string{"-af", filter}
+	if o.SampleRate > 0 {
+		args = append(args, "-ar", strconv.Itoa(o.SampleRate))
+	}
+	if format, bits, _ := o.sampleFormat(); len(format) > 0 {
+		args = append(args, "-sample_fmt", format)
+		if bits > 0 {
+			args = append(args, "-bits_per_raw_sample", strconv.Itoa(bits))
+		}
+	}
+	return args
+}
+
+// Resample writes the audio of input to output (flac or wav) converted as configured
+func Resample(ctx context.Context, cfg *Config, input, output string, opts ResampleOptions) error {
+	if len(output) == 0 {
+		return errors.New("missing output option")
+	}
+	if _, err := opts.Filter(); err != nil {
+		return err
+	}
+	f, err := audioFormatOf("", output)
+	if err != nil {
+		return err
+	}
+	args := []string{"-y", "-i", input, "-map", "0:a:0", "-vn", "-sn", "-map_metadata", "0"}
+	switch f.muxer {
+	case "wav":
+		codec := "pcm_s16le"
+		if opts.Float {
+			codec = "pcm_f32le"
+		} else if opts.BitDepth > 0 {
+			codec = "pcm_s" + strconv.Itoa(opts.BitDepth) + "le"
+		}
+		args = append(args, "-c:a", codec)
+	case "flac":
+		args = append(args, f.args...)
+	default:
+		return fmt.Errorf("unsupported lossless audio format %q", f.ext)
+	}
+	args = append(args, opts.GetStrArguments()...)
+	_, err = run(ctx, cfg, append(args, "-f", f.muxer, output)...)
+	return err
+}
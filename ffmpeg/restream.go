@@ -0,0 +1,585 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/admpub/transcoder"
+)
+
+// Restreaming modes
+const (
+	// RestreamTee pulls the source once and pushes to every destination with the tee
+	// muxer in a single process. A failed destination does not stop the others, but
+	// rejoining it or changing the destinations restarts the process
+	RestreamTee = "tee"
+	// RestreamParallel runs one process per destination, each pulling the source, so
+	// destinations connect, fail and reconnect independently
+	RestreamParallel = "parallel"
+)
+
+// Destination is a restreaming target
+type Destination struct {
+	// Name identifies the destination, defaults to URL
+	Name string
+	URL  string
+	// StreamKey is appended to URL, it is masked in logs and error messages
+	StreamKey string
+	// Format is the muxer, guessed from the URL scheme: flv for rtmp, mpegts for srt, udp and tcp
+	Format string
+}
+
+// name ...
+func (d Destination) name() string {
+	if len(d.Name) > 0 {
+		return d.Name
+	}
+	return d.URL
+}
+
+// format ...
+func (d Destination) format() (string, error) {
+	if len(d.Format) > 0 {
+		return d.Format, nil
+	}
+	u, err := url.Parse(d.URL)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "rtmp", "rtmps", "rtmpt", "rtmpe", "rtmpte", "rtmpts":
+		return "flv", nil
+	case "srt", "udp", "tcp", "rist":
+		return "mpegts", nil
+	case "rtsp", "rtsps":
+		return "rtsp", nil
+	}
+	return "", fmt.Errorf("unable to guess the format of destination %q", d.name())
+}
+
+// DestinationStatus is the health of a destination
+type DestinationStatus struct {
+	Name    string
+	State   string
+	Attempt int
+	// Cause is the error that ended the last attempt
+	Cause error
+	// Since is when the destination entered State
+	Since time.Time
+}
+
+// DestinationProgress is sent on the progress channel of a Restreamer when the
+// state of a destination changes. In parallel mode the progress of each process
+// is sent as a DestinationProgress with an empty State
+type DestinationProgress struct {
+	Progress
+	Destination string
+	State       string
+	Attempt     int
+	Cause       error
+}
+
+// RestreamerOptions configures a Restreamer
+type RestreamerOptions struct {
+	// Mode defaults to RestreamTee
+	Mode string
+	// Realtime reads the input at its native frame rate (-re). It is enabled
+	// automatically for local file inputs
+	Realtime *bool
+	// Options are the encoding options, defaults to copying the source streams
+	Options *Options
+	// Backoff controls the reconnection of the source and of each destination
+	Backoff Backoff
+	// SpeedSLO reports through its OnHealth when a process cannot keep up with the
+	// source, see Transcoder.WithSpeedSLO
+	SpeedSLO *SpeedSLO
+}
+
+// destination is a registered destination and its reconnection state
+type destination struct {
+	Destination
+	status   DestinationStatus
+	failures int
+	delay    time.Duration
+	// waiting is set while a failed tee destination waits for its delay
+	waiting bool
+	// cancel stops the process of a parallel destination
+	cancel context.CancelFunc
+}
+
+// Restreamer ingests one live source and pushes it to several destinations,
+// which can be added and removed while it runs
+type Restreamer struct {
+	config         *Config
+	input          string
+	options        RestreamerOptions
+	commandContext context.Context
+
+	mu           sync.Mutex
+	destinations []*destination
+	started      bool
+	ctx          context.Context
+	out          chan transcoder.Progress
+	stopped      chan struct{}
+	restart      chan struct{}
+	workers      sync.WaitGroup
+}
+
+// NewRestreamer ...
+func NewRestreamer(cfg *Config, input string, opts RestreamerOptions) *Restreamer {
+	return &Restreamer{config: cfg, input: input, options: opts, restart: make(chan struct{}, 1)}
+}
+
+// WithContext is to be used *before Starting*, cancelling the context stops the restreaming
+func (r *Restreamer) WithContext(ctx context.Context) *Restreamer {
+	r.commandContext = ctx
+	return r
+}
+
+// AddDestination registers a destination, it starts pushing right away when the Restreamer runs
+func (r *Restreamer) AddDestination(d Destination) error {
+	if len(d.URL) == 0 {
+		return errors.New("missing destination URL")
+	}
+	if _, err := d.format(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	for _, o := range r.destinations {
+		if o.name() == d.name() {
+			r.mu.Unlock()
+			return fmt.Errorf("destination %q already exists", d.name())
+		}
+	}
+	dest := &destination{
+		Destination: d,
+		status:      DestinationStatus{Name: d.name(), State: StateConnecting, Since: time.Now()},
+		delay:       r.options.Backoff.withDefaults().Initial,
+	}
+	r.destinations = append(r.destinations, dest)
+	started := r.started
+	r.mu.Unlock()
+
+	if started {
+		if r.mode() == RestreamParallel {
+			r.startWorker(dest)
+		} else {
+			r.signalRestart()
+		}
+	}
+	return nil
+}
+
+// RemoveDestination stops pushing to the named destination
+func (r *Restreamer) RemoveDestination(name string) error {
+	r.mu.Lock()
+	var dest *destination
+	for i, d := range r.destinations {
+		if d.name() == name {
+			dest = d
+			r.destinations = append(r.destinations[:i], r.destinations[i+1:]...)
+			break
+		}
+	}
+	started := r.started
+	var cancel context.CancelFunc
+	if dest != nil {
+		cancel = dest.cancel
+	}
+	r.mu.Unlock()
+	if dest == nil {
+		return fmt.Errorf("destination %q not found", name)
+	}
+
+	if started {
+		if cancel != nil {
+			cancel()
+		} else {
+			r.signalRestart()
+		}
+		r.send(DestinationProgress{Destination: name, State: StateStopped, Attempt: dest.status.Attempt})
+	}
+	return nil
+}
+
+// Status returns the health of every destination
+func (r *Restreamer) Status() []DestinationStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status := make([]DestinationStatus, len(r.destinations))
+	for i, d := range r.destinations {
+		status[i] = d.status
+	}
+	return status
+}
+
+// mode ...
+func (r *Restreamer) mode() string {
+	if len(r.options.Mode) == 0 {
+		return RestreamTee
+	}
+	return r.options.Mode
+}
+
+// validate ...
+func (r *Restreamer) validate() error {
+	if len(r.input) == 0 {
+		return errors.New("missing input option")
+	}
+	switch r.mode() {
+	case RestreamTee, RestreamParallel:
+	default:
+		return fmt.Errorf("invalid restreaming mode %q", r.options.Mode)
+	}
+	return nil
+}
+
+// Start restreams until the context is cancelled. In tee mode it also ends when the
+// source ends or its reconnection gives up. ConnectionProgress values report the
+// state of the source in tee mode, DestinationProgress values the state of each destination
+func (r *Restreamer) Start() (<-chan transcoder.Progress, error) {
+	if err := r.validate(); err != nil {
+		return nil, err
+	}
+	ctx := r.commandContext
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	r.mu.Lock()
+	if r.started {
+		r.mu.Unlock()
+		return nil, errors.New("restreamer already started")
+	}
+	r.started = true
+	r.ctx = ctx
+	r.out = make(chan transcoder.Progress)
+	r.stopped = make(chan struct{})
+	destinations := append([]*destination(nil), r.destinations...)
+	r.mu.Unlock()
+
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		if r.mode() == RestreamParallel {
+			for _, d := range destinations {
+				r.startWorker(d)
+			}
+			<-ctx.Done()
+			r.workers.Wait()
+		} else {
+			err = r.runTee(ctx)
+		}
+		done <- err
+		// r.out is never closed, destinations may still be removed concurrently
+		close(r.stopped)
+	}()
+
+	if !r.config.ProgressEnabled {
+		for {
+			select {
+			case <-r.out:
+			case <-r.stopped:
+				return nil, <-done
+			}
+		}
+	}
+	progress := make(chan transcoder.Progress)
+	go func() {
+		defer close(progress)
+		for {
+			select {
+			case msg := <-r.out:
+				progress <- msg
+			case <-r.stopped:
+				if err := <-done; err != nil {
+					progress <- Progress{Error: err}
+				}
+				return
+			}
+		}
+	}()
+	return progress, nil
+}
+
+// send forwards msg unless the Restreamer is stopping
+func (r *Restreamer) send(msg transcoder.Progress) {
+	select {
+	case r.out <- msg:
+	case <-r.ctx.Done():
+	case <-r.stopped:
+	}
+}
+
+// signalRestart asks the tee process to restart with the current destinations
+func (r *Restreamer) signalRestart() {
+	select {
+	case r.restart <- struct{}{}:
+	default:
+	}
+}
+
+// setState updates the status of dest and reports the change
+func (r *Restreamer) setState(dest *destination, state string, cause error) {
+	backoff := r.options.Backoff.withDefaults()
+	r.mu.Lock()
+	if !r.registered(dest) {
+		// removed while its process was stopping
+		r.mu.Unlock()
+		return
+	}
+	if state == StateDisconnected {
+		if dest.status.State == StateConnected && time.Since(dest.status.Since) >= backoff.ResetAfter {
+			dest.failures = 0
+			dest.delay = backoff.Initial
+		}
+		dest.failures++
+	}
+	if state == StateConnecting {
+		dest.status.Attempt++
+	}
+	changed := dest.status.State != state || state == StateConnecting
+	dest.status.State = state
+	dest.status.Cause = cause
+	if changed {
+		dest.status.Since = time.Now()
+	}
+	msg := DestinationProgress{Destination: dest.name(), State: state, Attempt: dest.status.Attempt, Cause: cause}
+	r.mu.Unlock()
+	if changed {
+		r.send(msg)
+	}
+}
+
+// registered reports whether dest was not removed, r.mu must be held
+func (r *Restreamer) registered(dest *destination) bool {
+	for _, d := range r.destinations {
+		if d == dest {
+			return true
+		}
+	}
+	return false
+}
+
+// transcoder returns a process pushing the source to output
+func (r *Restreamer) transcoder(ctx context.Context, output string) *Transcoder {
+	cfg := progressConfig(r.config)
+	realtime := isFile(r.input)
+	if r.options.Realtime != nil {
+		realtime = *r.options.Realtime
+	}
+	var input Args
+	if realtime {
+		input = append(input, "-re")
+	}
+	var encoding Options
+	if r.options.Options != nil {
+		encoding = *r.options.Options
+	} else {
+		codec := "copy"
+		encoding = Options{VideoCodec: &codec, AudioCodec: &codec}
+	}
+	t := New(cfg).Input(r.input).Output(output).(*Transcoder)
+	t.WithInputOptions(input).
+		WithOptions(encoding).
+		WithContext(ctx)
+	if r.options.SpeedSLO != nil {
+		t.WithSpeedSLO(*r.options.SpeedSLO)
+	}
+	return t
+}
+
+// startWorker pushes the source to dest in its own supervised process
+func (r *Restreamer) startWorker(dest *destination) {
+	r.mu.Lock()
+	if r.ctx.Err() != nil {
+		r.mu.Unlock()
+		return
+	}
+	ctx, cancel := context.WithCancel(r.ctx)
+	dest.cancel = cancel
+	r.workers.Add(1)
+	r.mu.Unlock()
+
+	format, _ := dest.format()
+	start := func(ctx context.Context, attempt int) (<-chan transcoder.Progress, error) {
+		t := r.transcoder(ctx, joinStreamKey(dest.URL, dest.StreamKey))
+		t.WithSecrets(dest.StreamKey).WithAdditionalOptions(Args{"-f", format})
+		return t.Start(Options{})
+	}
+
+	go func() {
+		defer r.workers.Done()
+		defer cancel()
+		inner := make(chan transcoder.Progress)
+		done := make(chan error, 1)
+		go func() {
+			done <- supervise(ctx, r.options.Backoff, start, inner, nil)
+			close(inner)
+		}()
+		for msg := range inner {
+			if ctx.Err() != nil {
+				continue
+			}
+			if c, ok := msg.(ConnectionProgress); ok {
+				r.setState(dest, c.State, c.Cause)
+				continue
+			}
+			r.send(DestinationProgress{Progress: toProgress(msg), Destination: dest.name()})
+		}
+		if err := <-done; err != nil && ctx.Err() == nil {
+			r.setState(dest, StateStopped, err)
+		}
+	}()
+}
+
+// toProgress copies the values of msg
+func toProgress(msg transcoder.Progress) Progress {
+	return Progress{
+		FramesProcessed: msg.GetFramesProcessed(),
+		CurrentTime:     msg.GetCurrentTime(),
+		CurrentBitrate:  msg.GetCurrentBitrate(),
+		Progress:        msg.GetProgress(),
+		Speed:           msg.GetSpeed(),
+		Error:           msg.GetError(),
+	}
+}
+
+// reSlaveFailed matches the tee muxer report of a failed destination
+var reSlaveFailed = regexp.MustCompile(`Slave muxer #(\d+) failed: (.*?), continuing with`)
+
+// teeEscape escapes the tee muxer special characters of a slave URL
+func teeEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `|`, `\|`, `[`, `\[`, `]`, `\]`).Replace(s)
+}
+
+// active returns the destinations the tee process pushes to
+func (r *Restreamer) active() []*destination {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var active []*destination
+	for _, d := range r.destinations {
+		if !d.waiting && d.status.State != StateStopped {
+			active = append(active, d)
+		}
+	}
+	return active
+}
+
+// teeAttempt starts one tee process pushing to dests
+func (r *Restreamer) teeAttempt(dests []*destination) attemptFunc {
+	var slaves, secrets []string
+	for _, d := range dests {
+		format, _ := d.format()
+		slaves = append(slaves, "[f="+format+":onfail=ignore]"+teeEscape(joinStreamKey(d.URL, d.StreamKey)))
+		secrets = append(secrets, d.StreamKey)
+	}
+	return func(ctx context.Context, attempt int) (<-chan transcoder.Progress, error) {
+		t := r.transcoder(ctx, strings.Join(slaves, "|"))
+		t.WithSecrets(secrets...).
+			WithAdditionalOptions(Args{"-map", "0:v?", "-map", "0:a?", "-flags", "+global_header", "-f", "tee"})
+		t.onLine = func(line string) {
+			m := reSlaveFailed.FindStringSubmatch(line)
+			if m == nil {
+				return
+			}
+			if i, err := strconv.Atoi(m[1]); err == nil && i < len(dests) {
+				r.teeFailed(dests[i], errors.New(m[2]))
+			}
+		}
+		return t.Start(Options{})
+	}
+}
+
+// teeFailed takes a failed destination out of the tee process until its delay expires
+func (r *Restreamer) teeFailed(dest *destination, cause error) {
+	r.setState(dest, StateDisconnected, cause)
+	backoff := r.options.Backoff.withDefaults()
+	r.mu.Lock()
+	if backoff.MaxAttempts > 0 && dest.failures >= backoff.MaxAttempts {
+		r.mu.Unlock()
+		r.setState(dest, StateStopped, cause)
+		return
+	}
+	dest.waiting = true
+	delay := dest.delay
+	dest.delay = time.Duration(float64(dest.delay) * backoff.Multiplier)
+	if dest.delay > backoff.Max {
+		dest.delay = backoff.Max
+	}
+	r.mu.Unlock()
+
+	time.AfterFunc(delay, func() {
+		r.mu.Lock()
+		dest.waiting = false
+		r.mu.Unlock()
+		r.signalRestart()
+	})
+}
+
+// runTee runs the tee process, restarting it when the destinations change
+func (r *Restreamer) runTee(ctx context.Context) error {
+	for {
+		dests := r.active()
+		if len(dests) == 0 {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-r.restart:
+				continue
+			}
+		}
+
+		attemptCtx, cancel := context.WithCancel(ctx)
+		inner := make(chan transcoder.Progress)
+		done := make(chan error, 1)
+		go func() {
+			done <- supervise(attemptCtx, r.options.Backoff, r.teeAttempt(dests), inner, nil)
+			close(inner)
+		}()
+
+		restarting := false
+	forward:
+		for {
+			select {
+			case msg, ok := <-inner:
+				if !ok {
+					break forward
+				}
+				if c, ok := msg.(ConnectionProgress); ok {
+					if restarting && c.State == StateStopped {
+						// restarts caused by destination changes are not reported
+						continue
+					}
+					for _, d := range dests {
+						r.mu.Lock()
+						skip := d.waiting || d.status.State == StateStopped
+						r.mu.Unlock()
+						if !skip && c.State != StateStopped {
+							r.setState(d, c.State, c.Cause)
+						}
+					}
+				}
+				r.send(msg)
+			case <-r.restart:
+				restarting = true
+				cancel()
+			}
+		}
+		cancel()
+		err := <-done
+		if ctx.Err() != nil {
+			return nil
+		}
+		if !restarting {
+			return err
+		}
+	}
+}
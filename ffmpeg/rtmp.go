@@ -0,0 +1,176 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/admpub/transcoder"
+	"github.com/admpub/transcoder/utils"
+)
+
+// LiveOutputOptions configures a push to an RTMP(S) endpoint
+type LiveOutputOptions struct {
+	// URL of the ingest application, e.g. rtmps://live.example.com/app
+	URL string
+	// StreamKey is appended to URL, it is masked in logs and error messages
+	StreamKey string
+	// Realtime reads the input at its native frame rate (-re). It is enabled
+	// automatically for local file inputs
+	Realtime *bool
+	// Options are the encoding options, defaults to H.264/AAC suitable for RTMP
+	Options *Options
+	// FlvFlags are flv muxer flags, defaults to no_duration_filesize
+	FlvFlags []string
+	// Resume restarts file inputs where the previous attempt stopped, instead of from the beginning
+	Resume bool
+	// Backoff controls reconnection when the endpoint drops the connection
+	Backoff Backoff
+}
+
+// LiveOutput pushes an input to an RTMP(S) endpoint, reconnecting when the connection drops
+type LiveOutput struct {
+	config         *Config
+	input          string
+	options        LiveOutputOptions
+	commandContext context.Context
+}
+
+// NewLiveOutput ...
+func NewLiveOutput(cfg *Config, input string, opts LiveOutputOptions) *LiveOutput {
+	return &LiveOutput{config: cfg, input: input, options: opts}
+}
+
+// WithContext is to be used *before Starting*, cancelling the context stops the push
+func (l *LiveOutput) WithContext(ctx context.Context) *LiveOutput {
+	l.commandContext = ctx
+	return l
+}
+
+// isFile reports whether input is a local file rather than a network or device source
+func isFile(input string) bool {
+	u, err := url.Parse(input)
+	return err != nil || len(u.Scheme) <= 1 || u.Scheme == "file"
+}
+
+// target returns the publish URL including the stream key
+func (o LiveOutputOptions) target() string {
+	return joinStreamKey(o.URL, o.StreamKey)
+}
+
+// joinStreamKey appends a stream key to a publish URL
+func joinStreamKey(u, key string) string {
+	if len(key) == 0 {
+		return u
+	}
+	return strings.TrimRight(u, "/") + "/" + key
+}
+
+// encoding returns the encoding options of the push
+func (o LiveOutputOptions) encoding() Options {
+	if o.Options != nil {
+		return *o.Options
+	}
+	videoCodec, audioCodec := "libx264", "aac"
+	preset, tune := "veryfast", "zerolatency"
+	pixFmt := "yuv420p"
+	return Options{
+		VideoCodec: &videoCodec,
+		AudioCodec: &audioCodec,
+		Preset:     &preset,
+		Tune:       &tune,
+		PixFmt:     &pixFmt,
+	}
+}
+
+// validate ...
+func (l *LiveOutput) validate() error {
+	u, err := url.Parse(l.options.URL)
+	if err != nil || len(l.options.URL) == 0 {
+		return errors.New("missing or invalid live output URL")
+	}
+	switch u.Scheme {
+	case "rtmp", "rtmps", "rtmpt", "rtmpe", "rtmpte", "rtmpts":
+	default:
+		return errors.New("live output URL must use an rtmp scheme")
+	}
+	if len(l.input) == 0 {
+		return errors.New("missing input option")
+	}
+	return nil
+}
+
+// Start pushes the input until it ends, the context is cancelled or reconnection gives up.
+// ConnectionProgress values report the connection state
+func (l *LiveOutput) Start() (<-chan transcoder.Progress, error) {
+	if err := l.validate(); err != nil {
+		return nil, err
+	}
+	ctx := l.commandContext
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	cfg := progressConfig(l.config)
+	file := isFile(l.input)
+	realtime := file
+	if l.options.Realtime != nil {
+		realtime = *l.options.Realtime
+	}
+	flags := l.options.FlvFlags
+	if len(flags) == 0 {
+		flags = []string{"no_duration_filesize"}
+	}
+	format := "flv"
+	muxer := Args{"-f", format, "-flvflags", strings.Join(flags, "+")}
+
+	var mu sync.Mutex
+	var position, offset float64
+	start := func(ctx context.Context, attempt int) (<-chan transcoder.Progress, error) {
+		var input Args
+		if realtime {
+			input = append(input, "-re")
+		}
+		mu.Lock()
+		offset = 0
+		if l.options.Resume && file && position > 0 {
+			// the resumed attempt reports time from its seek point
+			offset = position
+			input = append(input, "-ss", utils.SecToDur(position))
+		}
+		mu.Unlock()
+		t := New(cfg).Input(l.input).Output(l.options.target()).(*Transcoder)
+		return t.WithInputOptions(input).
+			WithSecrets(l.options.StreamKey).
+			WithOptions(l.options.encoding()).
+			WithAdditionalOptions(muxer).
+			WithContext(ctx).
+			Start(Options{})
+	}
+	track := func(msg transcoder.Progress) {
+		t := utils.DurToSec(msg.GetCurrentTime())
+		mu.Lock()
+		position = offset + t
+		mu.Unlock()
+	}
+
+	out := make(chan transcoder.Progress)
+	if !l.config.ProgressEnabled {
+		done := make(chan error, 1)
+		go func() {
+			done <- supervise(ctx, l.options.Backoff, start, out, track)
+			close(out)
+		}()
+		for range out {
+		}
+		return out, <-done
+	}
+	go func() {
+		defer close(out)
+		if err := supervise(ctx, l.options.Backoff, start, out, track); err != nil {
+			out <- Progress{Error: err}
+		}
+	}()
+	return out, nil
+}
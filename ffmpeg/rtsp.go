@@ -0,0 +1,108 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// RTSP lower transports
+const (
+	RTSPTransportTCP       = "tcp"
+	RTSPTransportUDP       = "udp"
+	RTSPTransportMulticast = "udp_multicast"
+	RTSPTransportHTTP      = "http"
+)
+
+// RTSPOptions are the options of an RTSP (IP camera) input
+type RTSPOptions struct {
+	// Transport defaults to TCP, which survives NAT and lossy links better than UDP
+	Transport string
+	// PreferTCP tries TCP first and falls back to UDP (rtsp_flags prefer_tcp)
+	PreferTCP bool
+	// Timeout is the socket I/O timeout, defaults to 10s. Without it ffmpeg
+	// blocks forever on a camera that stops sending
+	Timeout time.Duration
+	// StallTimeout kills the process when the stream time does not advance for this
+	// long (a connected camera sending no frames), Start then reports ErrStalled.
+	// 0 disables it. It needs ProgressEnabled
+	StallTimeout time.Duration
+	// SpeedSLO reports when the encoder cannot keep up with the camera, see
+	// Transcoder.WithSpeedSLO
+	SpeedSLO *SpeedSLO
+	// WallclockTimestamps replaces the camera timestamps, which often jump or reset
+	WallclockTimestamps bool
+	// BufferSize is the UDP receive buffer in bytes
+	BufferSize int
+}
+
+// arguments returns the input options for ffmpeg v
+func (o RTSPOptions) arguments(v Version) Args {
+	transport := o.Transport
+	if len(transport) == 0 {
+		transport = RTSPTransportTCP
+	}
+	args := Args{"-rtsp_transport", transport}
+	if o.PreferTCP {
+		args = append(args, "-rtsp_flags", "prefer_tcp")
+	}
+	timeout := o.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	// ffmpeg 5 renamed stimeout to timeout, which was the listen timeout before
+	flag := "-timeout"
+	if !v.AtLeast(5, 0) {
+		flag = "-stimeout"
+	}
+	args = append(args, flag, strconv.FormatInt(timeout.Microseconds(), 10))
+	if o.BufferSize > 0 {
+		args = append(args, "-buffer_size", strconv.Itoa(o.BufferSize))
+	}
+	if o.WallclockTimestamps {
+		args = append(args, "-use_wallclock_as_timestamps", "1")
+	}
+	return args
+}
+
+// InputRTSP sets an RTSP input. Credentials in the URL are masked in error messages.
+// RTSP has no reconnection at the protocol level: a dropped or stale stream ends the
+// transcoding with an error, restart it to reconnect
+func (t *Transcoder) InputRTSP(input string, opts RTSPOptions) (*Transcoder, error) {
+	u, err := url.Parse(input)
+	if err != nil {
+		return t, err
+	}
+	if u.Scheme != "rtsp" && u.Scheme != "rtsps" {
+		return t, errors.New("RTSP input URL must use the rtsp or rtsps scheme")
+	}
+	switch opts.Transport {
+	case "", RTSPTransportTCP, RTSPTransportUDP, RTSPTransportMulticast, RTSPTransportHTTP:
+	default:
+		return t, fmt.Errorf("invalid RTSP transport %q", opts.Transport)
+	}
+	ctx := t.commandContext
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	// assume a current release when the version cannot be detected
+	v, err := DetectVersion(ctx, t.config)
+	if err != nil {
+		v = Version{Master: true}
+	}
+	t.Input(input)
+	t.WithInputOptions(opts.arguments(v))
+	if password, ok := u.User.Password(); ok && len(password) > 0 {
+		t.WithSecrets(password, url.QueryEscape(password))
+	}
+	if opts.StallTimeout > 0 {
+		t.WithStallTimeout(opts.StallTimeout)
+	}
+	if opts.SpeedSLO != nil {
+		t.WithSpeedSLO(*opts.SpeedSLO)
+	}
+	return t, nil
+}
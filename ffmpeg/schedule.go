@@ -0,0 +1,204 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/admpub/transcoder"
+	"github.com/admpub/transcoder/utils"
+)
+
+// ErrScheduleMissed is returned when a scheduled recording could not start before its stop time
+var ErrScheduleMissed = errors.New("scheduled recording missed")
+
+// scheduleCheckInterval bounds each wait, so that wall clock corrections (NTP, DST
+// fixes, suspended hosts) are honored instead of firing at the originally computed instant
+var scheduleCheckInterval = time.Second
+
+// Moment is an absolute time or a duration. A start duration is relative to the
+// scheduling call, a stop duration to the scheduled start
+type Moment struct {
+	At    time.Time
+	After time.Duration
+}
+
+// AtTime returns the Moment t
+func AtTime(t time.Time) Moment {
+	return Moment{At: t}
+}
+
+// AfterDuration returns the Moment d after its reference
+func AfterDuration(d time.Duration) Moment {
+	return Moment{After: d}
+}
+
+// resolve ...
+func (m Moment) resolve(ref time.Time) time.Time {
+	if !m.At.IsZero() {
+		return m.At
+	}
+	return ref.Add(m.After)
+}
+
+// ScheduleOptions configures a scheduled recording
+type ScheduleOptions struct {
+	// PreRoll starts the process early to absorb the connection time of the source
+	PreRoll time.Duration
+	// PostRoll keeps recording after the stop time
+	PostRoll time.Duration
+	// MaxLateness fails a recording starting later than this, 0 records whatever remains
+	MaxLateness time.Duration
+	// StallTimeout ends the recording when the source stops sending, defaults to 10s
+	StallTimeout time.Duration
+	// RTSP are the options of rtsp:// sources
+	RTSP RTSPOptions
+	// InputOptions are placed before the input
+	InputOptions transcoder.Options
+	// Options are the encoding options, defaults to copying the source streams
+	Options *Options
+}
+
+// RecordingReport describes a scheduled recording
+type RecordingReport struct {
+	Source         string
+	Output         string
+	ScheduledStart time.Time
+	ScheduledStop  time.Time
+	// Started and Stopped are the wall clock times of the process
+	Started time.Time
+	Stopped time.Time
+	// Late is how much later than scheduled the recording started
+	Late time.Duration
+	// Duration is the recorded media time reported by ffmpeg
+	Duration time.Duration
+	// Size of the output file, 0 for network outputs
+	Size int64
+	// Complete is set when the recording covered the whole schedule
+	Complete bool
+	Error    error
+}
+
+// waitUntil sleeps until the wall clock reaches t
+func waitUntil(ctx context.Context, t time.Time) error {
+	for {
+		d := time.Until(t)
+		if d <= 0 {
+			return nil
+		}
+		if d > scheduleCheckInterval {
+			d = scheduleCheckInterval
+		}
+		timer := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// ScheduleRecording waits until start, records source to output until stop and
+// returns a report of the recording. A late start records what remains of the schedule
+func ScheduleRecording(ctx context.Context, cfg *Config, source string, start, stop Moment, output string, opts ScheduleOptions) (RecordingReport, error) {
+	report := RecordingReport{Source: displayInput(source), Output: displayInput(output)}
+	if len(source) == 0 {
+		return report, errors.New("missing input option")
+	}
+	if len(output) == 0 {
+		return report, errors.New("missing output option")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	report.ScheduledStart = start.resolve(time.Now())
+	report.ScheduledStop = stop.resolve(report.ScheduledStart)
+	if !report.ScheduledStop.After(report.ScheduledStart) {
+		return report, errors.New("recording stop must be after its start")
+	}
+
+	if err := waitUntil(ctx, report.ScheduledStart.Add(-opts.PreRoll)); err != nil {
+		return report, err
+	}
+	now := time.Now()
+	end := report.ScheduledStop.Add(opts.PostRoll)
+	if !now.Before(end) {
+		report.Error = fmt.Errorf("%w: now %s, stop %s", ErrScheduleMissed, now.Format(time.RFC3339), end.Format(time.RFC3339))
+		return report, report.Error
+	}
+	if late := now.Sub(report.ScheduledStart.Add(-opts.PreRoll)); late > scheduleCheckInterval {
+		report.Late = late
+		if opts.MaxLateness > 0 && late > opts.MaxLateness {
+			report.Error = fmt.Errorf("%w: started %s late", ErrScheduleMissed, late)
+			return report, report.Error
+		}
+	}
+
+	// -t stops on the media time, the deadline catches sources running slow
+	length := end.Sub(now)
+	recordCtx, cancel := context.WithDeadline(ctx, end.Add(30*time.Second))
+	defer cancel()
+
+	t := New(progressConfig(cfg)).(*Transcoder)
+	t.WithContext(recordCtx)
+	if u, err := url.Parse(source); err == nil && (u.Scheme == "rtsp" || u.Scheme == "rtsps") {
+		if _, err := t.InputRTSP(source, opts.RTSP); err != nil {
+			return report, err
+		}
+	} else {
+		t.Input(source)
+	}
+	if opts.InputOptions != nil {
+		t.WithInputOptions(opts.InputOptions)
+	}
+	var encoding Options
+	if opts.Options != nil {
+		encoding = *opts.Options
+	} else {
+		codec := "copy"
+		encoding = Options{VideoCodec: &codec, AudioCodec: &codec}
+	}
+	stall := opts.StallTimeout
+	if stall <= 0 {
+		stall = 10 * time.Second
+	}
+	t.Output(output).
+		WithOptions(encoding).
+		WithAdditionalOptions(Args{"-t", strconv.FormatFloat(length.Seconds(), 'f', 3, 64)})
+	t.WithStallTimeout(stall)
+
+	report.Started = time.Now()
+	ch, err := t.Start(Options{})
+	if err != nil {
+		report.Error = err
+		return report, err
+	}
+	for msg := range ch {
+		if e := msg.GetError(); e != nil {
+			report.Error = e
+			continue
+		}
+		if sec := utils.DurToSec(msg.GetCurrentTime()); sec > 0 {
+			report.Duration = time.Duration(sec * float64(time.Second))
+		}
+	}
+	report.Stopped = time.Now()
+	file := output
+	if !filepath.IsAbs(file) && len(cfg.Dir) > 0 {
+		file = filepath.Join(cfg.Dir, file)
+	}
+	if info, err := os.Stat(file); err == nil && isFile(output) {
+		report.Size = info.Size()
+	}
+	if report.Error == nil && ctx.Err() != nil {
+		report.Error = ctx.Err()
+	}
+	report.Complete = report.Error == nil && report.Late == 0 && !report.Stopped.Before(report.ScheduledStop)
+	return report, report.Error
+}
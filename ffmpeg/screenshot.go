@@ -0,0 +1,145 @@
+package ffmpeg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/admpub/transcoder/utils"
+)
+
+// ScreenshotOptions configures the extraction of a single frame
+type ScreenshotOptions struct {
+	// Output is the image file, when empty the frame is decoded and returned as an image.Image
+	Output string
+	// Format is jpg, png or webp, guessed from the extension of Output and defaulting to jpg
+	Format string
+	// Width and Height of the image, 0 follows the aspect ratio, both 0 keep the source size
+	Width  int
+	Height int
+	// Quality from 1 (worst) to 100 (best) for jpg and webp, defaults to 90
+	Quality int
+	// Keyframe takes the keyframe before the timestamp, faster but inexact
+	Keyframe bool
+}
+
+// ScreenshotResult holds the extracted frame, either written to Path or decoded in Image
+type ScreenshotResult struct {
+	Path  string
+	Image image.Image
+}
+
+// format ...
+func (o ScreenshotOptions) format() (string, error) {
+	format := strings.ToLower(o.Format)
+	if len(format) == 0 && len(o.Output) > 0 {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(o.Output)), ".")
+	}
+	switch format {
+	case "", "jpg", "jpeg":
+		return "jpg", nil
+	case "png", "webp":
+		return format, nil
+	}
+	return "", fmt.Errorf("unsupported screenshot format %q", format)
+}
+
+// screenshotScale returns the scale filter for the requested size, empty to keep the source size
+func screenshotScale(width, height int) string {
+	if width <= 0 && height <= 0 {
+		return ""
+	}
+	if width <= 0 {
+		width = -1
+	}
+	if height <= 0 {
+		height = -1
+	}
+	return fmt.Sprintf("scale=%d:%d", width, height)
+}
+
+// encoderArguments returns the image encoder options of format
+func encoderArguments(format string, quality int) []string {
+	if quality <= 0 || quality > 100 {
+		quality = 90
+	}
+	switch format {
+	case "png":
+		return []string{"-c:v", "png"}
+	case "webp":
+		return []string{"-c:v", "libwebp", "-quality", strconv.Itoa(quality)}
+	case "avif":
+		// map quality 1..100 to crf 63..10
+		crf := 63 - (quality-1)*53/99
+		return []string{"-c:v", "libaom-av1", "-still-picture", "1", "-crf", strconv.Itoa(crf), "-b:v", "0", "-pix_fmt", "yuv420p"}
+	}
+	// mjpeg qscale goes from 2 (best) to 31 (worst)
+	q := 31 - (quality-1)*29/99
+	return []string{"-c:v", "mjpeg", "-q:v", strconv.Itoa(q)}
+}
+
+// Screenshot extracts the frame of input displayed at the given time
+func Screenshot(ctx context.Context, cfg *Config, input string, at time.Duration, opts ScreenshotOptions) (*ScreenshotResult, error) {
+	if len(input) == 0 {
+		return nil, errors.New("missing input option")
+	}
+	if cfg.FfmpegBinPath == "" {
+		return nil, errors.New("ffmpeg binary path not found")
+	}
+	format, err := opts.format()
+	if err != nil {
+		return nil, err
+	}
+
+	// -ss before -i seeks to the previous keyframe then decodes up to the exact time
+	args := []string{"-nostdin", "-hide_banner", "-y"}
+	if opts.Keyframe {
+		args = append(args, "-noaccurate_seek", "-skip_frame", "nokey")
+	}
+	args = append(args, "-ss", utils.SecToDur(at.Seconds()), "-i", input, "-an", "-sn", "-frames:v", "1")
+	if scale := screenshotScale(opts.Width, opts.Height); len(scale) > 0 {
+		args = append(args, "-vf", scale)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if len(opts.Output) > 0 {
+		args = append(args, encoderArguments(format, opts.Quality)...)
+		args = append(args, "-f", "image2", "-update", "1", opts.Output)
+	} else {
+		// decoded through png, the standard library has no webp decoder
+		args = append(args, "-c:v", "png", "-f", "image2pipe", "-")
+	}
+	cmd := command(ctx, cfg, cfg.FfmpegBinPath, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("failed to execute (%s) with args (%s) with error %w | message: %s", cfg.FfmpegBinPath, redact(args), err, tail(stderr.Bytes()))
+	}
+
+	if len(opts.Output) > 0 {
+		file := opts.Output
+		if !filepath.IsAbs(file) && len(cfg.Dir) > 0 {
+			file = filepath.Join(cfg.Dir, file)
+		}
+		if info, err := os.Stat(file); err != nil || info.Size() == 0 {
+			return nil, fmt.Errorf("no frame at %s in %s", at, input)
+		}
+		return &ScreenshotResult{Path: opts.Output}, nil
+	}
+	if stdout.Len() == 0 {
+		return nil, fmt.Errorf("no frame at %s in %s", at, input)
+	}
+	img, err := png.Decode(&stdout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode screenshot with error %w", err)
+	}
+	return &ScreenshotResult{Image: img}, nil
+}
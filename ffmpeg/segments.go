@@ -0,0 +1,163 @@
+package ffmpeg
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/admpub/transcoder"
+)
+
+// segmentPollInterval is how often media playlists are re-read while packaging
+var segmentPollInterval = 500 * time.Millisecond
+
+// Segment is a media segment completed by the packager
+type Segment struct {
+	// Rendition names the rendition, for DASH it is the representation index
+	Rendition string
+	// Index is the media sequence number of the segment
+	Index int
+	// Duration in seconds
+	Duration float64
+	// URI as written in the media playlist, Path is the file on disk
+	URI  string
+	Path string
+}
+
+// SegmentProgress is sent on the progress channel for every completed segment,
+// the embedded Progress is the last progress reported before the segment completed
+type SegmentProgress struct {
+	Progress
+	Segment Segment
+}
+
+// segmentWatcher detects segments appended to a media playlist
+type segmentWatcher struct {
+	rendition string
+	playlist  string
+	next      int
+}
+
+// playlistEntry is a segment listed in a media playlist
+type playlistEntry struct {
+	sequence int
+	duration float64
+	uri      string
+}
+
+// parseMediaPlaylist returns the segments listed in an m3u8 media playlist
+func parseMediaPlaylist(data []byte) []playlistEntry {
+	var entries []playlistEntry
+	sequence := 0
+	duration := -1.0
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			sequence, _ = strconv.Atoi(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"))
+		case strings.HasPrefix(line, "#EXTINF:"):
+			value := strings.TrimPrefix(line, "#EXTINF:")
+			if i := strings.IndexByte(value, ','); i >= 0 {
+				value = value[:i]
+			}
+			duration, _ = strconv.ParseFloat(value, 64)
+		case len(line) == 0 || line[0] == '#':
+		default:
+			if duration < 0 {
+				continue
+			}
+			entries = append(entries, playlistEntry{sequence: sequence, duration: duration, uri: line})
+			sequence++
+			duration = -1
+		}
+	}
+	return entries
+}
+
+// poll returns the segments completed since the previous call
+func (w *segmentWatcher) poll() []Segment {
+	data, err := ioutil.ReadFile(w.playlist)
+	if err != nil {
+		return nil
+	}
+	var segments []Segment
+	for _, e := range parseMediaPlaylist(data) {
+		if e.sequence < w.next {
+			continue
+		}
+		w.next = e.sequence + 1
+		p := e.uri
+		if !strings.Contains(p, "://") && !filepath.IsAbs(p) {
+			p = filepath.Join(filepath.Dir(w.playlist), filepath.FromSlash(p))
+		}
+		segments = append(segments, Segment{
+			Rendition: w.rendition,
+			Index:     e.sequence,
+			Duration:  e.duration,
+			URI:       e.uri,
+			Path:      p,
+		})
+	}
+	return segments
+}
+
+// watchSegments forwards the messages of in and adds a SegmentProgress for every segment
+// appended to the watched playlists, calling onSegment (when not nil) for each of them.
+// A failing onSegment is reported on the channel and stops the job through cancel,
+// which is also called once in is closed to release the job context
+func watchSegments(in <-chan transcoder.Progress, watchers []*segmentWatcher, onSegment func(Segment) error, cancel func()) <-chan transcoder.Progress {
+	out := make(chan transcoder.Progress)
+	go func() {
+		defer close(out)
+		defer cancel()
+		ticker := time.NewTicker(segmentPollInterval)
+		defer ticker.Stop()
+
+		var last Progress
+		failed := false
+		emit := func() {
+			for _, w := range watchers {
+				for _, s := range w.poll() {
+					if failed {
+						continue
+					}
+					if onSegment != nil {
+						if err := onSegment(s); err != nil {
+							failed = true
+							cancel()
+							out <- Progress{Error: err}
+							continue
+						}
+					}
+					out <- SegmentProgress{Progress: last, Segment: s}
+				}
+			}
+		}
+
+		for {
+			select {
+			case msg, ok := <-in:
+				if !ok {
+					emit()
+					return
+				}
+				if msg.GetError() == nil {
+					if p, ok := msg.(Progress); ok {
+						last = p
+					} else if p, ok := msg.(*Progress); ok && p != nil {
+						last = *p
+					}
+				}
+				out <- msg
+			case <-ticker.C:
+				emit()
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,210 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TrimSilenceOptions configures TrimSilence
+type TrimSilenceOptions struct {
+	// HeadThreshold and TailThreshold are the levels in dB under which the audio is
+	// silence, 0 disables the trimming of that end
+	HeadThreshold float64
+	TailThreshold float64
+	// MinDuration is the shortest silence removed, defaults to 100ms
+	MinDuration time.Duration
+	// Format of the output (m4a, mp3, flac, wav or opus), guessed from its extension
+	Format string
+}
+
+// SplitOnSilenceOptions configures SplitOnSilence
+type SplitOnSilenceOptions struct {
+	// Dir receives the parts
+	Dir string
+	// Pattern is the printf name of the parts formatted with their number, defaults
+	// to part_%03d followed by the extension of Format
+	Pattern string
+	// Format of the parts (m4a, mp3, flac, wav or opus), defaults to the extension of Pattern or m4a
+	Format string
+}
+
+// Silence is a silent interval
+type Silence struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// AudioPart is a part of a split
+type AudioPart struct {
+	Start time.Duration
+	End   time.Duration
+	Path  string
+}
+
+// SilenceSplit is the result of SplitOnSilence
+type SilenceSplit struct {
+	Silences []Silence
+	// Cuts are the split times, in the middle of each silence
+	Cuts  []time.Duration
+	Parts []AudioPart
+}
+
+// reSilence matches the lines printed by silencedetect
+var reSilence = regexp.MustCompile(`silence_(start|end):\s*(-?[\d.]+)`)
+
+// parseSeconds converts a decimal number of seconds
+func parseSeconds(s string) time.Duration {
+	f, _ := strconv.ParseFloat(s, 64)
+	return time.Duration(f * float64(time.Second))
+}
+
+// parseSilences returns the silences printed by silencedetect, a silence running
+// to the end of the input ends at duration
+func parseSilences(stderr []byte, duration time.Duration) []Silence {
+	var silences []Silence
+	open := false
+	for _, m := range reSilence.FindAllSubmatch(stderr, -1) {
+		t := parseSeconds(string(m[2]))
+		if t < 0 {
+			t = 0
+		}
+		if string(m[1]) == "start" {
+			silences = append(silences, Silence{Start: t, End: duration})
+			open = true
+		} else if open {
+			silences[len(silences)-1].End = t
+			open = false
+		}
+	}
+	return silences
+}
+
+// threshold formats a level in dB
+func threshold(db float64) string {
+	return strconv.FormatFloat(db, 'f', -1, 64) + "dB"
+}
+
+// DetectSilences returns the intervals of input under threshold dB lasting at least minSilence
+func DetectSilences(ctx context.Context, cfg *Config, input string, minSilence time.Duration, thresholdDB float64) ([]Silence, error) {
+	duration, _, err := probeDuration(ctx, cfg, input)
+	if err != nil {
+		return nil, err
+	}
+	filter := "silencedetect=noise=" + threshold(thresholdDB) + ":d=" + seconds(minSilence)
+	stderr, err := run(ctx, cfg, "-i", input, "-vn", "-sn", "-af", filter, "-f", "null", "-")
+	if err != nil {
+		return nil, err
+	}
+	return parseSilences(stderr, time.Duration(duration*float64(time.Second))), nil
+}
+
+// TrimSilence writes input to output without its leading and trailing silence
+func TrimSilence(ctx context.Context, cfg *Config, input, output string, opts TrimSilenceOptions) error {
+	if len(output) == 0 {
+		return errors.New("missing output option")
+	}
+	if opts.HeadThreshold == 0 && opts.TailThreshold == 0 {
+		return errors.New("no silence threshold given")
+	}
+	f, err := audioFormatOf(opts.Format, output)
+	if err != nil {
+		return err
+	}
+	minDuration := opts.MinDuration
+	if minDuration <= 0 {
+		minDuration = 100 * time.Millisecond
+	}
+	remove := func(db float64) string {
+		return "silenceremove=start_periods=1:start_threshold=" + threshold(db) + ":start_duration=" + seconds(minDuration)
+	}
+	var filters []string
+	if opts.HeadThreshold != 0 {
+		filters = append(filters, remove(opts.HeadThreshold))
+	}
+	if opts.TailThreshold != 0 {
+		// the tail is trimmed as the head of the reversed audio
+		filters = append(filters, "areverse", remove(opts.TailThreshold), "areverse")
+	}
+	args := []string{"-y", "-i", input, "-vn", "-sn", "-map_metadata", "0", "-af", strings.Join(filters, ",")}
+	args = append(args, f.args...)
+	_, err = run(ctx, cfg, append(args, "-f", f.muxer, output)...)
+	return err
+}
+
+// SplitOnSilence cuts input into parts in the middle of each silence under
+// threshold dB lasting at least minSilence
+func SplitOnSilence(ctx context.Context, cfg *Config, input string, minSilence time.Duration, thresholdDB float64, opts SplitOnSilenceOptions) (*SilenceSplit, error) {
+	if len(opts.Dir) == 0 {
+		return nil, errors.New("missing parts output directory")
+	}
+	if minSilence <= 0 {
+		return nil, errors.New("minimum silence duration must be positive")
+	}
+	format := opts.Format
+	if len(format) == 0 && len(opts.Pattern) > 0 {
+		format = strings.TrimPrefix(filepath.Ext(opts.Pattern), ".")
+	}
+	if len(format) == 0 {
+		format = "m4a"
+	}
+	f, err := audioFormatOf(format, "")
+	if err != nil {
+		return nil, err
+	}
+	pattern := opts.Pattern
+	if len(pattern) == 0 {
+		pattern = "part_%03d." + f.ext
+	}
+
+	duration, _, err := probeDuration(ctx, cfg, input)
+	if err != nil {
+		return nil, err
+	}
+	end := time.Duration(duration * float64(time.Second))
+	silences, err := DetectSilences(ctx, cfg, input, minSilence, thresholdDB)
+	if err != nil {
+		return nil, err
+	}
+	split := &SilenceSplit{Silences: silences}
+	for _, s := range silences {
+		// silences at the very start or end do not split anything
+		if s.Start <= 0 || s.End >= end {
+			continue
+		}
+		split.Cuts = append(split.Cuts, s.Start+(s.End-s.Start)/2)
+	}
+
+	if err := os.MkdirAll(opts.Dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	args := []string{"-y", "-i", input, "-map", "0:a:0", "-vn", "-sn", "-map_metadata", "0"}
+	args = append(args, f.args...)
+	args = append(args, "-f", "segment", "-segment_format", f.muxer, "-reset_timestamps", "1")
+	if len(split.Cuts) > 0 {
+		times := make([]string, len(split.Cuts))
+		for i, c := range split.Cuts {
+			times[i] = seconds(c)
+		}
+		args = append(args, "-segment_times", strings.Join(times, ","))
+	} else {
+		args = append(args, "-segment_time", strconv.FormatFloat(duration+1, 'f', 0, 64))
+	}
+	if _, err := run(ctx, cfg, append(args, filepath.Join(opts.Dir, pattern))...); err != nil {
+		return nil, err
+	}
+
+	start := time.Duration(0)
+	bounds := append(append([]time.Duration{}, split.Cuts...), end)
+	for i, b := range bounds {
+		split.Parts = append(split.Parts, AudioPart{Start: start, End: b, Path: filepath.Join(opts.Dir, fmt.Sprintf(pattern, i))})
+		start = b
+	}
+	return split, nil
+}
@@ -0,0 +1,195 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/admpub/transcoder"
+)
+
+// DefaultMaxBranches is the number of outputs above which SinglePass falls back to one process per output
+const DefaultMaxBranches = 8
+
+// maxFilterComplexLength keeps the generated filtergraph well below command line length limits
+const maxFilterComplexLength = 16 * 1024
+
+// SinglePassOutput is one output of a single pass multi rendition encode
+type SinglePassOutput struct {
+	Name   string
+	Output string
+	// Options are the encoding options, Resolution, FrameRate and VideoFilter are
+	// moved into the output's filtergraph branch
+	Options Options
+	// Muxer holds output format options (e.g. the hls muxer options)
+	Muxer transcoder.Options
+}
+
+// SinglePassOptions configures a single pass multi rendition encode
+type SinglePassOptions struct {
+	Outputs []SinglePassOutput
+	// MaxBranches defaults to DefaultMaxBranches
+	MaxBranches int
+	// Concurrency is the number of processes run at the same time when falling back, defaults to 1
+	Concurrency int
+}
+
+// SinglePass decodes the input once and encodes every output from a split filtergraph
+// (split + scale per branch + per output maps) in a single ffmpeg process. When the
+// filtergraph gets too complex it falls back to one ffmpeg process per output
+type SinglePass struct {
+	config         *Config
+	input          string
+	options        SinglePassOptions
+	commandContext context.Context
+}
+
+// NewSinglePass ...
+func NewSinglePass(cfg *Config, input string, opts SinglePassOptions) *SinglePass {
+	return &SinglePass{config: cfg, input: input, options: opts}
+}
+
+// WithContext is to be used *before Starting* to pass in a context.Context
+// object that can be used to kill the running ffmpeg processes
+func (s *SinglePass) WithContext(ctx context.Context) *SinglePass {
+	s.commandContext = ctx
+	return s
+}
+
+// branch returns the filter chain of an output branch
+func branch(opts Options) string {
+	var filters []string
+	if w, h := parseResolution(strValue(opts.Resolution)); w > 0 && h > 0 {
+		filters = append(filters, fmt.Sprintf("scale=%d:%d", w, h))
+	}
+	if opts.FrameRate != nil {
+		filters = append(filters, fmt.Sprintf("fps=%d", *opts.FrameRate))
+	}
+	if opts.VideoFilter != nil && len(*opts.VideoFilter) > 0 {
+		filters = append(filters, *opts.VideoFilter)
+	}
+	if len(filters) == 0 {
+		return "null"
+	}
+	return strings.Join(filters, ",")
+}
+
+// FilterComplex returns the filtergraph splitting the decoded video into one branch per output
+func (s *SinglePass) FilterComplex() string {
+	n := len(s.options.Outputs)
+	buf := new(strings.Builder)
+	fmt.Fprintf(buf, "[0:v:0]split=%d", n)
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(buf, "[s%d]", i)
+	}
+	for i, o := range s.options.Outputs {
+		fmt.Fprintf(buf, ";[s%d]%s[v%d]", i, branch(o.Options), i)
+	}
+	return buf.String()
+}
+
+// TooComplex reports whether the encode runs one process per output instead of a single pass
+func (s *SinglePass) TooComplex() bool {
+	max := s.options.MaxBranches
+	if max <= 0 {
+		max = DefaultMaxBranches
+	}
+	if len(s.options.Outputs) > max {
+		return true
+	}
+	return len(s.FilterComplex()) > maxFilterComplexLength
+}
+
+// outputArguments returns the mapping and encoding arguments of the index-th output
+func (s *SinglePass) outputArguments(index int) Args {
+	o := s.options.Outputs[index]
+	opts := o.Options
+	opts.Resolution = nil
+	opts.FrameRate = nil
+	opts.VideoFilter = nil
+
+	args := Args{"-map", fmt.Sprintf("[v%d]", index)}
+	if !boolValue(opts.SkipAudio) {
+		args = append(args, "-map", "0:a:0?")
+	}
+	args = append(args, opts.GetStrArguments()...)
+	if o.Muxer != nil {
+		args = append(args, o.Muxer.GetStrArguments()...)
+	}
+	return args
+}
+
+// validate ...
+func (s *SinglePass) validate() error {
+	if len(s.options.Outputs) == 0 {
+		return errors.New("missing output option")
+	}
+	for index, o := range s.options.Outputs {
+		if len(o.Output) == 0 {
+			return fmt.Errorf("output at index %d is an empty string", index)
+		}
+	}
+	return nil
+}
+
+// fallbackJobs returns one ffmpeg process per output
+func (s *SinglePass) fallbackJobs() []renditionJob {
+	cfg := progressConfig(s.config)
+	jobs := make([]renditionJob, len(s.options.Outputs))
+	for i, o := range s.options.Outputs {
+		o := o
+		name := o.Name
+		if len(name) == 0 {
+			name = o.Output
+		}
+		jobs[i] = renditionJob{
+			name: name,
+			start: func(ctx context.Context) (<-chan transcoder.Progress, error) {
+				t := New(cfg).
+					Input(s.input).
+					Output(o.Output).
+					WithOptions(o.Options)
+				if o.Muxer != nil {
+					t.WithAdditionalOptions(o.Muxer)
+				}
+				return t.WithContext(ctx).Start(Options{})
+			},
+		}
+	}
+	return jobs
+}
+
+// Start runs the encode
+func (s *SinglePass) Start() (<-chan transcoder.Progress, error) {
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+	ctx := s.commandContext
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if s.TooComplex() {
+		jobs := s.fallbackJobs()
+		out := make(chan transcoder.Progress)
+		if !s.config.ProgressEnabled {
+			defer close(out)
+			return out, runRenditions(ctx, jobs, s.options.Concurrency, nil)
+		}
+		go func() {
+			defer close(out)
+			if err := runRenditions(ctx, jobs, s.options.Concurrency, out); err != nil {
+				out <- Progress{Error: err}
+			}
+		}()
+		return out, nil
+	}
+
+	t := New(s.config).Input(s.input).WithContext(ctx)
+	for i, o := range s.options.Outputs {
+		t.Output(o.Output)
+		t.WithAdditionalOptions(s.outputArguments(i))
+	}
+	return t.Start(Args{"-filter_complex", s.FilterComplex()})
+}
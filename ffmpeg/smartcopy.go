@@ -0,0 +1,245 @@
+package ffmpeg
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/admpub/transcoder"
+)
+
+// StreamDecision is what SmartCopy chose for a stream of the source
+type StreamDecision struct {
+	Index int    `json:"index"`
+	Type  string `json:"type"`
+	Codec string `json:"codec"`
+	// Copied is set when the stream is copied, Reason otherwise telling what the
+	// options change
+	Copied bool   `json:"copied"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// SmartCopyReport lists the streams SmartCopy copied and those it left to the
+// encoders
+type SmartCopyReport struct {
+	Streams []StreamDecision `json:"streams"`
+}
+
+// containerCodecs are the codecs each container copies, the containers absent from
+// it, such as the m3u8 and mpd manifests, copying none
+var containerCodecs = map[string][]string{
+	"matroska": {"h264", "hevc", "av1", "vp8", "vp9", "mpeg4", "mpeg2video", "prores", "ffv1", "aac", "mp3", "ac3", "eac3", "dts", "truehd", "opus", "vorbis", "flac", "alac", "pcm_s16le", "pcm_s24le"},
+	"mp4":      {"h264", "hevc", "av1", "vp9", "mpeg4", "aac", "mp3", "ac3", "eac3", "opus", "flac", "alac"},
+	"mov":      {"h264", "hevc", "av1", "mpeg4", "prores", "mjpeg", "aac", "mp3", "ac3", "eac3", "alac", "pcm_s16le", "pcm_s24le"},
+	"webm":     {"vp8", "vp9", "av1", "opus", "vorbis"},
+	"ts":       {"h264", "hevc", "mpeg2video", "aac", "mp3", "mp2", "ac3", "eac3"},
+	"flv":      {"h264", "aac", "mp3"},
+}
+
+// containerAliases are the formats and extensions of the containers of containerCodecs
+var containerAliases = map[string]string{
+	"m4v": "mp4", "m4a": "mp4", "ismv": "mp4", "3gp": "mp4", "mpegts": "ts", "m2ts": "ts", "mts": "ts",
+	"mkv": "matroska", "mka": "matroska",
+}
+
+// encoderCodec returns the codec an encoder produces, such as h264 for libx264
+func encoderCodec(encoder string) string {
+	encoder = strings.ToLower(encoder)
+	switch {
+	case encoder == "libx264" || strings.HasPrefix(encoder, "h264"):
+		return "h264"
+	case encoder == "libx265" || strings.HasPrefix(encoder, "hevc"):
+		return "hevc"
+	case encoder == "libvpx":
+		return "vp8"
+	case encoder == "libvpx-vp9" || strings.HasPrefix(encoder, "vp9"):
+		return "vp9"
+	case encoder == "libaom-av1" || encoder == "libsvtav1" || encoder == "librav1e" || strings.HasPrefix(encoder, "av1"):
+		return "av1"
+	case encoder == "libxvid":
+		return "mpeg4"
+	case encoder == "libmp3lame" || strings.HasPrefix(encoder, "mp3"):
+		return "mp3"
+	case encoder == "libopus":
+		return "opus"
+	case encoder == "libvorbis":
+		return "vorbis"
+	case encoder == "libfdk_aac" || strings.HasPrefix(encoder, "aac"):
+		return "aac"
+	}
+	return encoder
+}
+
+// containerAccepts reports whether the container of output, or format when set,
+// copies codec
+func containerAccepts(output, format, codec string) bool {
+	container := strings.ToLower(format)
+	if len(container) == 0 {
+		container = strings.ToLower(strings.TrimPrefix(filepath.Ext(output), "."))
+	}
+	if alias, ok := containerAliases[container]; ok {
+		container = alias
+	}
+	for _, c := range containerCodecs[container] {
+		if c == codec {
+			return true
+		}
+	}
+	return false
+}
+
+// selectedStream returns the stream of kind ffmpeg maps without -map: the video of
+// the most pixels, the audio of the most channels, the first on ties
+func selectedStream(metadata transcoder.Metadata, kind string) transcoder.Streams {
+	var selected transcoder.Streams
+	score := -1
+	for _, s := range metadata.GetStreams() {
+		if s.GetCodecType() != kind {
+			continue
+		}
+		n := s.GetChannels()
+		if kind == "video" {
+			n = s.GetWidth() * s.GetHeight()
+		}
+		if n > score {
+			selected, score = s, n
+		}
+	}
+	return selected
+}
+
+// overBitrate reports whether the bitrate of s is unknown or above the requested one
+func overBitrate(s transcoder.Streams, requested int64) bool {
+	bitrate, _ := strconv.ParseInt(s.GetBitRate(), 10, 64)
+	return bitrate <= 0 || bitrate > requested
+}
+
+// videoReason returns why the video stream s is encoded with opts, empty when it can
+// be copied to output
+func videoReason(s transcoder.Streams, opts Options, output string) string {
+	codec := s.GetCodecName()
+	switch {
+	case opts.VideoCodec != nil && encoderCodec(*opts.VideoCodec) != codec:
+		return "codec"
+	case !containerAccepts(output, strValue(opts.OutputFormat), codec):
+		return "container"
+	case opts.VideoFilter != nil:
+		return "filter"
+	case opts.Resolution != nil && *opts.Resolution != strconv.Itoa(s.GetWidth())+"x"+strconv.Itoa(s.GetHeight()):
+		return "resolution"
+	case opts.FrameRate != nil && !sameRate(s.GetRFrameRrate(), strconv.Itoa(*opts.FrameRate)):
+		return "frame rate"
+	case opts.PixFmt != nil && *opts.PixFmt != s.GetPixFmt():
+		return "pixel format"
+	case opts.VideoProfile != nil && !strings.EqualFold(*opts.VideoProfile, s.GetProfile()):
+		return "profile"
+	case opts.Crf != nil || opts.Qscale != nil:
+		return "quality"
+	case opts.VideoBitRate != nil && overBitrate(s, parseBitrate(*opts.VideoBitRate)):
+		return "bitrate"
+	case opts.VideoMaxBitRate != nil && overBitrate(s, int64(*opts.VideoMaxBitRate)):
+		return "bitrate"
+	case opts.SeekTime != nil:
+		// a copy starts on the keyframe before
+		return "seek"
+	case opts.KeyframeInterval != nil || opts.Bframe != nil:
+		return "gop"
+	case opts.VideoLevel != nil:
+		return "level"
+	case opts.Aspect != nil:
+		return "aspect"
+	case opts.Target != nil:
+		return "target"
+	case opts.Vframes != nil:
+		return "frames"
+	case len(opts.ExtraArgs) > 0:
+		// extra arguments may apply to the encoder
+		return "extra arguments"
+	}
+	return ""
+}
+
+// audioReason returns why the audio stream s is encoded with opts, empty when it can
+// be copied to output
+func audioReason(s transcoder.Streams, opts Options, output string) string {
+	codec := s.GetCodecName()
+	switch {
+	case opts.AudioCodec != nil && encoderCodec(*opts.AudioCodec) != codec:
+		return "codec"
+	case !containerAccepts(output, strValue(opts.OutputFormat), codec):
+		return "container"
+	case opts.AudioFilter != nil:
+		return "filter"
+	case opts.AudioRate != nil && strconv.Itoa(*opts.AudioRate) != s.GetSampleRate():
+		return "sample rate"
+	case opts.AudioChannels != nil && *opts.AudioChannels != s.GetChannels():
+		return "channels"
+	case opts.AudioProfile != nil && !strings.EqualFold(*opts.AudioProfile, s.GetProfile()):
+		return "profile"
+	case opts.AudioVariableBitrate != nil:
+		return "quality"
+	case opts.AudioBitrate != nil && overBitrate(s, parseBitrate(*opts.AudioBitrate)):
+		return "bitrate"
+	}
+	return ""
+}
+
+// SmartCopy returns opts copying the streams of the source described by metadata
+// that output gets unchanged, a container only change needing no encode, and the
+// report of its choices. The streams are those ffmpeg maps without -map
+func SmartCopy(metadata transcoder.Metadata, opts Options, output string) (Options, SmartCopyReport) {
+	var report SmartCopyReport
+	copyCodec := "copy"
+	if !boolValue(opts.SkipVideo) && strValue(opts.VideoCodec) != copyCodec {
+		if s := selectedStream(metadata, "video"); s != nil {
+			reason := videoReason(s, opts, output)
+			report.Streams = append(report.Streams, StreamDecision{Index: s.GetIndex(), Type: "video", Codec: s.GetCodecName(), Copied: len(reason) == 0, Reason: reason})
+			if len(reason) == 0 {
+				opts.VideoCodec = &copyCodec
+			}
+		}
+	}
+	if !boolValue(opts.SkipAudio) && strValue(opts.AudioCodec) != copyCodec {
+		if s := selectedStream(metadata, "audio"); s != nil {
+			reason := audioReason(s, opts, output)
+			report.Streams = append(report.Streams, StreamDecision{Index: s.GetIndex(), Type: "audio", Codec: s.GetCodecName(), Copied: len(reason) == 0, Reason: reason})
+			if len(reason) == 0 {
+				opts.AudioCodec = &copyCodec
+			}
+		}
+	}
+	return opts, report
+}
+
+// WithSmartCopy copies the streams needing no encode, see SmartCopy, reporting the
+// choices to fn when it is not nil. It applies to the transcodes of a single output
+// with Options and a probed input
+func (t *Transcoder) WithSmartCopy(fn func(SmartCopyReport)) *Transcoder {
+	t.smartCopy = true
+	t.onSmartCopy = fn
+	return t
+}
+
+// applySmartCopy returns opts with the streams copied by SmartCopy
+func (t *Transcoder) applySmartCopy(opts transcoder.Options) transcoder.Options {
+	if !t.smartCopy || t.metadata == nil || len(t.output) != 1 || len(t.options) > 0 {
+		return opts
+	}
+	var o Options
+	switch v := opts.(type) {
+	case Options:
+		o = v
+	case *Options:
+		if v == nil {
+			return opts
+		}
+		o = *v
+	default:
+		return opts
+	}
+	o, report := SmartCopy(t.metadata, o, t.output[0])
+	if t.onSmartCopy != nil {
+		t.onSmartCopy(report)
+	}
+	return o
+}
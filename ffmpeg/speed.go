@@ -0,0 +1,99 @@
+package ffmpeg
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/admpub/transcoder"
+)
+
+// Health states reported by HealthProgress
+const (
+	// HealthDegraded is reported once the speed stayed under the SLO for SpeedSLO.For
+	HealthDegraded = "degraded"
+	// HealthRecovered is reported when the speed of a degraded transcode is back
+	HealthRecovered = "recovered"
+)
+
+// SpeedSLO is the speed a live transcode must sustain to keep up with its source
+type SpeedSLO struct {
+	// MinSpeed is the lowest speed reported by ffmpeg, defaults to 1.0 (realtime)
+	MinSpeed float64
+	// For is how long the speed stays under MinSpeed before the transcode is degraded,
+	// short drops being normal, defaults to 10s
+	For time.Duration
+	// OnHealth receives the health events, which are also sent on the progress channel
+	OnHealth func(HealthProgress)
+}
+
+// HealthProgress is sent on the progress channel when the health of a live transcode
+// changes. Since is when the speed fell under the SLO
+type HealthProgress struct {
+	Progress
+	State    string
+	Speed    float64
+	MinSpeed float64
+	Since    time.Time
+}
+
+// parseSpeed returns the speed reported by ffmpeg, such as 0.98x, -1 while it is
+// unknown (N/A)
+func parseSpeed(s string) float64 {
+	v, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "x"), 64)
+	if err != nil || v < 0 {
+		return -1
+	}
+	return v
+}
+
+// watchSpeed forwards in, adding a HealthProgress when the speed stays under the
+// SLO and when it recovers
+func watchSpeed(in <-chan transcoder.Progress, slo SpeedSLO, log transcoder.Logger) <-chan transcoder.Progress {
+	if slo.MinSpeed <= 0 {
+		slo.MinSpeed = 1
+	}
+	if slo.For <= 0 {
+		slo.For = 10 * time.Second
+	}
+	out := make(chan transcoder.Progress)
+	go func() {
+		defer close(out)
+		var below time.Time
+		degraded := false
+		for msg := range in {
+			out <- msg
+			speed := parseSpeed(msg.GetSpeed())
+			if msg.GetError() != nil || speed < 0 {
+				continue
+			}
+			now := time.Now()
+			health := HealthProgress{Progress: toProgress(msg), Speed: speed, MinSpeed: slo.MinSpeed}
+			switch {
+			case speed < slo.MinSpeed:
+				if below.IsZero() {
+					below = now
+				}
+				if degraded || now.Sub(below) < slo.For {
+					continue
+				}
+				degraded = true
+				health.State, health.Since = HealthDegraded, below
+				log.Log(transcoder.LevelWarn, "encoder cannot keep up with the source", "speed", speed, "min_speed", slo.MinSpeed, "since", below)
+			case degraded:
+				degraded = false
+				health.State, health.Since = HealthRecovered, below
+				log.Log(transcoder.LevelInfo, "encoder keeps up with the source again", "speed", speed, "degraded_for", now.Sub(below).String())
+				below = time.Time{}
+			default:
+				below = time.Time{}
+				continue
+			}
+			if slo.OnHealth != nil {
+				slo.OnHealth(health)
+			}
+			out <- health
+		}
+	}()
+	return out
+}
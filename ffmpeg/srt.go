@@ -0,0 +1,133 @@
+package ffmpeg
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SRT connection modes
+const (
+	SRTCaller     = "caller"
+	SRTListener   = "listener"
+	SRTRendezvous = "rendezvous"
+)
+
+// SRTOptions are the options of an SRT input or output
+type SRTOptions struct {
+	// Mode defaults to SRTCaller
+	Mode string
+	// Latency is the receiver buffering latency, the main knob trading delay for resilience
+	Latency time.Duration
+	// Passphrase enables AES encryption, 10 to 79 characters. It is masked in logs
+	Passphrase string
+	// KeyLength is the AES key length in bytes: 16, 24 or 32
+	KeyLength int
+	// StreamID selects the stream on multiplexing servers
+	StreamID string
+	// ConnectTimeout for caller mode
+	ConnectTimeout time.Duration
+	// Timeout drops the connection when no data arrives for this long
+	Timeout time.Duration
+	// PayloadSize defaults to 1316 (7 MPEG-TS packets)
+	PayloadSize int
+	// MaxBandwidth in bytes per second, 0 lets SRT decide
+	MaxBandwidth int64
+}
+
+// validate ...
+func (o SRTOptions) validate() error {
+	switch o.Mode {
+	case "", SRTCaller, SRTListener, SRTRendezvous:
+	default:
+		return fmt.Errorf("invalid SRT mode %q", o.Mode)
+	}
+	if n := len(o.Passphrase); n > 0 && (n < 10 || n > 79) {
+		return errors.New("SRT passphrase must be 10 to 79 characters")
+	}
+	switch o.KeyLength {
+	case 0, 16, 24, 32:
+	default:
+		return fmt.Errorf("invalid SRT key length %d", o.KeyLength)
+	}
+	return nil
+}
+
+// URL returns the srt:// URL of address (host:port, the host may be empty in listener mode)
+func (o SRTOptions) URL(address string) (string, error) {
+	if err := o.validate(); err != nil {
+		return "", err
+	}
+	query := url.Values{}
+	if len(o.Mode) > 0 {
+		query.Set("mode", o.Mode)
+	}
+	// ffmpeg expects microseconds for latency and timeout, milliseconds for connect_timeout
+	if o.Latency > 0 {
+		query.Set("latency", strconv.FormatInt(o.Latency.Microseconds(), 10))
+	}
+	if o.Timeout > 0 {
+		query.Set("timeout", strconv.FormatInt(o.Timeout.Microseconds(), 10))
+	}
+	if o.ConnectTimeout > 0 {
+		query.Set("connect_timeout", strconv.FormatInt(o.ConnectTimeout.Milliseconds(), 10))
+	}
+	if len(o.Passphrase) > 0 {
+		query.Set("passphrase", o.Passphrase)
+	}
+	if o.KeyLength > 0 {
+		query.Set("pbkeylen", strconv.Itoa(o.KeyLength))
+	}
+	if len(o.StreamID) > 0 {
+		query.Set("streamid", o.StreamID)
+	}
+	if o.PayloadSize > 0 {
+		query.Set("payload_size", strconv.Itoa(o.PayloadSize))
+	}
+	if o.MaxBandwidth > 0 {
+		query.Set("maxbw", strconv.FormatInt(o.MaxBandwidth, 10))
+	}
+	u := "srt://" + strings.TrimPrefix(address, "srt://")
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u, nil
+}
+
+// secrets returns the values to mask in logs, both raw and URL encoded
+func (o SRTOptions) secrets() []string {
+	if len(o.Passphrase) == 0 {
+		return nil
+	}
+	return []string{o.Passphrase, url.QueryEscape(o.Passphrase)}
+}
+
+// InputSRT sets an SRT input. Listener inputs are not probed before starting,
+// since the caller would be accepted by ffprobe instead of ffmpeg
+func (t *Transcoder) InputSRT(address string, opts SRTOptions) (*Transcoder, error) {
+	u, err := opts.URL(address)
+	if err != nil {
+		return t, err
+	}
+	t.Input(u)
+	t.WithSecrets(opts.secrets()...)
+	if opts.Mode == SRTListener {
+		t.WithoutProbe()
+	}
+	return t, nil
+}
+
+// OutputSRT appends an SRT output. SRT carries MPEG-TS, so the options of this
+// output should set OutputFormat to "mpegts"
+func (t *Transcoder) OutputSRT(address string, opts SRTOptions) (*Transcoder, error) {
+	u, err := opts.URL(address)
+	if err != nil {
+		return t, err
+	}
+	t.Output(u)
+	t.WithSecrets(opts.secrets()...)
+	return t, nil
+}
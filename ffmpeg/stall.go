@@ -0,0 +1,70 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/admpub/transcoder"
+	"github.com/admpub/transcoder/utils"
+)
+
+// ErrStalled is returned when a process stops making progress, e.g. a live source went stale
+var ErrStalled = errors.New("transcoding stalled")
+
+// watchStall forwards in, calling cancel when the reported time does not advance
+// for timeout. The error of the killed process is then replaced by ErrStalled
+func watchStall(in <-chan transcoder.Progress, timeout time.Duration, cancel context.CancelFunc) <-chan transcoder.Progress {
+	out := make(chan transcoder.Progress)
+	go func() {
+		defer close(out)
+		defer cancel()
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		var last float64 = -1
+		stalled := false
+		for {
+			select {
+			case msg, ok := <-in:
+				if !ok {
+					if stalled {
+						out <- Progress{Error: fmt.Errorf("%w: no progress for %s", ErrStalled, timeout)}
+					}
+					return
+				}
+				if msg.GetError() != nil {
+					if !stalled {
+						out <- msg
+					}
+					continue
+				}
+				if t := parseProgressTime(msg.GetCurrentTime()); t > last {
+					last = t
+					if !timer.Stop() {
+						select {
+						case <-timer.C:
+						default:
+						}
+					}
+					timer.Reset(timeout)
+				}
+				out <- msg
+			case <-timer.C:
+				if !stalled {
+					stalled = true
+					cancel()
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// parseProgressTime returns the time reported by ffmpeg, -1 while it is unknown (N/A)
+func parseProgressTime(s string) float64 {
+	if len(s) == 0 || s == "N/A" || s[0] == '-' {
+		return -1
+	}
+	return utils.DurToSec(s)
+}
@@ -0,0 +1,59 @@
+package ffmpeg
+
+import "strconv"
+
+// videoStreamFlags maps encoding flags of Options to their video stream specifier form
+var videoStreamFlags = map[string]string{
+	"-c:v":       "-c:v",
+	"-b:v":       "-b:v",
+	"-s":         "-s:v",
+	"-aspect":    "-aspect:v",
+	"-r":         "-r:v",
+	"-maxrate":   "-maxrate:v",
+	"-minrate":   "-minrate:v",
+	"-bufsize":   "-bufsize:v",
+	"-bt":        "-bt:v",
+	"-g":         "-g:v",
+	"-profile:v": "-profile:v",
+	"-level:v":   "-level:v",
+	"-preset":    "-preset:v",
+	"-tune":      "-tune:v",
+	"-crf":       "-crf:v",
+	"-qscale":    "-qscale:v",
+	"-pix_fmt":   "-pix_fmt:v",
+	"-bf":        "-bf:v",
+	"-vf":        "-filter:v",
+	"-vframes":   "-frames:v",
+}
+
+// audioStreamFlags maps encoding flags of Options to their audio stream specifier form
+var audioStreamFlags = map[string]string{
+	"-c:a":       "-c:a",
+	"-ab":        "-b:a",
+	"-ar":        "-ar:a",
+	"-ac":        "-ac:a",
+	"-profile:a": "-profile:a",
+	"-af":        "-filter:a",
+}
+
+// streamArguments returns the encoding flags of opts bound to the index-th output stream
+// of the given kind ('v' or 'a'), e.g. -b:v becomes -b:v:2. Flags that do not apply to
+// the stream kind are dropped, so one Options value can describe a single stream of a
+// multi stream output
+func streamArguments(opts Options, kind byte, index int) []string {
+	table := videoStreamFlags
+	if kind == 'a' {
+		table = audioStreamFlags
+	}
+	suffix := ":" + strconv.Itoa(index)
+
+	values := []string{}
+	for _, arg := range opts.arguments() {
+		flag, ok := table[arg.flag]
+		if !ok || !arg.hasValue {
+			continue
+		}
+		values = append(values, flag+suffix, arg.value)
+	}
+	return values
+}
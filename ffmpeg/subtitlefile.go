@@ -0,0 +1,235 @@
+package ffmpeg
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Subtitle file formats
+const (
+	SubtitleSRT = "srt"
+	SubtitleVTT = "vtt"
+)
+
+// SubtitleCue is a timed text of a subtitle file
+type SubtitleCue struct {
+	ID    string
+	Start time.Duration
+	End   time.Duration
+	// Settings are the WebVTT cue settings (position, align...)
+	Settings string
+	Text     string
+}
+
+// SubtitleFile is a parsed SRT or WebVTT file
+type SubtitleFile struct {
+	Format string
+	// Header holds the WebVTT header and the STYLE, REGION and NOTE blocks before the first cue
+	Header string
+	Cues   []SubtitleCue
+}
+
+// parseSubtitleTime parses a [hh:]mm:ss,mmm or [hh:]mm:ss.mmm timestamp
+func parseSubtitleTime(s string) (time.Duration, error) {
+	s = strings.Replace(strings.TrimSpace(s), ",", ".", 1)
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid subtitle timestamp %q", s)
+	}
+	var d time.Duration
+	for _, p := range parts[:len(parts)-1] {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return 0, fmt.Errorf("invalid subtitle timestamp %q", s)
+		}
+		d = d*60 + time.Duration(n)
+	}
+	sec, err := strconv.ParseFloat(parts[len(parts)-1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid subtitle timestamp %q", s)
+	}
+	return d*60*time.Second + time.Duration(sec*1000+0.5)*time.Millisecond, nil
+}
+
+// formatSubtitleTime formats t as hh:mm:ss followed by sep and the milliseconds
+func formatSubtitleTime(t time.Duration, sep string) string {
+	if t < 0 {
+		t = 0
+	}
+	ms := t.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", ms/3600000, ms/60000%60, ms/1000%60, sep, ms%1000)
+}
+
+// ParseSubtitles parses an SRT or WebVTT file, the format being detected from its header
+func ParseSubtitles(r io.Reader) (*SubtitleFile, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	text := strings.TrimPrefix(string(data), "\ufeff")
+	text = strings.Replace(text, "\r\n", "\n", -1)
+	text = strings.Replace(text, "\r", "\n", -1)
+
+	f := &SubtitleFile{Format: SubtitleSRT}
+	if strings.HasPrefix(text, "WEBVTT") {
+		f.Format = SubtitleVTT
+	}
+	var header []string
+	for _, block := range strings.Split(text, "\n\n") {
+		block = strings.Trim(block, "\n")
+		if len(block) == 0 {
+			continue
+		}
+		lines := strings.Split(block, "\n")
+		timing := -1
+		for i, line := range lines {
+			if strings.Contains(line, "-->") {
+				timing = i
+				break
+			}
+		}
+		if timing < 0 {
+			// the WebVTT header and metadata blocks, kept when they come first
+			if len(f.Cues) == 0 && f.Format == SubtitleVTT {
+				header = append(header, block)
+			}
+			continue
+		}
+		cue := SubtitleCue{ID: strings.Join(lines[:timing], "\n"), Text: strings.Join(lines[timing+1:], "\n")}
+		arrow := strings.Index(lines[timing], "-->")
+		if cue.Start, err = parseSubtitleTime(lines[timing][:arrow]); err != nil {
+			return nil, err
+		}
+		end := strings.Fields(lines[timing][arrow+3:])
+		if len(end) == 0 {
+			return nil, fmt.Errorf("missing end time in %q", lines[timing])
+		}
+		if cue.End, err = parseSubtitleTime(end[0]); err != nil {
+			return nil, err
+		}
+		cue.Settings = strings.Join(end[1:], " ")
+		f.Cues = append(f.Cues, cue)
+	}
+	f.Header = strings.Join(header, "\n\n")
+	if len(f.Cues) == 0 && f.Format == SubtitleSRT {
+		return nil, errors.New("no subtitle cue found")
+	}
+	return f, nil
+}
+
+// ReadSubtitleFile parses the SRT or WebVTT file at path
+func ReadSubtitleFile(path string) (*SubtitleFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseSubtitles(file)
+}
+
+// Shift moves every cue by offset, cues ending before the start are dropped
+func (f *SubtitleFile) Shift(offset time.Duration) {
+	cues := f.Cues[:0]
+	for _, c := range f.Cues {
+		c.Start += offset
+		c.End += offset
+		if c.End <= 0 {
+			continue
+		}
+		if c.Start < 0 {
+			c.Start = 0
+		}
+		cues = append(cues, c)
+	}
+	f.Cues = cues
+}
+
+// Scale multiplies every timestamp by factor, the ratio of the source frame rate to
+// the target one: e.g. 25/23.976 for subtitles timed for a 25 fps (PAL sped up)
+// release applied to the 23.976 fps one, which plays longer, and 23.976/25 the reverse
+func (f *SubtitleFile) Scale(factor float64) {
+	for i := range f.Cues {
+		f.Cues[i].Start = time.Duration(float64(f.Cues[i].Start) * factor)
+		f.Cues[i].End = time.Duration(float64(f.Cues[i].End) * factor)
+	}
+}
+
+// Write writes the cues in format (SubtitleSRT or SubtitleVTT), empty keeping the parsed format
+func (f *SubtitleFile) Write(w io.Writer, format string) error {
+	if len(format) == 0 {
+		format = f.Format
+	}
+	b := bufio.NewWriter(w)
+	switch format {
+	case SubtitleSRT:
+		for i, c := range f.Cues {
+			fmt.Fprintf(b, "%d\n%s --> %s\n%s\n\n", i+1, formatSubtitleTime(c.Start, ","), formatSubtitleTime(c.End, ","), c.Text)
+		}
+	case SubtitleVTT:
+		header := f.Header
+		if len(header) == 0 || f.Format != SubtitleVTT {
+			header = "WEBVTT"
+		}
+		b.WriteString(header + "\n\n")
+		for _, c := range f.Cues {
+			// SRT numbers are not kept as WebVTT identifiers
+			if len(c.ID) > 0 && f.Format == SubtitleVTT {
+				b.WriteString(c.ID + "\n")
+			}
+			b.WriteString(formatSubtitleTime(c.Start, ".") + " --> " + formatSubtitleTime(c.End, "."))
+			if len(c.Settings) > 0 {
+				b.WriteString(" " + c.Settings)
+			}
+			b.WriteString("\n" + c.Text + "\n\n")
+		}
+	default:
+		return fmt.Errorf("unsupported subtitle format %q", format)
+	}
+	return b.Flush()
+}
+
+// WriteFile writes the cues to path in the format of its extension
+func (f *SubtitleFile) WriteFile(path string) error {
+	format := strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := f.Write(file, format); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// ShiftSubtitles writes the subtitle file input to output with its timing moved by offset
+func ShiftSubtitles(input, output string, offset time.Duration) error {
+	f, err := ReadSubtitleFile(input)
+	if err != nil {
+		return err
+	}
+	f.Shift(offset)
+	return f.WriteFile(output)
+}
+
+// ScaleSubtitleTiming writes the subtitle file input to output with its timestamps multiplied by factor,
+// 25/23.976 retiming subtitles from 25 to 23.976 fps, see SubtitleFile.Scale
+func ScaleSubtitleTiming(input, output string, factor float64) error {
+	if factor <= 0 {
+		return errors.New("timing factor must be positive")
+	}
+	f, err := ReadSubtitleFile(input)
+	if err != nil {
+		return err
+	}
+	f.Scale(factor)
+	return f.WriteFile(output)
+}
@@ -0,0 +1,200 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// SubtitleStyle overrides the style of burned subtitles, zero values keep the style
+// of the subtitles (or the libass defaults for SRT)
+type SubtitleStyle struct {
+	FontName string
+	FontSize int
+	// PrimaryColor and OutlineColor are #RRGGBB, #RRGGBBAA or ASS &HAABBGGRR colors
+	PrimaryColor string
+	OutlineColor string
+	BackColor    string
+	Bold         bool
+	Italic       bool
+	// Outline and Shadow are thicknesses in pixels
+	Outline int
+	Shadow  int
+	// BorderStyle 3 draws an opaque box behind the text
+	BorderStyle int
+	// Alignment uses the numpad layout, 2 being bottom center
+	Alignment int
+	MarginV   int
+}
+
+// BurnSubtitlesOptions configures BurnSubtitles
+type BurnSubtitlesOptions struct {
+	// File is a subtitle file (srt, vtt, ass...), when empty the subtitle stream
+	// Stream (0 based among subtitle streams) of the input is burned
+	File   string
+	Stream int
+	Style  SubtitleStyle
+	// FontsDir holds fonts used by the subtitles in addition to the system fonts
+	FontsDir string
+	// Charenc is the character encoding of File, for non UTF-8 SRT files
+	Charenc string
+	// Options are the encoding options, their VideoFilter runs before text subtitles
+	// and after bitmap ones. Empty options encode with libx264 and copy the audio
+	Options Options
+}
+
+// assColor converts a #RRGGBB or #RRGGBBAA color to the ASS &HAABBGGRR format,
+// where the alpha is a transparency
+func assColor(c string) string {
+	if !strings.HasPrefix(c, "#") || (len(c) != 7 && len(c) != 9) {
+		return c
+	}
+	alpha := "00"
+	if len(c) == 9 {
+		a, err := strconv.ParseUint(c[7:9], 16, 8)
+		if err != nil {
+			return c
+		}
+		alpha = fmt.Sprintf("%02X", 255-a)
+	}
+	return strings.ToUpper("&H" + alpha + c[5:7] + c[3:5] + c[1:3])
+}
+
+// forceStyle returns the force_style value of the style, empty when nothing is overridden
+func (s SubtitleStyle) forceStyle() string {
+	var fields []string
+	add := func(name, value string) {
+		if len(value) > 0 {
+			fields = append(fields, name+"="+value)
+		}
+	}
+	num := func(n int) string {
+		if n <= 0 {
+			return ""
+		}
+		return strconv.Itoa(n)
+	}
+	add("FontName", s.FontName)
+	add("FontSize", num(s.FontSize))
+	if len(s.PrimaryColor) > 0 {
+		add("PrimaryColour", assColor(s.PrimaryColor))
+	}
+	if len(s.OutlineColor) > 0 {
+		add("OutlineColour", assColor(s.OutlineColor))
+	}
+	if len(s.BackColor) > 0 {
+		add("BackColour", assColor(s.BackColor))
+	}
+	if s.Bold {
+		add("Bold", "1")
+	}
+	if s.Italic {
+		add("Italic", "1")
+	}
+	add("Outline", num(s.Outline))
+	add("Shadow", num(s.Shadow))
+	add("BorderStyle", num(s.BorderStyle))
+	add("Alignment", num(s.Alignment))
+	add("MarginV", num(s.MarginV))
+	return strings.Join(fields, ",")
+}
+
+// subtitlesFilter returns the filter rendering the text subtitles of opts over the video of input
+func subtitlesFilter(input string, opts BurnSubtitlesOptions) string {
+	style := opts.Style.forceStyle()
+	file := opts.File
+	ext := strings.ToLower(filepath.Ext(file))
+	var filter string
+	if len(file) > 0 && (ext == ".ass" || ext == ".ssa") && len(style) == 0 && len(opts.Charenc) == 0 {
+		// the ass filter keeps the exact rendering of the ASS script
+		filter = "ass=filename=" + filterPath(file)
+	} else if len(file) > 0 {
+		filter = "subtitles=filename=" + filterPath(file)
+	} else {
+		filter = "subtitles=filename=" + filterPath(input) + ":si=" + strconv.Itoa(opts.Stream)
+	}
+	if len(opts.FontsDir) > 0 {
+		filter += ":fontsdir=" + filterPath(opts.FontsDir)
+	}
+	if len(opts.Charenc) > 0 && len(file) > 0 {
+		filter += ":charenc=" + filterQuote(opts.Charenc)
+	}
+	if len(style) > 0 {
+		filter += ":force_style=" + filterQuote(style)
+	}
+	return filter
+}
+
+// bitmapSubtitles reports whether the subtitles burned by opts are image based
+func bitmapSubtitles(ctx context.Context, cfg *Config, input string, opts BurnSubtitlesOptions) (bool, error) {
+	if len(opts.File) > 0 {
+		// PGS and VobSub (read through its .idx file)
+		switch strings.ToLower(filepath.Ext(opts.File)) {
+		case ".sup", ".idx":
+			return true, nil
+		}
+		return false, nil
+	}
+	streams, err := SubtitleStreams(ctx, cfg, input)
+	if err != nil {
+		return false, err
+	}
+	for _, s := range streams {
+		if s.Position == opts.Stream {
+			return s.Bitmap, nil
+		}
+	}
+	return false, fmt.Errorf("no subtitle stream %d in %s", opts.Stream, input)
+}
+
+// BurnSubtitles writes input to output with subtitles rendered into the video, from a
+// subtitle file or a subtitle stream of the input. Bitmap subtitles (PGS, VobSub, DVB)
+// are scaled to the video and overlaid, Style, FontsDir and Charenc then do not apply
+func BurnSubtitles(ctx context.Context, cfg *Config, input, output string, opts BurnSubtitlesOptions) error {
+	if len(output) == 0 {
+		return errors.New("missing output option")
+	}
+	if len(opts.File) == 0 && opts.Stream < 0 {
+		return fmt.Errorf("invalid subtitle stream %d", opts.Stream)
+	}
+	bitmap, err := bitmapSubtitles(ctx, cfg, input, opts)
+	if err != nil {
+		return err
+	}
+	encoding := opts.Options
+	args := []string{"-y", "-i", input}
+	if bitmap {
+		sub := "0:s:" + strconv.Itoa(opts.Stream)
+		if len(opts.File) > 0 {
+			args = append(args, "-i", opts.File)
+			sub = "1:s:0"
+		}
+		// the subtitle canvas is scaled to the video, the video goes on after the last subtitle
+		graph := "[" + sub + "][0:v:0]scale2ref[sub][video];[video][sub]overlay=eof_action=pass"
+		if encoding.VideoFilter != nil && len(*encoding.VideoFilter) > 0 {
+			graph += "," + *encoding.VideoFilter
+		}
+		encoding.VideoFilter = nil
+		args = append(args, "-filter_complex", graph+"[out]", "-map", "[out]")
+	} else {
+		filter := subtitlesFilter(input, opts)
+		if encoding.VideoFilter != nil && len(*encoding.VideoFilter) > 0 {
+			filter = *encoding.VideoFilter + "," + filter
+		}
+		encoding.VideoFilter = &filter
+		args = append(args, "-map", "0:v:0")
+	}
+	args = append(args, "-map", "0:a?", "-sn")
+	if encoding.VideoCodec == nil {
+		args = append(args, "-c:v", "libx264", "-crf", "20", "-preset", "medium")
+	}
+	if encoding.AudioCodec == nil {
+		args = append(args, "-c:a", "copy")
+	}
+	args = append(args, encoding.GetStrArguments()...)
+	_, err = run(ctx, cfg, append(args, output)...)
+	return err
+}
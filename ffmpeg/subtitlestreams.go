@@ -0,0 +1,140 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ErrBitmapSubtitle is reported for image based subtitles (PGS, VobSub, DVB), which
+// cannot be converted to a text format without OCR
+var ErrBitmapSubtitle = errors.New("bitmap subtitles cannot be converted to text")
+
+// bitmapSubtitleCodecs are the image based subtitle codecs
+var bitmapSubtitleCodecs = map[string]bool{
+	"hdmv_pgs_subtitle": true,
+	"dvd_subtitle":      true,
+	"dvb_subtitle":      true,
+	"xsub":              true,
+	"dvb_teletext":      true,
+}
+
+// subtitleFormats maps the requested formats to their encoder and file extension
+var subtitleFormats = map[string][2]string{
+	"srt":    {"srt", "srt"},
+	"vtt":    {"webvtt", "vtt"},
+	"webvtt": {"webvtt", "vtt"},
+	"ass":    {"ass", "ass"},
+	"ssa":    {"ass", "ass"},
+}
+
+// SubtitleStream describes a subtitle stream of an input
+type SubtitleStream struct {
+	// Index of the stream in the input
+	Index int
+	// Position among the subtitle streams, as in the 0:s:N stream specifier
+	Position int
+	Codec    string
+	Language string
+	Title    string
+	Default  bool
+	Forced   bool
+	// Bitmap is set for image based subtitles
+	Bitmap bool
+}
+
+// ExtractedSubtitle is a subtitle stream written to its own file, Err is set
+// when the stream could not be converted
+type ExtractedSubtitle struct {
+	SubtitleStream
+	Path string
+	Err  error
+}
+
+// SubtitleStreams lists the subtitle streams of input
+func SubtitleStreams(ctx context.Context, cfg *Config, input string) ([]SubtitleStream, error) {
+	metadata, err := probe(ctx, cfg, input)
+	if err != nil {
+		return nil, err
+	}
+	var streams []SubtitleStream
+	for _, s := range metadata.GetStreams() {
+		if s.GetCodecType() != "subtitle" {
+			continue
+		}
+		stream := SubtitleStream{
+			Index:    s.GetIndex(),
+			Position: len(streams),
+			Codec:    s.GetCodecName(),
+			Language: s.GetTags()["language"],
+			Title:    s.GetTags()["title"],
+			Bitmap:   bitmapSubtitleCodecs[s.GetCodecName()],
+		}
+		if d := s.GetDisposition(); d != nil {
+			stream.Default = d.GetDefault() == 1
+			stream.Forced = d.GetForced() == 1
+		}
+		streams = append(streams, stream)
+	}
+	return streams, nil
+}
+
+// ExtractSubtitles writes each text subtitle stream of input to its own file in dir,
+// converted to format (srt, vtt or ass). Bitmap streams are listed with ErrBitmapSubtitle
+func ExtractSubtitles(ctx context.Context, cfg *Config, input, dir, format string) ([]ExtractedSubtitle, error) {
+	if len(dir) == 0 {
+		return nil, errors.New("missing subtitles output directory")
+	}
+	if len(format) == 0 {
+		format = "srt"
+	}
+	f, ok := subtitleFormats[strings.ToLower(format)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported subtitle format %q", format)
+	}
+	streams, err := SubtitleStreams(ctx, cfg, input)
+	if err != nil {
+		return nil, err
+	}
+	if len(streams) == 0 {
+		return nil, fmt.Errorf("no subtitle stream in %s", input)
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	base := strings.TrimSuffix(filepath.Base(input), filepath.Ext(input))
+	args := []string{"-y", "-i", input}
+	var extracted []ExtractedSubtitle
+	converted := 0
+	for _, s := range streams {
+		e := ExtractedSubtitle{SubtitleStream: s}
+		if s.Bitmap {
+			e.Err = fmt.Errorf("subtitle stream %d (%s): %w", s.Index, s.Codec, ErrBitmapSubtitle)
+			extracted = append(extracted, e)
+			continue
+		}
+		name := base + "_sub" + strconv.Itoa(s.Position+1)
+		if len(s.Language) > 0 && s.Language != "und" {
+			name += "_" + s.Language
+		}
+		if s.Forced {
+			name += ".forced"
+		}
+		e.Path = filepath.Join(dir, name+"."+f[1])
+		args = append(args, "-map", "0:"+strconv.Itoa(s.Index), "-c:s", f[0], e.Path)
+		extracted = append(extracted, e)
+		converted++
+	}
+	if converted == 0 {
+		return extracted, fmt.Errorf("no text subtitle stream in %s: %w", input, ErrBitmapSubtitle)
+	}
+	if _, err := run(ctx, cfg, args...); err != nil {
+		return nil, err
+	}
+	return extracted, nil
+}
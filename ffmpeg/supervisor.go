@@ -0,0 +1,130 @@
+package ffmpeg
+
+import (
+	"context"
+	"time"
+
+	"github.com/admpub/transcoder"
+)
+
+// Connection states reported by live outputs
+const (
+	StateConnecting   = "connecting"
+	StateConnected    = "connected"
+	StateDisconnected = "disconnected"
+	StateStopped      = "stopped"
+)
+
+// ConnectionProgress is sent on the progress channel of live outputs when the
+// connection state changes. Cause is the error that ended the previous attempt,
+// reconnections are not reported through GetError
+type ConnectionProgress struct {
+	Progress
+	State   string
+	Attempt int
+	Cause   error
+}
+
+// Backoff configures reconnection delays
+type Backoff struct {
+	// Initial delay, defaults to 1s
+	Initial time.Duration
+	// Max delay, defaults to 30s
+	Max time.Duration
+	// Multiplier applied after each failed attempt, defaults to 2
+	Multiplier float64
+	// MaxAttempts gives up after this many consecutive failures, 0 retries forever
+	MaxAttempts int
+	// ResetAfter resets the delay once a connection stayed up this long, defaults to 30s
+	ResetAfter time.Duration
+}
+
+func (b Backoff) withDefaults() Backoff {
+	if b.Initial <= 0 {
+		b.Initial = time.Second
+	}
+	if b.Max <= 0 {
+		b.Max = 30 * time.Second
+	}
+	if b.Multiplier < 1 {
+		b.Multiplier = 2
+	}
+	if b.ResetAfter <= 0 {
+		b.ResetAfter = 30 * time.Second
+	}
+	return b
+}
+
+// attemptFunc starts one attempt of a supervised process
+type attemptFunc func(ctx context.Context, attempt int) (<-chan transcoder.Progress, error)
+
+// supervise restarts the process started by start until ctx is done or the backoff
+// gives up, forwarding its progress and reporting connection state changes on out.
+// onProgress (when not nil) sees every progress message of the running attempt
+func supervise(ctx context.Context, backoff Backoff, start attemptFunc, out chan<- transcoder.Progress, onProgress func(transcoder.Progress)) error {
+	backoff = backoff.withDefaults()
+	delay := backoff.Initial
+	failures := 0
+
+	send := func(msg transcoder.Progress) {
+		select {
+		case out <- msg:
+		case <-ctx.Done():
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		send(ConnectionProgress{State: StateConnecting, Attempt: attempt})
+		began := time.Now()
+		connected := false
+
+		ch, err := start(ctx, attempt)
+		if err == nil {
+			for msg := range ch {
+				if e := msg.GetError(); e != nil {
+					err = e
+					continue
+				}
+				if !connected {
+					connected = true
+					send(ConnectionProgress{State: StateConnected, Attempt: attempt})
+				}
+				if onProgress != nil {
+					onProgress(msg)
+				}
+				send(msg)
+			}
+		}
+
+		if ctx.Err() != nil {
+			send(ConnectionProgress{State: StateStopped, Attempt: attempt})
+			return nil
+		}
+		if err == nil {
+			// the input ended (e.g. a file source was fully pushed)
+			send(ConnectionProgress{State: StateStopped, Attempt: attempt})
+			return nil
+		}
+
+		if connected && time.Since(began) >= backoff.ResetAfter {
+			delay = backoff.Initial
+			failures = 0
+		}
+		failures++
+		send(ConnectionProgress{State: StateDisconnected, Attempt: attempt, Cause: err})
+		if backoff.MaxAttempts > 0 && failures >= backoff.MaxAttempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			send(ConnectionProgress{State: StateStopped, Attempt: attempt})
+			return nil
+		case <-time.After(delay):
+		}
+		delay = time.Duration(float64(delay) * backoff.Multiplier)
+		if delay > backoff.Max {
+			delay = backoff.Max
+		}
+	}
+}
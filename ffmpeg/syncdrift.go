@@ -0,0 +1,138 @@
+package ffmpeg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// packet is a demuxed packet as listed by ffprobe, times in seconds
+type packet struct {
+	PTS      float64
+	Duration float64
+	Size     int
+	Key      bool
+}
+
+// probePackets returns the packets of stream (a stream specifier such as "a:0") of input in decoding order,
+// args being additional ffprobe options such as -read_intervals
+func probePackets(ctx context.Context, cfg *Config, input, stream string, args ...string) ([]packet, error) {
+	out, err := probeEntries(ctx, cfg, input, append([]string{"-select_streams", stream,
+		"-show_entries", "packet=pts_time,duration_time,size,flags", "-of", "compact=p=0"}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	var packets []packet
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		p := packet{PTS: math.NaN()}
+		for _, field := range strings.Split(scanner.Text(), "|") {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			switch kv[0] {
+			case "pts_time":
+				if v, err := strconv.ParseFloat(kv[1], 64); err == nil {
+					p.PTS = v
+				}
+			case "duration_time":
+				p.Duration, _ = strconv.ParseFloat(kv[1], 64)
+			case "size":
+				p.Size, _ = strconv.Atoi(kv[1])
+			case "flags":
+				p.Key = strings.HasPrefix(kv[1], "K")
+			}
+		}
+		if !math.IsNaN(p.PTS) {
+			packets = append(packets, p)
+		}
+	}
+	return packets, scanner.Err()
+}
+
+// SyncDriftOptions configures MeasureSyncDrift
+type SyncDriftOptions struct {
+	// Interval between drift samples, defaults to 10s
+	Interval time.Duration
+	// Threshold is the drift over which the sync is degraded, defaults to 40ms
+	Threshold time.Duration
+}
+
+// SyncSample is the drift measured at a time of the input
+type SyncSample struct {
+	Time  time.Duration `json:"time"`
+	Drift time.Duration `json:"drift"`
+}
+
+// SyncDrift reports the audio/video sync of an input over time
+type SyncDrift struct {
+	// StartOffset is how late the first audio packet starts after the first video packet
+	StartOffset time.Duration `json:"start_offset"`
+	// EndOffset is how late the audio ends after the video
+	EndOffset time.Duration `json:"end_offset"`
+	Samples   []SyncSample  `json:"samples"`
+	// MaxDrift is the largest drift in absolute value
+	MaxDrift time.Duration `json:"max_drift"`
+	// Degraded is set when MaxDrift exceeds the threshold
+	Degraded bool `json:"degraded"`
+}
+
+// MeasureSyncDrift compares the timestamps of the first audio stream of input with the
+// duration of the audio actually carried before them. Players clock the video on the
+// audio they play, so audio timestamps running ahead of or behind the audio samples
+// (dropped or duplicated audio, wrong sample rate) shift the picture by the drift. A
+// positive drift means the audio plays late
+func MeasureSyncDrift(ctx context.Context, cfg *Config, input string, opts SyncDriftOptions) (*SyncDrift, error) {
+	interval, threshold := opts.Interval, opts.Threshold
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	if threshold <= 0 {
+		threshold = 40 * time.Millisecond
+	}
+	video, err := probePackets(ctx, cfg, input, "v:0")
+	if err != nil {
+		return nil, err
+	}
+	audio, err := probePackets(ctx, cfg, input, "a:0")
+	if err != nil {
+		return nil, err
+	}
+	if len(video) == 0 || len(audio) == 0 {
+		return nil, errors.New("sync drift needs both an audio and a video stream")
+	}
+	// video packets are stored in decoding order
+	sort.Slice(video, func(i, j int) bool { return video[i].PTS < video[j].PTS })
+	last := video[len(video)-1]
+	videoStart, videoEnd := video[0].PTS, last.PTS+last.Duration
+
+	duration := func(s float64) time.Duration { return time.Duration(s * float64(time.Second)) }
+	drift := &SyncDrift{StartOffset: duration(audio[0].PTS - videoStart)}
+	carried := 0.0
+	next := 0.0
+	for _, p := range audio {
+		at := p.PTS - audio[0].PTS
+		if at >= next {
+			d := duration(carried - at)
+			drift.Samples = append(drift.Samples, SyncSample{Time: duration(p.PTS - videoStart), Drift: d})
+			if d < 0 {
+				d = -d
+			}
+			if d > drift.MaxDrift {
+				drift.MaxDrift = d
+			}
+			next += interval.Seconds()
+		}
+		carried += p.Duration
+	}
+	drift.EndOffset = duration(audio[0].PTS + carried - videoEnd)
+	drift.Degraded = drift.MaxDrift > threshold
+	return drift, nil
+}
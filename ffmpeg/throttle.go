@@ -0,0 +1,132 @@
+package ffmpeg
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Throttle caps the bandwidth of the outputs of a transcode, so that bulk encodes
+// writing to NFS or uploading don't saturate the production links. The outputs
+// copied from a pipe are throttled with NewThrottledWriter instead, whose limiter
+// may be shared to cap the total of several transcodes
+type Throttle struct {
+	// Rate is the bandwidth of the outputs in bytes per second. ffmpeg reads the
+	// input with -readrate, so that the outputs are written at about Rate from their
+	// estimated bitrate, the transcode being left unthrottled when it is unknown.
+	// Needs ffmpeg 5.0
+	Rate int64
+}
+
+// readRateArgs returns the -readrate input option writing the outputs of args at
+// about Rate
+func (th *Throttle) readRateArgs(args []string, t *Transcoder) []string {
+	if th == nil || th.Rate <= 0 {
+		return nil
+	}
+	for _, arg := range args {
+		if arg == "-re" || arg == "-readrate" {
+			return nil
+		}
+	}
+	bitrate := outputBitrate(args, t.metadata, len(t.output))
+	if bitrate <= 0 {
+		return nil
+	}
+	// the speed relative to realtime writing Rate bytes per second
+	speed := float64(th.Rate*8) / float64(bitrate)
+	return []string{"-readrate", strconv.FormatFloat(speed, 'f', 3, 64)}
+}
+
+// RateLimiter is a token bucket of bytes per second, shared by the writers of the
+// transcodes whose total bandwidth it caps
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a limiter of rate bytes per second, allowing bursts of
+// burst bytes. Both must be positive
+func NewRateLimiter(rate, burst int64) (*RateLimiter, error) {
+	if rate <= 0 {
+		return nil, fmt.Errorf("rate limiter rate must be positive, got %d", rate)
+	}
+	if burst <= 0 {
+		return nil, fmt.Errorf("rate limiter burst must be positive, got %d", burst)
+	}
+	return &RateLimiter{rate: float64(rate), burst: float64(burst), tokens: float64(burst), last: time.Now()}, nil
+}
+
+// WaitN waits until n bytes may be written
+func (l *RateLimiter) WaitN(ctx context.Context, n int) error {
+	for n > 0 {
+		chunk := n
+		if float64(chunk) > l.burst {
+			chunk = int(l.burst)
+		}
+		l.mu.Lock()
+		now := time.Now()
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+		l.last = now
+		l.tokens -= float64(chunk)
+		wait := time.Duration(-l.tokens / l.rate * float64(time.Second))
+		l.mu.Unlock()
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			}
+		}
+		n -= chunk
+	}
+	return nil
+}
+
+// throttledWriter ...
+type throttledWriter struct {
+	ctx     context.Context
+	w       io.Writer
+	limiter *RateLimiter
+}
+
+// NewThrottledWriter returns a writer writing to w at the rate of limiter, such as
+// that of a pipe:1 output copied to object storage
+func NewThrottledWriter(ctx context.Context, w io.Writer, limiter *RateLimiter) io.Writer {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return &throttledWriter{ctx: ctx, w: w, limiter: limiter}
+}
+
+// Write writes p by chunks of the burst of the limiter
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if float64(len(chunk)) > w.limiter.burst {
+			chunk = chunk[:int(w.limiter.burst)]
+		}
+		if err := w.limiter.WaitN(w.ctx, len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := w.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
@@ -0,0 +1,146 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Thumbnail selection modes
+const (
+	ThumbnailsInterval    = "interval"
+	ThumbnailsSceneChange = "scene"
+)
+
+// ThumbnailsOptions configures the extraction of a series of images
+type ThumbnailsOptions struct {
+	// Mode defaults to ThumbnailsInterval
+	Mode string
+	// Every is the interval between images, defaults to 10s
+	Every time.Duration
+	// Representative picks the most representative frame of each interval (thumbnail
+	// filter) rather than the first one, it decodes every frame and is slower
+	Representative bool
+	// SceneThreshold is the scene change score from 0 to 1, defaults to 0.4
+	SceneThreshold float64
+	// Dir receives the images
+	Dir string
+	// Pattern is the printf file name of the images, defaults to thumb_%04d.jpg.
+	// The extension selects the format
+	Pattern string
+	// Width and Height of the images, 0 follows the aspect ratio, both 0 keep the source size
+	Width  int
+	Height int
+	// Quality from 1 (worst) to 100 (best) for jpg and webp, defaults to 90
+	Quality int
+	// MaxCount stops after this many images, 0 extracts all of them
+	MaxCount int
+}
+
+// Thumbnail is an extracted image and the time of its frame
+type Thumbnail struct {
+	Time time.Duration
+	Path string
+}
+
+var rePtsTime = regexp.MustCompile(`Parsed_showinfo.*\sn:\s*(\d+).*\spts_time:(-?[\d.]+)`)
+
+// thumbnailsFilter returns the filter graph selecting the frames
+func thumbnailsFilter(opts ThumbnailsOptions, fps float64) string {
+	var filters []string
+	switch opts.Mode {
+	case ThumbnailsSceneChange:
+		threshold := opts.SceneThreshold
+		if threshold <= 0 || threshold >= 1 {
+			threshold = 0.4
+		}
+		filters = append(filters, fmt.Sprintf("select='gt(scene\\,%s)'", strconv.FormatFloat(threshold, 'f', -1, 64)))
+	default:
+		every := opts.Every
+		if every <= 0 {
+			every = 10 * time.Second
+		}
+		if opts.Representative && fps > 0 {
+			filters = append(filters, "thumbnail="+strconv.Itoa(int(math.Max(1, math.Round(every.Seconds()*fps)))))
+		} else {
+			// select keeps the real timestamps, unlike fps which resamples
+			filters = append(filters, fmt.Sprintf("select='isnan(prev_selected_t)+gte(t-prev_selected_t\\,%s)'", strconv.FormatFloat(every.Seconds(), 'f', -1, 64)))
+		}
+	}
+	if scale := screenshotScale(opts.Width, opts.Height); len(scale) > 0 {
+		filters = append(filters, scale)
+	}
+	return strings.Join(append(filters, "showinfo"), ",")
+}
+
+// Thumbnails extracts images at a regular interval or at scene changes and
+// returns them ordered by time
+func Thumbnails(ctx context.Context, cfg *Config, input string, opts ThumbnailsOptions) ([]Thumbnail, error) {
+	if len(opts.Dir) == 0 {
+		return nil, errors.New("missing thumbnails output directory")
+	}
+	switch opts.Mode {
+	case "", ThumbnailsInterval, ThumbnailsSceneChange:
+	default:
+		return nil, fmt.Errorf("invalid thumbnails mode %q", opts.Mode)
+	}
+	pattern := opts.Pattern
+	if len(pattern) == 0 {
+		pattern = "thumb_%04d.jpg"
+	}
+	format, err := ScreenshotOptions{Output: pattern}.format()
+	if err != nil {
+		return nil, err
+	}
+
+	var fps float64
+	if opts.Representative && opts.Mode != ThumbnailsSceneChange {
+		metadata, err := probe(ctx, cfg, input)
+		if err != nil {
+			return nil, err
+		}
+		if _, _, fps, _, err = sourceVideo(metadata); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(opts.Dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	// frames without a selected image must not be duplicated
+	vsync := []string{"-fps_mode", "vfr"}
+	if v, err := DetectVersion(ctx, cfg); err == nil && !v.AtLeast(5, 1) {
+		vsync = []string{"-vsync", "vfr"}
+	}
+	args := []string{"-y", "-i", input, "-an", "-sn", "-vf", thumbnailsFilter(opts, fps)}
+	args = append(args, vsync...)
+	if opts.MaxCount > 0 {
+		args = append(args, "-frames:v", strconv.Itoa(opts.MaxCount))
+	}
+	args = append(args, encoderArguments(format, opts.Quality)...)
+	args = append(args, "-f", "image2", "-start_number", "1", filepath.Join(opts.Dir, pattern))
+	stderr, err := run(ctx, cfg, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var thumbnails []Thumbnail
+	for _, m := range rePtsTime.FindAllSubmatch(stderr, -1) {
+		n, _ := strconv.Atoi(string(m[1]))
+		sec, _ := strconv.ParseFloat(string(m[2]), 64)
+		path := filepath.Join(opts.Dir, fmt.Sprintf(pattern, n+1))
+		if _, err := os.Stat(path); err != nil {
+			// showinfo may report a frame the muxer did not write (MaxCount)
+			continue
+		}
+		thumbnails = append(thumbnails, Thumbnail{Time: time.Duration(sec * float64(time.Second)), Path: path})
+	}
+	return thumbnails, nil
+}
@@ -0,0 +1,103 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+
+	"github.com/admpub/transcoder"
+)
+
+// startSpan starts a span when cfg has a tracer, the span is nil otherwise
+func startSpan(ctx context.Context, cfg *Config, name string, attributes map[string]interface{}) (context.Context, transcoder.Span) {
+	if cfg == nil || cfg.Tracer == nil {
+		return ctx, nil
+	}
+	parent := ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	return cfg.Tracer.Start(parent, name, attributes)
+}
+
+// exitCode returns the exit code of a failed process, -1 when err is another error
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// endSpan records the outcome of a span, which may be nil
+func endSpan(span transcoder.Span, err error) {
+	if span == nil {
+		return
+	}
+	if err == nil {
+		span.SetAttributes(map[string]interface{}{"process.exit_code": 0})
+	} else {
+		if code := exitCode(err); code >= 0 {
+			span.SetAttributes(map[string]interface{}{"process.exit_code": code})
+		}
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+// traceProgress ends span once in is closed, with the last error it carried
+func traceProgress(span transcoder.Span, in <-chan transcoder.Progress, err error) (<-chan transcoder.Progress, error) {
+	if span == nil {
+		return in, err
+	}
+	if err != nil || in == nil {
+		endSpan(span, err)
+		return in, err
+	}
+	out := make(chan transcoder.Progress)
+	go func() {
+		defer close(out)
+		var last error
+		for p := range in {
+			if p.GetError() != nil {
+				last = p.GetError()
+			}
+			out <- p
+		}
+		endSpan(span, last)
+	}()
+	return out, nil
+}
+
+// commandAttributes returns the attributes of a process span
+func commandAttributes(bin string, args []string, secrets ...string) map[string]interface{} {
+	return map[string]interface{}{
+		"process.executable": bin,
+		"process.args":       strings.Join(redact(args, secrets...), " "),
+	}
+}
+
+// metadataAttributes adds the codecs and resolution of metadata to attributes
+func metadataAttributes(attributes map[string]interface{}, metadata transcoder.Metadata) map[string]interface{} {
+	if metadata == nil || metadata.GetFormat() == nil {
+		return attributes
+	}
+	attributes["media.format"] = metadata.GetFormat().GetFormatName()
+	attributes["media.duration"] = metadata.GetFormat().GetDuration()
+	for _, s := range metadata.GetStreams() {
+		switch s.GetCodecType() {
+		case "video":
+			if _, ok := attributes["video.codec"]; !ok {
+				attributes["video.codec"] = s.GetCodecName()
+				attributes["video.width"] = s.GetWidth()
+				attributes["video.height"] = s.GetHeight()
+			}
+		case "audio":
+			if _, ok := attributes["audio.codec"]; !ok {
+				attributes["audio.codec"] = s.GetCodecName()
+			}
+		}
+	}
+	return attributes
+}
@@ -0,0 +1,106 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Transcriber turns speech into timed text, implemented on top of Whisper, a cloud
+// speech to text service or any other engine
+type Transcriber interface {
+	// Transcribe returns the cues of the speech in audio, a 16 kHz mono WAV file, timed
+	// from its start. language is an ISO 639 code, empty to let the engine detect it
+	Transcribe(ctx context.Context, audio string, language string) ([]SubtitleCue, error)
+}
+
+// TranscribeOptions configures Transcribe
+type TranscribeOptions struct {
+	// Language of the speech, empty to let the transcriber detect it
+	Language string
+	// ChunkDuration splits long audio into chunks transcribed one after the other,
+	// defaults to 10 minutes which keeps the chunks under 20 MB
+	ChunkDuration time.Duration
+	// Output is the SRT or WebVTT file the subtitles are written to
+	Output string
+	// MuxOutput receives the input with the subtitles muxed as a soft subtitle track,
+	// tagged with Language ("und" when empty)
+	MuxOutput string
+}
+
+// Transcribe extracts the audio of input, feeds it chunk by chunk to transcriber and
+// returns the resulting subtitles timed against input, optionally written to a subtitle
+// file and muxed into a copy of input
+func Transcribe(ctx context.Context, cfg *Config, input string, transcriber Transcriber, opts TranscribeOptions) (*SubtitleFile, error) {
+	if transcriber == nil {
+		return nil, errors.New("missing transcriber")
+	}
+	chunk := opts.ChunkDuration
+	if chunk <= 0 {
+		chunk = 10 * time.Minute
+	}
+	dir, err := ioutil.TempDir("", "transcribe")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	args := []string{"-y", "-i", input, "-map", "0:a:0", "-vn", "-sn", "-ac", "1", "-ar", "16000", "-c:a", "pcm_s16le",
+		"-f", "segment", "-segment_format", "wav", "-segment_time", seconds(chunk), "-reset_timestamps", "1",
+		filepath.Join(dir, "chunk_%05d.wav")}
+	if _, err := run(ctx, cfg, args...); err != nil {
+		return nil, err
+	}
+	chunks, err := filepath.Glob(filepath.Join(dir, "chunk_*.wav"))
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks) == 0 {
+		return nil, fmt.Errorf("no audio in %s", input)
+	}
+	sort.Strings(chunks)
+
+	subtitles := &SubtitleFile{Format: SubtitleVTT}
+	for i, c := range chunks {
+		cues, err := transcriber.Transcribe(ctx, c, opts.Language)
+		if err != nil {
+			return nil, fmt.Errorf("failed to transcribe chunk %d of %s with error %w", i, input, err)
+		}
+		offset := time.Duration(i) * chunk
+		for _, cue := range cues {
+			cue.Start += offset
+			cue.End += offset
+			subtitles.Cues = append(subtitles.Cues, cue)
+		}
+	}
+	sort.SliceStable(subtitles.Cues, func(i, j int) bool { return subtitles.Cues[i].Start < subtitles.Cues[j].Start })
+	for i := range subtitles.Cues {
+		subtitles.Cues[i].ID = strconv.Itoa(i + 1)
+	}
+
+	if len(opts.Output) > 0 {
+		if err := subtitles.WriteFile(opts.Output); err != nil {
+			return subtitles, err
+		}
+	}
+	if len(opts.MuxOutput) > 0 {
+		file := filepath.Join(dir, "subtitles.srt")
+		if err := subtitles.WriteFile(file); err != nil {
+			return subtitles, err
+		}
+		lang := opts.Language
+		if len(lang) == 0 {
+			lang = "und"
+		}
+		if err := AttachSubtitles(ctx, cfg, input, opts.MuxOutput, map[string]string{lang: file}, nil, AttachSubtitlesOptions{}); err != nil {
+			return subtitles, err
+		}
+	}
+	return subtitles, nil
+}
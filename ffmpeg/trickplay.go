@@ -0,0 +1,312 @@
+package ffmpeg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/admpub/transcoder"
+)
+
+// TrickPlayOptions configures trick play (scrubbing preview) assets
+type TrickPlayOptions struct {
+	// Dir receives the trick play assets
+	Dir string
+	// Interval between preview frames, defaults to 1s for I-frame playlists
+	// and 10s for storyboards
+	Interval time.Duration
+	// Width of the preview frames, defaults to 320; the height follows the source aspect ratio
+	Width int
+	// IFrames generates a dedicated I-frame only rendition referenced by EXT-X-I-FRAME-STREAM-INF
+	IFrames bool
+	// IFrameBitrate defaults to 200k
+	IFrameBitrate string
+	// Storyboard generates sprite images and a WebVTT track mapping time ranges to sprite tiles
+	Storyboard bool
+	// Columns and Rows of each sprite image, default to 10x10
+	Columns int
+	Rows    int
+	// ImageFormat is the sprite image extension, defaults to "jpg"
+	ImageFormat string
+}
+
+// TrickPlayResult lists the generated trick play assets
+type TrickPlayResult struct {
+	// IFrameVariant is the I-frame playlist entry for the master playlist, URI is relative to Dir
+	IFrameVariant *Variant
+	// StoryboardVTT is the path of the WebVTT storyboard track
+	StoryboardVTT string
+	Sprites       []string
+}
+
+// SpriteTile locates one preview frame inside a sprite image
+type SpriteTile struct {
+	Start  time.Duration `json:"start"`
+	End    time.Duration `json:"end"`
+	Image  string        `json:"image"`
+	X      int           `json:"x"`
+	Y      int           `json:"y"`
+	Width  int           `json:"width"`
+	Height int           `json:"height"`
+}
+
+// spriteLayout computes the tile of every preview frame
+func spriteLayout(duration float64, interval time.Duration, columns, rows, width, height int, imageName func(int) string) []SpriteTile {
+	step := interval.Seconds()
+	count := int(math.Ceil(duration / step))
+	perSprite := columns * rows
+	tiles := make([]SpriteTile, 0, count)
+	for i := 0; i < count; i++ {
+		end := float64(i+1) * step
+		if end > duration {
+			end = duration
+		}
+		index := i % perSprite
+		tiles = append(tiles, SpriteTile{
+			Start:  time.Duration(float64(i) * step * float64(time.Second)),
+			End:    time.Duration(end * float64(time.Second)),
+			Image:  imageName(i/perSprite + 1),
+			X:      (index % columns) * width,
+			Y:      (index / columns) * height,
+			Width:  width,
+			Height: height,
+		})
+	}
+	return tiles
+}
+
+// vttTimestamp formats d as a WebVTT timestamp
+func vttTimestamp(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// writeSpriteVTT writes a WebVTT track whose cues point to media fragments of the sprites
+func writeSpriteVTT(filename string, tiles []SpriteTile) error {
+	buf := new(bytes.Buffer)
+	buf.WriteString("WEBVTT\n")
+	for _, t := range tiles {
+		fmt.Fprintf(buf, "\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n", vttTimestamp(t.Start), vttTimestamp(t.End), t.Image, t.X, t.Y, t.Width, t.Height)
+	}
+	return ioutil.WriteFile(filename, buf.Bytes(), 0644)
+}
+
+// previewSize returns the preview frame size for a source of the given metadata
+func previewSize(metadata transcoder.Metadata, width int) (int, int, error) {
+	srcWidth, srcHeight, _, _, err := sourceVideo(metadata)
+	if err != nil {
+		return 0, 0, err
+	}
+	width = even(float64(width))
+	return width, even(float64(width) * float64(srcHeight) / float64(srcWidth)), nil
+}
+
+// sprites renders preview frames every interval tiled into columns x rows images named by pattern (printf %03d)
+func sprites(ctx context.Context, cfg *Config, input string, interval time.Duration, width, height, columns, rows int, pattern string) error {
+	filter := fmt.Sprintf("fps=1/%s,scale=%d:%d,tile=%dx%d",
+		strconv.FormatFloat(interval.Seconds(), 'f', -1, 64), width, height, columns, rows)
+	_, err := run(ctx, cfg, "-y", "-i", input, "-an", "-sn", "-vf", filter, "-q:v", "3", "-start_number", "1", pattern)
+	return err
+}
+
+// TrickPlay generates I-frame only playlists and/or WebVTT storyboards for player scrubbing previews
+func TrickPlay(ctx context.Context, cfg *Config, input string, opts TrickPlayOptions) (*TrickPlayResult, error) {
+	if len(opts.Dir) == 0 {
+		return nil, errors.New("missing trick play output directory")
+	}
+	if !opts.IFrames && !opts.Storyboard {
+		return nil, errors.New("neither I-frame playlists nor storyboards requested")
+	}
+	if opts.Width <= 0 {
+		opts.Width = 320
+	}
+	duration, metadata, err := probeDuration(ctx, cfg, input)
+	if err != nil {
+		return nil, err
+	}
+	width, height, err := previewSize(metadata, opts.Width)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(opts.Dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	result := &TrickPlayResult{}
+	if opts.IFrames {
+		variant, err := iframePlaylist(ctx, cfg, input, opts, width, height)
+		if err != nil {
+			return nil, err
+		}
+		result.IFrameVariant = variant
+	}
+
+	if opts.Storyboard {
+		interval := opts.Interval
+		if interval <= 0 {
+			interval = 10 * time.Second
+		}
+		sheet, err := spriteSheet(ctx, cfg, input, duration, width, height, opts.Columns, opts.Rows, interval, SpriteOptions{
+			Dir:    opts.Dir,
+			Format: opts.ImageFormat,
+			VTT:    "storyboard.vtt",
+		})
+		if err != nil {
+			return nil, err
+		}
+		result.StoryboardVTT = sheet.VTT
+		result.Sprites = sheet.Sprites
+	}
+	return result, nil
+}
+
+// iframePlaylist encodes an intra only low resolution rendition listed in an I-frame playlist
+func iframePlaylist(ctx context.Context, cfg *Config, input string, opts TrickPlayOptions, width, height int) (*Variant, error) {
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	bitrate := opts.IFrameBitrate
+	if len(bitrate) == 0 {
+		bitrate = "200k"
+	}
+	dir := filepath.Join(opts.Dir, "iframes")
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	videoCodec := "libx264"
+	filter := fmt.Sprintf("fps=1/%s,scale=%d:%d", strconv.FormatFloat(interval.Seconds(), 'f', -1, 64), width, height)
+	_, err := run(ctx, cfg, "-y", "-i", input, "-an", "-sn",
+		"-vf", filter,
+		"-c:v", videoCodec, "-g", "1", "-b:v", bitrate,
+		"-f", "hls", "-hls_playlist_type", "vod", "-hls_list_size", "0",
+		"-hls_flags", HLSFlagIframesOnly+"+"+HLSFlagSingleFile,
+		"-hls_segment_filename", filepath.Join(dir, "iframes.ts"),
+		filepath.Join(dir, "index.m3u8"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	rate := parseBitrate(bitrate)
+	return &Variant{
+		URI:       path.Join("iframes", "index.m3u8"),
+		Bandwidth: rate + rate/10,
+		Width:     width,
+		Height:    height,
+		Codecs:    VideoCodecString(Options{VideoCodec: &videoCodec}),
+	}, nil
+}
+
+// SpriteOptions configures sprite sheets
+type SpriteOptions struct {
+	// Dir receives the sprites and their map
+	Dir string
+	// Width of a tile, defaults to 160; the height follows the source aspect ratio
+	Width int
+	// Format is the image extension, defaults to "jpg"
+	Format string
+	// Name of the sprite images, printf pattern defaulting to sprite_%03d
+	Name string
+	// VTT and JSON are the names of the maps written in Dir, VTT defaults to sprites.vtt
+	// when both are empty
+	VTT  string
+	JSON string
+}
+
+// SpriteResult lists a generated sprite sheet
+type SpriteResult struct {
+	Sprites []string
+	Tiles   []SpriteTile
+	// VTT and JSON are the paths of the written maps
+	VTT  string
+	JSON string
+}
+
+// GenerateSprites renders a preview frame every interval, tiled columns x rows per
+// sprite image, with a WebVTT and/or JSON map of time ranges to tiles for player hover previews
+func GenerateSprites(ctx context.Context, cfg *Config, input string, columns, rows int, interval time.Duration, opts SpriteOptions) (*SpriteResult, error) {
+	if len(opts.Dir) == 0 {
+		return nil, errors.New("missing sprites output directory")
+	}
+	if interval <= 0 {
+		return nil, errors.New("sprite interval must be positive")
+	}
+	if opts.Width <= 0 {
+		opts.Width = 160
+	}
+	duration, metadata, err := probeDuration(ctx, cfg, input)
+	if err != nil {
+		return nil, err
+	}
+	width, height, err := previewSize(metadata, opts.Width)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(opts.Dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	return spriteSheet(ctx, cfg, input, duration, width, height, columns, rows, interval, opts)
+}
+
+// spriteSheet renders the sprites of input and writes their maps
+func spriteSheet(ctx context.Context, cfg *Config, input string, duration float64, width, height, columns, rows int, interval time.Duration, opts SpriteOptions) (*SpriteResult, error) {
+	if columns <= 0 {
+		columns = 10
+	}
+	if rows <= 0 {
+		rows = 10
+	}
+	format := opts.Format
+	if len(format) == 0 {
+		format = "jpg"
+	}
+	pattern := opts.Name
+	if len(pattern) == 0 {
+		pattern = "sprite_%03d"
+	}
+	pattern += "." + format
+	name := func(n int) string {
+		return fmt.Sprintf(pattern, n)
+	}
+	if err := sprites(ctx, cfg, input, interval, width, height, columns, rows, filepath.Join(opts.Dir, pattern)); err != nil {
+		return nil, err
+	}
+
+	result := &SpriteResult{Tiles: spriteLayout(duration, interval, columns, rows, width, height, name)}
+	seen := map[string]bool{}
+	for _, t := range result.Tiles {
+		if !seen[t.Image] {
+			seen[t.Image] = true
+			result.Sprites = append(result.Sprites, filepath.Join(opts.Dir, t.Image))
+		}
+	}
+	if len(opts.VTT) == 0 && len(opts.JSON) == 0 {
+		opts.VTT = "sprites.vtt"
+	}
+	if len(opts.VTT) > 0 {
+		result.VTT = filepath.Join(opts.Dir, opts.VTT)
+		if err := writeSpriteVTT(result.VTT, result.Tiles); err != nil {
+			return nil, err
+		}
+	}
+	if len(opts.JSON) > 0 {
+		result.JSON = filepath.Join(opts.Dir, opts.JSON)
+		data, err := json.MarshalIndent(result.Tiles, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(result.JSON, data, 0644); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,80 @@
+package ffmpeg
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/admpub/transcoder"
+)
+
+// usageSampler measures the resources used by a running process
+type usageSampler struct {
+	process *os.Process
+	started time.Time
+	stop    chan struct{}
+	done    chan struct{}
+
+	mu sync.Mutex
+	// sampled is the last usage read while the process ran, where the platform
+	// provides it
+	sampled transcoder.Usage
+}
+
+// sampleUsage starts measuring the resources used by p
+func sampleUsage(p *os.Process) *usageSampler {
+	s := &usageSampler{process: p, started: time.Now(), stop: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			s.sample()
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return s
+}
+
+// sample reads the current usage of the process
+func (s *usageSampler) sample() {
+	u, ok := readProcessUsage(s.process.Pid)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	s.sampled = u
+	s.mu.Unlock()
+}
+
+// finish stops sampling and returns the usage of the process, once it exited with
+// state. s may be nil
+func (s *usageSampler) finish(state *os.ProcessState) (transcoder.Usage, bool) {
+	if s == nil {
+		return transcoder.Usage{}, false
+	}
+	close(s.stop)
+	<-s.done
+	s.mu.Lock()
+	u := s.sampled
+	s.mu.Unlock()
+	u.WallSeconds = time.Since(s.started).Seconds()
+	if state != nil {
+		u.UserSeconds = state.UserTime().Seconds()
+		u.SystemSeconds = state.SystemTime().Seconds()
+		u.CPUSeconds = u.UserSeconds + u.SystemSeconds
+		exitUsage(state, &u)
+	}
+	return u, true
+}
+
+// WithUsageHook registers fn, called with the resources used by the ffmpeg process
+// once it exited, for cost accounting
+func (t *Transcoder) WithUsageHook(fn func(transcoder.Usage)) transcoder.Transcoder {
+	t.onUsage = fn
+	return t
+}
@@ -0,0 +1,46 @@
+package ffmpeg
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/admpub/transcoder"
+)
+
+// readProcessUsage reads the I/O and the peak memory of a running process in /proc
+func readProcessUsage(pid int) (transcoder.Usage, bool) {
+	var u transcoder.Usage
+	dir := "/proc/" + strconv.Itoa(pid) + "/"
+	counters, err := readProcFields(dir + "io")
+	if err != nil {
+		return u, false
+	}
+	u.ReadBytes, _ = strconv.ParseInt(counters["rchar"], 10, 64)
+	u.WriteBytes, _ = strconv.ParseInt(counters["wchar"], 10, 64)
+	if status, err := readProcFields(dir + "status"); err == nil {
+		// VmHWM: 123456 kB
+		if kb, err := strconv.ParseInt(strings.TrimSuffix(status["VmHWM"], " kB"), 10, 64); err == nil {
+			u.MaxRSS = kb * 1024
+		}
+	}
+	return u, true
+}
+
+// readProcFields reads the "name: value" lines of a /proc file
+func readProcFields(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fields := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if i := strings.IndexByte(scanner.Text(), ':'); i > 0 {
+			fields[scanner.Text()[:i]] = strings.TrimSpace(scanner.Text()[i+1:])
+		}
+	}
+	return fields, scanner.Err()
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package ffmpeg
+
+import "github.com/admpub/transcoder"
+
+// readProcessUsage is only available on Linux, the usage comes from the exit status
+func readProcessUsage(pid int) (transcoder.Usage, bool) {
+	return transcoder.Usage{}, false
+}
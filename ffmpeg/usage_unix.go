@@ -0,0 +1,32 @@
+//go:build !windows
+// +build !windows
+
+package ffmpeg
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+
+	"github.com/admpub/transcoder"
+)
+
+// exitUsage completes u with the rusage of an exited process
+func exitUsage(state *os.ProcessState, u *transcoder.Usage) {
+	rusage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return
+	}
+	// kilobytes, bytes on macOS
+	rss := int64(rusage.Maxrss)
+	if runtime.GOOS != "darwin" {
+		rss *= 1024
+	}
+	if rss > u.MaxRSS {
+		u.MaxRSS = rss
+	}
+	if u.ReadBytes == 0 && u.WriteBytes == 0 {
+		u.ReadBytes = int64(rusage.Inblock) * 512
+		u.WriteBytes = int64(rusage.Oublock) * 512
+	}
+}
@@ -0,0 +1,10 @@
+package ffmpeg
+
+import (
+	"os"
+
+	"github.com/admpub/transcoder"
+)
+
+// exitUsage keeps the CPU times of u, Windows has no rusage
+func exitUsage(state *os.ProcessState, u *transcoder.Usage) {}
@@ -0,0 +1,277 @@
+package ffmpeg
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/admpub/transcoder"
+	"github.com/admpub/transcoder/utils"
+)
+
+// DeliveryProfile declares the technical requirements of a delivery, empty fields
+// are not checked
+type DeliveryProfile struct {
+	Name string
+	// Containers are accepted ffprobe format names (mxf, mov, matroska...)
+	Containers []string
+	// MaxDuration and MinDuration in seconds
+	MinDuration float64
+	MaxDuration float64
+
+	VideoCodecs   []string
+	VideoProfiles []string
+	PixelFormats  []string
+	// Resolutions are accepted WIDTHxHEIGHT sizes
+	Resolutions []string
+	// FrameRates are accepted rates such as "25" or "30000/1001"
+	FrameRates []string
+	// FieldOrders are accepted field orders: progressive, tt, bb, tb or bt
+	FieldOrders []string
+	// MinVideoBitrate and MaxVideoBitrate in bits per second
+	MinVideoBitrate int64
+	MaxVideoBitrate int64
+
+	// AudioTracks is the exact number of audio streams required, 0 for any
+	AudioTracks    int
+	AudioCodecs    []string
+	SampleRates    []int
+	ChannelLayouts []string
+	// MinChannels is the number of channels of every audio stream
+	MinChannels int
+
+	// Loudness in LUFS with LoudnessTolerance in LU, checked when not 0
+	Loudness          float64
+	LoudnessTolerance float64
+	// MaxTruePeak in dBTP, checked when not 0
+	MaxTruePeak float64
+
+	// Timecode requires a start timecode, TimecodeStart is its required value (e.g. 10:00:00:00)
+	Timecode      bool
+	TimecodeStart string
+}
+
+// Common delivery profiles, approximations of the published specifications which
+// do not replace a certified checker
+var (
+	// ProfileAS11UKDPPHD follows the AS-11 UK DPP HD video, audio and timing requirements
+	ProfileAS11UKDPPHD = DeliveryProfile{
+		Name:              "AS-11 UK DPP HD",
+		Containers:        []string{"mxf"},
+		VideoCodecs:       []string{"h264"},
+		VideoProfiles:     []string{"High 4:2:2 Intra"},
+		PixelFormats:      []string{"yuv422p10le"},
+		Resolutions:       []string{"1920x1080"},
+		FrameRates:        []string{"25"},
+		FieldOrders:       []string{"tt", "progressive"},
+		AudioCodecs:       []string{"pcm_s24le"},
+		SampleRates:       []int{48000},
+		MinChannels:       1,
+		AudioTracks:       4,
+		Loudness:          -23,
+		LoudnessTolerance: 0.5,
+		MaxTruePeak:       -1,
+		Timecode:          true,
+		TimecodeStart:     "10:00:00:00",
+	}
+	// ProfileStreamingMezzanine is a typical ProRes mezzanine for streaming platforms
+	ProfileStreamingMezzanine = DeliveryProfile{
+		Name:              "Streaming ProRes mezzanine",
+		Containers:        []string{"mov"},
+		VideoCodecs:       []string{"prores"},
+		VideoProfiles:     []string{"HQ", "4444", "XQ"},
+		FieldOrders:       []string{"progressive"},
+		AudioCodecs:       []string{"pcm_s24le", "pcm_s16le"},
+		SampleRates:       []int{48000},
+		Loudness:          -27,
+		LoudnessTolerance: 2,
+		MaxTruePeak:       -2,
+		Timecode:          true,
+	}
+)
+
+// Violation is a requirement of a profile the input does not meet
+type Violation struct {
+	Rule string `json:"rule"`
+	// Stream is the index of the faulty stream, -1 for the container
+	Stream   int    `json:"stream"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+}
+
+// Error ...
+func (v Violation) Error() string {
+	if v.Stream < 0 {
+		return fmt.Sprintf("%s: expected %s, got %s", v.Rule, v.Expected, v.Actual)
+	}
+	return fmt.Sprintf("%s of stream %d: expected %s, got %s", v.Rule, v.Stream, v.Expected, v.Actual)
+}
+
+// ValidationReport lists the violations of a profile
+type ValidationReport struct {
+	Profile    string      `json:"profile"`
+	Passed     bool        `json:"passed"`
+	Violations []Violation `json:"violations,omitempty"`
+}
+
+// oneOf reports whether value is in allowed, ignoring case
+func oneOf(value string, allowed []string) bool {
+	for _, a := range allowed {
+		if strings.EqualFold(a, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameRate reports whether two frame rates are equal, comparing fractions numerically
+func sameRate(a, b string) bool {
+	ra, rb := utils.ParseRate(a), utils.ParseRate(b)
+	return ra > 0 && math.Abs(ra-rb) < 0.001
+}
+
+// Validate checks input against profile and returns the itemized violations. Loudness
+// rules decode the audio, the other rules only probe the input
+func Validate(ctx context.Context, cfg *Config, input string, profile DeliveryProfile) (*ValidationReport, error) {
+	metadata, err := probe(ctx, cfg, input)
+	if err != nil {
+		return nil, err
+	}
+	report := &ValidationReport{Profile: profile.Name}
+	violate := func(rule string, stream int, expected, actual string) {
+		report.Violations = append(report.Violations, Violation{Rule: rule, Stream: stream, Expected: expected, Actual: actual})
+	}
+	list := func(values []string) string { return strings.Join(values, " or ") }
+
+	format := metadata.GetFormat()
+	if len(profile.Containers) > 0 {
+		ok := false
+		// ffprobe names families of formats, such as "mov,mp4,m4a,3gp,3g2,mj2"
+		for _, name := range strings.Split(format.GetFormatName(), ",") {
+			ok = ok || oneOf(name, profile.Containers)
+		}
+		if !ok {
+			violate("container", -1, list(profile.Containers), format.GetFormatName())
+		}
+	}
+	duration, _ := strconv.ParseFloat(format.GetDuration(), 64)
+	if profile.MinDuration > 0 && duration < profile.MinDuration {
+		violate("min duration", -1, fmt.Sprintf(">= %gs", profile.MinDuration), fmt.Sprintf("%gs", duration))
+	}
+	if profile.MaxDuration > 0 && duration > profile.MaxDuration {
+		violate("max duration", -1, fmt.Sprintf("<= %gs", profile.MaxDuration), fmt.Sprintf("%gs", duration))
+	}
+
+	var video, audio []transcoder.Streams
+	timecode := format.GetTags().GetTimecode()
+	for _, s := range metadata.GetStreams() {
+		switch s.GetCodecType() {
+		case "video":
+			video = append(video, s)
+		case "audio":
+			audio = append(audio, s)
+		}
+		if tc := s.GetTags()["timecode"]; len(timecode) == 0 && len(tc) > 0 {
+			timecode = tc
+		}
+	}
+
+	if len(video) == 0 && (len(profile.VideoCodecs) > 0 || len(profile.Resolutions) > 0) {
+		violate("video stream", -1, "a video stream", "none")
+	}
+	for _, v := range video {
+		i := v.GetIndex()
+		if len(profile.VideoCodecs) > 0 && !oneOf(v.GetCodecName(), profile.VideoCodecs) {
+			violate("video codec", i, list(profile.VideoCodecs), v.GetCodecName())
+		}
+		if len(profile.VideoProfiles) > 0 && !oneOf(v.GetProfile(), profile.VideoProfiles) {
+			violate("video profile", i, list(profile.VideoProfiles), v.GetProfile())
+		}
+		if len(profile.PixelFormats) > 0 && !oneOf(v.GetPixFmt(), profile.PixelFormats) {
+			violate("pixel format", i, list(profile.PixelFormats), v.GetPixFmt())
+		}
+		if size := fmt.Sprintf("%dx%d", v.GetWidth(), v.GetHeight()); len(profile.Resolutions) > 0 && !oneOf(size, profile.Resolutions) {
+			violate("resolution", i, list(profile.Resolutions), size)
+		}
+		if len(profile.FrameRates) > 0 {
+			ok := false
+			for _, r := range profile.FrameRates {
+				ok = ok || sameRate(v.GetAvgFrameRate(), r) || sameRate(v.GetRFrameRrate(), r)
+			}
+			if !ok {
+				violate("frame rate", i, list(profile.FrameRates), v.GetAvgFrameRate())
+			}
+		}
+		if len(profile.FieldOrders) > 0 && !oneOf(v.GetFieldOrder(), profile.FieldOrders) {
+			violate("field order", i, list(profile.FieldOrders), v.GetFieldOrder())
+		}
+		bitrate, _ := strconv.ParseInt(v.GetBitRate(), 10, 64)
+		if len(video) == 1 && bitrate == 0 {
+			// some containers only know the overall bitrate
+			bitrate, _ = strconv.ParseInt(format.GetBitRate(), 10, 64)
+		}
+		if profile.MinVideoBitrate > 0 && bitrate < profile.MinVideoBitrate {
+			violate("min video bitrate", i, fmt.Sprintf(">= %d", profile.MinVideoBitrate), strconv.FormatInt(bitrate, 10))
+		}
+		if profile.MaxVideoBitrate > 0 && bitrate > profile.MaxVideoBitrate {
+			violate("max video bitrate", i, fmt.Sprintf("<= %d", profile.MaxVideoBitrate), strconv.FormatInt(bitrate, 10))
+		}
+	}
+
+	if profile.AudioTracks > 0 && len(audio) != profile.AudioTracks {
+		violate("audio tracks", -1, strconv.Itoa(profile.AudioTracks), strconv.Itoa(len(audio)))
+	}
+	for _, a := range audio {
+		i := a.GetIndex()
+		if len(profile.AudioCodecs) > 0 && !oneOf(a.GetCodecName(), profile.AudioCodecs) {
+			violate("audio codec", i, list(profile.AudioCodecs), a.GetCodecName())
+		}
+		if len(profile.SampleRates) > 0 {
+			rate, _ := strconv.Atoi(a.GetSampleRate())
+			ok := false
+			var rates []string
+			for _, r := range profile.SampleRates {
+				ok = ok || r == rate
+				rates = append(rates, strconv.Itoa(r))
+			}
+			if !ok {
+				violate("sample rate", i, list(rates), a.GetSampleRate())
+			}
+		}
+		if len(profile.ChannelLayouts) > 0 && !oneOf(a.GetChannelLayout(), profile.ChannelLayouts) {
+			violate("channel layout", i, list(profile.ChannelLayouts), a.GetChannelLayout())
+		}
+		if profile.MinChannels > 0 && a.GetChannels() < profile.MinChannels {
+			violate("channels", i, fmt.Sprintf(">= %d", profile.MinChannels), strconv.Itoa(a.GetChannels()))
+		}
+	}
+
+	if (profile.Loudness != 0 || profile.MaxTruePeak != 0) && len(audio) > 0 {
+		loudness, err := MeasureLoudness(ctx, cfg, input)
+		if err != nil {
+			return nil, err
+		}
+		tolerance := profile.LoudnessTolerance
+		if tolerance <= 0 {
+			tolerance = 1
+		}
+		if profile.Loudness != 0 && math.Abs(loudness.Integrated-profile.Loudness) > tolerance {
+			violate("loudness", audio[0].GetIndex(), fmt.Sprintf("%.1f±%.1f LUFS", profile.Loudness, tolerance), fmt.Sprintf("%.1f LUFS", loudness.Integrated))
+		}
+		if profile.MaxTruePeak != 0 && loudness.TruePeak > profile.MaxTruePeak {
+			violate("true peak", audio[0].GetIndex(), fmt.Sprintf("<= %.1f dBTP", profile.MaxTruePeak), fmt.Sprintf("%.1f dBTP", loudness.TruePeak))
+		}
+	}
+
+	if profile.Timecode && len(timecode) == 0 {
+		violate("timecode", -1, "a start timecode", "none")
+	}
+	// drop frame timecodes use a semicolon before the frames
+	if len(profile.TimecodeStart) > 0 && len(timecode) > 0 && strings.Replace(timecode, ";", ":", -1) != strings.Replace(profile.TimecodeStart, ";", ":", -1) {
+		violate("timecode start", -1, profile.TimecodeStart, timecode)
+	}
+	report.Passed = len(report.Violations) == 0
+	return report, nil
+}
@@ -0,0 +1,147 @@
+package ffmpeg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"sync"
+)
+
+// ErrUnsupportedVersion is returned when a feature needs a newer ffmpeg than the configured one
+var ErrUnsupportedVersion = errors.New("feature not supported by this ffmpeg version")
+
+// Version is a parsed ffmpeg release number. Builds from git master report
+// no release number and are considered newer than every release
+type Version struct {
+	Major  int
+	Minor  int
+	Patch  int
+	Master bool
+	// Raw is the first line printed by ffmpeg -version
+	Raw string
+}
+
+// String ...
+func (v Version) String() string {
+	if v.Master {
+		return "master"
+	}
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// AtLeast reports whether v is the given release or newer
+func (v Version) AtLeast(major, minor int) bool {
+	if v.Master {
+		return true
+	}
+	if v.Major != major {
+		return v.Major > major
+	}
+	return v.Minor >= minor
+}
+
+// require returns ErrUnsupportedVersion when v is older than major.minor
+func (v Version) require(feature string, major, minor int) error {
+	if v.AtLeast(major, minor) {
+		return nil
+	}
+	return fmt.Errorf("%s requires ffmpeg %d.%d, found %s: %w", feature, major, minor, v, ErrUnsupportedVersion)
+}
+
+var reVersion = regexp.MustCompile(`version\s+n?(\d+)\.(\d+)(?:\.(\d+))?`)
+
+// ParseVersion parses the output of ffmpeg -version
+func ParseVersion(output []byte) (Version, error) {
+	line := output
+	if i := bytes.IndexByte(output, '\n'); i >= 0 {
+		line = output[:i]
+	}
+	v := Version{Raw: string(bytes.TrimSpace(line))}
+	if m := reVersion.FindSubmatch(line); m != nil {
+		v.Major, _ = strconv.Atoi(string(m[1]))
+		v.Minor, _ = strconv.Atoi(string(m[2]))
+		if len(m[3]) > 0 {
+			v.Patch, _ = strconv.Atoi(string(m[3]))
+		}
+		return v, nil
+	}
+	// git builds: "ffmpeg version N-112345-g0123abcd" or a bare commit hash
+	if bytes.Contains(line, []byte("version N-")) || bytes.Contains(line, []byte("version git-")) {
+		v.Master = true
+		return v, nil
+	}
+	return v, fmt.Errorf("unable to parse ffmpeg version from %q", v.Raw)
+}
+
+var versions = struct {
+	sync.Mutex
+	cache map[string]Version
+}{cache: map[string]Version{}}
+
+// DetectVersion runs ffmpeg -version once per binary and caches the result
+func DetectVersion(ctx context.Context, cfg *Config) (Version, error) {
+	if cfg.FfmpegBinPath == "" {
+		return Version{}, errors.New("ffmpeg binary path not found")
+	}
+	versions.Lock()
+	v, ok := versions.cache[cfg.FfmpegBinPath]
+	versions.Unlock()
+	if ok {
+		return v, nil
+	}
+
+	cmd := command(ctx, cfg, cfg.FfmpegBinPath, "-version")
+	output, err := cmd.Output()
+	if err != nil {
+		return Version{}, fmt.Errorf("failed to execute (%s) with args (-version) with error %w", cfg.FfmpegBinPath, err)
+	}
+	v, err = ParseVersion(output)
+	if err != nil {
+		return v, err
+	}
+	versions.Lock()
+	versions.cache[cfg.FfmpegBinPath] = v
+	versions.Unlock()
+	return v, nil
+}
+
+var muxers = struct {
+	sync.Mutex
+	cache map[string]map[string]bool
+}{cache: map[string]map[string]bool{}}
+
+// reMuxer matches a line of ffmpeg -muxers, e.g. " E  whip            WHIP muxer"
+var reMuxer = regexp.MustCompile(`^\s*D?Ed?\s+(\S+)`)
+
+// hasMuxer reports whether the ffmpeg binary of cfg was built with the named muxer
+func hasMuxer(ctx context.Context, cfg *Config, name string) (bool, error) {
+	if cfg.FfmpegBinPath == "" {
+		return false, errors.New("ffmpeg binary path not found")
+	}
+	muxers.Lock()
+	list, ok := muxers.cache[cfg.FfmpegBinPath]
+	muxers.Unlock()
+	if !ok {
+		cmd := command(ctx, cfg, cfg.FfmpegBinPath, "-hide_banner", "-muxers")
+		output, err := cmd.Output()
+		if err != nil {
+			return false, fmt.Errorf("failed to execute (%s) with args (-muxers) with error %w", cfg.FfmpegBinPath, err)
+		}
+		list = map[string]bool{}
+		for _, line := range bytes.Split(output, []byte("\n")) {
+			if m := reMuxer.FindSubmatch(line); m != nil {
+				// aliases are listed comma separated, e.g. "mov,mp4,m4a"
+				for _, n := range bytes.Split(m[1], []byte(",")) {
+					list[string(n)] = true
+				}
+			}
+		}
+		muxers.Lock()
+		muxers.cache[cfg.FfmpegBinPath] = list
+		muxers.Unlock()
+	}
+	return list[name], nil
+}
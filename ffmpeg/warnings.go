@@ -0,0 +1,116 @@
+package ffmpeg
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/admpub/transcoder"
+)
+
+// Warning categories
+const (
+	// WarningTimestamp is a timestamp discontinuity or non monotonic timestamps, which
+	// desynchronize audio and video
+	WarningTimestamp = "timestamp"
+	// WarningCorrupt is a corrupt packet or frame, shown as artifacts or concealed
+	WarningCorrupt = "corrupt"
+	// WarningBuffer is a buffer underflow or overrun, often losing data
+	WarningBuffer = "buffer"
+	// WarningDropped is a dropped or duplicated frame
+	WarningDropped = "dropped"
+	// WarningDeprecated is a deprecated option, harmless until ffmpeg removes it
+	WarningDeprecated = "deprecated"
+)
+
+// Warning is a classified line ffmpeg wrote on stderr
+type Warning struct {
+	Category string `json:"category"`
+	// Component is the component reporting it, such as h264 or mpegts, when the line
+	// has a [component @ 0x...] prefix
+	Component string `json:"component,omitempty"`
+	Message   string `json:"message"`
+	// QualityAffecting is set on the categories altering the output
+	QualityAffecting bool      `json:"quality_affecting"`
+	Time             time.Time `json:"time"`
+}
+
+// WarningProgress is sent on the progress channel for each warning, see
+// Transcoder.WithWarnings. Progress holds the values of the last progress
+type WarningProgress struct {
+	Progress
+	Warning Warning
+}
+
+// warningPatterns are the messages, lowercased, of each category
+var warningPatterns = []struct {
+	category string
+	patterns []string
+}{
+	{WarningDeprecated, []string{"deprecated"}},
+	{WarningTimestamp, []string{
+		"non-monotonous dts", "non-monotonic dts", "non monotonically increasing dts",
+		"timestamp discontinuity", "dts discontinuity", "invalid timestamps", "pts has no value",
+		"past duration", "backward in time", "invalid dts", "invalid pts", "discontinuity detected",
+	}},
+	{WarningCorrupt, []string{
+		"corrupt decoded frame", "corrupt input packet", "packet corrupt", "error while decoding",
+		"concealing", "invalid nal unit", "missing picture in access unit", "decode_slice_header error",
+		"continuity check failed", "pes packet size mismatch", "header missing", "invalid data found",
+		"co located pocs unavailable", "rtp: missed", "packet mismatch", "damaged",
+	}},
+	{WarningBuffer, []string{
+		"buffer underflow", "vbv underflow", "circular buffer overrun", "buffer overrun", "buffer overflow",
+		"thread message queue blocking", "max delay reached", "queue full",
+	}},
+	{WarningDropped, []string{"dropping frame", "frame dropped", "*** drop", "*** dup", "frames dropped"}},
+}
+
+// reComponent matches the [component @ 0x...] prefix of a line
+var reComponent = regexp.MustCompile(`^\[([\w:.-]+) @ 0x[0-9a-f]+\]\s*`)
+
+// ClassifyWarning returns the warning of a stderr line, false when the line is of
+// none of the categories
+func ClassifyWarning(line string) (Warning, bool) {
+	line = strings.TrimSpace(line)
+	if len(line) == 0 || (strings.Contains(line, "time=") && strings.Contains(line, "bitrate=")) {
+		return Warning{}, false
+	}
+	w := Warning{Message: line}
+	if m := reComponent.FindStringSubmatch(line); m != nil {
+		w.Component, w.Message = m[1], line[len(m[0]):]
+	}
+	message := strings.ToLower(w.Message)
+	for _, c := range warningPatterns {
+		for _, p := range c.patterns {
+			if strings.Contains(message, p) {
+				w.Category = c.category
+				w.QualityAffecting = c.category != WarningDeprecated
+				return w, true
+			}
+		}
+	}
+	return Warning{}, false
+}
+
+// WithWarnings classifies the lines ffmpeg writes on stderr and sends the warnings on
+// the progress channel as WarningProgress, and to fn when it is not nil. It needs
+// ProgressEnabled
+func (t *Transcoder) WithWarnings(fn func(Warning)) *Transcoder {
+	t.warnings = true
+	t.onWarning = fn
+	return t
+}
+
+// warning sends the warning of line when it is one
+func (t *Transcoder) warning(line string, last Progress, out chan transcoder.Progress) {
+	w, ok := ClassifyWarning(line)
+	if !ok {
+		return
+	}
+	w.Time = time.Now()
+	if t.onWarning != nil {
+		t.onWarning(w)
+	}
+	out <- WarningProgress{Progress: last, Warning: w}
+}
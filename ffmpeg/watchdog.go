@@ -0,0 +1,241 @@
+package ffmpeg
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Limits tripped by a Watchdog
+const (
+	LimitMaxDuration    = "max_duration"
+	LimitMaxOutputBytes = "max_output_bytes"
+	LimitMaxStderrBytes = "max_stderr_bytes"
+)
+
+// ErrLimitExceeded is matched by the LimitError of the transcodes a Watchdog
+// terminated
+var ErrLimitExceeded = errors.New("limit exceeded")
+
+// LimitError is the error of a transcode terminated by its Watchdog
+type LimitError struct {
+	// Limit is the tripped limit, LimitMaxDuration, LimitMaxOutputBytes or
+	// LimitMaxStderrBytes
+	Limit string
+	// Value and Max are bytes, or a time.Duration for LimitMaxDuration
+	Value int64
+	Max   int64
+	Err   error
+}
+
+// Error ...
+func (e *LimitError) Error() string {
+	if e.Limit == LimitMaxDuration {
+		return fmt.Sprintf("%v: %s %s over %s: %v", ErrLimitExceeded, e.Limit, time.Duration(e.Value), time.Duration(e.Max), e.Err)
+	}
+	return fmt.Sprintf("%v: %s %d over %d: %v", ErrLimitExceeded, e.Limit, e.Value, e.Max, e.Err)
+}
+
+// Is matches ErrLimitExceeded
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
+
+// Unwrap ...
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
+
+// Watchdog terminates the runaway transcodes, such as a live input accidentally
+// recorded to a file forever. The zero values leave each limit unchecked
+type Watchdog struct {
+	// MaxDuration is the wall clock time of the process
+	MaxDuration time.Duration
+	// MaxOutputBytes is the total size of the local output files
+	MaxOutputBytes int64
+	// MaxStderrBytes is what ffmpeg writes on stderr, a flood of warnings being the
+	// sign of a broken input
+	MaxStderrBytes int64
+	// Grace is the time ffmpeg gets to finish its outputs once interrupted before
+	// being killed, defaults to 5s
+	Grace time.Duration
+	// Interval is the interval between two checks of the output size, defaults to 1s
+	Interval time.Duration
+}
+
+// WithWatchdog sets the watchdog of this transcode, instead of that of the Config
+func (t *Transcoder) WithWatchdog(w Watchdog) *Transcoder {
+	t.watchdog = &w
+	return t
+}
+
+// watchdogConfig returns the watchdog of the transcode, nil when there is none
+func (t *Transcoder) watchdogConfig() *Watchdog {
+	if t.watchdog != nil {
+		return t.watchdog
+	}
+	return t.config.Watchdog
+}
+
+// watcher enforces a Watchdog on a running process
+type watcher struct {
+	config  Watchdog
+	outputs []string
+	stderr  int64
+	stop    chan struct{}
+	done    chan struct{}
+
+	mu      sync.Mutex
+	process *os.Process
+	tripped *LimitError
+}
+
+// newWatcher returns the watcher of the outputs, config may be nil
+func newWatcher(config *Watchdog, dir string, outputs []string) *watcher {
+	if config == nil {
+		return nil
+	}
+	w := &watcher{config: *config}
+	if w.config.Grace <= 0 {
+		w.config.Grace = 5 * time.Second
+	}
+	if w.config.Interval <= 0 {
+		w.config.Interval = time.Second
+	}
+	for _, output := range outputs {
+		if localFile(output) {
+			if !filepath.IsAbs(output) && len(dir) > 0 {
+				output = filepath.Join(dir, output)
+			}
+			w.outputs = append(w.outputs, output)
+		}
+	}
+	return w
+}
+
+// Write counts the stderr bytes
+func (w *watcher) Write(p []byte) (int, error) {
+	if n := atomic.AddInt64(&w.stderr, int64(len(p))); w.config.MaxStderrBytes > 0 && n > w.config.MaxStderrBytes {
+		w.trip(LimitMaxStderrBytes, n, w.config.MaxStderrBytes)
+	}
+	return len(p), nil
+}
+
+// stderrWriter returns stderr counted by w. w may be nil
+func (w *watcher) stderrWriter(stderr io.Writer) io.Writer {
+	if w == nil || w.config.MaxStderrBytes <= 0 {
+		return stderr
+	}
+	if stderr == nil {
+		return w
+	}
+	return io.MultiWriter(stderr, w)
+}
+
+// stderrReader returns stderr counted by w. w may be nil
+func (w *watcher) stderrReader(stderr io.ReadCloser) io.ReadCloser {
+	if w == nil || w.config.MaxStderrBytes <= 0 {
+		return stderr
+	}
+	return teeReadCloser(stderr, w)
+}
+
+// start watches p. w may be nil
+func (w *watcher) start(p *os.Process) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	w.process = p
+	w.mu.Unlock()
+	w.stop, w.done = make(chan struct{}), make(chan struct{})
+	started := time.Now()
+	go func() {
+		defer close(w.done)
+		ticker := time.NewTicker(w.config.Interval)
+		defer ticker.Stop()
+		var deadline <-chan time.Time
+		if w.config.MaxDuration > 0 {
+			timer := time.NewTimer(w.config.MaxDuration)
+			defer timer.Stop()
+			deadline = timer.C
+		}
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-deadline:
+				w.trip(LimitMaxDuration, int64(time.Since(started).Round(time.Millisecond)), int64(w.config.MaxDuration))
+			case <-ticker.C:
+				if w.config.MaxOutputBytes > 0 {
+					if size := w.outputSize(); size > w.config.MaxOutputBytes {
+						w.trip(LimitMaxOutputBytes, size, w.config.MaxOutputBytes)
+					}
+				}
+			}
+		}
+	}()
+}
+
+// outputSize returns the total size of the output files
+func (w *watcher) outputSize() int64 {
+	var size int64
+	for _, output := range w.outputs {
+		if fi, err := os.Stat(output); err == nil {
+			size += fi.Size()
+		}
+	}
+	return size
+}
+
+// trip interrupts the process for exceeding limit, then kills it after the grace
+// period. Only the first limit is reported
+func (w *watcher) trip(limit string, value, max int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.tripped != nil || w.process == nil {
+		return
+	}
+	w.tripped = &LimitError{Limit: limit, Value: value, Max: max}
+	p := w.process
+	// Windows has no interrupt
+	if err := p.Signal(os.Interrupt); err != nil {
+		p.Kill()
+		return
+	}
+	stop := w.stop
+	go func() {
+		timer := time.NewTimer(w.config.Grace)
+		defer timer.Stop()
+		select {
+		case <-stop:
+		case <-timer.C:
+			p.Kill()
+		}
+	}()
+}
+
+// finish stops watching the process, which exited with err, and returns the
+// LimitError of the tripped limit, err otherwise. w may be nil
+func (w *watcher) finish(err error) error {
+	if w == nil || w.stop == nil {
+		return err
+	}
+	close(w.stop)
+	<-w.done
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.tripped == nil {
+		return err
+	}
+	if err == nil {
+		err = errors.New("terminated by the watchdog")
+	}
+	w.tripped.Err = err
+	return w.tripped
+}
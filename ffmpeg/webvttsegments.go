@@ -0,0 +1,107 @@
+package ffmpeg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// WebVTTSegmentOptions configures SegmentWebVTT
+type WebVTTSegmentOptions struct {
+	// Pattern is the printf name of the segments formatted with their number, defaults to %05d.vtt
+	Pattern string
+	// Playlist is the name of the media playlist, defaults to index.m3u8
+	Playlist string
+	// Duration is the length of the video, segments cover it entirely. Defaults to the end of the last cue
+	Duration time.Duration
+	// MPEGTS is the 90 kHz timestamp of the start of the video, written in the
+	// X-TIMESTAMP-MAP header for MPEG-TS segments starting at a non zero timestamp
+	MPEGTS int64
+}
+
+// WebVTTSegments are the files written by SegmentWebVTT
+type WebVTTSegments struct {
+	Playlist string
+	Segments []string
+}
+
+// SegmentWebVTT splits the SRT or WebVTT file input into WebVTT segments of
+// segmentDuration written to dir along with their media playlist, to be used as an HLS
+// subtitle rendition aligned with video segments of the same duration. Cues overlapping
+// a segment boundary are repeated in each segment
+func SegmentWebVTT(input, dir string, segmentDuration time.Duration, opts WebVTTSegmentOptions) (*WebVTTSegments, error) {
+	if segmentDuration <= 0 {
+		return nil, errors.New("segment duration must be positive")
+	}
+	f, err := ReadSubtitleFile(input)
+	if err != nil {
+		return nil, err
+	}
+	pattern, playlist := opts.Pattern, opts.Playlist
+	if len(pattern) == 0 {
+		pattern = "%05d.vtt"
+	}
+	if len(playlist) == 0 {
+		playlist = "index.m3u8"
+	}
+	total := opts.Duration
+	for _, c := range f.Cues {
+		if opts.Duration <= 0 && c.End > total {
+			total = c.End
+		}
+	}
+	if total <= 0 {
+		total = segmentDuration
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	header := "WEBVTT"
+	if len(f.Header) > 0 && f.Format == SubtitleVTT {
+		// the header line only, the X-TIMESTAMP-MAP must directly follow it
+		header = strings.SplitN(f.Header, "\n", 2)[0]
+	}
+	if opts.MPEGTS > 0 {
+		header += fmt.Sprintf("\nX-TIMESTAMP-MAP=MPEGTS:%d,LOCAL:00:00:00.000", opts.MPEGTS)
+	}
+	count := int(math.Ceil(float64(total) / float64(segmentDuration)))
+	result := &WebVTTSegments{Playlist: filepath.Join(dir, playlist)}
+	m3u8 := &bytes.Buffer{}
+	fmt.Fprintf(m3u8, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n",
+		int(math.Ceil(segmentDuration.Seconds())))
+	for i := 0; i < count; i++ {
+		start := time.Duration(i) * segmentDuration
+		end := start + segmentDuration
+		if end > total {
+			end = total
+		}
+		segment := &SubtitleFile{Format: SubtitleVTT, Header: header}
+		for _, c := range f.Cues {
+			if c.Start < end && c.End > start {
+				if f.Format == SubtitleSRT {
+					c.ID = ""
+				}
+				segment.Cues = append(segment.Cues, c)
+			}
+		}
+		name := fmt.Sprintf(pattern, i)
+		file := filepath.Join(dir, name)
+		if err := segment.WriteFile(file); err != nil {
+			return nil, err
+		}
+		result.Segments = append(result.Segments, file)
+		fmt.Fprintf(m3u8, "#EXTINF:%.3f,\n%s\n", (end - start).Seconds(), filepath.ToSlash(name))
+	}
+	m3u8.WriteString("#EXT-X-ENDLIST\n")
+	if err := ioutil.WriteFile(result.Playlist, m3u8.Bytes(), 0644); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,267 @@
+package ffmpeg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/admpub/transcoder"
+)
+
+// RTPForwarder publishes to a WHIP endpoint the RTP streams sent by ffmpeg, for
+// builds without the whip muxer. It is the extension point for WebRTC stacks
+// such as pion, which this package does not depend on
+type RTPForwarder interface {
+	// Listen returns the local UDP addresses (host:port) ffmpeg sends the video and
+	// audio RTP packets to, audio is empty when the forwarder does not take audio
+	Listen(ctx context.Context) (video string, audio string, err error)
+	// Publish negotiates the WebRTC session with the endpoint and forwards the
+	// packets until ctx is done or the session fails. sdp is the session description
+	// ffmpeg wrote for the RTP streams, giving the payload types and the codec
+	// parameters (sprop-parameter-sets...) of the offer
+	Publish(ctx context.Context, endpoint string, token string, sdp string) error
+}
+
+// WHIPOutputOptions configures a push to a WebRTC-HTTP ingestion (WHIP) endpoint
+type WHIPOutputOptions struct {
+	// Endpoint is the WHIP URL
+	Endpoint string
+	// Token is sent as bearer authorization, it is masked in logs and error messages
+	Token string
+	// Realtime reads the input at its native frame rate (-re). It is enabled
+	// automatically for local file inputs
+	Realtime *bool
+	// Options are the encoding options, defaults to H.264 without B-frames and Opus,
+	// which every browser decodes
+	Options *Options
+	// Forwarder, when set, is used instead of the whip muxer
+	Forwarder RTPForwarder
+	// Backoff controls reconnection when the session drops
+	Backoff Backoff
+}
+
+// WHIPOutput pushes an input to a WHIP endpoint so it can be played in browsers,
+// using the whip muxer of ffmpeg 8 or an RTPForwarder
+type WHIPOutput struct {
+	config         *Config
+	input          string
+	options        WHIPOutputOptions
+	commandContext context.Context
+}
+
+// NewWHIPOutput ...
+func NewWHIPOutput(cfg *Config, input string, opts WHIPOutputOptions) *WHIPOutput {
+	return &WHIPOutput{config: cfg, input: input, options: opts}
+}
+
+// WithContext is to be used *before Starting*, cancelling the context stops the push
+func (w *WHIPOutput) WithContext(ctx context.Context) *WHIPOutput {
+	w.commandContext = ctx
+	return w
+}
+
+// encoding returns the encoding options of the push
+func (o WHIPOutputOptions) encoding() Options {
+	if o.Options != nil {
+		return *o.Options
+	}
+	videoCodec, audioCodec := "libx264", "libopus"
+	preset, tune := "veryfast", "zerolatency"
+	profile := "baseline"
+	pixFmt := "yuv420p"
+	bframes := 0
+	audioRate := 48000
+	return Options{
+		VideoCodec:   &videoCodec,
+		AudioCodec:   &audioCodec,
+		Preset:       &preset,
+		Tune:         &tune,
+		VideoProfile: &profile,
+		PixFmt:       &pixFmt,
+		Bframe:       &bframes,
+		AudioRate:    &audioRate,
+	}
+}
+
+// validate ...
+func (w *WHIPOutput) validate() error {
+	u, err := url.Parse(w.options.Endpoint)
+	if err != nil || len(w.options.Endpoint) == 0 {
+		return errors.New("missing or invalid WHIP endpoint")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("WHIP endpoint must use the http or https scheme")
+	}
+	if len(w.input) == 0 {
+		return errors.New("missing input option")
+	}
+	return nil
+}
+
+// transcoder returns a process reading the input
+func (w *WHIPOutput) transcoder(ctx context.Context) *Transcoder {
+	file := isFile(w.input)
+	realtime := file
+	if w.options.Realtime != nil {
+		realtime = *w.options.Realtime
+	}
+	var input Args
+	if realtime {
+		input = append(input, "-re")
+	}
+	t := New(progressConfig(w.config)).Input(w.input).(*Transcoder)
+	t.WithInputOptions(input).
+		WithSecrets(w.options.Token).
+		WithContext(ctx)
+	return t
+}
+
+// muxerAttempt pushes with the whip muxer
+func (w *WHIPOutput) muxerAttempt(ctx context.Context, attempt int) (<-chan transcoder.Progress, error) {
+	t := w.transcoder(ctx)
+	muxer := Args{"-f", "whip"}
+	if len(w.options.Token) > 0 {
+		muxer = append(muxer, "-authorization", w.options.Token)
+	}
+	t.Output(w.options.Endpoint).
+		WithOptions(w.options.encoding()).
+		WithAdditionalOptions(muxer)
+	return t.Start(Options{})
+}
+
+// sdpTimeout is the time ffmpeg has to write the SDP of the RTP streams
+const sdpTimeout = 10 * time.Second
+
+// readSDP waits for the SDP ffmpeg writes to path once the RTP outputs are open
+func readSDP(ctx context.Context, path string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, sdpTimeout)
+	defer cancel()
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if b, err := ioutil.ReadFile(path); err == nil && bytes.Contains(b, []byte("\nm=")) {
+			return string(b), nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return "", fmt.Errorf("ffmpeg wrote no SDP with error %w", ctx.Err())
+		}
+	}
+}
+
+// forwarderAttempt sends RTP to the forwarder, which publishes it with the SDP
+// written by ffmpeg
+func (w *WHIPOutput) forwarderAttempt(parent context.Context, attempt int) (<-chan transcoder.Progress, error) {
+	ctx, cancel := context.WithCancel(parent)
+	video, audio, err := w.options.Forwarder.Listen(ctx)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to listen for RTP with error %w", err)
+	}
+	dir, err := ioutil.TempDir("", "whip")
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	sdpFile := filepath.Join(dir, "session.sdp")
+
+	encoding := w.options.encoding()
+	videoOpts := encoding
+	videoOpts.AudioCodec = nil
+	videoOpts.AudioRate = nil
+	// the options of each output must be a single object, they are matched by index
+	t := w.transcoder(ctx)
+	videoArgs := append(Args{"-map", "0:v:0"}, videoOpts.GetStrArguments()...)
+	// a single SDP describes every RTP output
+	t.Output("rtp://" + video).WithOptions(append(videoArgs, "-f", "rtp", "-sdp_file", sdpFile))
+	if len(audio) > 0 {
+		audioOpts := Options{AudioCodec: encoding.AudioCodec, AudioRate: encoding.AudioRate}
+		audioArgs := append(Args{"-map", "0:a:0?"}, audioOpts.GetStrArguments()...)
+		t.Output("rtp://" + audio).WithAdditionalOptions(append(audioArgs, "-f", "rtp"))
+	}
+	ch, err := t.Start(Options{})
+	if err != nil {
+		cancel()
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	published := make(chan error, 1)
+	go func() {
+		// a failed session ends the attempt
+		defer cancel()
+		sdp, err := readSDP(ctx, sdpFile)
+		if err != nil {
+			published <- err
+			return
+		}
+		published <- w.options.Forwarder.Publish(ctx, w.options.Endpoint, w.options.Token, sdp)
+	}()
+	out := make(chan transcoder.Progress)
+	go func() {
+		defer close(out)
+		defer os.RemoveAll(dir)
+		defer cancel()
+		for msg := range ch {
+			out <- msg
+		}
+		select {
+		case err := <-published:
+			// the session canceled ctx when it failed
+			if err != nil && parent.Err() == nil {
+				out <- Progress{Error: fmt.Errorf("WHIP session failed with error %w", err)}
+			}
+		default:
+		}
+	}()
+	return out, nil
+}
+
+// Start pushes the input until it ends, the context is cancelled or reconnection gives up.
+// Without a Forwarder it returns ErrUnsupportedVersion when ffmpeg has no whip muxer.
+// ConnectionProgress values report the connection state
+func (w *WHIPOutput) Start() (<-chan transcoder.Progress, error) {
+	if err := w.validate(); err != nil {
+		return nil, err
+	}
+	ctx := w.commandContext
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	start := w.forwarderAttempt
+	if w.options.Forwarder == nil {
+		ok, err := hasMuxer(ctx, w.config, "whip")
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("WHIP output requires ffmpeg 8.0 built with the whip muxer, or a Forwarder: %w", ErrUnsupportedVersion)
+		}
+		start = w.muxerAttempt
+	}
+
+	out := make(chan transcoder.Progress)
+	if !w.config.ProgressEnabled {
+		done := make(chan error, 1)
+		go func() {
+			done <- supervise(ctx, w.options.Backoff, start, out, nil)
+			close(out)
+		}()
+		for range out {
+		}
+		return out, <-done
+	}
+	go func() {
+		defer close(out)
+		if err := supervise(ctx, w.options.Backoff, start, out, nil); err != nil {
+			out <- Progress{Error: err}
+		}
+	}()
+	return out, nil
+}
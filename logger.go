@@ -0,0 +1,132 @@
+package transcoder
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Level is the severity of a log record, with the values of log/slog
+type Level int
+
+// Levels
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+// String ...
+func (l Level) String() string {
+	switch {
+	case l < LevelInfo:
+		return "DEBUG"
+	case l < LevelWarn:
+		return "INFO"
+	case l < LevelError:
+		return "WARN"
+	}
+	return "ERROR"
+}
+
+// Logger receives the logs of the library. keyvals are alternating keys and values,
+// as with log/slog, see SlogLogger
+type Logger interface {
+	Log(level Level, msg string, keyvals ...interface{})
+	// With returns a logger adding keyvals to every record
+	With(keyvals ...interface{}) Logger
+}
+
+// stdLogger writes the records of min level and above with the standard log package
+type stdLogger struct {
+	logger  *log.Logger
+	min     Level
+	keyvals []interface{}
+}
+
+// NewStdLogger returns a Logger writing the records of min level and above to l, or
+// to the standard logger when l is nil, as "ERROR message key=value"
+func NewStdLogger(l *log.Logger, min Level) Logger {
+	return &stdLogger{logger: l, min: min}
+}
+
+// Log ...
+func (s *stdLogger) Log(level Level, msg string, keyvals ...interface{}) {
+	if level < s.min {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(level.String())
+	b.WriteByte(' ')
+	b.WriteString(msg)
+	writeKeyvals(&b, s.keyvals)
+	writeKeyvals(&b, keyvals)
+	if s.logger == nil {
+		log.Output(2, b.String())
+		return
+	}
+	s.logger.Output(2, b.String())
+}
+
+// With ...
+func (s *stdLogger) With(keyvals ...interface{}) Logger {
+	return &stdLogger{logger: s.logger, min: s.min, keyvals: append(append([]interface{}{}, s.keyvals...), keyvals...)}
+}
+
+// writeKeyvals appends keyvals to b as key=value pairs, quoting the values with spaces
+func writeKeyvals(b *strings.Builder, keyvals []interface{}) {
+	for i := 0; i < len(keyvals); i += 2 {
+		b.WriteByte(' ')
+		b.WriteString(fmt.Sprint(keyvals[i]))
+		b.WriteByte('=')
+		if i+1 == len(keyvals) {
+			b.WriteString("!MISSING")
+			break
+		}
+		v := fmt.Sprint(keyvals[i+1])
+		if strings.ContainsAny(v, " \t\n\"=") {
+			v = fmt.Sprintf("%q", v)
+		}
+		b.WriteString(v)
+	}
+}
+
+// nopLogger discards the records
+type nopLogger struct{}
+
+// Log ...
+func (nopLogger) Log(Level, string, ...interface{}) {}
+
+// With ...
+func (n nopLogger) With(...interface{}) Logger { return n }
+
+// NopLogger is a Logger discarding the records
+var NopLogger Logger = nopLogger{}
+
+// logFieldsKey is the context key of the log fields
+type logFieldsKey struct{}
+
+// WithLogFields returns a context whose logs carry keyvals, such as the id of the job
+// a transcode runs for, in addition to the fields of ctx
+func WithLogFields(ctx context.Context, keyvals ...interface{}) context.Context {
+	return context.WithValue(ctx, logFieldsKey{}, append(append([]interface{}{}, LogFields(ctx)...), keyvals...))
+}
+
+// LogFields returns the log fields of ctx, which may be nil
+func LogFields(ctx context.Context) []interface{} {
+	if ctx == nil {
+		return nil
+	}
+	keyvals, _ := ctx.Value(logFieldsKey{}).([]interface{})
+	return keyvals
+}
+
+// ContextLogger returns l with the log fields of ctx
+func ContextLogger(ctx context.Context, l Logger) Logger {
+	if keyvals := LogFields(ctx); len(keyvals) > 0 {
+		return l.With(keyvals...)
+	}
+	return l
+}
@@ -0,0 +1,32 @@
+//go:build go1.21
+// +build go1.21
+
+package transcoder
+
+import (
+	"context"
+	"log/slog"
+)
+
+// slogLogger is a Logger writing to a slog.Logger
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+// SlogLogger returns a Logger writing to l, or to slog.Default when l is nil
+func SlogLogger(l *slog.Logger) Logger {
+	if l == nil {
+		l = slog.Default()
+	}
+	return slogLogger{logger: l}
+}
+
+// Log ...
+func (s slogLogger) Log(level Level, msg string, keyvals ...interface{}) {
+	s.logger.Log(context.Background(), slog.Level(level), msg, keyvals...)
+}
+
+// With ...
+func (s slogLogger) With(keyvals ...interface{}) Logger {
+	return slogLogger{logger: s.logger.With(keyvals...)}
+}
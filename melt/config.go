@@ -0,0 +1,42 @@
+package melt
+
+import (
+	"io"
+	"os"
+
+	"github.com/admpub/transcoder"
+)
+
+// Config ...
+type Config struct {
+	MeltBinPath     string
+	ProgressEnabled bool
+	// Verbose copies what melt writes on stderr to VerboseOutput, os.Stdout when it is
+	// nil, progress still being reported
+	Verbose       bool
+	VerboseOutput io.Writer
+	Env           []string
+	Dir           string
+	OnMetadata    func(transcoder.Metadata) error
+	// Admission delays the renderings while too many run or the host is saturated,
+	// transcoder.DefaultAdmission when it is nil
+	Admission *transcoder.Admission
+	// Logger receives the logs, the standard logger receives the errors when it is nil
+	Logger transcoder.Logger
+}
+
+// verboseOutput returns the writer receiving stderr in verbose mode
+func (c *Config) verboseOutput() io.Writer {
+	if c.VerboseOutput != nil {
+		return c.VerboseOutput
+	}
+	return os.Stdout
+}
+
+// admission returns the Admission of the renderings, nil when unlimited
+func (c *Config) admission() *transcoder.Admission {
+	if c.Admission != nil {
+		return c.Admission
+	}
+	return transcoder.DefaultAdmission()
+}
@@ -0,0 +1,334 @@
+package melt
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/admpub/transcoder"
+	"github.com/admpub/transcoder/utils"
+)
+
+// Transcoder renders MLT XML timelines with the melt command line tool
+type Transcoder struct {
+	config           *Config
+	input            string
+	output           []string
+	options          []transcoder.Options
+	metadata         transcoder.Metadata
+	inputPipeReader  io.ReadCloser
+	outputPipeReader io.ReadCloser
+	inputPipeWriter  io.WriteCloser
+	outputPipeWriter io.WriteCloser
+	commandContext   context.Context
+}
+
+// New ...
+func New(cfg *Config) transcoder.Transcoder {
+	return &Transcoder{config: cfg}
+}
+
+// Start ...
+func (t *Transcoder) Start(opts transcoder.Options) (<-chan transcoder.Progress, error) {
+
+	var stderrIn io.ReadCloser
+
+	defer t.closePipes()
+
+	// Validates config
+	if err := t.validate(); err != nil {
+		return nil, err
+	}
+
+	// Get timeline metadata. melt reports its own percentage, so the metadata
+	// is only mandatory when the caller asked to inspect it
+	metadata, err := t.GetMetadata()
+	if err != nil {
+		if t.config.OnMetadata != nil {
+			return nil, err
+		}
+		metadata = nil
+	} else if t.config.OnMetadata != nil {
+		if err := t.config.OnMetadata(metadata); err != nil {
+			return nil, err
+		}
+	}
+
+	// A single melt process feeds exactly one consumer
+	consumer := "avformat"
+	if o, ok := opts.(Options); ok {
+		consumer = o.consumer()
+	} else if o, ok := opts.(*Options); ok && o != nil {
+		consumer = o.consumer()
+	}
+	args := []string{t.input, "-consumer", consumer + ":" + t.output[0]}
+	args = append(args, opts.GetStrArguments()...)
+	for _, o := range t.options {
+		args = append(args, o.GetStrArguments()...)
+	}
+	if t.config.ProgressEnabled {
+		args = append(args, "-progress")
+	}
+
+	release, err := t.config.admission().Acquire(t.commandContext)
+	if err != nil {
+		return nil, fmt.Errorf("failed waiting for admission with error %w", err)
+	}
+
+	// Initialize command
+	var cmd *exec.Cmd
+	if t.commandContext == nil {
+		cmd = exec.Command(t.config.MeltBinPath, args...)
+	} else {
+		cmd = exec.CommandContext(t.commandContext, t.config.MeltBinPath, args...)
+	}
+	cmd.Env = append(t.config.Env, os.Environ()...)
+	cmd.Dir = t.config.Dir
+
+	// If progresss enabled, get stderr pipe and start progress process
+	if t.config.ProgressEnabled {
+		stderrIn, err = cmd.StderrPipe()
+		if err != nil {
+			release()
+			return nil, fmt.Errorf("failed getting rendering progress (%s) with args (%s) with error %w", t.config.MeltBinPath, args, err)
+		}
+	}
+
+	// the progress parser reads stderr, the verbose output gets a copy
+	if t.config.Verbose {
+		if stderrIn != nil {
+			stderrIn = struct {
+				io.Reader
+				io.Closer
+			}{io.TeeReader(stderrIn, t.config.verboseOutput()), stderrIn}
+		} else {
+			cmd.Stderr = t.config.verboseOutput()
+		}
+	}
+
+	// Start process
+	err = cmd.Start()
+	if err != nil {
+		release()
+		return nil, fmt.Errorf("failed starting rendering (%s) with args (%s) with error %w", t.config.MeltBinPath, args, err)
+	}
+
+	out := make(chan transcoder.Progress)
+	if t.config.ProgressEnabled {
+		go func() {
+			defer close(out)
+			// Wait closes stderr, which is read to the end first
+			t.progress(stderrIn, out)
+			err := cmd.Wait()
+			release()
+			if err != nil {
+				err = fmt.Errorf("failed to rendering (%s) with args (%s) with error %w", t.config.MeltBinPath, args, err)
+				t.logger().Log(transcoder.LevelError, "rendering failed", "input", t.input, "error", err)
+				out <- &Progress{Error: err}
+			}
+		}()
+	} else {
+		err = cmd.Wait()
+		release()
+		if err != nil {
+			return nil, fmt.Errorf("failed to rendering (%s) with args (%s) with error %w", t.config.MeltBinPath, args, err)
+		}
+	}
+
+	return out, err
+}
+
+// Input sets the MLT XML timeline (or any other producer melt accepts)
+func (t *Transcoder) Input(arg string) transcoder.Transcoder {
+	t.input = arg
+	return t
+}
+
+// Output sets the consumer target, melt supports a single output per process
+func (t *Transcoder) Output(arg string) transcoder.Transcoder {
+	t.output = append(t.output, arg)
+	return t
+}
+
+// InputPipe ...
+func (t *Transcoder) InputPipe(w io.WriteCloser, r io.ReadCloser) transcoder.Transcoder {
+	if len(t.input) == 0 {
+		t.inputPipeWriter = w
+		t.inputPipeReader = r
+	}
+	return t
+}
+
+// OutputPipe ...
+func (t *Transcoder) OutputPipe(w io.WriteCloser, r io.ReadCloser) transcoder.Transcoder {
+	if len(t.output) == 0 {
+		t.outputPipeWriter = w
+		t.outputPipeReader = r
+	}
+	return t
+}
+
+// WithOptions Sets the options object
+func (t *Transcoder) WithOptions(opts transcoder.Options) transcoder.Transcoder {
+	t.options = []transcoder.Options{opts}
+	return t
+}
+
+// WithAdditionalOptions Appends an additional options object
+func (t *Transcoder) WithAdditionalOptions(opts transcoder.Options) transcoder.Transcoder {
+	t.options = append(t.options, opts)
+	return t
+}
+
+// WithContext is to be used on a Transcoder *before Starting* to
+// pass in a context.Context object that can be used to kill
+// a running melt process. Usage of this method is optional
+func (t *Transcoder) WithContext(ctx context.Context) transcoder.Transcoder {
+	t.commandContext = ctx
+	return t
+}
+
+// validate ...
+func (t *Transcoder) validate() error {
+	if t.config.MeltBinPath == "" {
+		return errors.New("melt binary path not found")
+	}
+
+	if t.input == "" {
+		return errors.New("missing input option")
+	}
+
+	outputLength := len(t.output)
+
+	if outputLength == 0 {
+		return errors.New("missing output option")
+	}
+
+	if outputLength > 1 {
+		return errors.New("melt supports a single output per process")
+	}
+
+	if t.output[0] == "" {
+		return errors.New("output at index 0 is an empty string")
+	}
+
+	return nil
+}
+
+// GetMetadata Returns the profile and length of the input MLT XML timeline
+func (t *Transcoder) GetMetadata() (transcoder.Metadata, error) {
+	input := t.input
+	if len(t.config.Dir) > 0 && !filepath.IsAbs(input) {
+		input = filepath.Join(t.config.Dir, input)
+	}
+
+	metadata, err := readMetadata(input)
+	if err != nil {
+		return nil, err
+	}
+
+	t.metadata = metadata
+
+	return metadata, nil
+}
+
+var reProgress = regexp.MustCompile(`Current Frame:\s*(\d+),\s*percentage:\s*(\d+)`)
+
+// progress sends through given channel the rendering status
+func (t *Transcoder) progress(stream io.ReadCloser, out chan transcoder.Progress) {
+
+	defer stream.Close()
+
+	split := func(data []byte, atEOF bool) (advance int, token []byte, spliterror error) {
+		if atEOF && len(data) == 0 {
+			return 0, nil, nil
+		}
+		if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+			return i + 1, data[0:i], nil
+		}
+		if atEOF {
+			return len(data), data, nil
+		}
+
+		return 0, nil, nil
+	}
+
+	scanner := bufio.NewScanner(stream)
+	scanner.Split(split)
+
+	var fps float64
+	var total float64
+	if t.metadata != nil {
+		if streams := t.metadata.GetStreams(); len(streams) > 0 {
+			fps = utils.ParseRate(streams[0].GetAvgFrameRate())
+			total = float64(streams[0].GetDurationTs())
+		}
+	}
+
+	var errMessages []string
+	last := -1
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		m := reProgress.FindStringSubmatch(line)
+		if m == nil {
+			if strings.HasPrefix(line, "Failed") || strings.Contains(line, "[error]") {
+				errMessages = append(errMessages, line)
+			}
+			continue
+		}
+
+		frame, _ := strconv.Atoi(m[1])
+		if frame == last {
+			continue
+		}
+		last = frame
+
+		percentage, _ := strconv.ParseFloat(m[2], 64)
+		if total > 0 {
+			percentage = float64(frame) * 100 / total
+		}
+
+		progress := Progress{
+			FramesProcessed: m[1],
+			Progress:        percentage,
+		}
+		if fps > 0 {
+			progress.CurrentTime = utils.SecToDur(float64(frame) / fps)
+		}
+
+		out <- progress
+	}
+	if len(errMessages) > 0 {
+		out <- &Progress{Error: errors.New(strings.Join(errMessages, "\n"))}
+	}
+}
+
+// closePipes Closes pipes if opened
+func (t *Transcoder) closePipes() {
+	if t.inputPipeReader != nil {
+		t.inputPipeReader.Close()
+	}
+
+	if t.outputPipeWriter != nil {
+		t.outputPipeWriter.Close()
+	}
+}
+
+// logger returns the logger of the config with the log fields of the context
+func (t *Transcoder) logger() transcoder.Logger {
+	l := t.config.Logger
+	if l == nil {
+		l = transcoder.NewStdLogger(nil, transcoder.LevelInfo)
+	}
+	return transcoder.ContextLogger(t.commandContext, l)
+}
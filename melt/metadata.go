@@ -0,0 +1,153 @@
+package melt
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/admpub/transcoder/ffmpeg"
+	"github.com/admpub/transcoder/utils"
+)
+
+// mltDocument is the subset of an MLT XML timeline needed to describe the render
+type mltDocument struct {
+	XMLName  xml.Name     `xml:"mlt"`
+	Producer string       `xml:"producer,attr"`
+	Profile  mltProfile   `xml:"profile"`
+	Tractors []mltService `xml:"tractor"`
+	Lists    []mltService `xml:"playlist"`
+}
+
+type mltProfile struct {
+	Description  string `xml:"description,attr"`
+	Width        int    `xml:"width,attr"`
+	Height       int    `xml:"height,attr"`
+	FrameRateNum int    `xml:"frame_rate_num,attr"`
+	FrameRateDen int    `xml:"frame_rate_den,attr"`
+	DisplayNum   int    `xml:"display_aspect_num,attr"`
+	DisplayDen   int    `xml:"display_aspect_den,attr"`
+	SampleNum    int    `xml:"sample_aspect_num,attr"`
+	SampleDen    int    `xml:"sample_aspect_den,attr"`
+	Colorspace   int    `xml:"colorspace,attr"`
+	Progressive  int    `xml:"progressive,attr"`
+}
+
+type mltService struct {
+	ID  string `xml:"id,attr"`
+	In  string `xml:"in,attr"`
+	Out string `xml:"out,attr"`
+}
+
+// fps returns the profile frame rate
+func (p mltProfile) fps() float64 {
+	if p.FrameRateNum <= 0 || p.FrameRateDen <= 0 {
+		return 0
+	}
+	return float64(p.FrameRateNum) / float64(p.FrameRateDen)
+}
+
+// main returns the service rendered by melt: the one named by the producer
+// attribute, otherwise the last tractor (or playlist) in the document
+func (d mltDocument) main() *mltService {
+	for _, list := range [][]mltService{d.Tractors, d.Lists} {
+		for i := range list {
+			if len(d.Producer) > 0 && list[i].ID == d.Producer {
+				return &list[i]
+			}
+		}
+	}
+	if n := len(d.Tractors); n > 0 {
+		return &d.Tractors[n-1]
+	}
+	if n := len(d.Lists); n > 0 {
+		return &d.Lists[n-1]
+	}
+	return nil
+}
+
+// frames converts an MLT position (frame count or HH:MM:SS.mmm clock) to a frame number
+func frames(pos string, fps float64) (int, bool) {
+	pos = strings.TrimSpace(pos)
+	if len(pos) == 0 {
+		return 0, false
+	}
+	if n, err := strconv.Atoi(pos); err == nil {
+		return n, true
+	}
+	if fps <= 0 {
+		return 0, false
+	}
+	// MLT also accepts HH:MM:SS;FF (SMPTE drop-frame) but clock values are by far the most common
+	sec := utils.DurToSec(strings.Replace(pos, ",", ".", 1))
+	if sec <= 0 {
+		return 0, false
+	}
+	return int(sec*fps + 0.5), true
+}
+
+// parseMetadata reads an MLT XML timeline and describes it with ffmpeg metadata types
+func parseMetadata(filename string, r io.Reader) (ffmpeg.Metadata, error) {
+	var doc mltDocument
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return ffmpeg.Metadata{}, fmt.Errorf("failed parsing MLT XML (%s): %w", filename, err)
+	}
+
+	fps := doc.Profile.fps()
+	metadata := ffmpeg.Metadata{
+		Format: ffmpeg.Format{
+			Filename:       filename,
+			NbStreams:      1,
+			FormatName:     "mlt",
+			FormatLongName: "MLT XML",
+		},
+	}
+
+	stream := ffmpeg.Streams{
+		CodecType:   "video",
+		Width:       doc.Profile.Width,
+		Height:      doc.Profile.Height,
+		CodedWidth:  doc.Profile.Width,
+		CodedHeight: doc.Profile.Height,
+	}
+	if fps > 0 {
+		stream.AvgFrameRate = fmt.Sprintf("%d/%d", doc.Profile.FrameRateNum, doc.Profile.FrameRateDen)
+		stream.RFrameRrate = stream.AvgFrameRate
+		stream.TimeBase = fmt.Sprintf("%d/%d", doc.Profile.FrameRateDen, doc.Profile.FrameRateNum)
+	}
+	if doc.Profile.DisplayNum > 0 && doc.Profile.DisplayDen > 0 {
+		stream.DisplayAspectRatio = fmt.Sprintf("%d:%d", doc.Profile.DisplayNum, doc.Profile.DisplayDen)
+	}
+	if doc.Profile.SampleNum > 0 && doc.Profile.SampleDen > 0 {
+		stream.SampleAspectRatio = fmt.Sprintf("%d:%d", doc.Profile.SampleNum, doc.Profile.SampleDen)
+	}
+
+	if main := doc.main(); main != nil && fps > 0 {
+		if out, ok := frames(main.Out, fps); ok {
+			in, _ := frames(main.In, fps)
+			count := out - in + 1
+			stream.DurationTs = count
+			stream.Duration = strconv.FormatFloat(float64(count)/fps, 'f', 6, 64)
+			metadata.Format.Duration = stream.Duration
+		}
+	}
+
+	metadata.Streams = []ffmpeg.Streams{stream}
+	return metadata, nil
+}
+
+// readMetadata parses the MLT XML file at path
+func readMetadata(path string) (ffmpeg.Metadata, error) {
+	if len(path) == 0 {
+		return ffmpeg.Metadata{}, errors.New("missing input option")
+	}
+	fp, err := os.Open(path)
+	if err != nil {
+		return ffmpeg.Metadata{}, err
+	}
+	defer fp.Close()
+	return parseMetadata(path, fp)
+}
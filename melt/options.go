@@ -0,0 +1,83 @@
+package melt
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Options defines allowed melt arguments.
+// Fields tagged with `prop` are set on the consumer as name=value pairs,
+// fields tagged with `flag` are passed to melt itself.
+type Options struct {
+	Consumer     *string // defaults to "avformat"
+	Profile      *string `flag:"-profile"`
+	Threads      *int    `prop:"threads"`
+	RealTime     *int    `prop:"real_time"`
+	OutputFormat *string `prop:"f"`
+	VideoCodec   *string `prop:"vcodec"`
+	VideoBitRate *string `prop:"vb"`
+	AudioCodec   *string `prop:"acodec"`
+	AudioBitrate *string `prop:"ab"`
+	AudioRate    *int    `prop:"ar"`
+	Channels     *int    `prop:"channels"`
+	Width        *int    `prop:"width"`
+	Height       *int    `prop:"height"`
+	FrameRateNum *int    `prop:"frame_rate_num"`
+	FrameRateDen *int    `prop:"frame_rate_den"`
+	Preset       *string `prop:"preset"`
+	Crf          *int    `prop:"crf"`
+	Properties   map[string]interface{}
+}
+
+// GetStrArguments returns the consumer properties followed by the melt flags
+func (opts Options) GetStrArguments() []string {
+	f := reflect.TypeOf(opts)
+	v := reflect.ValueOf(opts)
+
+	props := []string{}
+	flags := []string{}
+
+	for i := 0; i < f.NumField(); i++ {
+		prop := f.Field(i).Tag.Get("prop")
+		flag := f.Field(i).Tag.Get("flag")
+		rv := v.Field(i)
+		value := rv.Interface()
+
+		if rv.IsNil() {
+			continue
+		}
+
+		if vm, ok := value.(map[string]interface{}); ok {
+			for k, v := range vm {
+				props = append(props, fmt.Sprintf("%s=%v", k, v))
+			}
+			continue
+		}
+
+		var str string
+		switch vv := value.(type) {
+		case *string:
+			str = *vv
+		case *int:
+			str = fmt.Sprintf("%d", *vv)
+		default:
+			continue
+		}
+
+		if len(prop) > 0 {
+			props = append(props, prop+"="+str)
+		} else if len(flag) > 0 {
+			flags = append(flags, flag, str)
+		}
+	}
+
+	return append(props, flags...)
+}
+
+// consumer returns the consumer service name
+func (opts Options) consumer() string {
+	if opts.Consumer != nil && len(*opts.Consumer) > 0 {
+		return *opts.Consumer
+	}
+	return "avformat"
+}
@@ -0,0 +1,41 @@
+package melt
+
+// Progress ...
+type Progress struct {
+	FramesProcessed string
+	CurrentTime     string
+	CurrentBitrate  string
+	Progress        float64
+	Speed           string
+	Error           error
+}
+
+// GetFramesProcessed ...
+func (p Progress) GetFramesProcessed() string {
+	return p.FramesProcessed
+}
+
+// GetCurrentTime ...
+func (p Progress) GetCurrentTime() string {
+	return p.CurrentTime
+}
+
+// GetCurrentBitrate melt does not report bitrate, so this is always empty
+func (p Progress) GetCurrentBitrate() string {
+	return p.CurrentBitrate
+}
+
+// GetProgress ...
+func (p Progress) GetProgress() float64 {
+	return p.Progress
+}
+
+// GetSpeed melt does not report speed, so this is always empty
+func (p Progress) GetSpeed() string {
+	return p.Speed
+}
+
+// GetError ...
+func (p Progress) GetError() error {
+	return p.Error
+}
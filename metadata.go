@@ -53,11 +53,17 @@ type Streams interface {
 	GetBitRate() string
 	GetTags() map[string]string
 	GetSideDataList() []map[string]interface{}
+	GetClosedCaptions() int
+	GetSampleRate() string
+	GetChannels() int
+	GetChannelLayout() string
+	GetFieldOrder() string
 }
 
 // Tags ...
 type Tags interface {
 	GetEncoder() string
+	GetTimecode() string
 }
 
 // Disposition ...
@@ -0,0 +1,156 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/admpub/transcoder"
+	"github.com/admpub/transcoder/ffmpeg"
+)
+
+// rawOptions is a transcoder.Options made of raw arguments
+type rawOptions []string
+
+// GetStrArguments ...
+func (o rawOptions) GetStrArguments() []string {
+	return o
+}
+
+// param returns a required parameter of step
+func param(step StepSpec, name string) (string, error) {
+	v := step.Params[name]
+	if len(v) == 0 {
+		return "", fmt.Errorf("pipeline: step %q needs the %s parameter", step.Name, name)
+	}
+	return v, nil
+}
+
+// DefaultActions returns the built-in actions running ffmpeg with cfg:
+//
+//	ffmpeg    transcodes params input to params output with args
+//	probe     stores the metadata of params input under the step name
+//	qc        runs ffmpeg.QC on params input with the comma separated params checks,
+//	          failing when a check fails, and stores the report under the step name
+//	loudness  measures params input, failing outside the params min and max LUFS,
+//	          and stores the measure under the step name
+//	webhook   posts the step params and the "result" of the workspace to params url
+func DefaultActions(cfg *ffmpeg.Config) map[string]Action {
+	return map[string]Action{
+		"ffmpeg": func(ctx context.Context, ws *Workspace, step StepSpec) error {
+			input, err := param(step, "input")
+			if err != nil {
+				return err
+			}
+			output, err := param(step, "output")
+			if err != nil {
+				return err
+			}
+			progress, err := ffmpeg.New(cfg).Input(input).Output(output).WithContext(ctx).Start(rawOptions(step.Args))
+			if err != nil {
+				return err
+			}
+			for p := range progress {
+				if p.GetError() != nil {
+					err = p.GetError()
+				}
+			}
+			return err
+		},
+		"probe": func(ctx context.Context, ws *Workspace, step StepSpec) error {
+			input, err := param(step, "input")
+			if err != nil {
+				return err
+			}
+			metadata, err := ffmpeg.New(cfg).Input(input).WithContext(ctx).GetMetadata()
+			if err != nil {
+				return err
+			}
+			ws.Set(step.Name, metadata)
+			return nil
+		},
+		"qc": func(ctx context.Context, ws *Workspace, step StepSpec) error {
+			input, err := param(step, "input")
+			if err != nil {
+				return err
+			}
+			var opts ffmpeg.QCOptions
+			if checks := step.Params["checks"]; len(checks) > 0 {
+				for _, c := range strings.Split(checks, ",") {
+					opts.Checks = append(opts.Checks, strings.TrimSpace(c))
+				}
+			}
+			report, err := ffmpeg.QC(ctx, cfg, input, opts)
+			if err != nil {
+				return err
+			}
+			ws.Set(step.Name, report)
+			if !report.Passed {
+				var failed []string
+				for _, r := range report.Results {
+					if !r.Passed && !r.Skipped {
+						failed = append(failed, r.Check)
+					}
+				}
+				return fmt.Errorf("qc of %s failed: %s", input, strings.Join(failed, ", "))
+			}
+			return nil
+		},
+		"loudness": func(ctx context.Context, ws *Workspace, step StepSpec) error {
+			input, err := param(step, "input")
+			if err != nil {
+				return err
+			}
+			loudness, err := ffmpeg.MeasureLoudness(ctx, cfg, input)
+			if err != nil {
+				return err
+			}
+			ws.Set(step.Name, loudness)
+			if v := step.Params["min"]; len(v) > 0 {
+				if min, err := strconv.ParseFloat(v, 64); err != nil || loudness.Integrated < min {
+					return fmt.Errorf("loudness of %s is %.1f LUFS, under %s", input, loudness.Integrated, v)
+				}
+			}
+			if v := step.Params["max"]; len(v) > 0 {
+				if max, err := strconv.ParseFloat(v, 64); err != nil || loudness.Integrated > max {
+					return fmt.Errorf("loudness of %s is %.1f LUFS, over %s", input, loudness.Integrated, v)
+				}
+			}
+			return nil
+		},
+		"webhook": func(ctx context.Context, ws *Workspace, step StepSpec) error {
+			url, err := param(step, "url")
+			if err != nil {
+				return err
+			}
+			result, _ := ws.Get("result")
+			body, err := json.Marshal(map[string]interface{}{"step": step.Name, "params": step.Params, "result": result})
+			if err != nil {
+				return err
+			}
+			req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+			if err != nil {
+				return err
+			}
+			req.Header.Set("Content-Type", "application/json")
+			client := &http.Client{Timeout: 10 * time.Second}
+			resp, err := client.Do(req.WithContext(ctx))
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			if resp.StatusCode/100 != 2 {
+				return errors.New("webhook " + url + " answered " + resp.Status)
+			}
+			return nil
+		},
+	}
+}
+
+var _ transcoder.Options = rawOptions(nil)
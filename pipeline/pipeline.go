@@ -0,0 +1,311 @@
+// Package pipeline runs media workflows declared as steps with dependencies, such as
+// probe, audio normalization, ladder encode, packaging and thumbnails, with as many
+// steps running at once as their dependencies allow.
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Step states
+const (
+	StatePending   = "pending"
+	StateSucceeded = "succeeded"
+	StateFailed    = "failed"
+	// StateSkipped is the state of the steps depending on a failed step
+	StateSkipped  = "skipped"
+	StateCanceled = "canceled"
+)
+
+// ErrFailed is returned by Run when a required step failed
+var ErrFailed = errors.New("pipeline: step failed")
+
+// Step is a unit of work of a pipeline
+type Step struct {
+	Name string
+	// DependsOn are the names of the steps that must succeed first
+	DependsOn []string
+	Run       func(ctx context.Context, ws *Workspace) error
+	// Optional steps may fail without skipping their dependents or failing the pipeline
+	Optional bool
+}
+
+// StepResult is the outcome of a step
+type StepResult struct {
+	Name     string        `json:"name"`
+	State    string        `json:"state"`
+	Error    string        `json:"error,omitempty"`
+	Started  time.Time     `json:"started,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Result is the outcome of a pipeline run, steps being in declaration order
+type Result struct {
+	Steps []StepResult `json:"steps"`
+}
+
+// Step returns the result of the named step
+func (r *Result) Step(name string) (StepResult, bool) {
+	for _, s := range r.Steps {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return StepResult{}, false
+}
+
+// Workspace is shared by the steps of a run: a directory for their files and values
+// for their results
+type Workspace struct {
+	Dir    string
+	temp   bool
+	mu     sync.RWMutex
+	values map[string]interface{}
+}
+
+// NewWorkspace returns a workspace in dir, created when missing. An empty dir creates
+// a temporary directory that Cleanup removes
+func NewWorkspace(dir string) (*Workspace, error) {
+	ws := &Workspace{Dir: dir, values: map[string]interface{}{}}
+	if len(dir) == 0 {
+		d, err := ioutil.TempDir("", "pipeline")
+		if err != nil {
+			return nil, err
+		}
+		ws.Dir, ws.temp = d, true
+	} else if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	return ws, nil
+}
+
+// Path returns the path of name in the workspace directory
+func (ws *Workspace) Path(name ...string) string {
+	return filepath.Join(append([]string{ws.Dir}, name...)...)
+}
+
+// Set stores a value for the following steps
+func (ws *Workspace) Set(key string, value interface{}) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.values[key] = value
+}
+
+// Get returns a value stored by a previous step
+func (ws *Workspace) Get(key string) (interface{}, bool) {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+	v, ok := ws.values[key]
+	return v, ok
+}
+
+// Cleanup removes the directory of a temporary workspace
+func (ws *Workspace) Cleanup() error {
+	if !ws.temp {
+		return nil
+	}
+	return os.RemoveAll(ws.Dir)
+}
+
+// Pipeline is a set of steps forming a directed acyclic graph
+type Pipeline struct {
+	steps []Step
+	index map[string]int
+	// Concurrency limits the steps running at once, 0 for no limit
+	Concurrency int
+	// FailFast cancels the running steps on the first failure of a required step,
+	// otherwise the branches that do not depend on it go on
+	FailFast bool
+}
+
+// New returns an empty pipeline
+func New() *Pipeline {
+	return &Pipeline{index: map[string]int{}}
+}
+
+// Add declares a step, its dependencies may be declared later
+func (p *Pipeline) Add(step Step) error {
+	if len(step.Name) == 0 || step.Run == nil {
+		return errors.New("pipeline: step needs a name and a run function")
+	}
+	if _, ok := p.index[step.Name]; ok {
+		return fmt.Errorf("pipeline: duplicate step %q", step.Name)
+	}
+	p.index[step.Name] = len(p.steps)
+	p.steps = append(p.steps, step)
+	return nil
+}
+
+// Validate checks that dependencies exist and are free of cycles
+func (p *Pipeline) Validate() error {
+	for _, s := range p.steps {
+		for _, d := range s.DependsOn {
+			if _, ok := p.index[d]; !ok {
+				return fmt.Errorf("pipeline: step %q depends on unknown step %q", s.Name, d)
+			}
+		}
+	}
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	marks := make([]int, len(p.steps))
+	var path []string
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch marks[i] {
+		case visiting:
+			return fmt.Errorf("pipeline: dependency cycle %s -> %s", strings.Join(path, " -> "), p.steps[i].Name)
+		case visited:
+			return nil
+		}
+		marks[i] = visiting
+		path = append(path, p.steps[i].Name)
+		deps := append([]string{}, p.steps[i].DependsOn...)
+		sort.Strings(deps)
+		for _, d := range deps {
+			if err := visit(p.index[d]); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		marks[i] = visited
+		return nil
+	}
+	for i := range p.steps {
+		if err := visit(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run executes the steps in ws, each one as soon as its dependencies succeeded. Steps
+// depending on a failed required step are skipped. It returns ErrFailed when a required
+// step failed, and ctx.Err() when ctx is done before the end
+func (p *Pipeline) Run(ctx context.Context, ws *Workspace) (*Result, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	if ws == nil {
+		return nil, errors.New("pipeline: missing workspace")
+	}
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	n := len(p.steps)
+	results := make([]StepResult, n)
+	remaining := make([]int, n)
+	dependents := make([][]int, n)
+	for i, s := range p.steps {
+		results[i] = StepResult{Name: s.Name, State: StatePending}
+		remaining[i] = len(s.DependsOn)
+		for _, d := range s.DependsOn {
+			dependents[p.index[d]] = append(dependents[p.index[d]], i)
+		}
+	}
+
+	type outcome struct {
+		step int
+		err  error
+	}
+	done := make(chan outcome)
+	var slots chan struct{}
+	if p.Concurrency > 0 {
+		slots = make(chan struct{}, p.Concurrency)
+	}
+	running, failed := 0, false
+	start := func(i int) {
+		running++
+		go func() {
+			if slots != nil {
+				select {
+				case slots <- struct{}{}:
+					defer func() { <-slots }()
+				case <-runCtx.Done():
+					done <- outcome{i, runCtx.Err()}
+					return
+				}
+			}
+			results[i].Started = time.Now()
+			err := runCtx.Err()
+			if err == nil {
+				err = p.steps[i].Run(runCtx, ws)
+			}
+			results[i].Duration = time.Since(results[i].Started)
+			done <- outcome{i, err}
+		}()
+	}
+	// skip marks the pending dependents of a failed step, recursively
+	var skip func(i int, cause string)
+	skip = func(i int, cause string) {
+		for _, d := range dependents[i] {
+			if results[d].State != StatePending {
+				continue
+			}
+			results[d].State, results[d].Error = StateSkipped, "dependency "+cause+" failed"
+			skip(d, cause)
+		}
+	}
+
+	for i := range p.steps {
+		if remaining[i] == 0 {
+			start(i)
+		}
+	}
+	for running > 0 {
+		o := <-done
+		running--
+		s := p.steps[o.step]
+		switch {
+		case o.err == nil:
+			results[o.step].State = StateSucceeded
+		case runCtx.Err() != nil:
+			results[o.step].State, results[o.step].Error = StateCanceled, o.err.Error()
+		default:
+			results[o.step].State, results[o.step].Error = StateFailed, o.err.Error()
+		}
+		if results[o.step].State == StateCanceled {
+			// the dependents end up canceled too
+			continue
+		}
+		if results[o.step].State == StateFailed && !s.Optional {
+			failed = true
+			if p.FailFast {
+				cancel()
+			}
+			skip(o.step, s.Name)
+			continue
+		}
+		for _, d := range dependents[o.step] {
+			remaining[d]--
+			if remaining[d] == 0 && results[d].State == StatePending {
+				start(d)
+			}
+		}
+	}
+	for i := range results {
+		// steps never started because of a cancellation
+		if results[i].State == StatePending {
+			results[i].State = StateCanceled
+		}
+	}
+	result := &Result{Steps: results}
+	if ctx.Err() != nil {
+		return result, ctx.Err()
+	}
+	if failed {
+		return result, ErrFailed
+	}
+	return result, nil
+}
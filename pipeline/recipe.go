@@ -0,0 +1,203 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// StepSpec declares a step of a recipe
+type StepSpec struct {
+	Name string `json:"name" yaml:"name"`
+	// Action is the name of the Action running the step
+	Action string `json:"action" yaml:"action"`
+	// DependsOn adds dependencies to the steps of the previous section
+	DependsOn []string `json:"depends_on,omitempty" yaml:"depends_on,omitempty"`
+	Optional  bool     `json:"optional,omitempty" yaml:"optional,omitempty"`
+	// Params and Args may reference the recipe inputs as ${name}, and ${workspace}
+	Params map[string]string `json:"params,omitempty" yaml:"params,omitempty"`
+	Args   []string          `json:"args,omitempty" yaml:"args,omitempty"`
+}
+
+// Recipe is a declarative workflow. The steps of a section depend on every step of the
+// previous non-empty section: analysis gates the outputs, which are packaged, then the
+// notifications run whatever the outcome
+type Recipe struct {
+	Name string `json:"name" yaml:"name"`
+	// Inputs are the default values of the inputs, overridden by the values given to
+	// RunRecipe
+	Inputs    map[string]string `json:"inputs,omitempty" yaml:"inputs,omitempty"`
+	Analysis  []StepSpec        `json:"analysis,omitempty" yaml:"analysis,omitempty"`
+	Outputs   []StepSpec        `json:"outputs,omitempty" yaml:"outputs,omitempty"`
+	Packaging []StepSpec        `json:"packaging,omitempty" yaml:"packaging,omitempty"`
+	// Notifications also reference ${status}, succeeded or failed
+	Notifications []StepSpec `json:"notifications,omitempty" yaml:"notifications,omitempty"`
+}
+
+// Action runs a step of a recipe, step holding its expanded parameters
+type Action func(ctx context.Context, ws *Workspace, step StepSpec) error
+
+// ParseRecipe decodes a recipe with unmarshal, such as yaml.Unmarshal of a YAML
+// library, JSON being decoded when it is nil
+func ParseRecipe(data []byte, unmarshal func([]byte, interface{}) error) (*Recipe, error) {
+	if unmarshal == nil {
+		unmarshal = json.Unmarshal
+	}
+	r := &Recipe{}
+	if err := unmarshal(data, r); err != nil {
+		return nil, fmt.Errorf("failed to parse recipe with error %w", err)
+	}
+	return r, nil
+}
+
+// LoadRecipe reads a recipe file, .yaml and .yml files being decoded with
+// yamlUnmarshal and the others as JSON
+func LoadRecipe(path string, yamlUnmarshal func([]byte, interface{}) error) (*Recipe, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if yamlUnmarshal == nil {
+			return nil, errors.New("pipeline: no YAML decoder for " + path)
+		}
+		return ParseRecipe(data, yamlUnmarshal)
+	}
+	return ParseRecipe(data, nil)
+}
+
+// expand replaces the ${name} references of s with vars
+func expand(s string, vars map[string]string, missing map[string]bool) string {
+	return os.Expand(s, func(name string) string {
+		v, ok := vars[name]
+		if !ok {
+			missing[name] = true
+		}
+		return v
+	})
+}
+
+// expandStep returns step with its references replaced
+func expandStep(step StepSpec, vars map[string]string, missing map[string]bool) StepSpec {
+	params := make(map[string]string, len(step.Params))
+	for k, v := range step.Params {
+		params[k] = expand(v, vars, missing)
+	}
+	args := make([]string, len(step.Args))
+	for i, a := range step.Args {
+		args[i] = expand(a, vars, missing)
+	}
+	step.Params, step.Args = params, args
+	return step
+}
+
+// Build returns the pipeline of the analysis, output and packaging steps of r, with
+// vars overriding its inputs
+func (r *Recipe) Build(ws *Workspace, actions map[string]Action, vars map[string]string) (*Pipeline, error) {
+	values := r.values(ws, vars)
+	missing := map[string]bool{}
+	p := New()
+	var previous []string
+	for _, section := range [][]StepSpec{r.Analysis, r.Outputs, r.Packaging} {
+		var names []string
+		for _, spec := range section {
+			action, ok := actions[spec.Action]
+			if !ok {
+				return nil, fmt.Errorf("pipeline: step %q has unknown action %q", spec.Name, spec.Action)
+			}
+			spec := expandStep(spec, values, missing)
+			err := p.Add(Step{
+				Name:      spec.Name,
+				DependsOn: append(append([]string{}, previous...), spec.DependsOn...),
+				Optional:  spec.Optional,
+				Run: func(ctx context.Context, ws *Workspace) error {
+					return action(ctx, ws, spec)
+				},
+			})
+			if err != nil {
+				return nil, err
+			}
+			names = append(names, spec.Name)
+		}
+		if len(names) > 0 {
+			previous = names
+		}
+	}
+	for _, spec := range r.Notifications {
+		if _, ok := actions[spec.Action]; !ok {
+			return nil, fmt.Errorf("pipeline: notification %q has unknown action %q", spec.Name, spec.Action)
+		}
+	}
+	if len(missing) > 0 {
+		var names []string
+		for name := range missing {
+			if name != "status" {
+				names = append(names, name)
+			}
+		}
+		if len(names) > 0 {
+			sort.Strings(names)
+			return nil, fmt.Errorf("pipeline: recipe %q misses inputs %s", r.Name, strings.Join(names, ", "))
+		}
+	}
+	return p, p.Validate()
+}
+
+// values returns the inputs of r overridden by vars
+func (r *Recipe) values(ws *Workspace, vars map[string]string) map[string]string {
+	values := map[string]string{"workspace": ws.Dir}
+	for k, v := range r.Inputs {
+		values[k] = v
+	}
+	for k, v := range vars {
+		values[k] = v
+	}
+	return values
+}
+
+// RecipeOptions configures RunRecipe
+type RecipeOptions struct {
+	// Vars override the inputs of the recipe
+	Vars map[string]string
+	// Concurrency and FailFast configure the pipeline, see Pipeline
+	Concurrency int
+	FailFast    bool
+}
+
+// RunRecipe runs r in ws with the given actions, see DefaultActions, then its
+// notifications. The Result of the pipeline is stored in ws under "result" for them
+func RunRecipe(ctx context.Context, r *Recipe, ws *Workspace, actions map[string]Action, opts RecipeOptions) (*Result, error) {
+	p, err := r.Build(ws, actions, opts.Vars)
+	if err != nil {
+		return nil, err
+	}
+	p.Concurrency, p.FailFast = opts.Concurrency, opts.FailFast
+	result, err := p.Run(ctx, ws)
+	if result == nil {
+		return nil, err
+	}
+	status := StateSucceeded
+	if err != nil {
+		status = StateFailed
+	}
+	ws.Set("result", result)
+	values := r.values(ws, opts.Vars)
+	values["status"] = status
+	missing := map[string]bool{}
+	for _, spec := range r.Notifications {
+		spec = expandStep(spec, values, missing)
+		n := StepResult{Name: spec.Name, State: StateSucceeded}
+		if nerr := actions[spec.Action](ctx, ws, spec); nerr != nil {
+			n.State, n.Error = StateFailed, nerr.Error()
+		}
+		result.Steps = append(result.Steps, n)
+	}
+	return result, err
+}
@@ -0,0 +1,138 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Publisher publishes a message on an event bus. key orders the messages of a job,
+// it is the message key with Kafka and can be ignored with NATS JetStream whose
+// subjects are ordered. Adapt a client with PublisherFunc, e.g. with nats.go:
+//
+//	queue.PublisherFunc(func(ctx context.Context, topic, key string, data []byte) error {
+//		_, err := js.Publish(topic, data, nats.Context(ctx))
+//		return err
+//	})
+//
+// or with kafka-go:
+//
+//	queue.PublisherFunc(func(ctx context.Context, topic, key string, data []byte) error {
+//		return writer.WriteMessages(ctx, kafka.Message{Topic: topic, Key: []byte(key), Value: data})
+//	})
+type Publisher interface {
+	Publish(ctx context.Context, topic, key string, data []byte) error
+}
+
+// PublisherFunc is a function implementing Publisher
+type PublisherFunc func(ctx context.Context, topic, key string, data []byte) error
+
+// Publish ...
+func (f PublisherFunc) Publish(ctx context.Context, topic, key string, data []byte) error {
+	return f(ctx, topic, key, data)
+}
+
+// Subscriber consumes the messages of a topic until ctx is done, acknowledging a
+// message once handle returns nil and redelivering it otherwise, as a JetStream pull
+// consumer or a Kafka consumer group committing offsets does
+type Subscriber interface {
+	Subscribe(ctx context.Context, topic string, handle func(ctx context.Context, data []byte) error) error
+}
+
+// SubscriberFunc is a function implementing Subscriber
+type SubscriberFunc func(ctx context.Context, topic string, handle func(ctx context.Context, data []byte) error) error
+
+// Subscribe ...
+func (f SubscriberFunc) Subscribe(ctx context.Context, topic string, handle func(ctx context.Context, data []byte) error) error {
+	return f(ctx, topic, handle)
+}
+
+// EventBusConfig ...
+type EventBusConfig struct {
+	Publisher Publisher
+	// Topic receives the events, defaults to transcoder.jobs
+	Topic string
+	// TopicPerType publishes each event type on Topic followed by the type, such as
+	// transcoder.jobs.job.completed, for NATS subjects filtered by wildcards
+	TopicPerType bool
+	// Milestones are the progress percentages publishing a job.progress event, see
+	// EventCallbacks
+	Milestones []float64
+	// Timeout bounds each publication, defaults to 5s
+	Timeout time.Duration
+	// OnError receives the events that could not be published
+	OnError func(event Event, err error)
+}
+
+// EventBus publishes job events on an event bus, from the goroutines running the jobs
+// so that events are published in order
+type EventBus struct {
+	config EventBusConfig
+}
+
+// NewEventBus ...
+func NewEventBus(config EventBusConfig) (*EventBus, error) {
+	if config.Publisher == nil {
+		return nil, errors.New("queue: event bus needs a publisher")
+	}
+	if len(config.Topic) == 0 {
+		config.Topic = "transcoder.jobs"
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 5 * time.Second
+	}
+	return &EventBus{config: config}, nil
+}
+
+// Publish publishes an event
+func (b *EventBus) Publish(event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	topic := b.config.Topic
+	if b.config.TopicPerType {
+		topic += "." + event.Type
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), b.config.Timeout)
+	defer cancel()
+	if err := b.config.Publisher.Publish(ctx, topic, event.Job.ID, data); err != nil {
+		return fmt.Errorf("failed to publish %s of job %s with error %w", event.Type, event.Job.ID, err)
+	}
+	return nil
+}
+
+// Callbacks returns callbacks publishing the events of a job, then calling next
+func (b *EventBus) Callbacks(next Callbacks) Callbacks {
+	return EventCallbacks(func(event Event) {
+		if err := b.Publish(event); err != nil && b.config.OnError != nil {
+			b.config.OnError(event, err)
+		}
+	}, b.config.Milestones, next)
+}
+
+// Submission is a job submission consumed from an event bus
+type Submission struct {
+	Spec     Spec     `json:"spec"`
+	Priority Priority `json:"priority"`
+}
+
+// ConsumeSubmissions submits to q the submissions of topic until ctx is done. Malformed
+// messages are acknowledged and reported to onError so that they are not redelivered
+// forever. A redelivered submission is deduplicated when it carries a Spec.Key or when
+// q has a Config.Dedup
+func ConsumeSubmissions(ctx context.Context, sub Subscriber, topic string, q *Queue, callbacks Callbacks, onError func(data []byte, err error)) error {
+	return sub.Subscribe(ctx, topic, func(ctx context.Context, data []byte) error {
+		var s Submission
+		if err := json.Unmarshal(data, &s); err != nil {
+			if onError != nil {
+				onError(data, err)
+			}
+			return nil
+		}
+		_, err := q.Submit(s.Spec, s.Priority, callbacks)
+		return err
+	})
+}
@@ -0,0 +1,305 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Broker is the shared queue workers pull jobs from. Coordinator implements it in
+// process, HTTPBroker over HTTP, and RequestFunc relays these calls to a coordinator
+// through a request/reply transport such as NATS or Redis
+type Broker interface {
+	// Claim assigns the next pending job to worker, ok is false when there is none
+	Claim(ctx context.Context, worker string) (job Job, ok bool, err error)
+	// Heartbeat renews the leases of the jobs of worker and records their progress,
+	// it returns the IDs of the jobs to stop because they were canceled or reassigned
+	Heartbeat(ctx context.Context, worker string, progress map[string]float64) (stop []string, err error)
+	// Complete records the outcome of a job, message being empty on success
+	Complete(ctx context.Context, worker, id, message string) error
+}
+
+// CoordinatorConfig ...
+type CoordinatorConfig struct {
+	// Store persists the jobs when not nil, the jobs it holds are resumed
+	Store JobStore
+	// LeaseTimeout is the time without heartbeat after which a worker is dead and its
+	// jobs reassigned, defaults to 30s
+	LeaseTimeout time.Duration
+	// MaxAttempts is the number of runs after which a job of a dead worker fails, defaults to 3
+	MaxAttempts int
+	// OnError receives the errors of Store
+	OnError func(job Job, err error)
+}
+
+// WorkerInfo describes a worker seen by a coordinator
+type WorkerInfo struct {
+	ID       string    `json:"id"`
+	LastSeen time.Time `json:"last_seen"`
+	Jobs     []string  `json:"jobs"`
+}
+
+// Coordinator assigns jobs to workers on other hosts, tracks their heartbeats and
+// reassigns the jobs of dead workers
+type Coordinator struct {
+	config  CoordinatorConfig
+	mu      sync.Mutex
+	jobs    map[string]*Job
+	leases  map[string]time.Time
+	workers map[string]time.Time
+	nextID  int
+}
+
+// NewCoordinator returns a coordinator, resuming the jobs of config.Store. Jobs that
+// were running get a fresh lease so that their worker can report back in time
+func NewCoordinator(config CoordinatorConfig) (*Coordinator, error) {
+	if config.LeaseTimeout <= 0 {
+		config.LeaseTimeout = 30 * time.Second
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 3
+	}
+	c := &Coordinator{
+		config:  config,
+		jobs:    map[string]*Job{},
+		leases:  map[string]time.Time{},
+		workers: map[string]time.Time{},
+	}
+	if config.Store == nil {
+		return c, nil
+	}
+	jobs, err := config.Store.List()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for i := range jobs {
+		job := jobs[i]
+		c.jobs[job.ID] = &job
+		if job.State == StateRunning {
+			c.leases[job.ID] = now.Add(config.LeaseTimeout)
+		}
+	}
+	return c, nil
+}
+
+// persist saves job to the store, with c.mu held
+func (c *Coordinator) persist(job *Job) {
+	if c.config.Store == nil {
+		return
+	}
+	if err := c.config.Store.Save(*job); err != nil && c.config.OnError != nil {
+		c.config.OnError(*job, err)
+	}
+}
+
+// Submit queues a job for the workers and returns its ID
+func (c *Coordinator) Submit(spec Spec, priority Priority) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nextID++
+	job := &Job{ID: newID(c.nextID), Spec: spec, Priority: priority, State: StateQueued, Submitted: time.Now()}
+	if c.config.Store != nil {
+		if err := c.config.Store.Save(*job); err != nil {
+			return "", err
+		}
+	}
+	c.jobs[job.ID] = job
+	return job.ID, nil
+}
+
+// Get returns a snapshot of a job
+func (c *Coordinator) Get(id string) (Job, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	job, ok := c.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	return *job, nil
+}
+
+// List returns snapshots of the jobs in the given states, of every job when none is given
+func (c *Coordinator) List(states ...string) []Job {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var jobs []Job
+	for _, job := range c.jobs {
+		if len(states) == 0 {
+			jobs = append(jobs, *job)
+			continue
+		}
+		for _, s := range states {
+			if job.State == s {
+				jobs = append(jobs, *job)
+				break
+			}
+		}
+	}
+	return jobs
+}
+
+// Cancel cancels a queued job, or asks its worker to stop a running one on its next heartbeat
+func (c *Coordinator) Cancel(id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	job, ok := c.jobs[id]
+	if !ok {
+		return ErrNotFound
+	}
+	switch job.State {
+	case StateQueued, StateRunning:
+		job.State, job.Error, job.Finished = StateCanceled, ErrCanceled.Error(), time.Now()
+		delete(c.leases, id)
+	default:
+		return fmt.Errorf("queue: job %s already %s", id, job.State)
+	}
+	c.persist(job)
+	return nil
+}
+
+// Workers returns the workers seen within the lease timeout
+func (c *Coordinator) Workers() []WorkerInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var workers []WorkerInfo
+	for id, seen := range c.workers {
+		if time.Since(seen) > c.config.LeaseTimeout {
+			continue
+		}
+		w := WorkerInfo{ID: id, LastSeen: seen}
+		for _, job := range c.jobs {
+			if job.State == StateRunning && job.Worker == id {
+				w.Jobs = append(w.Jobs, job.ID)
+			}
+		}
+		workers = append(workers, w)
+	}
+	sort.Slice(workers, func(i, j int) bool { return workers[i].ID < workers[j].ID })
+	return workers
+}
+
+// Claim ...
+func (c *Coordinator) Claim(ctx context.Context, worker string) (Job, bool, error) {
+	if len(worker) == 0 {
+		return Job{}, false, errors.New("queue: missing worker id")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.workers[worker] = time.Now()
+	var next *Job
+	for _, job := range c.jobs {
+		if job.State != StateQueued {
+			continue
+		}
+		if next == nil || job.Priority > next.Priority ||
+			(job.Priority == next.Priority && job.Submitted.Before(next.Submitted)) {
+			next = job
+		}
+	}
+	if next == nil {
+		return Job{}, false, nil
+	}
+	next.State, next.Worker, next.Started = StateRunning, worker, time.Now()
+	next.Attempts++
+	next.Progress, next.Error = 0, ""
+	c.leases[next.ID] = time.Now().Add(c.config.LeaseTimeout)
+	c.persist(next)
+	return *next, true, nil
+}
+
+// Heartbeat ...
+func (c *Coordinator) Heartbeat(ctx context.Context, worker string, progress map[string]float64) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	c.workers[worker] = now
+	var stop []string
+	for id, p := range progress {
+		job, ok := c.jobs[id]
+		if !ok || job.Worker != worker || job.State != StateRunning {
+			// canceled, reassigned after a missed lease or unknown
+			stop = append(stop, id)
+			continue
+		}
+		job.Progress = p
+		c.leases[id] = now.Add(c.config.LeaseTimeout)
+	}
+	return stop, nil
+}
+
+// Complete ...
+func (c *Coordinator) Complete(ctx context.Context, worker, id, message string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	job, ok := c.jobs[id]
+	if !ok {
+		return ErrNotFound
+	}
+	c.workers[worker] = time.Now()
+	if job.Worker != worker || job.State != StateRunning {
+		// the outcome of a canceled or reassigned run is dropped
+		return nil
+	}
+	delete(c.leases, id)
+	job.Finished = time.Now()
+	if len(message) > 0 {
+		job.State, job.Error = StateFailed, message
+	} else {
+		job.State, job.Progress, job.Error = StateSucceeded, 100, ""
+	}
+	c.persist(job)
+	return nil
+}
+
+// Reap requeues the running jobs whose lease expired, failing those out of attempts,
+// and returns their IDs
+func (c *Coordinator) Reap() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	var reaped []string
+	for id, expires := range c.leases {
+		if now.Before(expires) {
+			continue
+		}
+		delete(c.leases, id)
+		job, ok := c.jobs[id]
+		if !ok || job.State != StateRunning {
+			continue
+		}
+		if job.Attempts >= c.config.MaxAttempts {
+			job.State, job.Finished = StateFailed, now
+			job.Error = fmt.Sprintf("queue: worker %s lost after %d attempts", job.Worker, job.Attempts)
+		} else {
+			job.State, job.Error = StateQueued, fmt.Sprintf("queue: worker %s lost, retrying", job.Worker)
+		}
+		c.persist(job)
+		reaped = append(reaped, id)
+	}
+	for id, seen := range c.workers {
+		if now.Sub(seen) > 10*c.config.LeaseTimeout {
+			delete(c.workers, id)
+		}
+	}
+	return reaped
+}
+
+// Run reaps the jobs of dead workers until ctx is done
+func (c *Coordinator) Run(ctx context.Context) error {
+	ticker := time.NewTicker(c.config.LeaseTimeout / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			c.Reap()
+		}
+	}
+}
+
+var _ Broker = (*Coordinator)(nil)
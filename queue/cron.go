@@ -0,0 +1,211 @@
+package queue
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule returns the activation times of a recurring job
+type Schedule interface {
+	// Next returns the first activation strictly after t, the zero time when there is none
+	Next(t time.Time) time.Time
+}
+
+// bits is a set of values of a cron field
+type bits uint64
+
+func (b bits) has(v int) bool {
+	return b&(1<<uint(v)) != 0
+}
+
+// cronField describes the values of a field of a cron expression
+type cronField struct {
+	min, max int
+	names    []string
+}
+
+var (
+	minuteField = cronField{min: 0, max: 59}
+	hourField   = cronField{min: 0, max: 23}
+	domField    = cronField{min: 1, max: 31}
+	monthField  = cronField{min: 1, max: 12, names: []string{"", "jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}}
+	dowField    = cronField{min: 0, max: 7, names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}}
+)
+
+// value parses a number or a name of f
+func (f cronField) value(s string) (int, error) {
+	for i, name := range f.names {
+		if len(name) > 0 && strings.EqualFold(s, name) {
+			return i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("value %q out of range [%d-%d]", s, f.min, f.max)
+	}
+	return v, nil
+}
+
+// parse parses a field made of comma separated *, values, ranges and /steps
+func (f cronField) parse(s string) (b bits, all bool, err error) {
+	for _, part := range strings.Split(s, ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			rng = part[:i]
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, false, fmt.Errorf("invalid step in %q", part)
+			}
+		}
+		lo, hi := f.min, f.max
+		switch {
+		case rng == "*" || rng == "?":
+			all = all || step == 1
+		case strings.Contains(rng, "-"):
+			i := strings.Index(rng, "-")
+			if lo, err = f.value(rng[:i]); err != nil {
+				return 0, false, err
+			}
+			if hi, err = f.value(rng[i+1:]); err != nil {
+				return 0, false, err
+			}
+			if hi < lo {
+				return 0, false, fmt.Errorf("invalid range %q", rng)
+			}
+		default:
+			if lo, err = f.value(rng); err != nil {
+				return 0, false, err
+			}
+			if strings.Contains(part, "/") {
+				// n/step runs from n to the end of the range
+				hi = f.max
+			} else {
+				hi = lo
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			b |= 1 << uint(v)
+		}
+	}
+	return b, all, nil
+}
+
+// cronSchedule is a parsed five field cron expression
+type cronSchedule struct {
+	minute, hour, dom, month, dow bits
+	// anyDom and anyDow tell whether the day fields were *, a day matches either
+	// restricted field as with the usual cron
+	anyDom, anyDow bool
+	loc            *time.Location
+}
+
+// descriptors are the @ shortcuts of cron expressions
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseCron parses a cron expression: five fields (minute, hour, day of month, month,
+// day of week) accepting *, lists, ranges, steps and English names, a descriptor such
+// as @daily or @hourly, or @every followed by a duration. The fields are read in loc,
+// the local time zone when nil
+func ParseCron(expr string, loc *time.Location) (Schedule, error) {
+	if loc == nil {
+		loc = time.Local
+	}
+	expr = strings.TrimSpace(expr)
+	if strings.HasPrefix(expr, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(expr[len("@every "):]))
+		if err != nil || d < time.Second {
+			return nil, fmt.Errorf("queue: invalid cron expression %q", expr)
+		}
+		return every(d), nil
+	}
+	if d, ok := descriptors[strings.ToLower(expr)]; ok {
+		expr = d
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("queue: cron expression %q needs 5 fields", expr)
+	}
+	s := &cronSchedule{loc: loc}
+	var err error
+	targets := []struct {
+		field cronField
+		bits  *bits
+		all   *bool
+	}{
+		{minuteField, &s.minute, nil},
+		{hourField, &s.hour, nil},
+		{domField, &s.dom, &s.anyDom},
+		{monthField, &s.month, nil},
+		{dowField, &s.dow, &s.anyDow},
+	}
+	for i, t := range targets {
+		var all bool
+		if *t.bits, all, err = t.field.parse(fields[i]); err != nil {
+			return nil, fmt.Errorf("queue: invalid cron expression %q: %v", expr, err)
+		}
+		if t.all != nil {
+			*t.all = all
+		}
+	}
+	if s.dow.has(7) {
+		// 7 is Sunday too
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+// day reports whether the day of t matches
+func (s *cronSchedule) day(t time.Time) bool {
+	dom, dow := s.dom.has(t.Day()), s.dow.has(int(t.Weekday()))
+	switch {
+	case s.anyDom && s.anyDow:
+		return true
+	case s.anyDom:
+		return dow
+	case s.anyDow:
+		return dom
+	}
+	return dom || dow
+}
+
+// Next ...
+func (s *cronSchedule) Next(t time.Time) time.Time {
+	t = t.In(s.loc)
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, s.loc)
+	// an expression such as Feb 30 never matches
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		y, m, d := t.Date()
+		switch {
+		case !s.month.has(int(m)):
+			t = time.Date(y, m+1, 1, 0, 0, 0, 0, s.loc)
+		case !s.day(t):
+			t = time.Date(y, m, d+1, 0, 0, 0, 0, s.loc)
+		case !s.hour.has(t.Hour()):
+			// skipped hours of daylight saving changes normalize to the next hour
+			t = time.Date(y, m, d, t.Hour()+1, 0, 0, 0, s.loc)
+		case !s.minute.has(t.Minute()):
+			t = time.Date(y, m, d, t.Hour(), t.Minute()+1, 0, 0, s.loc)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// every is a fixed interval schedule
+type every time.Duration
+
+// Next ...
+func (e every) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(e)).Truncate(time.Second)
+}
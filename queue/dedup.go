@@ -0,0 +1,94 @@
+package queue
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// DedupPolicy tells which existing job an identical submission reuses
+type DedupPolicy int
+
+// Deduplication policies
+const (
+	// DedupReuse reuses a queued, running or succeeded job, failed and canceled ones
+	// being run again
+	DedupReuse DedupPolicy = iota
+	// DedupActive only reuses a queued or running job, finished ones being run again
+	DedupActive
+	// DedupForce always runs the job, later submissions reusing it
+	DedupForce
+)
+
+// DedupConfig ...
+type DedupConfig struct {
+	// Window is how long after its submission a job is reused, 0 for as long as the
+	// queue knows it
+	Window time.Duration
+	// HashInput hashes the content of local inputs, otherwise their size and
+	// modification time stand for it
+	HashInput bool
+	// Policy is the policy of Submit
+	Policy DedupPolicy
+}
+
+// Fingerprint returns the hash of the input and options of spec. The input part is the
+// hash of its content with hashInput, its size and modification time otherwise, and
+// only its name when it is not a local file, such as a URL
+func Fingerprint(spec Spec, hashInput bool) (string, error) {
+	h := sha256.New()
+	write := func(s string) {
+		io.WriteString(h, strconv.Itoa(len(s))+":"+s)
+	}
+	write(spec.Kind)
+	write(spec.Tenant)
+	write(spec.Input)
+	write(spec.Output)
+	for _, a := range spec.Args {
+		write(a)
+	}
+	if info, err := os.Stat(spec.Input); err == nil && info.Mode().IsRegular() {
+		if hashInput {
+			f, err := os.Open(spec.Input)
+			if err != nil {
+				return "", err
+			}
+			defer f.Close()
+			content := sha256.New()
+			if _, err := io.Copy(content, f); err != nil {
+				return "", err
+			}
+			write(hex.EncodeToString(content.Sum(nil)))
+		} else {
+			write(strconv.FormatInt(info.Size(), 10) + "@" + strconv.FormatInt(info.ModTime().UnixNano(), 10))
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// duplicate returns the latest job of key reusable by policy, with q.mu held
+func (q *Queue) duplicate(key string, policy DedupPolicy, now time.Time) (string, bool) {
+	var found *Job
+	for _, e := range q.jobs {
+		job := &e.job
+		if job.Spec.Key != key || job.State == StateFailed || job.State == StateCanceled {
+			continue
+		}
+		if policy == DedupActive && job.Done() {
+			continue
+		}
+		if q.config.Dedup != nil && q.config.Dedup.Window > 0 && now.Sub(job.Submitted) > q.config.Dedup.Window {
+			continue
+		}
+		if found == nil || job.Submitted.After(found.Submitted) {
+			found = job
+		}
+	}
+	if found == nil {
+		return "", false
+	}
+	return found.ID, true
+}
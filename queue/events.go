@@ -0,0 +1,104 @@
+package queue
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/admpub/transcoder"
+)
+
+// Job event types
+const (
+	EventQueued    = "job.queued"
+	EventStarted   = "job.started"
+	EventProgress  = "job.progress"
+	EventCompleted = "job.completed"
+	EventFailed    = "job.failed"
+	EventCanceled  = "job.canceled"
+)
+
+// Event is a job lifecycle event, as sent by webhooks and event buses
+type Event struct {
+	// ID is unique per event, redeliveries share it so that receivers can ignore
+	// duplicates
+	ID   string    `json:"id"`
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	// Milestone is the progress percentage reached, for job.progress events
+	Milestone float64 `json:"milestone,omitempty"`
+	Job       Job     `json:"job"`
+}
+
+// eventSeq numbers the events of the process
+var eventSeq int64
+
+// newEvent returns an event of type t about job
+func newEvent(t string, job Job) Event {
+	n := atomic.AddInt64(&eventSeq, 1)
+	return Event{ID: newID(int(n)), Type: t, Time: time.Now(), Job: job}
+}
+
+// EventCallbacks returns callbacks calling emit with the events of a job, then calling
+// next. A job.progress event is emitted when the progress crosses one of milestones,
+// which default to 25, 50 and 75
+func EventCallbacks(emit func(Event), milestones []float64, next Callbacks) Callbacks {
+	if milestones == nil {
+		milestones = []float64{25, 50, 75}
+	}
+	var mu sync.Mutex
+	reached := map[string]int{}
+	return Callbacks{
+		OnQueued: func(job Job) {
+			emit(newEvent(EventQueued, job))
+			if next.OnQueued != nil {
+				next.OnQueued(job)
+			}
+		},
+		OnStart: func(job Job) {
+			mu.Lock()
+			// a retried job crosses its milestones again
+			delete(reached, job.ID)
+			mu.Unlock()
+			emit(newEvent(EventStarted, job))
+			if next.OnStart != nil {
+				next.OnStart(job)
+			}
+		},
+		OnProgress: func(job Job, p transcoder.Progress) {
+			mu.Lock()
+			n := reached[job.ID]
+			crossed := n
+			for crossed < len(milestones) && job.Progress >= milestones[crossed] {
+				crossed++
+			}
+			reached[job.ID] = crossed
+			mu.Unlock()
+			if crossed > n {
+				// a jump over several milestones emits the highest one
+				event := newEvent(EventProgress, job)
+				event.Milestone = milestones[crossed-1]
+				emit(event)
+			}
+			if next.OnProgress != nil {
+				next.OnProgress(job, p)
+			}
+		},
+		OnDone: func(job Job) {
+			mu.Lock()
+			delete(reached, job.ID)
+			mu.Unlock()
+			switch job.State {
+			case StateSucceeded:
+				emit(newEvent(EventCompleted, job))
+			case StateCanceled:
+				emit(newEvent(EventCanceled, job))
+			default:
+				emit(newEvent(EventFailed, job))
+			}
+			if next.OnDone != nil {
+				next.OnDone(job)
+			}
+		},
+	}
+}
@@ -0,0 +1,150 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// brokerRequest is the body of the broker HTTP calls
+type brokerRequest struct {
+	Worker   string             `json:"worker"`
+	ID       string             `json:"id,omitempty"`
+	Message  string             `json:"message,omitempty"`
+	Progress map[string]float64 `json:"progress,omitempty"`
+}
+
+// brokerResponse is the reply of the broker HTTP calls
+type brokerResponse struct {
+	Job   *Job     `json:"job,omitempty"`
+	Stop  []string `json:"stop,omitempty"`
+	Error string   `json:"error,omitempty"`
+}
+
+// BrokerHandler serves broker on POST /claim, /heartbeat and /complete, for workers
+// using an HTTPBroker. Authentication is left to a wrapping handler
+func BrokerHandler(broker Broker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req brokerRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp, ok := serveBroker(r.Context(), broker, path.Base(r.URL.Path), req)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if len(resp.Error) > 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		json.NewEncoder(w).Encode(resp)
+	})
+}
+
+// serveBroker runs the call of req to the method of broker, ok is false when there
+// is no such method
+func serveBroker(ctx context.Context, broker Broker, method string, req brokerRequest) (resp brokerResponse, ok bool) {
+	var err error
+	switch method {
+	case "claim":
+		var job Job
+		var claimed bool
+		if job, claimed, err = broker.Claim(ctx, req.Worker); claimed {
+			resp.Job = &job
+		}
+	case "heartbeat":
+		resp.Stop, err = broker.Heartbeat(ctx, req.Worker, req.Progress)
+	case "complete":
+		err = broker.Complete(ctx, req.Worker, req.ID, req.Message)
+	default:
+		return resp, false
+	}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	return resp, true
+}
+
+// HTTPBroker is a Broker calling a BrokerHandler served at URL
+type HTTPBroker struct {
+	URL string
+	// Client defaults to http.DefaultClient
+	Client *http.Client
+	// Header is added to every request, e.g. for authentication
+	Header http.Header
+}
+
+// call posts req to the endpoint of the handler
+func (b *HTTPBroker) call(ctx context.Context, endpoint string, req brokerRequest) (*brokerResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	r, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(b.URL, "/")+"/"+endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	r = r.WithContext(ctx)
+	for k, v := range b.Header {
+		r.Header[k] = v
+	}
+	r.Header.Set("Content-Type", "application/json")
+	client := b.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	var resp brokerResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("queue: broker replied %s with error %w", res.Status, err)
+	}
+	if len(resp.Error) > 0 {
+		return nil, fmt.Errorf("queue: broker %s: %s", endpoint, resp.Error)
+	}
+	return &resp, nil
+}
+
+// Claim ...
+func (b *HTTPBroker) Claim(ctx context.Context, worker string) (Job, bool, error) {
+	resp, err := b.call(ctx, "claim", brokerRequest{Worker: worker})
+	if err != nil || resp.Job == nil {
+		return Job{}, false, err
+	}
+	return *resp.Job, true, nil
+}
+
+// Heartbeat ...
+func (b *HTTPBroker) Heartbeat(ctx context.Context, worker string, progress map[string]float64) ([]string, error) {
+	resp, err := b.call(ctx, "heartbeat", brokerRequest{Worker: worker, Progress: progress})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Stop, nil
+}
+
+// Complete ...
+func (b *HTTPBroker) Complete(ctx context.Context, worker, id, message string) error {
+	_, err := b.call(ctx, "complete", brokerRequest{Worker: worker, ID: id, Message: message})
+	return err
+}
+
+var _ Broker = (*HTTPBroker)(nil)
@@ -0,0 +1,159 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/admpub/transcoder"
+)
+
+// Preemption modes
+const (
+	// PreemptSuspend stops the process of the preempted job (SIGSTOP) and continues it
+	// (SIGCONT) once a slot frees up. Jobs without an attached process, and every job
+	// on Windows, are requeued instead
+	PreemptSuspend = "suspend"
+	// PreemptRequeue cancels the preempted job and queues it again at the head of its
+	// lane, it starts over since ffmpeg cannot resume an encode
+	PreemptRequeue = "requeue"
+)
+
+// attachKey is the context key of the attachment of a running job
+type attachKey struct{}
+
+type attachment struct {
+	queue *Queue
+	entry *entry
+}
+
+// Attach registers the process running the job of ctx, the context a Runner receives,
+// so that Pause and PreemptSuspend can suspend it. TranscoderRunner does it for the
+// transcoders providing their process. It reports whether ctx belongs to a queued job
+func Attach(ctx context.Context, process *os.Process) bool {
+	a, ok := ctx.Value(attachKey{}).(*attachment)
+	if !ok {
+		return false
+	}
+	a.queue.mu.Lock()
+	defer a.queue.mu.Unlock()
+	a.entry.process = process
+	return true
+}
+
+// Account adds the resources used by a process of the job of ctx, the context a
+// Runner receives, to its Usage. It reports whether ctx belongs to a queued job
+func Account(ctx context.Context, usage transcoder.Usage) bool {
+	a, ok := ctx.Value(attachKey{}).(*attachment)
+	if !ok {
+		return false
+	}
+	a.queue.mu.Lock()
+	defer a.queue.mu.Unlock()
+	// the snapshots returned by Get share the previous value
+	var total transcoder.Usage
+	if a.entry.job.Usage != nil {
+		total = *a.entry.job.Usage
+	}
+	total.Add(usage)
+	a.entry.job.Usage = &total
+	return true
+}
+
+// unsuspend takes e out of the suspended jobs, with q.mu held
+func (q *Queue) unsuspend(e *entry) {
+	for i, s := range q.suspended {
+		if s == e {
+			q.suspended = append(q.suspended[:i:i], q.suspended[i+1:]...)
+			return
+		}
+	}
+}
+
+// suspend stops the process of a running job and frees its slot, with q.mu held
+func (q *Queue) suspend(e *entry) error {
+	if e.process == nil {
+		return fmt.Errorf("queue: job %s has no attached process", e.job.ID)
+	}
+	if err := suspendProcess(e.process); err != nil {
+		return err
+	}
+	q.release(e, time.Now())
+	e.job.State = StateSuspended
+	q.suspended = append(q.suspended, e)
+	q.persist(e.job)
+	return nil
+}
+
+// preempt frees a slot for a job of priority p by suspending or requeuing the most
+// recently started job of the lowest priority below p. It reports whether a slot was
+// freed, with q.mu held
+func (q *Queue) preempt(p Priority) bool {
+	if len(q.config.Preemption) == 0 {
+		return false
+	}
+	var victim *entry
+	for _, e := range q.jobs {
+		if e.preempting {
+			// a slot is already being freed
+			return false
+		}
+		if !e.slot || e.job.State != StateRunning || e.job.Priority >= p {
+			continue
+		}
+		if victim == nil || e.job.Priority < victim.job.Priority ||
+			(e.job.Priority == victim.job.Priority && e.job.Started.After(victim.job.Started)) {
+			victim = e
+		}
+	}
+	if victim == nil {
+		return false
+	}
+	if q.config.Preemption == PreemptSuspend && q.suspend(victim) == nil {
+		return true
+	}
+	// the slot frees up once the job has stopped, run then dispatches again
+	victim.preempting = true
+	victim.cancel()
+	return false
+}
+
+// Pause suspends a running job, freeing its slot until Resume. It fails for jobs
+// without an attached process and on Windows
+func (q *Queue) Pause(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	e, ok := q.jobs[id]
+	if !ok {
+		return ErrNotFound
+	}
+	switch {
+	case e.job.State == StateSuspended:
+	case e.job.State != StateRunning || e.preempting:
+		return fmt.Errorf("queue: job %s is %s", id, e.job.State)
+	default:
+		if err := q.suspend(e); err != nil {
+			return err
+		}
+	}
+	e.paused = true
+	q.dispatch()
+	return nil
+}
+
+// Resume lets a paused job continue as soon as a slot is free
+func (q *Queue) Resume(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	e, ok := q.jobs[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if !e.paused {
+		return fmt.Errorf("queue: job %s is not paused", id)
+	}
+	e.paused = false
+	q.dispatch()
+	return nil
+}
@@ -0,0 +1,120 @@
+//go:build !windows
+// +build !windows
+
+package queue
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/admpub/transcoder"
+)
+
+// sleepRunner runs each job as a sleep process attached to the job, sending the
+// started processes to started
+func sleepRunner(started chan<- *exec.Cmd) Runner {
+	return func(ctx context.Context, job Job) (<-chan transcoder.Progress, error) {
+		cmd := exec.CommandContext(ctx, "sleep", "30")
+		if err := cmd.Start(); err != nil {
+			return nil, err
+		}
+		Attach(ctx, cmd.Process)
+		started <- cmd
+		out := make(chan transcoder.Progress)
+		go func() {
+			defer close(out)
+			if err := cmd.Wait(); err != nil {
+				out <- progressError{err}
+			}
+		}()
+		return out, nil
+	}
+}
+
+// progressError is a Progress reporting err
+type progressError struct{ err error }
+
+func (p progressError) GetFramesProcessed() string { return "" }
+func (p progressError) GetCurrentTime() string     { return "" }
+func (p progressError) GetCurrentBitrate() string  { return "" }
+func (p progressError) GetProgress() float64       { return 0 }
+func (p progressError) GetSpeed() string           { return "" }
+func (p progressError) GetError() error            { return p.err }
+
+// waitState waits until the job id reaches state
+func waitState(t *testing.T, q *Queue, id, state string) Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, err := q.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.State == state {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s is %s, want %s", id, job.State, state)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDrainPausedJob(t *testing.T) {
+	started := make(chan *exec.Cmd, 1)
+	q, err := New(Config{Runner: sleepRunner(started)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := q.Submit(Spec{}, PriorityLow, Callbacks{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	if err := q.Pause(id); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	drained := make(chan error, 1)
+	go func() { drained <- q.Drain(ctx) }()
+	select {
+	case <-drained:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Drain did not return")
+	}
+	if job, _ := q.Get(id); job.State != StateCanceled {
+		t.Fatalf("job is %s, want %s", job.State, StateCanceled)
+	}
+}
+
+func TestSuspendedJobKilled(t *testing.T) {
+	started := make(chan *exec.Cmd, 2)
+	q, err := New(Config{Workers: 1, Preemption: PreemptSuspend, Runner: sleepRunner(started)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	low, err := q.Submit(Spec{}, PriorityLow, Callbacks{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lowCmd := <-started
+	high, err := q.Submit(Spec{}, PriorityHigh, Callbacks{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	highCmd := <-started
+	waitState(t, q, low, StateSuspended)
+
+	lowCmd.Process.Kill()
+	job := waitState(t, q, low, StateFailed)
+	highCmd.Process.Kill()
+	waitState(t, q, high, StateFailed)
+	// the slot freed by the high job must not resume the finished low job
+	time.Sleep(100 * time.Millisecond)
+	if after, _ := q.Get(low); after.State != StateFailed || after.Attempts != job.Attempts {
+		t.Fatalf("finished job was resumed: %s, attempt %d", after.State, after.Attempts)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package queue
+
+import (
+	"os"
+	"syscall"
+)
+
+func suspendProcess(p *os.Process) error {
+	return p.Signal(syscall.SIGSTOP)
+}
+
+func resumeProcess(p *os.Process) error {
+	return p.Signal(syscall.SIGCONT)
+}
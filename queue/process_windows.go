@@ -0,0 +1,16 @@
+package queue
+
+import (
+	"errors"
+	"os"
+)
+
+var errSuspendUnsupported = errors.New("queue: process suspension is not supported on windows")
+
+func suspendProcess(p *os.Process) error {
+	return errSuspendUnsupported
+}
+
+func resumeProcess(p *os.Process) error {
+	return errSuspendUnsupported
+}
@@ -0,0 +1,749 @@
+// Package queue runs transcoding jobs in process, with priorities, a limit on the
+// number of concurrent ffmpeg processes and graceful draining on shutdown.
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/admpub/transcoder"
+)
+
+var (
+	// ErrClosed is returned by Submit once the queue is closed
+	ErrClosed = errors.New("queue: closed")
+	// ErrNotFound is returned for unknown job IDs
+	ErrNotFound = errors.New("queue: job not found")
+	// ErrCanceled is the error of canceled jobs
+	ErrCanceled = errors.New("queue: job canceled")
+)
+
+// Priority of a job, each priority being a lane of the queue
+type Priority int
+
+// Priorities
+const (
+	PriorityLow Priority = iota
+	PriorityNormal
+	PriorityHigh
+)
+
+// Job states
+const (
+	StateQueued    = "queued"
+	StateRunning   = "running"
+	StateSucceeded = "succeeded"
+	StateFailed    = "failed"
+	StateCanceled  = "canceled"
+	// StateSuspended is the state of a running job paused by Pause or by a preemption
+	StateSuspended = "suspended"
+)
+
+// Spec describes the work of a job, it only holds plain values so that it can be
+// persisted and sent to other processes
+type Spec struct {
+	// Kind selects how the job runs when the runner handles several kinds of work
+	Kind   string   `json:"kind,omitempty"`
+	Input  string   `json:"input"`
+	Output string   `json:"output"`
+	Args   []string `json:"args,omitempty"`
+	// Tenant is the owner of the job, whose quota applies
+	Tenant string `json:"tenant,omitempty"`
+	// Key identifies identical jobs for deduplication, Fingerprint computes it when
+	// empty and Config.Dedup is set
+	Key string `json:"key,omitempty"`
+	// Metadata is free for the application
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Runner starts the work of a job. A nil progress channel means the work is done when
+// Runner returns, with its error
+type Runner func(ctx context.Context, job Job) (<-chan transcoder.Progress, error)
+
+// Callbacks are called from the goroutine running the job
+type Callbacks struct {
+	// OnQueued is called by Submit, from the submitting goroutine
+	OnQueued   func(job Job)
+	OnStart    func(job Job)
+	OnProgress func(job Job, progress transcoder.Progress)
+	OnDone     func(job Job)
+}
+
+// Job is a snapshot of a submitted job
+type Job struct {
+	ID        string    `json:"id"`
+	Spec      Spec      `json:"spec"`
+	Priority  Priority  `json:"priority"`
+	State     string    `json:"state"`
+	Progress  float64   `json:"progress"`
+	Error     string    `json:"error,omitempty"`
+	Submitted time.Time `json:"submitted"`
+	Started   time.Time `json:"started,omitempty"`
+	Finished  time.Time `json:"finished,omitempty"`
+	// Attempts counts the runs of the job
+	Attempts int `json:"attempts"`
+	// Worker runs the job, with a Coordinator
+	Worker string `json:"worker,omitempty"`
+	// ErrorClass is the class of Error, see ClassifyError
+	ErrorClass string `json:"error_class,omitempty"`
+	// RetryAt is when a failed job waiting for a retry is queued again
+	RetryAt time.Time `json:"retry_at,omitempty"`
+	// DeadLetter is set on the jobs that failed for good after their retries
+	DeadLetter bool `json:"dead_letter,omitempty"`
+	// Usage sums the resources used by the processes of every run, see Account
+	Usage *transcoder.Usage `json:"usage,omitempty"`
+	// Media describes the input, see Describe
+	Media *Media `json:"media,omitempty"`
+}
+
+// newID returns a job ID unique across restarts, n being a per process sequence
+func newID(n int) string {
+	return strconv.FormatInt(time.Now().UnixNano(), 36) + "-" + strconv.Itoa(n)
+}
+
+// Done reports whether the job reached a final state
+func (j Job) Done() bool {
+	return j.State == StateSucceeded || j.State == StateFailed || j.State == StateCanceled
+}
+
+// Config ...
+type Config struct {
+	// Workers is the maximum number of jobs running at once, defaults to 1
+	Workers int
+	// Lanes limits the running jobs of a priority, priorities without a limit only
+	// share the Workers limit
+	Lanes map[Priority]int
+	// Runner runs the jobs, see TranscoderRunner
+	Runner Runner
+	// Store persists the jobs when not nil
+	Store JobStore
+	// MaxAttempts is the number of runs after which Recover fails an orphaned job
+	// instead of queuing it again, defaults to 3
+	MaxAttempts int
+	// OnError receives the errors of Store once a job is accepted
+	OnError func(job Job, err error)
+	// Preemption lets a queued job take the slot of a running job of lower priority
+	// when all workers are busy, see PreemptSuspend and PreemptRequeue
+	Preemption string
+	// Tenants are the quotas of the tenants of Spec.Tenant, DefaultQuota applying to the
+	// others. Queued jobs are shared between tenants by weighted fair share
+	Tenants      map[string]TenantQuota
+	DefaultQuota TenantQuota
+	// Dedup makes Submit return the job already submitted for an identical spec
+	Dedup *DedupConfig
+	// Retry are the retry policies of the failed jobs per Spec.Kind, DefaultRetry
+	// applying to the other kinds
+	Retry        map[string]RetryPolicy
+	DefaultRetry RetryPolicy
+	// Tracer traces each run of a job when set, the spans of the Runner being its
+	// children when it passes the context on
+	Tracer transcoder.Tracer
+	// Logger receives the starts and outcomes of the runs when set. The Runner context
+	// carries the job.id and job.kind log fields either way, see transcoder.WithLogFields
+	Logger transcoder.Logger
+}
+
+// entry is the state of a job owned by the queue
+type entry struct {
+	job       Job
+	callbacks Callbacks
+	cancel    context.CancelFunc
+	done      chan struct{}
+	// process is the process of a running job, registered with Attach
+	process *os.Process
+	// slot is set while the job holds a worker slot, since then
+	slot  bool
+	since time.Time
+	// paused is set by Pause, preempting while a requeue preemption stops the job
+	paused     bool
+	preempting bool
+}
+
+// Queue ...
+type Queue struct {
+	config  Config
+	mu      sync.Mutex
+	jobs    map[string]*entry
+	lanes   map[Priority][]*entry
+	running map[Priority]int
+	total   int
+	nextID  int
+	closed  bool
+	ctx     context.Context
+	stop    context.CancelFunc
+	idle    chan struct{}
+	// suspended are the running jobs suspended by Pause or a preemption, in order
+	suspended []*entry
+	// tenants counts the running jobs per tenant, usage their running time today
+	tenants  map[string]int
+	usage    map[string]*dayUsage
+	midnight *time.Timer
+	// retrying are the failed jobs waiting for their retry
+	retrying map[*entry]*time.Timer
+}
+
+// New returns a queue running jobs with config.Runner
+func New(config Config) (*Queue, error) {
+	if config.Runner == nil {
+		return nil, errors.New("queue: missing runner")
+	}
+	if config.Workers <= 0 {
+		config.Workers = 1
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 3
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	return &Queue{
+		config:   config,
+		jobs:     map[string]*entry{},
+		lanes:    map[Priority][]*entry{},
+		running:  map[Priority]int{},
+		tenants:  map[string]int{},
+		usage:    map[string]*dayUsage{},
+		retrying: map[*entry]*time.Timer{},
+		ctx:      ctx,
+		stop:     stop,
+	}, nil
+}
+
+// Submit queues a job and returns its ID, which is the ID of an identical job when
+// deduplicated by Config.Dedup
+func (q *Queue) Submit(spec Spec, priority Priority, callbacks Callbacks) (string, error) {
+	policy := DedupReuse
+	if q.config.Dedup != nil {
+		policy = q.config.Dedup.Policy
+	}
+	id, _, err := q.SubmitUnique(spec, priority, callbacks, policy)
+	return id, err
+}
+
+// SubmitUnique queues a job unless an identical one is found according to policy, and
+// returns the ID of the job and whether it is an existing one, whose callbacks stay
+// those of its own submission. Specs are identical when their keys are equal, see
+// Spec.Key
+func (q *Queue) SubmitUnique(spec Spec, priority Priority, callbacks Callbacks, policy DedupPolicy) (string, bool, error) {
+	if len(spec.Key) == 0 && q.config.Dedup != nil {
+		key, err := Fingerprint(spec, q.config.Dedup.HashInput)
+		if err != nil {
+			return "", false, err
+		}
+		spec.Key = key
+	}
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return "", false, ErrClosed
+	}
+	if len(spec.Key) > 0 && policy != DedupForce {
+		if id, ok := q.duplicate(spec.Key, policy, time.Now()); ok {
+			q.mu.Unlock()
+			return id, true, nil
+		}
+	}
+	q.nextID++
+	e := &entry{
+		job: Job{
+			ID:        newID(q.nextID),
+			Spec:      spec,
+			Priority:  priority,
+			State:     StateQueued,
+			Submitted: time.Now(),
+		},
+		callbacks: callbacks,
+		done:      make(chan struct{}),
+	}
+	if q.config.Store != nil {
+		if err := q.config.Store.Save(e.job); err != nil {
+			q.mu.Unlock()
+			return "", false, err
+		}
+	}
+	job := e.job
+	q.jobs[e.job.ID] = e
+	q.lanes[priority] = append(q.lanes[priority], e)
+	q.dispatch()
+	q.mu.Unlock()
+	if callbacks.OnQueued != nil {
+		callbacks.OnQueued(job)
+	}
+	return job.ID, false, nil
+}
+
+// Recover loads the jobs of the store, queues the pending ones again and marks the jobs
+// found running or suspended, orphaned by a crash, for retry. callbacks apply to the recovered jobs.
+// It returns the number of queued jobs
+func (q *Queue) Recover(callbacks Callbacks) (int, error) {
+	if q.config.Store == nil {
+		return 0, errors.New("queue: no store to recover from")
+	}
+	jobs, err := q.config.Store.List()
+	if err != nil {
+		return 0, err
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Submitted.Before(jobs[j].Submitted) })
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return 0, ErrClosed
+	}
+	queued := 0
+	for _, job := range jobs {
+		if _, ok := q.jobs[job.ID]; ok {
+			continue
+		}
+		// the paused and preempting bookkeeping of the new entry starts cleared, a
+		// suspended job having no process to continue either
+		e := &entry{job: job, callbacks: callbacks, done: make(chan struct{})}
+		if job.State == StateRunning || job.State == StateSuspended {
+			if job.Attempts >= q.config.MaxAttempts {
+				e.job.State, e.job.Error = StateFailed, fmt.Sprintf("queue: orphaned after %d attempts", job.Attempts)
+				e.job.Finished = time.Now()
+			} else {
+				e.job.State, e.job.Error, e.job.Progress = StateQueued, "queue: orphaned, retrying", 0
+			}
+			q.persist(e.job)
+		}
+		q.jobs[job.ID] = e
+		if e.job.Done() {
+			close(e.done)
+			continue
+		}
+		q.lanes[e.job.Priority] = append(q.lanes[e.job.Priority], e)
+		queued++
+	}
+	q.dispatch()
+	return queued, nil
+}
+
+// persist saves job to the store, with q.mu held
+func (q *Queue) persist(job Job) {
+	if q.config.Store == nil {
+		return
+	}
+	if err := q.config.Store.Save(job); err != nil && q.config.OnError != nil {
+		q.config.OnError(job, err)
+	}
+}
+
+// Get returns a snapshot of a job
+func (q *Queue) Get(id string) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	e, ok := q.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	return e.job, nil
+}
+
+// List returns snapshots of the jobs in the given states, of every job when none is given
+func (q *Queue) List(states ...string) []Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var jobs []Job
+	for _, e := range q.jobs {
+		if len(states) == 0 {
+			jobs = append(jobs, e.job)
+			continue
+		}
+		for _, s := range states {
+			if e.job.State == s {
+				jobs = append(jobs, e.job)
+				break
+			}
+		}
+	}
+	return jobs
+}
+
+// Wait blocks until the job is done or ctx is done, and returns its last snapshot
+func (q *Queue) Wait(ctx context.Context, id string) (Job, error) {
+	q.mu.Lock()
+	e, ok := q.jobs[id]
+	var done chan struct{}
+	if ok {
+		done = e.done
+	}
+	q.mu.Unlock()
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	select {
+	case <-done:
+		return q.Get(id)
+	case <-ctx.Done():
+		return q.Get(id)
+	}
+}
+
+// Cancel removes a queued job or stops a running one
+func (q *Queue) Cancel(id string) error {
+	q.mu.Lock()
+	e, ok := q.jobs[id]
+	if !ok {
+		q.mu.Unlock()
+		return ErrNotFound
+	}
+	switch e.job.State {
+	case StateQueued:
+		q.remove(e)
+		q.stopRetry(e)
+		e.job.State, e.job.Error, e.job.Finished = StateCanceled, ErrCanceled.Error(), time.Now()
+		close(e.done)
+		q.persist(e.job)
+		job, onDone := e.job, e.callbacks.OnDone
+		q.checkIdle()
+		q.mu.Unlock()
+		if onDone != nil {
+			onDone(job)
+		}
+		return nil
+	case StateRunning, StateSuspended:
+		if e.job.State == StateSuspended {
+			q.unsuspend(e)
+		}
+		e.job.State = StateCanceled
+		cancel, process := e.cancel, e.process
+		q.mu.Unlock()
+		cancel()
+		if process != nil {
+			// a stopped process would not handle the cancellation
+			resumeProcess(process)
+		}
+		return nil
+	}
+	q.mu.Unlock()
+	return fmt.Errorf("queue: job %s already %s", id, e.job.State)
+}
+
+// Forget drops a finished job from the queue
+func (q *Queue) Forget(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	e, ok := q.jobs[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if !e.job.Done() {
+		return fmt.Errorf("queue: job %s is %s", id, e.job.State)
+	}
+	if q.config.Store != nil {
+		if err := q.config.Store.Delete(id); err != nil {
+			return err
+		}
+	}
+	delete(q.jobs, id)
+	return nil
+}
+
+// Close stops accepting jobs, Drain then finishes the accepted ones
+func (q *Queue) Close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+}
+
+// Drain closes the queue and waits until the queued and running jobs are done. When
+// ctx is done first, the remaining jobs are canceled and ctx.Err() is returned
+func (q *Queue) Drain(ctx context.Context) error {
+	q.mu.Lock()
+	q.closed = true
+	if q.idle == nil {
+		q.idle = make(chan struct{})
+	}
+	idle := q.idle
+	q.checkIdle()
+	q.mu.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+	}
+	q.mu.Lock()
+	var canceled []*entry
+	for _, e := range q.jobs {
+		if e.job.State == StateQueued {
+			q.remove(e)
+			q.stopRetry(e)
+			e.job.State, e.job.Error, e.job.Finished = StateCanceled, ErrCanceled.Error(), time.Now()
+			close(e.done)
+			q.persist(e.job)
+			canceled = append(canceled, e)
+		}
+	}
+	q.checkIdle()
+	q.mu.Unlock()
+	for _, e := range canceled {
+		if e.callbacks.OnDone != nil {
+			e.callbacks.OnDone(e.job)
+		}
+	}
+	// running jobs end on their own once their context is canceled
+	q.stop()
+	q.mu.Lock()
+	for _, e := range q.suspended {
+		if e.process != nil {
+			resumeProcess(e.process)
+		}
+	}
+	q.mu.Unlock()
+	<-idle
+	return ctx.Err()
+}
+
+// remove takes a queued entry out of its lane, with q.mu held
+func (q *Queue) remove(e *entry) {
+	lane := q.lanes[e.job.Priority]
+	for i, l := range lane {
+		if l == e {
+			q.lanes[e.job.Priority] = append(lane[:i:i], lane[i+1:]...)
+			return
+		}
+	}
+}
+
+// checkIdle signals Drain once nothing is left to run, with q.mu held
+func (q *Queue) checkIdle() {
+	if q.idle == nil || q.total > 0 || len(q.suspended) > 0 || len(q.retrying) > 0 {
+		return
+	}
+	for _, lane := range q.lanes {
+		if len(lane) > 0 {
+			return
+		}
+	}
+	select {
+	case <-q.idle:
+	default:
+		close(q.idle)
+	}
+}
+
+// next returns the queued or suspended job to run next that the lane limits allow,
+// highest priorities first and suspended jobs first within a priority, with q.mu held
+func (q *Queue) next() *entry {
+	var next *entry
+	now := time.Now()
+	for _, e := range q.suspended {
+		if e.job.State == StateSuspended && !e.paused && q.laneFree(e.job.Priority) && (next == nil || e.job.Priority > next.job.Priority) && q.eligible(e, now) {
+			next = e
+		}
+	}
+	for p, lane := range q.lanes {
+		if len(lane) == 0 || (next != nil && p <= next.job.Priority) || !q.laneFree(p) {
+			continue
+		}
+		if e := q.pick(lane, now); e != nil {
+			next = e
+		}
+	}
+	return next
+}
+
+// laneFree reports whether the lane limit of p allows one more running job, with q.mu held
+func (q *Queue) laneFree(p Priority) bool {
+	limit, ok := q.config.Lanes[p]
+	return !ok || limit <= 0 || q.running[p] < limit
+}
+
+// dispatch starts or resumes the jobs the limits allow, preempting lower priorities
+// when configured, with q.mu held
+func (q *Queue) dispatch() {
+	for {
+		next := q.next()
+		if next == nil {
+			return
+		}
+		if q.total >= q.config.Workers {
+			if !q.preempt(next.job.Priority) {
+				return
+			}
+			continue
+		}
+		q.acquire(next, time.Now())
+		if next.job.State == StateSuspended {
+			q.unsuspend(next)
+			next.job.State = StateRunning
+			q.persist(next.job)
+			if next.process != nil {
+				resumeProcess(next.process)
+			}
+			continue
+		}
+		q.remove(next)
+		ctx, cancel := context.WithCancel(q.ctx)
+		ctx = context.WithValue(ctx, attachKey{}, &attachment{queue: q, entry: next})
+		next.cancel = cancel
+		next.job.State, next.job.Started = StateRunning, time.Now()
+		next.job.Attempts++
+		q.persist(next.job)
+		go q.run(ctx, next, next.job)
+	}
+}
+
+// run executes a job and records its outcome
+func (q *Queue) run(ctx context.Context, e *entry, job Job) {
+	defer e.cancel()
+	ctx, span := q.startSpan(ctx, job)
+	ctx = transcoder.WithLogFields(ctx, "job.id", job.ID, "job.kind", job.Spec.Kind)
+	var err error
+	defer func() {
+		endSpan(span, job, err)
+		q.logRun(job, err)
+	}()
+	if q.config.Logger != nil {
+		q.config.Logger.Log(transcoder.LevelDebug, "job started", "job.id", job.ID, "job.kind", job.Spec.Kind, "job.attempt", job.Attempts)
+	}
+	if e.callbacks.OnStart != nil {
+		e.callbacks.OnStart(job)
+	}
+	progress, err := q.config.Runner(ctx, job)
+	if err != nil {
+		progress = nil
+	}
+	for p := range progressOrClosed(progress) {
+		if p.GetError() != nil {
+			err = p.GetError()
+		}
+		q.mu.Lock()
+		e.job.Progress = p.GetProgress()
+		job = e.job
+		q.mu.Unlock()
+		if e.callbacks.OnProgress != nil {
+			e.callbacks.OnProgress(job, p)
+		}
+	}
+
+	q.mu.Lock()
+	q.release(e, time.Now())
+	e.process = nil
+	// a job whose process ended while suspended is not to be resumed
+	q.unsuspend(e)
+	e.paused = false
+	if e.preempting && e.job.State == StateRunning {
+		// stopped to free its slot, it starts over once capacity frees up
+		e.preempting = false
+		e.job.State, e.job.Progress, e.job.Error = StateQueued, 0, "queue: preempted, requeued"
+		q.lanes[e.job.Priority] = append([]*entry{e}, q.lanes[e.job.Priority]...)
+		q.persist(e.job)
+		job = e.job
+		q.dispatch()
+		q.mu.Unlock()
+		return
+	}
+	switch {
+	case e.job.State == StateCanceled || (err != nil && ctx.Err() != nil):
+		e.job.State, e.job.Error = StateCanceled, ErrCanceled.Error()
+	case err != nil:
+		e.job.State, e.job.Error, e.job.ErrorClass = StateFailed, err.Error(), ClassifyError(err)
+		policy := q.retryPolicy(e.job.Spec.Kind)
+		if policy.retryable(e.job.Attempts, e.job.ErrorClass) && q.ctx.Err() == nil {
+			q.retry(e, policy.delay(e.job.Attempts))
+			job = e.job
+			q.dispatch()
+			q.mu.Unlock()
+			return
+		}
+		e.job.DeadLetter = policy.MaxAttempts > 1
+	default:
+		e.job.State, e.job.Progress = StateSucceeded, 100
+		e.job.Error, e.job.ErrorClass = "", ""
+	}
+	e.job.Finished = time.Now()
+	job = e.job
+	q.persist(job)
+	close(e.done)
+	q.dispatch()
+	q.checkIdle()
+	q.mu.Unlock()
+	if e.callbacks.OnDone != nil {
+		e.callbacks.OnDone(job)
+	}
+}
+
+// progressOrClosed returns progress, or a closed channel when it is nil
+func progressOrClosed(progress <-chan transcoder.Progress) <-chan transcoder.Progress {
+	if progress != nil {
+		return progress
+	}
+	closed := make(chan transcoder.Progress)
+	close(closed)
+	return closed
+}
+
+// args is a transcoder.Options made of raw arguments
+type args []string
+
+// GetStrArguments ...
+func (a args) GetStrArguments() []string {
+	return a
+}
+
+// logRun logs the outcome of a run of job
+func (q *Queue) logRun(job Job, err error) {
+	if q.config.Logger == nil {
+		return
+	}
+	l := q.config.Logger.With("job.id", job.ID, "job.kind", job.Spec.Kind, "job.attempt", job.Attempts)
+	switch {
+	case job.State == StateSucceeded:
+		l.Log(transcoder.LevelInfo, "job succeeded")
+	case job.State == StateFailed:
+		l.Log(transcoder.LevelError, "job failed", "error", job.Error, "job.error_class", job.ErrorClass, "job.dead_letter", job.DeadLetter)
+	case !job.RetryAt.IsZero():
+		l.Log(transcoder.LevelWarn, "job failed, retrying", "error", err, "job.retry_at", job.RetryAt)
+	default:
+		l.Log(transcoder.LevelInfo, "job "+string(job.State), "reason", job.Error)
+	}
+}
+
+// processHooker is implemented by the transcoders providing their process, such as
+// the ffmpeg one
+type processHooker interface {
+	WithProcessHook(fn func(*os.Process)) transcoder.Transcoder
+}
+
+// metadataHooker is implemented by the transcoders providing the metadata of their input
+type metadataHooker interface {
+	WithMetadataHook(fn func(transcoder.Metadata)) transcoder.Transcoder
+}
+
+// usageHooker is implemented by the transcoders reporting the resources they used
+type usageHooker interface {
+	WithUsageHook(fn func(transcoder.Usage)) transcoder.Transcoder
+}
+
+// TranscoderRunner returns a Runner transcoding Spec.Input to Spec.Output with the
+// Spec.Args output options, on a transcoder returned by newTranscoder for each job.
+// The process of transcoders with a WithProcessHook method is attached to the job, and
+// the usage of those with a WithUsageHook method is accounted to it. The metadata of
+// those with a WithMetadataHook method describes its Media
+func TranscoderRunner(newTranscoder func() transcoder.Transcoder) Runner {
+	return func(ctx context.Context, job Job) (<-chan transcoder.Progress, error) {
+		if len(job.Spec.Input) == 0 || len(job.Spec.Output) == 0 {
+			return nil, errors.New("queue: job without input or output")
+		}
+		t := newTranscoder()
+		if h, ok := t.(processHooker); ok {
+			h.WithProcessHook(func(p *os.Process) { Attach(ctx, p) })
+		}
+		if h, ok := t.(metadataHooker); ok {
+			h.WithMetadataHook(func(m transcoder.Metadata) { Describe(ctx, m) })
+		}
+		if h, ok := t.(usageHooker); ok {
+			h.WithUsageHook(func(u transcoder.Usage) { Account(ctx, u) })
+		}
+		return t.
+			Input(job.Spec.Input).
+			Output(job.Spec.Output).
+			WithContext(ctx).
+			Start(args(job.Spec.Args))
+	}
+}
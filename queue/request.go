@@ -0,0 +1,91 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// RequestFunc is a Broker relaying its calls to a coordinator through a request/reply
+// transport, subject being the method called: claim, heartbeat or complete. The
+// serving side answers with ServeBroker. Adapt a client, e.g. with nats.go:
+//
+//	queue.RequestFunc(func(ctx context.Context, subject string, data []byte) ([]byte, error) {
+//		msg, err := nc.RequestWithContext(ctx, "transcoder.broker."+subject, data)
+//		if err != nil {
+//			return nil, err
+//		}
+//		return msg.Data, nil
+//	})
+//
+// the coordinator replying from its subscription:
+//
+//	nc.Subscribe("transcoder.broker.*", func(msg *nats.Msg) {
+//		reply, err := queue.ServeBroker(ctx, coordinator, strings.TrimPrefix(msg.Subject, "transcoder.broker."), msg.Data)
+//		if err == nil {
+//			msg.Respond(reply)
+//		}
+//	})
+type RequestFunc func(ctx context.Context, subject string, data []byte) ([]byte, error)
+
+// call sends req to the subject and decodes the reply
+func (f RequestFunc) call(ctx context.Context, subject string, req brokerRequest) (*brokerResponse, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	data, err = f(ctx, subject, data)
+	if err != nil {
+		return nil, err
+	}
+	var resp brokerResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("queue: broker reply with error %w", err)
+	}
+	if len(resp.Error) > 0 {
+		return nil, fmt.Errorf("queue: broker %s: %s", subject, resp.Error)
+	}
+	return &resp, nil
+}
+
+// Claim ...
+func (f RequestFunc) Claim(ctx context.Context, worker string) (Job, bool, error) {
+	resp, err := f.call(ctx, "claim", brokerRequest{Worker: worker})
+	if err != nil || resp.Job == nil {
+		return Job{}, false, err
+	}
+	return *resp.Job, true, nil
+}
+
+// Heartbeat ...
+func (f RequestFunc) Heartbeat(ctx context.Context, worker string, progress map[string]float64) ([]string, error) {
+	resp, err := f.call(ctx, "heartbeat", brokerRequest{Worker: worker, Progress: progress})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Stop, nil
+}
+
+// Complete ...
+func (f RequestFunc) Complete(ctx context.Context, worker, id, message string) error {
+	_, err := f.call(ctx, "complete", brokerRequest{Worker: worker, ID: id, Message: message})
+	return err
+}
+
+var _ Broker = RequestFunc(nil)
+
+// ServeBroker answers the call of a RequestFunc to subject with broker, returning the
+// reply to send back. The error is that of a call that cannot be decoded, those of
+// broker being part of the reply
+func ServeBroker(ctx context.Context, broker Broker, subject string, data []byte) ([]byte, error) {
+	var req brokerRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, err
+	}
+	resp, ok := serveBroker(ctx, broker, subject, req)
+	if !ok {
+		return nil, errors.New("queue: unknown broker subject " + subject)
+	}
+	return json.Marshal(resp)
+}
@@ -0,0 +1,196 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Error classes
+const (
+	// ErrorClassInput is a missing, unreadable or invalid input, or invalid options
+	ErrorClassInput = "input"
+	// ErrorClassNetwork is a failure of a remote input or output
+	ErrorClassNetwork = "network"
+	// ErrorClassResource is a lack of disk space, memory or file descriptors
+	ErrorClassResource = "resource"
+	ErrorClassTimeout  = "timeout"
+	ErrorClassUnknown  = "unknown"
+)
+
+// errorPatterns are the messages of ffmpeg and of the system telling the error classes
+var errorPatterns = []struct {
+	class    string
+	patterns []string
+}{
+	{ErrorClassTimeout, []string{"transcoding stalled"}},
+	{ErrorClassResource, []string{"no space left", "cannot allocate memory", "too many open files", "resource temporarily unavailable", "disk quota exceeded"}},
+	{ErrorClassNetwork, []string{"connection refused", "connection reset", "connection timed out", "network is unreachable", "broken pipe", "i/o timeout", "no route to host", "server returned 5"}},
+	{ErrorClassInput, []string{"no such file", "invalid data found", "invalid argument", "permission denied", "unknown encoder", "unrecognized option", "does not contain any stream", "server returned 4", "moov atom not found"}},
+}
+
+// ClassifyError returns the class of a job error
+func ClassifyError(err error) string {
+	if err == nil {
+		return ""
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrorClassTimeout
+	}
+	if os.IsNotExist(err) || os.IsPermission(err) {
+		return ErrorClassInput
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return ErrorClassNetwork
+	}
+	message := strings.ToLower(err.Error())
+	for _, c := range errorPatterns {
+		for _, p := range c.patterns {
+			if strings.Contains(message, p) {
+				return c.class
+			}
+		}
+	}
+	return ErrorClassUnknown
+}
+
+// RetryPolicy tells how the failed jobs of a kind run again
+type RetryPolicy struct {
+	// MaxAttempts is the number of runs of a job, defaults to 1 which disables retries
+	MaxAttempts int
+	// Backoff is the wait before the first retry, doubled for each retry up to
+	// MaxBackoff. They default to 10s and 10m
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// RetryOn are the error classes retried, every class but ErrorClassInput when empty
+	RetryOn []string
+}
+
+// retryable reports whether a job that ran attempts times and failed with class runs again
+func (p RetryPolicy) retryable(attempts int, class string) bool {
+	if attempts >= p.MaxAttempts {
+		return false
+	}
+	if len(p.RetryOn) == 0 {
+		return class != ErrorClassInput
+	}
+	for _, c := range p.RetryOn {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// delay returns the wait before the run following attempts
+func (p RetryPolicy) delay(attempts int) time.Duration {
+	backoff, max := p.Backoff, p.MaxBackoff
+	if backoff <= 0 {
+		backoff = 10 * time.Second
+	}
+	if max <= 0 {
+		max = 10 * time.Minute
+	}
+	for i := 1; i < attempts && backoff < max; i++ {
+		backoff *= 2
+	}
+	if backoff > max {
+		backoff = max
+	}
+	return backoff
+}
+
+// retryPolicy returns the policy of the jobs of kind
+func (q *Queue) retryPolicy(kind string) RetryPolicy {
+	if p, ok := q.config.Retry[kind]; ok {
+		return p
+	}
+	return q.config.DefaultRetry
+}
+
+// retry queues e again after the backoff of its policy, with q.mu held
+func (q *Queue) retry(e *entry, delay time.Duration) {
+	e.job.State, e.job.Progress = StateQueued, 0
+	e.job.RetryAt = time.Now().Add(delay)
+	q.persist(e.job)
+	q.retrying[e] = time.AfterFunc(delay, func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		if _, ok := q.retrying[e]; !ok {
+			return
+		}
+		delete(q.retrying, e)
+		e.job.RetryAt = time.Time{}
+		q.lanes[e.job.Priority] = append(q.lanes[e.job.Priority], e)
+		q.dispatch()
+	})
+}
+
+// stopRetry cancels the pending retry of e, with q.mu held
+func (q *Queue) stopRetry(e *entry) {
+	if t, ok := q.retrying[e]; ok {
+		t.Stop()
+		delete(q.retrying, e)
+		e.job.RetryAt = time.Time{}
+	}
+}
+
+// DeadLetters returns the jobs that failed for good after their retries, oldest first
+func (q *Queue) DeadLetters() []Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var jobs []Job
+	for _, e := range q.jobs {
+		if e.job.DeadLetter {
+			jobs = append(jobs, e.job)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Finished.Before(jobs[j].Finished) })
+	return jobs
+}
+
+// DeadLetters returns the dead-lettered jobs of store, oldest first
+func DeadLetters(store JobStore) ([]Job, error) {
+	all, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	var jobs []Job
+	for _, job := range all {
+		if job.DeadLetter {
+			jobs = append(jobs, job)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Finished.Before(jobs[j].Finished) })
+	return jobs, nil
+}
+
+// Retry queues a failed or canceled job again, with its attempts reset, for instance
+// once the cause of a dead-lettered job is fixed
+func (q *Queue) Retry(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrClosed
+	}
+	e, ok := q.jobs[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if e.job.State != StateFailed && e.job.State != StateCanceled {
+		return fmt.Errorf("queue: job %s is %s", id, e.job.State)
+	}
+	e.job.State, e.job.Progress, e.job.Attempts = StateQueued, 0, 0
+	e.job.DeadLetter, e.job.Finished = false, time.Time{}
+	e.done = make(chan struct{})
+	q.persist(e.job)
+	q.lanes[e.job.Priority] = append(q.lanes[e.job.Priority], e)
+	q.dispatch()
+	return nil
+}
@@ -0,0 +1,269 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ScheduleKey is the Spec.Metadata key holding the ID of the recurring job that
+// submitted a job, to tell scheduled jobs from ad-hoc ones
+const ScheduleKey = "schedule"
+
+// Submitter is where a scheduler submits its jobs, Coordinator implements it and
+// QueueSubmitter adapts a Queue
+type Submitter interface {
+	Submit(spec Spec, priority Priority) (string, error)
+	Get(id string) (Job, error)
+}
+
+type queueSubmitter struct {
+	queue     *Queue
+	callbacks Callbacks
+}
+
+func (s queueSubmitter) Submit(spec Spec, priority Priority) (string, error) {
+	// recurring jobs run again on purpose, overlaps are handled by the scheduler
+	id, _, err := s.queue.SubmitUnique(spec, priority, s.callbacks, DedupForce)
+	return id, err
+}
+
+func (s queueSubmitter) Get(id string) (Job, error) {
+	return s.queue.Get(id)
+}
+
+// QueueSubmitter returns a Submitter submitting to q with callbacks
+func QueueSubmitter(q *Queue, callbacks Callbacks) Submitter {
+	return queueSubmitter{queue: q, callbacks: callbacks}
+}
+
+// Recurring describes a recurring job
+type Recurring struct {
+	ID       string   `json:"id"`
+	Cron     string   `json:"cron"`
+	Spec     Spec     `json:"spec"`
+	Priority Priority `json:"priority"`
+	// AllowOverlap submits the job even when the previous run is not done, which is
+	// otherwise skipped until the next activation
+	AllowOverlap bool      `json:"allow_overlap,omitempty"`
+	Paused       bool      `json:"paused,omitempty"`
+	Next         time.Time `json:"next,omitempty"`
+	Last         time.Time `json:"last,omitempty"`
+	// LastJob is the ID of the last submitted job
+	LastJob string `json:"last_job,omitempty"`
+	// LastError is the reason of the last skipped or failed submission
+	LastError string `json:"last_error,omitempty"`
+}
+
+// SchedulerConfig ...
+type SchedulerConfig struct {
+	Submitter Submitter
+	// Location is the time zone of the cron expressions, defaults to the local one
+	Location *time.Location
+	// OnError receives the submission errors
+	OnError func(recurring Recurring, err error)
+}
+
+// recurring is a registered recurring job
+type recurring struct {
+	Recurring
+	schedule Schedule
+}
+
+// Scheduler submits recurring jobs on their cron schedule. Registrations are not
+// persisted, applications register them again on startup
+type Scheduler struct {
+	config  SchedulerConfig
+	mu      sync.Mutex
+	entries map[string]*recurring
+	changed chan struct{}
+}
+
+// NewScheduler ...
+func NewScheduler(config SchedulerConfig) (*Scheduler, error) {
+	if config.Submitter == nil {
+		return nil, errors.New("queue: scheduler needs a submitter")
+	}
+	if config.Location == nil {
+		config.Location = time.Local
+	}
+	return &Scheduler{config: config, entries: map[string]*recurring{}, changed: make(chan struct{}, 1)}, nil
+}
+
+// wake makes Run compute its next activation again
+func (s *Scheduler) wake() {
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Add registers or replaces a recurring job, r.ID, r.Cron and r.Spec being required
+func (s *Scheduler) Add(r Recurring) error {
+	if len(r.ID) == 0 {
+		return errors.New("queue: recurring job needs an id")
+	}
+	schedule, err := ParseCron(r.Cron, s.config.Location)
+	if err != nil {
+		return err
+	}
+	r.Next, r.Last, r.LastJob, r.LastError = schedule.Next(time.Now()), time.Time{}, "", ""
+	if r.Next.IsZero() {
+		return fmt.Errorf("queue: cron expression %q never fires", r.Cron)
+	}
+	s.mu.Lock()
+	s.entries[r.ID] = &recurring{Recurring: r, schedule: schedule}
+	s.mu.Unlock()
+	s.wake()
+	return nil
+}
+
+// Remove unregisters a recurring job, the jobs it submitted stay in their queue
+func (s *Scheduler) Remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.entries, id)
+	return nil
+}
+
+// Pause stops submitting a recurring job until Resume
+func (s *Scheduler) Pause(id string) error {
+	return s.setPaused(id, true)
+}
+
+// Resume submits a paused recurring job again from its next activation
+func (s *Scheduler) Resume(id string) error {
+	return s.setPaused(id, false)
+}
+
+func (s *Scheduler) setPaused(id string, paused bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[id]
+	if !ok {
+		return ErrNotFound
+	}
+	e.Paused = paused
+	if !paused {
+		e.Next = e.schedule.Next(time.Now())
+		s.wake()
+	}
+	return nil
+}
+
+// Get returns a snapshot of a recurring job
+func (s *Scheduler) Get(id string) (Recurring, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[id]
+	if !ok {
+		return Recurring{}, ErrNotFound
+	}
+	return e.Recurring, nil
+}
+
+// List returns snapshots of the recurring jobs ordered by next activation
+func (s *Scheduler) List() []Recurring {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]Recurring, 0, len(s.entries))
+	for _, e := range s.entries {
+		list = append(list, e.Recurring)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Next.Equal(list[j].Next) {
+			return list[i].ID < list[j].ID
+		}
+		return list[i].Next.Before(list[j].Next)
+	})
+	return list
+}
+
+// Trigger submits a recurring job now, out of its schedule, and returns the job ID
+func (s *Scheduler) Trigger(id string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[id]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return s.submit(e, time.Now())
+}
+
+// submit submits the job of e, with s.mu held
+func (s *Scheduler) submit(e *recurring, now time.Time) (string, error) {
+	e.Last = now
+	if len(e.LastJob) > 0 && !e.AllowOverlap {
+		if job, err := s.config.Submitter.Get(e.LastJob); err == nil && !job.Done() {
+			e.LastError = fmt.Sprintf("skipped, job %s still %s", job.ID, job.State)
+			return "", fmt.Errorf("queue: recurring job %s %s", e.ID, e.LastError)
+		}
+	}
+	spec := e.Spec
+	spec.Metadata = map[string]string{}
+	for k, v := range e.Spec.Metadata {
+		spec.Metadata[k] = v
+	}
+	spec.Metadata[ScheduleKey] = e.ID
+	id, err := s.config.Submitter.Submit(spec, e.Priority)
+	if err != nil {
+		e.LastError = err.Error()
+		return "", err
+	}
+	e.LastJob, e.LastError = id, ""
+	return id, nil
+}
+
+// fire submits the jobs due at now and returns the next activation of all
+func (s *Scheduler) fire(now time.Time) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var next time.Time
+	for _, e := range s.entries {
+		if e.Paused || e.Next.IsZero() {
+			continue
+		}
+		if !e.Next.After(now) {
+			// activations missed while the host was suspended fire once
+			if _, err := s.submit(e, now); err != nil && s.config.OnError != nil {
+				s.config.OnError(e.Recurring, err)
+			}
+			e.Next = e.schedule.Next(now)
+		}
+		if !e.Next.IsZero() && (next.IsZero() || e.Next.Before(next)) {
+			next = e.Next
+		}
+	}
+	return next
+}
+
+// Run submits the recurring jobs on schedule until ctx is done
+func (s *Scheduler) Run(ctx context.Context) error {
+	for {
+		next := s.fire(time.Now())
+		// bounded waits follow wall clock changes
+		wait := time.Minute
+		if !next.IsZero() {
+			if d := time.Until(next); d < wait {
+				wait = d
+			}
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-s.changed:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+var _ Submitter = (*Coordinator)(nil)
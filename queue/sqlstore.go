@@ -0,0 +1,123 @@
+package queue
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var reTableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// SQLStore is a JobStore on a database/sql database, SQLite or any other engine whose
+// driver the application imports
+type SQLStore struct {
+	db    *sql.DB
+	table string
+	// dollar placeholders ($1) instead of question marks, for PostgreSQL
+	dollar bool
+}
+
+// NewSQLStore returns a store using table of db, created when missing. dollar selects
+// $1 placeholders (PostgreSQL) instead of ? (SQLite, MySQL)
+func NewSQLStore(db *sql.DB, table string, dollar bool) (*SQLStore, error) {
+	if !reTableName.MatchString(table) {
+		return nil, fmt.Errorf("queue: invalid table name %q", table)
+	}
+	s := &SQLStore{db: db, table: table, dollar: dollar}
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS " + table + " (id VARCHAR(64) PRIMARY KEY, state VARCHAR(16) NOT NULL, data TEXT NOT NULL)")
+	if err != nil {
+		return nil, fmt.Errorf("queue: failed to create table %s with error %w", table, err)
+	}
+	return s, nil
+}
+
+// query replaces the ? placeholders of q for the configured engine
+func (s *SQLStore) query(q string) string {
+	q = strings.Replace(q, "{table}", s.table, -1)
+	if !s.dollar {
+		return q
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range q {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Save ...
+func (s *SQLStore) Save(job Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	// portable upsert
+	if _, err := tx.Exec(s.query("DELETE FROM {table} WHERE id = ?"), job.ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec(s.query("INSERT INTO {table} (id, state, data) VALUES (?, ?, ?)"), job.ID, job.State, string(data)); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Load ...
+func (s *SQLStore) Load(id string) (Job, error) {
+	var data string
+	err := s.db.QueryRow(s.query("SELECT data FROM {table} WHERE id = ?"), id).Scan(&data)
+	if err == sql.ErrNoRows {
+		return Job{}, ErrNotFound
+	}
+	if err != nil {
+		return Job{}, err
+	}
+	var job Job
+	if err := json.Unmarshal([]byte(data), &job); err != nil {
+		return Job{}, fmt.Errorf("queue: failed to read job %s with error %w", id, err)
+	}
+	return job, nil
+}
+
+// List ...
+func (s *SQLStore) List() ([]Job, error) {
+	rows, err := s.db.Query(s.query("SELECT data FROM {table}"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var jobs []Job
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var job Job
+		if err := json.Unmarshal([]byte(data), &job); err != nil {
+			return nil, fmt.Errorf("queue: failed to read a job with error %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// Delete ...
+func (s *SQLStore) Delete(id string) error {
+	_, err := s.db.Exec(s.query("DELETE FROM {table} WHERE id = ?"), id)
+	return err
+}
+
+var _ JobStore = (*SQLStore)(nil)
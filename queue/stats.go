@@ -0,0 +1,382 @@
+package queue
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/admpub/transcoder"
+)
+
+// Media describes the input of a job, see Describe
+type Media struct {
+	VideoCodec string  `json:"video_codec,omitempty"`
+	Width      int     `json:"width,omitempty"`
+	Height     int     `json:"height,omitempty"`
+	Duration   float64 `json:"duration,omitempty"`
+}
+
+// Describe records the media of the input of the job of ctx, the context a Runner
+// receives. TranscoderRunner does it for the transcoders with a WithMetadataHook
+// method. It reports whether ctx belongs to a queued job
+func Describe(ctx context.Context, metadata transcoder.Metadata) bool {
+	a, ok := ctx.Value(attachKey{}).(*attachment)
+	if !ok || metadata == nil || metadata.GetFormat() == nil {
+		return false
+	}
+	media := &Media{}
+	media.Duration, _ = strconv.ParseFloat(metadata.GetFormat().GetDuration(), 64)
+	for _, s := range metadata.GetStreams() {
+		if s.GetCodecType() == "video" {
+			media.VideoCodec, media.Width, media.Height = s.GetCodecName(), s.GetWidth(), s.GetHeight()
+			break
+		}
+	}
+	a.queue.mu.Lock()
+	defer a.queue.mu.Unlock()
+	a.entry.job.Media = media
+	return true
+}
+
+// JobStat is the outcome of a finished job, as recorded by a StatsStore
+type JobStat struct {
+	JobID  string `json:"job_id"`
+	Kind   string `json:"kind,omitempty"`
+	Tenant string `json:"tenant,omitempty"`
+	State  string `json:"state"`
+	// Codec is the video encoder of Spec.Args, such as libx264, the input codec when
+	// the arguments set none
+	Codec string `json:"codec,omitempty"`
+	// Resolution is the class of the input, such as 1080p, see ResolutionClass
+	Resolution   string  `json:"resolution,omitempty"`
+	MediaSeconds float64 `json:"media_seconds,omitempty"`
+	WallSeconds  float64 `json:"wall_seconds"`
+	CPUSeconds   float64 `json:"cpu_seconds,omitempty"`
+	// Speed is MediaSeconds per WallSeconds, 2 encoding twice faster than realtime
+	Speed    float64   `json:"speed,omitempty"`
+	Attempts int       `json:"attempts"`
+	Finished time.Time `json:"finished"`
+}
+
+// StatFilter selects stats, empty fields matching every stat
+type StatFilter struct {
+	Kind       string
+	Tenant     string
+	State      string
+	Codec      string
+	Resolution string
+	Since      time.Time
+	Until      time.Time
+}
+
+// match reports whether s is selected by f
+func (f StatFilter) match(s JobStat) bool {
+	return (len(f.Kind) == 0 || f.Kind == s.Kind) &&
+		(len(f.Tenant) == 0 || f.Tenant == s.Tenant) &&
+		(len(f.State) == 0 || f.State == s.State) &&
+		(len(f.Codec) == 0 || f.Codec == s.Codec) &&
+		(len(f.Resolution) == 0 || f.Resolution == s.Resolution) &&
+		(f.Since.IsZero() || !s.Finished.Before(f.Since)) &&
+		(f.Until.IsZero() || s.Finished.Before(f.Until))
+}
+
+// StatsStore records the stats of the finished jobs
+type StatsStore interface {
+	Record(stat JobStat) error
+	// Stats returns the stats selected by filter, oldest first
+	Stats(filter StatFilter) ([]JobStat, error)
+}
+
+// resolutionClasses are the heights of the resolution classes, largest first
+var resolutionClasses = []int{4320, 2160, 1440, 1080, 720, 576, 480, 360, 240}
+
+// ResolutionClass returns the class of a width x height video, such as 1080p, from
+// its shortest side rounded down to a common height so that 1920x1072 and portrait
+// 1080x1920 are 1080p. It is empty without a size
+func ResolutionClass(width, height int) string {
+	side := height
+	if width > 0 && width < side {
+		side = width
+	}
+	if side <= 0 {
+		return ""
+	}
+	for _, c := range resolutionClasses {
+		// encoders pad to multiples of 16, 1080 is often 1072 or 1088
+		if side >= c-16 {
+			return strconv.Itoa(c) + "p"
+		}
+	}
+	return strconv.Itoa(side) + "p"
+}
+
+// videoCodec returns the video encoder set by args
+func videoCodec(args []string) string {
+	for i := 0; i+1 < len(args); i++ {
+		switch args[i] {
+		case "-c:v", "-vcodec", "-codec:v", "-c:v:0", "-codec:v:0":
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+// NewJobStat returns the stat of a finished job
+func NewJobStat(job Job) JobStat {
+	s := JobStat{
+		JobID:    job.ID,
+		Kind:     job.Spec.Kind,
+		Tenant:   job.Spec.Tenant,
+		State:    job.State,
+		Codec:    videoCodec(job.Spec.Args),
+		Attempts: job.Attempts,
+		Finished: job.Finished,
+	}
+	if !job.Started.IsZero() && job.Finished.After(job.Started) {
+		s.WallSeconds = job.Finished.Sub(job.Started).Seconds()
+	}
+	if job.Usage != nil {
+		s.CPUSeconds = job.Usage.CPUSeconds
+	}
+	if m := job.Media; m != nil {
+		if len(s.Codec) == 0 {
+			s.Codec = m.VideoCodec
+		}
+		s.Resolution = ResolutionClass(m.Width, m.Height)
+		s.MediaSeconds = m.Duration
+		if s.WallSeconds > 0 && m.Duration > 0 {
+			s.Speed = m.Duration / s.WallSeconds
+		}
+	}
+	return s
+}
+
+// StatsCallbacks returns callbacks recording the stat of each finished job in store,
+// and its errors to onError when not nil, then calling next
+func StatsCallbacks(store StatsStore, onError func(job Job, err error), next Callbacks) Callbacks {
+	callbacks := next
+	callbacks.OnDone = func(job Job) {
+		if err := store.Record(NewJobStat(job)); err != nil && onError != nil {
+			onError(job, err)
+		}
+		if next.OnDone != nil {
+			next.OnDone(job)
+		}
+	}
+	return callbacks
+}
+
+// StatsSummary summarizes stats, the speeds and durations being those of the
+// succeeded jobs
+type StatsSummary struct {
+	Count     int `json:"count"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+	Canceled  int `json:"canceled"`
+	// SpeedP50 is the median speed and SpeedP95 the speed 95% of the jobs reach, the
+	// slow tail capacity is planned for
+	SpeedP50 float64 `json:"speed_p50"`
+	SpeedP95 float64 `json:"speed_p95"`
+	// WallP50 and WallP95 are the percentiles of the durations, in seconds
+	WallP50 float64 `json:"wall_p50"`
+	WallP95 float64 `json:"wall_p95"`
+	// MediaSeconds and CPUSeconds are the totals
+	MediaSeconds float64 `json:"media_seconds"`
+	CPUSeconds   float64 `json:"cpu_seconds"`
+	// CPUPerMediaSecond is the CPU time spent per second of media, the cost of an
+	// encode of that kind
+	CPUPerMediaSecond float64 `json:"cpu_per_media_second,omitempty"`
+}
+
+// Percentile returns the p percentile, 0 to 100, of values by linear interpolation,
+// 0 without values
+func Percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	if lower >= len(sorted)-1 {
+		return sorted[len(sorted)-1]
+	}
+	if lower < 0 {
+		return sorted[0]
+	}
+	return sorted[lower] + (sorted[lower+1]-sorted[lower])*(rank-float64(lower))
+}
+
+// Summarize returns the summary of stats, e.g. the speeds of 1080p x264 encodes:
+//
+//	stats, err := store.Stats(queue.StatFilter{Codec: "libx264", Resolution: "1080p", State: queue.StateSucceeded})
+//	summary := queue.Summarize(stats) // summary.SpeedP50, summary.SpeedP95
+func Summarize(stats []JobStat) StatsSummary {
+	var summary StatsSummary
+	var speeds, walls []float64
+	var cpu, cpuMedia float64
+	for _, s := range stats {
+		summary.Count++
+		switch s.State {
+		case StateSucceeded:
+			summary.Succeeded++
+		case StateCanceled:
+			summary.Canceled++
+		default:
+			summary.Failed++
+		}
+		summary.CPUSeconds += s.CPUSeconds
+		if s.State != StateSucceeded {
+			continue
+		}
+		summary.MediaSeconds += s.MediaSeconds
+		if s.CPUSeconds > 0 && s.MediaSeconds > 0 {
+			cpu += s.CPUSeconds
+			cpuMedia += s.MediaSeconds
+		}
+		walls = append(walls, s.WallSeconds)
+		if s.Speed > 0 {
+			speeds = append(speeds, s.Speed)
+		}
+	}
+	summary.SpeedP50, summary.SpeedP95 = Percentile(speeds, 50), Percentile(speeds, 5)
+	summary.WallP50, summary.WallP95 = Percentile(walls, 50), Percentile(walls, 95)
+	if cpuMedia > 0 {
+		summary.CPUPerMediaSecond = cpu / cpuMedia
+	}
+	return summary
+}
+
+// GroupStats summarizes stats per key, such as
+//
+//	queue.GroupStats(stats, func(s queue.JobStat) string { return s.Codec + " " + s.Resolution })
+func GroupStats(stats []JobStat, key func(JobStat) string) map[string]StatsSummary {
+	groups := map[string][]JobStat{}
+	for _, s := range stats {
+		k := key(s)
+		groups[k] = append(groups[k], s)
+	}
+	summaries := make(map[string]StatsSummary, len(groups))
+	for k, g := range groups {
+		summaries[k] = Summarize(g)
+	}
+	return summaries
+}
+
+// MemoryStats is a StatsStore keeping the last stats in memory
+type MemoryStats struct {
+	mu    sync.Mutex
+	max   int
+	stats []JobStat
+}
+
+// NewMemoryStats returns a store keeping the last max stats, 10000 when max is 0
+func NewMemoryStats(max int) *MemoryStats {
+	if max <= 0 {
+		max = 10000
+	}
+	return &MemoryStats{max: max}
+}
+
+// Record ...
+func (s *MemoryStats) Record(stat JobStat) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats = append(s.stats, stat)
+	if len(s.stats) > s.max {
+		s.stats = append(s.stats[:0], s.stats[len(s.stats)-s.max:]...)
+	}
+	return nil
+}
+
+// Stats ...
+func (s *MemoryStats) Stats(filter StatFilter) ([]JobStat, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var stats []JobStat
+	for _, stat := range s.stats {
+		if filter.match(stat) {
+			stats = append(stats, stat)
+		}
+	}
+	return stats, nil
+}
+
+// FileStats is a StatsStore appending the stats as JSON lines to a file, which log
+// rotation tools can rotate
+type FileStats struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileStats returns a store appending to path, its directory being created when
+// missing
+func NewFileStats(path string) (*FileStats, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+	return &FileStats{path: path}, nil
+}
+
+// Record ...
+func (s *FileStats) Record(stat JobStat) error {
+	data, err := json.Marshal(stat)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Stats ...
+func (s *FileStats) Stats(filter StatFilter) ([]JobStat, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var stats []JobStat
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+		var stat JobStat
+		if err := json.Unmarshal([]byte(line), &stat); err != nil {
+			return nil, fmt.Errorf("queue: failed to read stat line %d of %s with error %w", n, s.path, err)
+		}
+		if filter.match(stat) {
+			stats = append(stats, stat)
+		}
+	}
+	return stats, scanner.Err()
+}
+
+var (
+	_ StatsStore = (*MemoryStats)(nil)
+	_ StatsStore = (*FileStats)(nil)
+)
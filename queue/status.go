@@ -0,0 +1,292 @@
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EventSnapshot is the first event of a stream for each job it follows, holding the
+// state of the job when the client connected
+const EventSnapshot = "job.snapshot"
+
+// StatusConfig ...
+type StatusConfig struct {
+	// Step is the progress percentage between two job.progress events, defaults to 1
+	Step float64
+	// Buffer is the number of events buffered per stream, the events of a client
+	// slower than that are dropped. Defaults to 64
+	Buffer int
+	// KeepAlive is the interval of the keep-alive messages of idle streams, defaults
+	// to 15s
+	KeepAlive time.Duration
+	// CheckOrigin accepts the WebSocket connections, defaults to the requests without
+	// Origin or whose Origin is the requested host
+	CheckOrigin func(r *http.Request) bool
+}
+
+// StatusHandler serves the jobs of a queue for dashboards:
+//
+//	GET /jobs                 the jobs, filtered by the comma separated ?state=
+//	GET /jobs/<id>            a job, 404 when unknown
+//	GET /events               the events of the jobs, ?job=<id> following one job,
+//	                          as Server-Sent Events or over a WebSocket when the
+//	                          request asks for an upgrade
+//
+// Mount it with http.StripPrefix and leave authentication to a wrapping handler. The
+// events are those of the jobs submitted with its Callbacks
+type StatusHandler struct {
+	queue  *Queue
+	config StatusConfig
+
+	mu      sync.Mutex
+	streams map[*stream]struct{}
+}
+
+// stream is a connected client
+type stream struct {
+	job    string
+	events chan Event
+}
+
+// NewStatusHandler ...
+func NewStatusHandler(q *Queue, config StatusConfig) *StatusHandler {
+	if config.Step <= 0 {
+		config.Step = 1
+	}
+	if config.Buffer <= 0 {
+		config.Buffer = 64
+	}
+	if config.KeepAlive <= 0 {
+		config.KeepAlive = 15 * time.Second
+	}
+	if config.CheckOrigin == nil {
+		config.CheckOrigin = sameOrigin
+	}
+	return &StatusHandler{queue: q, config: config, streams: map[*stream]struct{}{}}
+}
+
+// sameOrigin reports whether r has no Origin or comes from the requested host
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if len(origin) == 0 {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// Callbacks returns callbacks streaming the events of a job, then calling next
+func (h *StatusHandler) Callbacks(next Callbacks) Callbacks {
+	var milestones []float64
+	for m := h.config.Step; m < 100; m += h.config.Step {
+		milestones = append(milestones, m)
+	}
+	return EventCallbacks(h.Publish, milestones, next)
+}
+
+// Publish sends event to the streams following its job
+func (h *StatusHandler) Publish(event Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.streams {
+		if len(s.job) > 0 && s.job != event.Job.ID {
+			continue
+		}
+		select {
+		case s.events <- event:
+		default:
+		}
+	}
+}
+
+// subscribe registers a stream following job, every job when it is empty
+func (h *StatusHandler) subscribe(job string) *stream {
+	s := &stream{job: job, events: make(chan Event, h.config.Buffer)}
+	h.mu.Lock()
+	h.streams[s] = struct{}{}
+	h.mu.Unlock()
+	return s
+}
+
+// unsubscribe ...
+func (h *StatusHandler) unsubscribe(s *stream) {
+	h.mu.Lock()
+	delete(h.streams, s)
+	h.mu.Unlock()
+}
+
+// snapshots returns the snapshot events of the jobs followed by a stream
+func (h *StatusHandler) snapshots(job string) ([]Event, error) {
+	if len(job) > 0 {
+		j, err := h.queue.Get(job)
+		if err != nil {
+			return nil, err
+		}
+		return []Event{newEvent(EventSnapshot, j)}, nil
+	}
+	var events []Event
+	for _, j := range h.queue.List(StateQueued, StateRunning, StateSuspended) {
+		events = append(events, newEvent(EventSnapshot, j))
+	}
+	return events, nil
+}
+
+// ServeHTTP ...
+func (h *StatusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "jobs":
+		var states []string
+		if s := r.URL.Query().Get("state"); len(s) > 0 {
+			states = strings.Split(s, ",")
+		}
+		jobs := h.queue.List(states...)
+		if jobs == nil {
+			jobs = []Job{}
+		}
+		writeJSON(w, http.StatusOK, jobs)
+	case len(parts) == 2 && parts[0] == "jobs":
+		job, err := h.queue.Get(parts[1])
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, ErrNotFound) {
+				status = http.StatusNotFound
+			}
+			writeJSON(w, status, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, job)
+	case len(parts) == 1 && parts[0] == "events":
+		job := r.URL.Query().Get("job")
+		// subscribed before the snapshots so that no event falls in between
+		s := h.subscribe(job)
+		defer h.unsubscribe(s)
+		snapshots, err := h.snapshots(job)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if isWebSocket(r) {
+			h.serveWebSocket(w, r, s, snapshots)
+			return
+		}
+		h.serveEvents(w, r, s, snapshots)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// writeJSON writes v with status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// streamEnds reports whether a stream following a job ends after event
+func streamEnds(job string, event Event) bool {
+	return len(job) > 0 && event.Job.Done()
+}
+
+// serveEvents streams the events as Server-Sent Events until the client leaves or the
+// followed job is done
+func (h *StatusHandler) serveEvents(w http.ResponseWriter, r *http.Request, s *stream, snapshots []Event) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	write := func(event Event) error {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write([]byte("id: " + event.ID + "\nevent: " + event.Type + "\ndata: " + string(data) + "\n\n")); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+	for _, event := range snapshots {
+		if err := write(event); err != nil || streamEnds(s.job, event) {
+			return
+		}
+	}
+	flusher.Flush()
+	keepAlive := time.NewTicker(h.config.KeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := w.Write([]byte(": keep-alive\n\n")); err != nil {
+				return
+			}
+			flusher.Flush()
+		case event := <-s.events:
+			if err := write(event); err != nil || streamEnds(s.job, event) {
+				return
+			}
+		}
+	}
+}
+
+// serveWebSocket streams the events as WebSocket text messages until the client
+// leaves or the followed job is done
+func (h *StatusHandler) serveWebSocket(w http.ResponseWriter, r *http.Request, s *stream, snapshots []Event) {
+	if !h.config.CheckOrigin(r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	conn, err := upgrade(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.close()
+
+	write := func(event Event) error {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		return conn.write(opText, data)
+	}
+	for _, event := range snapshots {
+		if err := write(event); err != nil || streamEnds(s.job, event) {
+			return
+		}
+	}
+	keepAlive := time.NewTicker(h.config.KeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-conn.closed:
+			return
+		case <-keepAlive.C:
+			if err := conn.write(opPing, nil); err != nil {
+				return
+			}
+		case event := <-s.events:
+			if err := write(event); err != nil || streamEnds(s.job, event) {
+				return
+			}
+		}
+	}
+}
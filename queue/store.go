@@ -0,0 +1,234 @@
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// JobStore persists jobs so that a restarted service can resume them, see Queue.Recover
+type JobStore interface {
+	// Save creates or replaces a job
+	Save(job Job) error
+	// Load returns ErrNotFound for unknown jobs
+	Load(id string) (Job, error)
+	List() ([]Job, error)
+	Delete(id string) error
+}
+
+// MemoryStore is a JobStore keeping the jobs in memory, for tests and single runs
+type MemoryStore struct {
+	mu   sync.Mutex
+	jobs map[string]Job
+}
+
+// NewMemoryStore ...
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{jobs: map[string]Job{}}
+}
+
+// Save ...
+func (s *MemoryStore) Save(job Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[job.ID] = job
+	return nil
+}
+
+// Load ...
+func (s *MemoryStore) Load(id string) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	return job, nil
+}
+
+// List ...
+func (s *MemoryStore) List() ([]Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := make([]Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// Delete ...
+func (s *MemoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.jobs, id)
+	return nil
+}
+
+// FileStore is a JobStore writing each job as a JSON file of a directory
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore returns a store in dir, created when missing
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// path returns the file of a job
+func (s *FileStore) path(id string) (string, error) {
+	if len(id) == 0 || strings.ContainsAny(id, `/\`) || id == "." || id == ".." {
+		return "", fmt.Errorf("queue: invalid job id %q", id)
+	}
+	return filepath.Join(s.dir, id+".json"), nil
+}
+
+// Save writes the job to a temporary file renamed over the previous one, so that a
+// crash never leaves a truncated job
+func (s *FileStore) Save(job Job) error {
+	path, err := s.path(job.ID)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Load ...
+func (s *FileStore) Load(id string) (Job, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return Job{}, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return Job{}, ErrNotFound
+	}
+	if err != nil {
+		return Job{}, err
+	}
+	var job Job
+	if err := json.Unmarshal(data, &job); err != nil {
+		return Job{}, fmt.Errorf("queue: failed to read job %s with error %w", id, err)
+	}
+	return job, nil
+}
+
+// List ...
+func (s *FileStore) List() ([]Job, error) {
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	jobs := make([]Job, 0, len(files))
+	for _, f := range files {
+		job, err := s.Load(strings.TrimSuffix(filepath.Base(f), ".json"))
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// Delete ...
+func (s *FileStore) Delete(id string) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// KV is the subset of a key value database a KVStore needs. Thin wrappers around a
+// bolt bucket or a Redis hash implement it
+type KV interface {
+	Get(key string) ([]byte, bool, error)
+	Put(key string, value []byte) error
+	Delete(key string) error
+	// Values returns every stored value
+	Values() ([][]byte, error)
+}
+
+// KVStore is a JobStore storing jobs as JSON values of a KV
+type KVStore struct {
+	kv KV
+}
+
+// NewKVStore ...
+func NewKVStore(kv KV) *KVStore {
+	return &KVStore{kv: kv}
+}
+
+// Save ...
+func (s *KVStore) Save(job Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return s.kv.Put(job.ID, data)
+}
+
+// Load ...
+func (s *KVStore) Load(id string) (Job, error) {
+	data, ok, err := s.kv.Get(id)
+	if err != nil {
+		return Job{}, err
+	}
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	var job Job
+	if err := json.Unmarshal(data, &job); err != nil {
+		return Job{}, fmt.Errorf("queue: failed to read job %s with error %w", id, err)
+	}
+	return job, nil
+}
+
+// List ...
+func (s *KVStore) List() ([]Job, error) {
+	values, err := s.kv.Values()
+	if err != nil {
+		return nil, err
+	}
+	jobs := make([]Job, 0, len(values))
+	for _, data := range values {
+		var job Job
+		if err := json.Unmarshal(data, &job); err != nil {
+			return nil, fmt.Errorf("queue: failed to read a job with error %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// Delete ...
+func (s *KVStore) Delete(id string) error {
+	return s.kv.Delete(id)
+}
+
+var (
+	_ JobStore = (*MemoryStore)(nil)
+	_ JobStore = (*FileStore)(nil)
+	_ JobStore = (*KVStore)(nil)
+)
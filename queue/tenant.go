@@ -0,0 +1,177 @@
+package queue
+
+import (
+	"time"
+)
+
+// TenantQuota limits the jobs of a tenant, zero values meaning no limit
+type TenantQuota struct {
+	// MaxConcurrent is the number of jobs of the tenant running at once
+	MaxConcurrent int
+	// CPUMinutesPerDay is the running time of the jobs of the tenant per local day,
+	// accounted as the wall time they hold a worker slot. Jobs that would start
+	// past it wait for the next day, running jobs are not stopped
+	CPUMinutesPerDay float64
+	// Weight is the share of the workers the tenant gets when others compete for
+	// them, defaults to 1
+	Weight float64
+}
+
+// TenantUsage is the current usage of a tenant
+type TenantUsage struct {
+	Tenant  string `json:"tenant"`
+	Running int    `json:"running"`
+	Queued  int    `json:"queued"`
+	// CPUMinutes is the running time of the jobs of the tenant today
+	CPUMinutes float64 `json:"cpu_minutes"`
+}
+
+// dayUsage is the running time of a tenant during a day
+type dayUsage struct {
+	day     string
+	seconds float64
+}
+
+// quota returns the quota of tenant
+func (q *Queue) quota(tenant string) TenantQuota {
+	quota, ok := q.config.Tenants[tenant]
+	if !ok {
+		quota = q.config.DefaultQuota
+	}
+	if quota.Weight <= 0 {
+		quota.Weight = 1
+	}
+	return quota
+}
+
+// acquire gives a worker slot to e, with q.mu held
+func (q *Queue) acquire(e *entry, now time.Time) {
+	q.running[e.job.Priority]++
+	q.tenants[e.job.Spec.Tenant]++
+	q.total++
+	e.slot, e.since = true, now
+}
+
+// release takes the worker slot of e back and accounts its running time, with q.mu held
+func (q *Queue) release(e *entry, now time.Time) {
+	if !e.slot {
+		return
+	}
+	q.running[e.job.Priority]--
+	q.tenants[e.job.Spec.Tenant]--
+	q.total--
+	e.slot = false
+	// a slot held across midnight only counts for the day it ends
+	since := e.since
+	if midnight := startOfDay(now); since.Before(midnight) {
+		since = midnight
+	}
+	q.usedToday(e.job.Spec.Tenant, now).seconds += now.Sub(since).Seconds()
+}
+
+// startOfDay returns the local midnight starting the day of t
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// usedToday returns the usage of tenant for the day of now, with q.mu held
+func (q *Queue) usedToday(tenant string, now time.Time) *dayUsage {
+	day := now.Format("2006-01-02")
+	u, ok := q.usage[tenant]
+	if !ok || u.day != day {
+		u = &dayUsage{day: day}
+		q.usage[tenant] = u
+	}
+	return u
+}
+
+// cpuSeconds returns the running time of tenant today, including its running jobs,
+// with q.mu held
+func (q *Queue) cpuSeconds(tenant string, now time.Time) float64 {
+	seconds := q.usedToday(tenant, now).seconds
+	midnight := startOfDay(now)
+	for _, e := range q.jobs {
+		if e.slot && e.job.Spec.Tenant == tenant {
+			since := e.since
+			if since.Before(midnight) {
+				since = midnight
+			}
+			seconds += now.Sub(since).Seconds()
+		}
+	}
+	return seconds
+}
+
+// eligible reports whether the quota of the tenant of e allows it to run, with q.mu held
+func (q *Queue) eligible(e *entry, now time.Time) bool {
+	tenant := e.job.Spec.Tenant
+	quota := q.quota(tenant)
+	if quota.MaxConcurrent > 0 && q.tenants[tenant] >= quota.MaxConcurrent {
+		return false
+	}
+	if quota.CPUMinutesPerDay > 0 && q.cpuSeconds(tenant, now) >= quota.CPUMinutesPerDay*60 {
+		q.wakeTomorrow(now)
+		return false
+	}
+	return true
+}
+
+// wakeTomorrow dispatches again at the next local midnight, when the jobs waiting for
+// their daily quota may start, with q.mu held
+func (q *Queue) wakeTomorrow(now time.Time) {
+	if q.midnight != nil {
+		return
+	}
+	tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 1, 0, now.Location())
+	q.midnight = time.AfterFunc(tomorrow.Sub(now), func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		q.midnight = nil
+		q.dispatch()
+	})
+}
+
+// pick returns the job of lane to run next: among the jobs allowed by their quota, the
+// one whose tenant runs the fewest jobs relative to its weight, then has used the least
+// time today relative to its weight, the first submitted winning ties. With q.mu held
+func (q *Queue) pick(lane []*entry, now time.Time) *entry {
+	var (
+		best               *entry
+		bestShare, bestCPU float64
+		seen               = map[string]bool{}
+	)
+	for _, e := range lane {
+		tenant := e.job.Spec.Tenant
+		if seen[tenant] {
+			// only the first job of each tenant competes
+			continue
+		}
+		seen[tenant] = true
+		if !q.eligible(e, now) {
+			continue
+		}
+		weight := q.quota(tenant).Weight
+		share := float64(q.tenants[tenant]) / weight
+		cpu := q.usedToday(tenant, now).seconds / weight
+		if best == nil || share < bestShare || (share == bestShare && cpu < bestCPU) {
+			best, bestShare, bestCPU = e, share, cpu
+		}
+	}
+	return best
+}
+
+// Usage returns the usage of tenant
+func (q *Queue) Usage(tenant string) TenantUsage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	usage := TenantUsage{Tenant: tenant, Running: q.tenants[tenant], CPUMinutes: q.cpuSeconds(tenant, now) / 60}
+	for _, lane := range q.lanes {
+		for _, e := range lane {
+			if e.job.Spec.Tenant == tenant {
+				usage.Queued++
+			}
+		}
+	}
+	return usage
+}
@@ -0,0 +1,44 @@
+package queue
+
+import (
+	"context"
+	"errors"
+
+	"github.com/admpub/transcoder"
+)
+
+// startSpan starts the span of a run of job when the queue has a tracer, the span
+// is nil otherwise
+func (q *Queue) startSpan(ctx context.Context, job Job) (context.Context, transcoder.Span) {
+	if q.config.Tracer == nil {
+		return ctx, nil
+	}
+	return q.config.Tracer.Start(ctx, "transcoder.job", map[string]interface{}{
+		"job.id":       job.ID,
+		"job.kind":     job.Spec.Kind,
+		"job.tenant":   job.Spec.Tenant,
+		"job.priority": int(job.Priority),
+		"job.attempt":  job.Attempts,
+		"input":        job.Spec.Input,
+		"output":       job.Spec.Output,
+	})
+}
+
+// endSpan records the outcome of the run of job, the span may be nil
+func endSpan(span transcoder.Span, job Job, err error) {
+	if span == nil {
+		return
+	}
+	attributes := map[string]interface{}{"job.state": string(job.State)}
+	if len(job.ErrorClass) > 0 {
+		attributes["job.error_class"] = job.ErrorClass
+	}
+	span.SetAttributes(attributes)
+	if err == nil && job.State != StateSucceeded && len(job.Error) > 0 {
+		err = errors.New(job.Error)
+	}
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
@@ -0,0 +1,235 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Webhook headers
+const (
+	HeaderWebhookID        = "X-Webhook-Id"
+	HeaderWebhookEvent     = "X-Webhook-Event"
+	HeaderWebhookSignature = "X-Webhook-Signature"
+)
+
+// ErrBadSignature is returned by VerifyWebhook for unsigned, tampered or stale requests
+var ErrBadSignature = errors.New("queue: bad webhook signature")
+
+// WebhookConfig ...
+type WebhookConfig struct {
+	URL string
+	// Secret signs the requests with HMAC-SHA256 when not empty, see VerifyWebhook
+	Secret string
+	Header http.Header
+	// Client defaults to a client with a 10s timeout
+	Client *http.Client
+	// Events are the event types sent, every type when empty
+	Events []string
+	// Milestones are the progress percentages sending a job.progress event, defaults
+	// to 25, 50 and 75
+	Milestones []float64
+	// MaxAttempts is the number of deliveries of an event before it is dropped, defaults to 5
+	MaxAttempts int
+	// Backoff is the wait before the first retry, doubled for each retry up to
+	// MaxBackoff. They default to 1s and 1m
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Buffer is the number of events waiting for delivery, defaults to 256. Events
+	// beyond it are dropped and reported to OnError
+	Buffer int
+	// OnError receives the events that could not be delivered
+	OnError func(event Event, err error)
+}
+
+// Webhook posts job lifecycle events to an HTTP endpoint. Events are delivered in order
+// by Run, so that a slow endpoint never blocks the jobs
+type Webhook struct {
+	config WebhookConfig
+	events chan Event
+}
+
+// NewWebhook ...
+func NewWebhook(config WebhookConfig) (*Webhook, error) {
+	if len(config.URL) == 0 {
+		return nil, errors.New("queue: webhook needs a url")
+	}
+	if config.Client == nil {
+		config.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 5
+	}
+	if config.Backoff <= 0 {
+		config.Backoff = time.Second
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = time.Minute
+	}
+	if config.Buffer <= 0 {
+		config.Buffer = 256
+	}
+	return &Webhook{config: config, events: make(chan Event, config.Buffer)}, nil
+}
+
+// wanted reports whether events of type t are sent
+func (w *Webhook) wanted(t string) bool {
+	if len(w.config.Events) == 0 {
+		return true
+	}
+	for _, e := range w.config.Events {
+		if e == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Notify queues an event of type t about job
+func (w *Webhook) Notify(t string, job Job) {
+	w.notify(newEvent(t, job))
+}
+
+func (w *Webhook) notify(event Event) {
+	if !w.wanted(event.Type) {
+		return
+	}
+	select {
+	case w.events <- event:
+	default:
+		if w.config.OnError != nil {
+			w.config.OnError(event, errors.New("queue: webhook buffer full"))
+		}
+	}
+}
+
+// Callbacks returns callbacks notifying the events of a job, then calling next
+func (w *Webhook) Callbacks(next Callbacks) Callbacks {
+	return EventCallbacks(w.notify, w.config.Milestones, next)
+}
+
+// Run delivers the events until ctx is done
+func (w *Webhook) Run(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event := <-w.events:
+			if err := w.deliver(ctx, event); err != nil && w.config.OnError != nil {
+				w.config.OnError(event, err)
+			}
+		}
+	}
+}
+
+// deliver posts event, retrying with backoff on network errors, 5xx, 408 and 429
+func (w *Webhook) deliver(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	backoff := w.config.Backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := w.post(ctx, event, body)
+		if err == nil || !retry || attempt >= w.config.MaxAttempts {
+			if err != nil {
+				return fmt.Errorf("webhook %s %s failed after %d attempts with error %w", event.Type, event.ID, attempt, err)
+			}
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > w.config.MaxBackoff {
+			backoff = w.config.MaxBackoff
+		}
+	}
+}
+
+// post sends one request and tells whether a failure is worth retrying
+func (w *Webhook) post(ctx context.Context, event Event, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, w.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+	for k, v := range w.config.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderWebhookID, event.ID)
+	req.Header.Set(HeaderWebhookEvent, event.Type)
+	if len(w.config.Secret) > 0 {
+		req.Header.Set(HeaderWebhookSignature, SignWebhook(w.config.Secret, time.Now(), body))
+	}
+	resp, err := w.config.Client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("status %s", resp.Status)
+}
+
+// signature returns the hex HMAC-SHA256 of the timestamp and body
+func signature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignWebhook returns the signature header of body sent at t: t=<unix time>,v1=<hex
+// HMAC-SHA256 of "<unix time>." followed by the body>
+func SignWebhook(secret string, t time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + timestamp + ",v1=" + signature(secret, timestamp, body)
+}
+
+// VerifyWebhook checks the signature header of a received body, rejecting signatures
+// older than tolerance when it is positive to prevent replays
+func VerifyWebhook(secret, header string, body []byte, tolerance time.Duration) error {
+	var timestamp, sig string
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "t":
+			timestamp = kv[1]
+		case "v1":
+			sig = kv[1]
+		}
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(sig) == 0 {
+		return ErrBadSignature
+	}
+	if tolerance > 0 {
+		if age := time.Since(time.Unix(unix, 0)); age > tolerance || age < -tolerance {
+			return ErrBadSignature
+		}
+	}
+	if !hmac.Equal([]byte(sig), []byte(signature(secret, timestamp, body))) {
+		return ErrBadSignature
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Worker runs the jobs it claims from a Broker, reporting their progress with heartbeats
+type Worker struct {
+	// ID identifies the worker, unique among the workers of a coordinator
+	ID     string
+	Broker Broker
+	Runner Runner
+	// Concurrency is the number of jobs run at once, defaults to 1
+	Concurrency int
+	// PollInterval between claims when no job is pending, defaults to 2s
+	PollInterval time.Duration
+	// HeartbeatInterval defaults to 5s, it must stay well under the lease timeout
+	HeartbeatInterval time.Duration
+	// OnError receives the broker errors, which are retried
+	OnError func(err error)
+}
+
+// workerJob is a job running on a worker
+type workerJob struct {
+	cancel   context.CancelFunc
+	progress float64
+}
+
+// Run claims and runs jobs until ctx is done, then waits for the running jobs, which
+// stop with ctx. They are not completed, the coordinator requeues them once their lease expires
+func (w *Worker) Run(ctx context.Context) error {
+	if len(w.ID) == 0 || w.Broker == nil || w.Runner == nil {
+		return errors.New("queue: worker needs an ID, a broker and a runner")
+	}
+	concurrency, poll, beat := w.Concurrency, w.PollInterval, w.HeartbeatInterval
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	if poll <= 0 {
+		poll = 2 * time.Second
+	}
+	if beat <= 0 {
+		beat = 5 * time.Second
+	}
+	report := func(err error) {
+		if err != nil && w.OnError != nil {
+			w.OnError(err)
+		}
+	}
+	var (
+		mu      sync.Mutex
+		running = map[string]*workerJob{}
+		wg      sync.WaitGroup
+		slots   = make(chan struct{}, concurrency)
+	)
+
+	heartbeat := func() {
+		mu.Lock()
+		progress := make(map[string]float64, len(running))
+		for id, j := range running {
+			progress[id] = j.progress
+		}
+		mu.Unlock()
+		stop, err := w.Broker.Heartbeat(context.Background(), w.ID, progress)
+		report(err)
+		mu.Lock()
+		for _, id := range stop {
+			if j, ok := running[id]; ok {
+				j.cancel()
+			}
+		}
+		mu.Unlock()
+	}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(beat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				heartbeat()
+			}
+		}
+	}()
+
+	run := func(job Job) {
+		defer wg.Done()
+		defer func() { <-slots }()
+		jobCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		state := &workerJob{cancel: cancel}
+		mu.Lock()
+		running[job.ID] = state
+		mu.Unlock()
+		progress, err := w.Runner(jobCtx, job)
+		if err != nil {
+			progress = nil
+		}
+		for p := range progressOrClosed(progress) {
+			if p.GetError() != nil {
+				err = p.GetError()
+			}
+			mu.Lock()
+			state.progress = p.GetProgress()
+			mu.Unlock()
+		}
+		mu.Lock()
+		delete(running, job.ID)
+		mu.Unlock()
+		if ctx.Err() != nil {
+			return
+		}
+		message := ""
+		if err != nil {
+			message = err.Error()
+		}
+		report(w.Broker.Complete(context.Background(), w.ID, job.ID, message))
+	}
+
+	for {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			close(done)
+			wg.Wait()
+			return ctx.Err()
+		}
+		job, ok, err := w.Broker.Claim(ctx, w.ID)
+		if err != nil || !ok {
+			<-slots
+			report(err)
+			select {
+			case <-time.After(poll):
+				continue
+			case <-ctx.Done():
+				close(done)
+				wg.Wait()
+				return ctx.Err()
+			}
+		}
+		wg.Add(1)
+		go run(job)
+	}
+}
@@ -0,0 +1,22 @@
+package transcoder
+
+import "context"
+
+// Tracer starts the spans of transcodes, probes and packaging runs. An OpenTelemetry
+// adapter is a few lines around a trace.Tracer:
+//
+//	func (t otelTracer) Start(ctx context.Context, name string, attributes map[string]interface{}) (context.Context, transcoder.Span) {
+//		ctx, span := t.tracer.Start(ctx, name, trace.WithAttributes(toKeyValues(attributes)...))
+//		return ctx, otelSpan{span}
+//	}
+type Tracer interface {
+	// Start starts a span child of the span of ctx and returns a context holding it
+	Start(ctx context.Context, name string, attributes map[string]interface{}) (context.Context, Span)
+}
+
+// Span is a traced operation
+type Span interface {
+	SetAttributes(attributes map[string]interface{})
+	RecordError(err error)
+	End()
+}
@@ -0,0 +1,266 @@
+// Package transcodertest provides an in-process transcoder.Transcoder that
+// simulates a transcoding run without executing any binary, so that code
+// orchestrating transcoders can be unit tested.
+package transcodertest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/admpub/transcoder"
+	"github.com/admpub/transcoder/utils"
+)
+
+// ErrSimulated is the error reported by a failing fake when Config.Err is not set
+var ErrSimulated = errors.New("transcodertest: simulated failure")
+
+// Curve maps the elapsed fraction of a run (0..1) to the reported progress fraction (0..1)
+type Curve func(elapsed float64) float64
+
+// Linear reports progress proportional to elapsed time
+func Linear(elapsed float64) float64 {
+	return elapsed
+}
+
+// EaseIn reports slow progress at the beginning of the run, like an encoder warming up
+func EaseIn(elapsed float64) float64 {
+	return elapsed * elapsed
+}
+
+// Stall returns a curve that stops advancing once the given fraction is reached
+func Stall(at float64) Curve {
+	return func(elapsed float64) float64 {
+		if elapsed > at {
+			return at
+		}
+		return elapsed
+	}
+}
+
+// Config ...
+type Config struct {
+	// Duration is the wall clock time the simulated run takes
+	Duration time.Duration
+	// Steps is the number of progress events emitted, defaults to 10
+	Steps int
+	// Curve shapes the reported progress, defaults to Linear
+	Curve Curve
+	// MediaDuration is the duration of the simulated input, used for CurrentTime and GetMetadata
+	MediaDuration time.Duration
+
+	// StartErr is returned by Start before any progress is emitted
+	StartErr error
+	// MetadataErr is returned by GetMetadata (and therefore Start)
+	MetadataErr error
+	// FailAt makes the run fail once this fraction (0..1] of the run has elapsed
+	FailAt float64
+	// Err is the error sent on the progress channel when FailAt is reached, defaults to ErrSimulated
+	Err error
+
+	// Metadata is returned by GetMetadata, a minimal value is generated when nil
+	Metadata transcoder.Metadata
+	// ProgressEnabled mirrors the real backends: when false Start blocks until the run is over
+	ProgressEnabled bool
+}
+
+// Transcoder is a fake transcoder.Transcoder
+type Transcoder struct {
+	config         *Config
+	input          string
+	output         []string
+	options        []transcoder.Options
+	startOptions   transcoder.Options
+	commandContext context.Context
+	inputPipe      bool
+	outputPipe     bool
+
+	mu     sync.Mutex
+	starts int
+}
+
+// New ...
+func New(cfg *Config) *Transcoder {
+	return &Transcoder{config: cfg}
+}
+
+// Start ...
+func (t *Transcoder) Start(opts transcoder.Options) (<-chan transcoder.Progress, error) {
+	t.mu.Lock()
+	t.starts++
+	t.startOptions = opts
+	t.mu.Unlock()
+
+	if t.config.StartErr != nil {
+		return nil, t.config.StartErr
+	}
+	if t.input == "" && !t.inputPipe {
+		return nil, errors.New("missing input option")
+	}
+	if len(t.output) == 0 && !t.outputPipe {
+		return nil, errors.New("missing output option")
+	}
+	if _, err := t.GetMetadata(); err != nil {
+		return nil, err
+	}
+
+	ctx := t.commandContext
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	out := make(chan transcoder.Progress)
+	if !t.config.ProgressEnabled {
+		err := t.run(ctx, nil)
+		return out, err
+	}
+
+	go func() {
+		defer close(out)
+		if err := t.run(ctx, out); err != nil {
+			out <- Progress{Error: err}
+		}
+	}()
+	return out, nil
+}
+
+// run simulates the process, sending progress to out when not nil
+func (t *Transcoder) run(ctx context.Context, out chan<- transcoder.Progress) error {
+	steps := t.config.Steps
+	if steps <= 0 {
+		steps = 10
+	}
+	curve := t.config.Curve
+	if curve == nil {
+		curve = Linear
+	}
+	interval := t.config.Duration / time.Duration(steps)
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	media := t.config.MediaDuration.Seconds()
+	for i := 1; i <= steps; i++ {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			timer.Reset(interval)
+		}
+
+		elapsed := float64(i) / float64(steps)
+		if t.config.FailAt > 0 && elapsed >= t.config.FailAt {
+			if t.config.Err != nil {
+				return t.config.Err
+			}
+			return ErrSimulated
+		}
+		if out == nil {
+			continue
+		}
+
+		fraction := curve(elapsed)
+		p := Progress{
+			FramesProcessed: strconv.Itoa(int(fraction * media * 25)),
+			CurrentTime:     utils.SecToDur(fraction * media),
+			CurrentBitrate:  "1000.0kbits/s",
+			Progress:        fraction * 100,
+			Speed:           "1x",
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case out <- p:
+		}
+	}
+	return nil
+}
+
+// Input ...
+func (t *Transcoder) Input(arg string) transcoder.Transcoder {
+	t.input = arg
+	return t
+}
+
+// InputPipe ...
+func (t *Transcoder) InputPipe(w io.WriteCloser, r io.ReadCloser) transcoder.Transcoder {
+	t.inputPipe = true
+	return t
+}
+
+// Output ...
+func (t *Transcoder) Output(arg string) transcoder.Transcoder {
+	t.output = append(t.output, arg)
+	return t
+}
+
+// OutputPipe ...
+func (t *Transcoder) OutputPipe(w io.WriteCloser, r io.ReadCloser) transcoder.Transcoder {
+	t.outputPipe = true
+	return t
+}
+
+// WithOptions ...
+func (t *Transcoder) WithOptions(opts transcoder.Options) transcoder.Transcoder {
+	t.options = []transcoder.Options{opts}
+	return t
+}
+
+// WithAdditionalOptions ...
+func (t *Transcoder) WithAdditionalOptions(opts transcoder.Options) transcoder.Transcoder {
+	t.options = append(t.options, opts)
+	return t
+}
+
+// WithContext ...
+func (t *Transcoder) WithContext(ctx context.Context) transcoder.Transcoder {
+	t.commandContext = ctx
+	return t
+}
+
+// GetMetadata ...
+func (t *Transcoder) GetMetadata() (transcoder.Metadata, error) {
+	if t.config.MetadataErr != nil {
+		return nil, t.config.MetadataErr
+	}
+	if t.config.Metadata != nil {
+		return t.config.Metadata, nil
+	}
+	return Metadata{
+		Format: Format{
+			Filename: t.input,
+			Duration: strconv.FormatFloat(t.config.MediaDuration.Seconds(), 'f', 6, 64),
+		},
+	}, nil
+}
+
+// InputArg returns the input set on the fake
+func (t *Transcoder) InputArg() string {
+	return t.input
+}
+
+// Outputs returns the outputs set on the fake
+func (t *Transcoder) Outputs() []string {
+	return t.output
+}
+
+// Options returns the options set through WithOptions/WithAdditionalOptions
+func (t *Transcoder) Options() []transcoder.Options {
+	return t.options
+}
+
+// StartOptions returns the options passed to the last Start call
+func (t *Transcoder) StartOptions() transcoder.Options {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.startOptions
+}
+
+// Starts returns how many times Start was called
+func (t *Transcoder) Starts() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.starts
+}
@@ -0,0 +1,96 @@
+package transcodertest
+
+import "github.com/admpub/transcoder"
+
+// Progress ...
+type Progress struct {
+	FramesProcessed string
+	CurrentTime     string
+	CurrentBitrate  string
+	Progress        float64
+	Speed           string
+	Error           error
+}
+
+// GetFramesProcessed ...
+func (p Progress) GetFramesProcessed() string { return p.FramesProcessed }
+
+// GetCurrentTime ...
+func (p Progress) GetCurrentTime() string { return p.CurrentTime }
+
+// GetCurrentBitrate ...
+func (p Progress) GetCurrentBitrate() string { return p.CurrentBitrate }
+
+// GetProgress ...
+func (p Progress) GetProgress() float64 { return p.Progress }
+
+// GetSpeed ...
+func (p Progress) GetSpeed() string { return p.Speed }
+
+// GetError ...
+func (p Progress) GetError() error { return p.Error }
+
+// Metadata is a minimal transcoder.Metadata
+type Metadata struct {
+	Format  Format
+	Streams []transcoder.Streams
+}
+
+// GetFormat ...
+func (m Metadata) GetFormat() transcoder.Format { return m.Format }
+
+// GetStreams ...
+func (m Metadata) GetStreams() []transcoder.Streams { return m.Streams }
+
+// Format is a minimal transcoder.Format
+type Format struct {
+	Filename   string
+	FormatName string
+	Duration   string
+	Size       string
+	BitRate    string
+}
+
+// GetFilename ...
+func (f Format) GetFilename() string { return f.Filename }
+
+// GetNbStreams ...
+func (f Format) GetNbStreams() int { return 0 }
+
+// GetNbPrograms ...
+func (f Format) GetNbPrograms() int { return 0 }
+
+// GetFormatName ...
+func (f Format) GetFormatName() string { return f.FormatName }
+
+// GetFormatLongName ...
+func (f Format) GetFormatLongName() string { return f.FormatName }
+
+// GetDuration ...
+func (f Format) GetDuration() string { return f.Duration }
+
+// GetSize ...
+func (f Format) GetSize() string { return f.Size }
+
+// GetBitRate ...
+func (f Format) GetBitRate() string { return f.BitRate }
+
+// GetProbeScore ...
+func (f Format) GetProbeScore() int { return 100 }
+
+// GetTags ...
+func (f Format) GetTags() transcoder.Tags { return Tags{} }
+
+// Tags ...
+type Tags struct {
+	Encoder  string
+	Timecode string
+}
+
+// GetEncoder ...
+func (t Tags) GetEncoder() string { return t.Encoder }
+
+// GetTimecode ...
+func (t Tags) GetTimecode() string { return t.Timecode }
+
+var _ transcoder.Transcoder = (*Transcoder)(nil)
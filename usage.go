@@ -0,0 +1,30 @@
+package transcoder
+
+// Usage is the resources used by a process, or the sum of those of the processes of
+// a job
+type Usage struct {
+	// CPUSeconds is UserSeconds plus SystemSeconds
+	CPUSeconds    float64 `json:"cpu_seconds"`
+	UserSeconds   float64 `json:"user_seconds"`
+	SystemSeconds float64 `json:"system_seconds"`
+	WallSeconds   float64 `json:"wall_seconds"`
+	// MaxRSS is the peak resident memory in bytes, the highest of the processes
+	MaxRSS int64 `json:"max_rss"`
+	// ReadBytes and WriteBytes are the bytes read and written, through files, pipes
+	// and sockets alike on Linux, the block I/O only elsewhere
+	ReadBytes  int64 `json:"read_bytes"`
+	WriteBytes int64 `json:"write_bytes"`
+}
+
+// Add adds the usage of another process
+func (u *Usage) Add(o Usage) {
+	u.CPUSeconds += o.CPUSeconds
+	u.UserSeconds += o.UserSeconds
+	u.SystemSeconds += o.SystemSeconds
+	u.WallSeconds += o.WallSeconds
+	if o.MaxRSS > u.MaxRSS {
+		u.MaxRSS = o.MaxRSS
+	}
+	u.ReadBytes += o.ReadBytes
+	u.WriteBytes += o.WriteBytes
+}
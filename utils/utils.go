@@ -1,22 +1,60 @@
 package utils
 
 import (
+	"fmt"
 	"strconv"
 	"strings"
 )
 
-// DurToSec ...
+// DurToSec converts an HH:MM:SS.ss duration to seconds, 0 when it has another layout.
+// It does not allocate, being called for each progress line
 func DurToSec(dur string) (sec float64) {
-	durAry := strings.Split(dur, ":")
-	var secs float64
-	if len(durAry) != 3 {
+	i := strings.IndexByte(dur, ':')
+	if i < 0 {
 		return
 	}
-	hr, _ := strconv.ParseFloat(durAry[0], 64)
-	secs = hr * (60 * 60)
-	min, _ := strconv.ParseFloat(durAry[1], 64)
+	j := strings.IndexByte(dur[i+1:], ':')
+	if j < 0 {
+		return
+	}
+	j += i + 1
+	if strings.IndexByte(dur[j+1:], ':') >= 0 {
+		return
+	}
+	hr, _ := strconv.ParseFloat(dur[:i], 64)
+	secs := hr * (60 * 60)
+	min, _ := strconv.ParseFloat(dur[i+1:j], 64)
 	secs += min * (60)
-	second, _ := strconv.ParseFloat(durAry[2], 64)
+	second, _ := strconv.ParseFloat(dur[j+1:], 64)
 	secs += second
 	return secs
 }
+
+// SecToDur formats seconds as HH:MM:SS.ss, the layout used by ffmpeg for time=
+func SecToDur(sec float64) string {
+	if sec < 0 {
+		sec = 0
+	}
+	hr := int(sec / 3600)
+	sec -= float64(hr * 3600)
+	min := int(sec / 60)
+	sec -= float64(min * 60)
+	return fmt.Sprintf("%02d:%02d:%05.2f", hr, min, sec)
+}
+
+// ParseRate parses a rational such as "30000/1001" (or a plain number) into a float
+func ParseRate(rate string) float64 {
+	parts := strings.SplitN(rate, "/", 2)
+	num, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return 0
+	}
+	if len(parts) == 1 {
+		return num
+	}
+	den, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil || den == 0 {
+		return 0
+	}
+	return num / den
+}
@@ -0,0 +1,298 @@
+// Package watch implements hot folders: files dropped in watched directories are
+// processed by the recipe matching their name once they are completely copied, then
+// moved to a done or failed folder.
+package watch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/admpub/transcoder/queue"
+)
+
+// Event kinds
+const (
+	EventStarted   = "started"
+	EventSucceeded = "succeeded"
+	EventFailed    = "failed"
+)
+
+// Event reports the processing of a file
+type Event struct {
+	Kind string
+	Path string
+	// Moved is where the file was moved after processing
+	Moved string
+	Err   error
+}
+
+// Recipe processes the files whose base name matches Pattern (filepath.Match syntax,
+// case insensitive)
+type Recipe struct {
+	Pattern string
+	Process func(ctx context.Context, path string) error
+}
+
+// Config ...
+type Config struct {
+	// Dirs are the watched directories, their subdirectories are not watched
+	Dirs    []string
+	Recipes []Recipe
+	// DoneDir and FailedDir receive the processed files, default to the done and
+	// failed subdirectories of the watched directory
+	DoneDir   string
+	FailedDir string
+	// PollInterval between scans, defaults to 2s
+	PollInterval time.Duration
+	// StableFor is how long the size and modification time of a file must stay the
+	// same before it is processed, defaults to 5s
+	StableFor time.Duration
+	// Concurrency is the number of files processed at once, defaults to 1
+	Concurrency int
+	// Notify triggers an immediate scan on each receive, to plug a file system
+	// notification library such as fsnotify in front of polling
+	Notify <-chan struct{}
+	// OnEvent is called for each processed file
+	OnEvent func(Event)
+}
+
+// candidate is a file waiting to be stable
+type candidate struct {
+	size    int64
+	modTime time.Time
+	since   time.Time
+}
+
+// Watcher ...
+type Watcher struct {
+	config     Config
+	mu         sync.Mutex
+	candidates map[string]candidate
+	processing map[string]bool
+}
+
+// New returns a watcher for config
+func New(config Config) (*Watcher, error) {
+	if len(config.Dirs) == 0 || len(config.Recipes) == 0 {
+		return nil, errors.New("watch: needs directories and recipes")
+	}
+	for _, r := range config.Recipes {
+		if _, err := filepath.Match(r.Pattern, ""); err != nil || r.Process == nil {
+			return nil, fmt.Errorf("watch: invalid recipe %q", r.Pattern)
+		}
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = 2 * time.Second
+	}
+	if config.StableFor <= 0 {
+		config.StableFor = 5 * time.Second
+	}
+	if config.Concurrency <= 0 {
+		config.Concurrency = 1
+	}
+	return &Watcher{config: config, candidates: map[string]candidate{}, processing: map[string]bool{}}, nil
+}
+
+// recipe returns the first recipe matching name
+func (w *Watcher) recipe(name string) *Recipe {
+	lower := strings.ToLower(name)
+	for i, r := range w.config.Recipes {
+		if ok, _ := filepath.Match(strings.ToLower(r.Pattern), lower); ok {
+			return &w.config.Recipes[i]
+		}
+	}
+	return nil
+}
+
+// target returns the done or failed directory of a watched directory
+func (w *Watcher) target(dir string, failed bool) string {
+	if failed {
+		if len(w.config.FailedDir) > 0 {
+			return w.config.FailedDir
+		}
+		return filepath.Join(dir, "failed")
+	}
+	if len(w.config.DoneDir) > 0 {
+		return w.config.DoneDir
+	}
+	return filepath.Join(dir, "done")
+}
+
+// partial reports whether name looks like a file still being written by a copy tool
+func partial(name string) bool {
+	if strings.HasPrefix(name, ".") || strings.HasPrefix(name, "~") {
+		return true
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".part", ".partial", ".tmp", ".crdownload", ".filepart":
+		return true
+	}
+	return false
+}
+
+// scan returns the files that became stable
+func (w *Watcher) scan(now time.Time) []string {
+	var ready []string
+	seen := map[string]bool{}
+	for _, dir := range w.config.Dirs {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, f := range files {
+			if f.IsDir() || partial(f.Name()) || w.recipe(f.Name()) == nil {
+				continue
+			}
+			path := filepath.Join(dir, f.Name())
+			seen[path] = true
+			w.mu.Lock()
+			if w.processing[path] {
+				w.mu.Unlock()
+				continue
+			}
+			c, ok := w.candidates[path]
+			if !ok || c.size != f.Size() || !c.modTime.Equal(f.ModTime()) {
+				w.candidates[path] = candidate{size: f.Size(), modTime: f.ModTime(), since: now}
+			} else if now.Sub(c.since) >= w.config.StableFor && readable(path) {
+				delete(w.candidates, path)
+				w.processing[path] = true
+				ready = append(ready, path)
+			}
+			w.mu.Unlock()
+		}
+	}
+	w.mu.Lock()
+	for path := range w.candidates {
+		if !seen[path] {
+			delete(w.candidates, path)
+		}
+	}
+	w.mu.Unlock()
+	return ready
+}
+
+// readable reports whether path can be opened, which fails on Windows while a copy
+// still holds the file
+func readable(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	f.Close()
+	return true
+}
+
+// process runs the recipe of path and moves the file
+func (w *Watcher) process(ctx context.Context, path string) {
+	defer func() {
+		w.mu.Lock()
+		delete(w.processing, path)
+		w.mu.Unlock()
+	}()
+	emit := func(e Event) {
+		if w.config.OnEvent != nil {
+			w.config.OnEvent(e)
+		}
+	}
+	emit(Event{Kind: EventStarted, Path: path})
+	err := w.recipe(filepath.Base(path)).Process(ctx, path)
+	if ctx.Err() != nil {
+		// left in place to be processed again on the next run
+		return
+	}
+	dir := w.target(filepath.Dir(path), err != nil)
+	moved, moveErr := move(path, dir)
+	if err == nil && moveErr != nil {
+		err = moveErr
+	}
+	if err != nil {
+		if moveErr == nil {
+			// the reason of the failure sits next to the file
+			ioutil.WriteFile(moved+".error.txt", []byte(err.Error()+"\n"), 0644)
+		}
+		emit(Event{Kind: EventFailed, Path: path, Moved: moved, Err: err})
+		return
+	}
+	emit(Event{Kind: EventSucceeded, Path: path, Moved: moved})
+}
+
+// move moves path into dir, renaming it when a file of the same name exists there
+func move(path, dir string) (string, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", err
+	}
+	base := filepath.Base(path)
+	ext := filepath.Ext(base)
+	target := filepath.Join(dir, base)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(target); os.IsNotExist(err) {
+			break
+		}
+		target = filepath.Join(dir, fmt.Sprintf("%s_%d%s", strings.TrimSuffix(base, ext), i, ext))
+	}
+	return target, os.Rename(path, target)
+}
+
+// Run watches the directories until ctx is done, then waits for the files being processed
+func (w *Watcher) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, w.config.Concurrency)
+	ticker := time.NewTicker(w.config.PollInterval)
+	defer ticker.Stop()
+	for {
+		for _, path := range w.scan(time.Now()) {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				wg.Wait()
+				return ctx.Err()
+			}
+			wg.Add(1)
+			go func(path string) {
+				defer wg.Done()
+				defer func() { <-slots }()
+				w.process(ctx, path)
+			}(path)
+		}
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return ctx.Err()
+		case <-ticker.C:
+		case <-w.config.Notify:
+		}
+	}
+}
+
+// QueueRecipe returns a recipe submitting the matching files to q with the spec built
+// by spec, and waiting for the job so that the file is moved after its transcoding
+func QueueRecipe(q *queue.Queue, pattern string, priority queue.Priority, spec func(path string) queue.Spec) Recipe {
+	return Recipe{
+		Pattern: pattern,
+		Process: func(ctx context.Context, path string) error {
+			id, err := q.Submit(spec(path), priority, queue.Callbacks{})
+			if err != nil {
+				return err
+			}
+			job, err := q.Wait(ctx, id)
+			if err != nil {
+				return err
+			}
+			if ctx.Err() != nil {
+				q.Cancel(id)
+				return ctx.Err()
+			}
+			if job.State != queue.StateSucceeded {
+				return fmt.Errorf("watch: job %s %s: %s", id, job.State, job.Error)
+			}
+			return nil
+		},
+	}
+}